@@ -10,12 +10,21 @@ import (
 
 type (
 	Config struct {
-		App    `yaml:"app"`
-		HTTP   `yaml:"http"`
-		Log    `yaml:"log"`
-		PG     `yaml:"postgres"`
-		JWT    `yaml:"jwt"`
-		Hasher `yaml:"hasher"`
+		App               `yaml:"app"`
+		HTTP              `yaml:"http"`
+		Log               `yaml:"log"`
+		PG                `yaml:"postgres"`
+		JWT               `yaml:"jwt"`
+		Hasher            `yaml:"hasher"`
+		LoginThrottle     `yaml:"login_throttle"`
+		PasswordReset     `yaml:"password_reset"`
+		EmailVerification `yaml:"email_verification"`
+		Idempotency       `yaml:"idempotency"`
+		TaskCatalog       `yaml:"task_catalog"`
+		Streak            `yaml:"streak"`
+		Referral          `yaml:"referral"`
+		Verification      `yaml:"verification"`
+		Notifier          `yaml:"notifier"`
 	}
 
 	App struct {
@@ -25,6 +34,8 @@ type (
 
 	HTTP struct {
 		Port string `env-required:"true" yaml:"port" env:"HTTP_PORT"`
+		// TrustedProxies are the addresses or CIDRs whose X-Forwarded-For is honored
+		TrustedProxies []string `yaml:"trusted_proxies" env:"HTTP_TRUSTED_PROXIES" env-separator:","`
 	}
 
 	Log struct {
@@ -37,13 +48,150 @@ type (
 	}
 
 	JWT struct {
-		SignKey  string        `env-required:"true"                  env:"JWT_SIGN_KEY"`
-		TokenTTL time.Duration `env-required:"true" yaml:"token_ttl" env:"JWT_TOKEN_TTL"`
+		// Algorithm is HS256 (SignKey secret) or RS256/EdDSA (PEM Keys)
+		Algorithm      string        `yaml:"algorithm"        env:"JWT_ALGORITHM"        env-default:"HS256"`
+		SignKey        string        `                        env:"JWT_SIGN_KEY"`
+		ActiveKeyId    string        `yaml:"active_kid"       env:"JWT_ACTIVE_KID"`
+		Keys           []JWTKey      `yaml:"keys"`
+		KeyGracePeriod time.Duration `yaml:"key_grace_period" env:"JWT_KEY_GRACE_PERIOD" env-default:"24h"`
+
+		TokenTTL        time.Duration `env-required:"true" yaml:"token_ttl"         env:"JWT_TOKEN_TTL"`
+		RefreshTokenTTL time.Duration `env-required:"true" yaml:"refresh_token_ttl" env:"JWT_REFRESH_TOKEN_TTL"`
+
+		Issuer   string        `yaml:"issuer"   env:"JWT_ISSUER"   env-default:"denet-test-task"`
+		Audience []string      `yaml:"audience" env:"JWT_AUDIENCE" env-default:"denet-test-task" env-separator:","`
+		Leeway   time.Duration `yaml:"leeway"   env:"JWT_LEEWAY"   env-default:"30s"`
+		// RequiredClaims lists registered claims every access token must carry
+		RequiredClaims []string `yaml:"required_claims" env:"JWT_REQUIRED_CLAIMS" env-default:"exp,iat,jti,sub" env-separator:","`
+
+		// RevocationCacheTTL is how long revocation checks are cached in-process
+		RevocationCacheTTL time.Duration `yaml:"revocation_cache_ttl" env:"JWT_REVOCATION_CACHE_TTL" env-default:"30s"`
+	}
+
+	JWTKey struct {
+		Id   string `yaml:"kid"`
+		Path string `yaml:"path"`
+		// Algorithm defaults to JWT.Algorithm; set it for keys of a previous algorithm
+		Algorithm string `yaml:"algorithm"`
+		// RetiredAt is set when the key stops signing; it is then accepted
+		// for verification during the grace period only
+		RetiredAt time.Time `yaml:"retired_at"`
 	}
 
 	Hasher struct {
+		Algorithm         string `yaml:"algorithm"          env:"HASHER_ALGORITHM"          env-default:"argon2id"`
+		Argon2Memory      uint32 `yaml:"argon2_memory"      env:"HASHER_ARGON2_MEMORY"      env-default:"19456"`
+		Argon2Iterations  uint32 `yaml:"argon2_iterations"  env:"HASHER_ARGON2_ITERATIONS"  env-default:"2"`
+		Argon2Parallelism uint8  `yaml:"argon2_parallelism" env:"HASHER_ARGON2_PARALLELISM" env-default:"1"`
+		BcryptCost        int    `yaml:"bcrypt_cost"        env:"HASHER_BCRYPT_COST"        env-default:"12"`
+		// Salt of the legacy SHA-1 hashes; only used to verify and upgrade
+		// them, but required while such hashes may be stored
 		Salt string `env-required:"true" env:"HASHER_SALT"`
 	}
+
+	PasswordReset struct {
+		TokenTTL time.Duration `yaml:"token_ttl" env:"PASSWORD_RESET_TOKEN_TTL" env-default:"30m"`
+	}
+
+	EmailVerification struct {
+		CodeTTL     time.Duration `yaml:"code_ttl"     env:"EMAIL_VERIFICATION_CODE_TTL"     env-default:"24h"`
+		MaxAttempts int           `yaml:"max_attempts" env:"EMAIL_VERIFICATION_MAX_ATTEMPTS" env-default:"5"`
+		// ResendInterval is how long a user waits before a new code is sent
+		ResendInterval time.Duration `yaml:"resend_interval" env:"EMAIL_VERIFICATION_RESEND_INTERVAL" env-default:"1m"`
+	}
+
+	Idempotency struct {
+		// KeyTTL is how long responses are kept for replay
+		KeyTTL time.Duration `yaml:"key_ttl" env:"IDEMPOTENCY_KEY_TTL" env-default:"24h"`
+	}
+
+	TaskCatalog struct {
+		// TTL is how often the tasks are reloaded when no change notification arrives
+		TTL time.Duration `yaml:"ttl" env:"TASK_CATALOG_TTL" env-default:"1m"`
+	}
+
+	Streak struct {
+		// GraceDays is how many days without completions keep a streak going
+		GraceDays   int                `yaml:"grace_days"  env:"STREAK_GRACE_DAYS" env-default:"1"`
+		Multipliers []StreakMultiplier `yaml:"multipliers"`
+	}
+
+	StreakMultiplier struct {
+		// Days is the streak length the multiplier applies from
+		Days       int     `yaml:"days"`
+		Multiplier float64 `yaml:"multiplier"`
+	}
+
+	Referral struct {
+		// MaxChainDepth is the longest referrer chain checked for cycles;
+		// referrers with a longer chain are refused
+		MaxChainDepth int `yaml:"max_chain_depth" env:"REFERRAL_MAX_CHAIN_DEPTH" env-default:"100"`
+		// RecheckInterval is how often pending referral rewards are run
+		// through the anti-fraud rules again
+		RecheckInterval time.Duration `yaml:"recheck_interval" env:"REFERRAL_RECHECK_INTERVAL" env-default:"1h"`
+		// Antifraud holds the rules the rewards of referrers are released by
+		Antifraud ReferralAntifraud `yaml:"antifraud"`
+	}
+
+	// ReferralAntifraud turns a rule off with its zero value. The network of
+	// a referral and their referrer is compared by the first IPv4Bits or
+	// IPv6Bits of their sign-up addresses; the same address is always rejected.
+	ReferralAntifraud struct {
+		IPv4Bits          int           `yaml:"ipv4_bits"           env:"REFERRAL_ANTIFRAUD_IPV4_BITS"           env-default:"24"`
+		IPv6Bits          int           `yaml:"ipv6_bits"           env:"REFERRAL_ANTIFRAUD_IPV6_BITS"           env-default:"64"`
+		MinAccountAge     time.Duration `yaml:"min_account_age"     env:"REFERRAL_ANTIFRAUD_MIN_ACCOUNT_AGE"     env-default:"24h"`
+		DailyCap          int           `yaml:"daily_cap"           env:"REFERRAL_ANTIFRAUD_DAILY_CAP"           env-default:"10"`
+		MinCompletedTasks int           `yaml:"min_completed_tasks" env:"REFERRAL_ANTIFRAUD_MIN_COMPLETED_TASKS" env-default:"1"`
+	}
+
+	Verification struct {
+		// ResultTTL is how long a verified or rejected completion is reused,
+		// PendingTTL how long a pending check is not repeated
+		ResultTTL  time.Duration        `yaml:"result_ttl"  env:"VERIFICATION_RESULT_TTL"  env-default:"1m"`
+		PendingTTL time.Duration        `yaml:"pending_ttl" env:"VERIFICATION_PENDING_TTL" env-default:"30s"`
+		Timeout    time.Duration        `yaml:"timeout"     env:"VERIFICATION_TIMEOUT"     env-default:"5s"`
+		Telegram   Telegram             `yaml:"telegram"`
+		Callback   VerificationCallback `yaml:"callback"`
+	}
+
+	// Telegram verifies telegram_subscription tasks; it is off without a bot token.
+	// LoginTTL is how long the data of the Login Widget links an account
+	Telegram struct {
+		APIURL   string        `yaml:"api_url"   env:"TELEGRAM_API_URL"   env-default:"https://api.telegram.org"`
+		BotToken string        `                 env:"TELEGRAM_BOT_TOKEN"`
+		LoginTTL time.Duration `yaml:"login_ttl" env:"TELEGRAM_LOGIN_TTL" env-default:"1h"`
+	}
+
+	// VerificationCallback verifies external_verification tasks; it is off without a URL
+	VerificationCallback struct {
+		URL    string `yaml:"url" env:"VERIFICATION_CALLBACK_URL"`
+		Secret string `           env:"VERIFICATION_CALLBACK_SECRET"`
+	}
+
+	Notifier struct {
+		// Type is how notifications are delivered: log, file or smtp
+		Type     string `yaml:"type"      env:"NOTIFIER_TYPE"      env-default:"log"`
+		FilePath string `yaml:"file_path" env:"NOTIFIER_FILE_PATH" env-default:"notifications.log"`
+		SMTP     SMTP   `yaml:"smtp"`
+	}
+
+	SMTP struct {
+		Host     string `yaml:"host"     env:"SMTP_HOST"`
+		Port     int    `yaml:"port"     env:"SMTP_PORT"     env-default:"587"`
+		Username string `yaml:"username" env:"SMTP_USERNAME"`
+		Password string `                env:"SMTP_PASSWORD"`
+		From     string `yaml:"from"     env:"SMTP_FROM"`
+	}
+
+	LoginThrottle struct {
+		// Store is where failed sign-in counters are kept: postgres or memory
+		Store            string        `yaml:"store"              env:"LOGIN_THROTTLE_STORE"              env-default:"postgres"`
+		UserFreeAttempts int           `yaml:"user_free_attempts" env:"LOGIN_THROTTLE_USER_FREE_ATTEMPTS" env-default:"5"`
+		IPFreeAttempts   int           `yaml:"ip_free_attempts"   env:"LOGIN_THROTTLE_IP_FREE_ATTEMPTS"   env-default:"20"`
+		BaseLockout      time.Duration `yaml:"base_lockout"       env:"LOGIN_THROTTLE_BASE_LOCKOUT"       env-default:"1s"`
+		MaxLockout       time.Duration `yaml:"max_lockout"        env:"LOGIN_THROTTLE_MAX_LOCKOUT"        env-default:"15m"`
+		Window           time.Duration `yaml:"window"             env:"LOGIN_THROTTLE_WINDOW"             env-default:"15m"`
+	}
 )
 
 func NewConfig(configPath string) (*Config, error) {
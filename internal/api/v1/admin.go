@@ -0,0 +1,321 @@
+package v1
+
+import (
+	"denet-test-task/internal/api/v1/apierrs"
+	"denet-test-task/internal/api/v1/dto"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/services/auth"
+	"denet-test-task/internal/services/tasks"
+	"denet-test-task/internal/services/users"
+	"denet-test-task/pkg/validator"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type adminRoutes struct {
+	authService  auth.Auth
+	tasksService tasks.Tasks
+	usersService users.Users
+}
+
+type setRoleInput struct {
+	Role string `json:"role" validate:"required"`
+}
+
+type taskInput struct {
+	Name            string         `json:"name"             validate:"required"`
+	Descr           string         `json:"descr"`
+	Points          int            `json:"points"           validate:"required"`
+	Kind            string         `json:"kind"`
+	Metadata        map[string]any `json:"metadata"`
+	StartsAt        *time.Time     `json:"starts_at"`
+	EndsAt          *time.Time     `json:"ends_at"`
+	Recurrence      string         `json:"recurrence"`
+	CompletionLimit int            `json:"completion_limit"`
+	StreakGroup     string         `json:"streak_group"`
+}
+
+// defaultReferralRewardsLimit is the size of the review queue page when no
+// limit is given.
+const defaultReferralRewardsLimit = 50
+
+func newAdminRoutes(router chi.Router, authService auth.Auth, tasksService tasks.Tasks, usersService users.Users) {
+	routes := &adminRoutes{
+		authService:  authService,
+		tasksService: tasksService,
+		usersService: usersService,
+	}
+
+	router.Put("/users/{user_id}/role", routes.handleSetRole)
+
+	router.Route("/tasks", func(tr chi.Router) {
+		tr.Get("/", routes.handleGetTasks)
+		tr.Post("/", routes.handleCreateTask)
+		tr.Post("/reload", routes.handleReloadTasks)
+		tr.Put("/{task_id}", routes.handleUpdateTask)
+		tr.Post("/{task_id}/archive", routes.handleArchiveTask)
+		tr.Post("/{task_id}/restore", routes.handleRestoreTask)
+	})
+
+	router.Route("/referral-rewards", func(rr chi.Router) {
+		rr.Get("/", routes.handleGetReferralRewards)
+		rr.Post("/{reward_id}/release", routes.handleReleaseReferralReward)
+		rr.Post("/{reward_id}/reject", routes.handleRejectReferralReward)
+	})
+}
+
+func (r *adminRoutes) handleSetRole(w http.ResponseWriter, req *http.Request) {
+
+	userId := chi.URLParam(req, "user_id")
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	var input setRoleInput
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = r.authService.SetRole(req.Context(), auth.AuthSetRoleInput{UserId: userIdInt, Role: entity.Role(input.Role)})
+	if err != nil {
+		switch err {
+		case auth.ErrInvalidRole:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		case auth.ErrUserNotFound:
+			apierrs.NewErrorResponseHTTP(w, http.StatusNotFound, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *adminRoutes) handleGetTasks(w http.ResponseWriter, req *http.Request) {
+
+	tasks, err := r.tasksService.GetAllTasksWithArchived(req.Context())
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(dto.NewTasks(tasks))
+}
+
+func (r *adminRoutes) handleCreateTask(w http.ResponseWriter, req *http.Request) {
+
+	var input taskInput
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	id, err := r.tasksService.Create(req.Context(), tasks.TasksCreateInput{
+		Name:     input.Name,
+		Descr:    input.Descr,
+		Points:   input.Points,
+		Kind:     entity.TaskKind(input.Kind),
+		Metadata: input.Metadata,
+
+		StartsAt:        input.StartsAt,
+		EndsAt:          input.EndsAt,
+		Recurrence:      entity.TaskRecurrence(input.Recurrence),
+		CompletionLimit: input.CompletionLimit,
+		StreakGroup:     input.StreakGroup,
+	})
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	type response struct {
+		Id int `json:"id"`
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(response{Id: id})
+}
+
+func (r *adminRoutes) handleUpdateTask(w http.ResponseWriter, req *http.Request) {
+
+	taskId, err := strconv.Atoi(chi.URLParam(req, "task_id"))
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+
+	var input taskInput
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = r.tasksService.Update(req.Context(), tasks.TasksUpdateInput{
+		TaskId:   taskId,
+		Name:     input.Name,
+		Descr:    input.Descr,
+		Points:   input.Points,
+		Kind:     entity.TaskKind(input.Kind),
+		Metadata: input.Metadata,
+
+		StartsAt:        input.StartsAt,
+		EndsAt:          input.EndsAt,
+		Recurrence:      entity.TaskRecurrence(input.Recurrence),
+		CompletionLimit: input.CompletionLimit,
+		StreakGroup:     input.StreakGroup,
+	})
+	if err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *adminRoutes) handleArchiveTask(w http.ResponseWriter, req *http.Request) {
+
+	taskId, err := strconv.Atoi(chi.URLParam(req, "task_id"))
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+
+	if err := r.tasksService.Archive(req.Context(), tasks.TasksArchiveInput{TaskId: taskId}); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *adminRoutes) handleRestoreTask(w http.ResponseWriter, req *http.Request) {
+
+	taskId, err := strconv.Atoi(chi.URLParam(req, "task_id"))
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid task id")
+		return
+	}
+
+	if err := r.tasksService.Restore(req.Context(), tasks.TasksRestoreInput{TaskId: taskId}); err != nil {
+		writeTaskError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *adminRoutes) handleReloadTasks(w http.ResponseWriter, req *http.Request) {
+
+	if err := r.tasksService.Reload(req.Context()); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *adminRoutes) handleGetReferralRewards(w http.ResponseWriter, req *http.Request) {
+
+	status := entity.ReferralRewardReview
+	if s := req.URL.Query().Get("status"); s != "" {
+		status = entity.ReferralRewardStatus(s)
+	}
+
+	limitInt := defaultReferralRewardsLimit
+	if limit := req.URL.Query().Get("limit"); limit != "" {
+		var err error
+		limitInt, err = strconv.Atoi(limit)
+		if err != nil || limitInt <= 0 || limitInt > 100 {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+	}
+
+	rewards, err := r.usersService.GetReferralRewards(req.Context(), users.UsersGetReferralRewardsInput{Status: status, Limit: limitInt})
+	if err != nil {
+		if err == users.ErrInvalidReferralRewardStatus {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(dto.NewReferralRewards(rewards))
+}
+
+func (r *adminRoutes) handleReleaseReferralReward(w http.ResponseWriter, req *http.Request) {
+	r.reviewReferralReward(w, req, true)
+}
+
+func (r *adminRoutes) handleRejectReferralReward(w http.ResponseWriter, req *http.Request) {
+	r.reviewReferralReward(w, req, false)
+}
+
+func (r *adminRoutes) reviewReferralReward(w http.ResponseWriter, req *http.Request, release bool) {
+
+	rewardId, err := strconv.Atoi(chi.URLParam(req, "reward_id"))
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid reward id")
+		return
+	}
+
+	err = r.usersService.ReviewReferralReward(req.Context(), users.UsersReviewReferralRewardInput{RewardId: rewardId, Release: release})
+	if err != nil {
+		switch err {
+		case users.ErrReferralRewardNotFound:
+			apierrs.NewErrorResponseHTTP(w, http.StatusNotFound, err.Error())
+		case users.ErrReferralRewardDecided:
+			apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTaskError maps the errors of the task management methods to responses.
+func writeTaskError(w http.ResponseWriter, err error) {
+	switch err {
+	case tasks.ErrInvalidTaskName, tasks.ErrInvalidTaskDescr, tasks.ErrInvalidTaskPoints, tasks.ErrInvalidTaskKind,
+		tasks.ErrInvalidTaskWindow, tasks.ErrInvalidTaskRecurrence, tasks.ErrInvalidTaskLimit, tasks.ErrTaskNotRepeatable,
+		tasks.ErrInvalidStreakGroup, tasks.ErrTaskNotInStreak, tasks.ErrInvalidTaskMetadata:
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+	case tasks.ErrTaskNotFound:
+		apierrs.NewErrorResponseHTTP(w, http.StatusNotFound, err.Error())
+	case tasks.ErrTaskAlreadyExists:
+		apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+	default:
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+	}
+}
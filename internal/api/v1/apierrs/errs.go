@@ -9,6 +9,26 @@ import (
 var (
 	ErrInvalidAuthHeader = fmt.Errorf("invalid auth header")
 	ErrCannotParseToken  = fmt.Errorf("cannot parse token")
+	ErrAccessDenied      = fmt.Errorf("access denied")
+)
+
+// Bearer token error codes (RFC 6750, section 3.1).
+const (
+	BearerInvalidRequest    = "invalid_request"
+	BearerInvalidToken      = "invalid_token"
+	BearerInsufficientScope = "insufficient_scope"
+)
+
+// Reasons an access token is rejected, sent as "code" in the body and at
+// the start of the challenge's error_description so that clients can tell a
+// token to refresh from one to drop. The challenge keeps the invalid_token
+// error code of RFC 6750 for all of them, as it defines no others.
+const (
+	TokenExpired      = "token_expired"
+	TokenRevoked      = "token_revoked"
+	TokenMalformed    = "token_malformed"
+	TokenBadSignature = "token_bad_signature"
+	TokenInvalid      = "token_invalid"
 )
 
 func NewErrorResponseHTTP(w http.ResponseWriter, errStatus int, message string) {
@@ -16,3 +36,26 @@ func NewErrorResponseHTTP(w http.ResponseWriter, errStatus int, message string)
 	w.WriteHeader(errStatus)
 	_ = json.NewEncoder(w).Encode(map[string]string{"message": message})
 }
+
+// NewBearerErrorResponseHTTP responds like NewErrorResponseHTTP and sets the
+// WWW-Authenticate challenge. An empty code produces a bare challenge, as
+// required for requests without credentials.
+func NewBearerErrorResponseHTTP(w http.ResponseWriter, errStatus int, code string, message string) {
+	challenge := "Bearer"
+	if code != "" {
+		challenge = fmt.Sprintf("Bearer error=%q, error_description=%q", code, message)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	NewErrorResponseHTTP(w, errStatus, message)
+}
+
+// NewInvalidTokenResponseHTTP rejects the access token with 401, the
+// invalid_token challenge and the reason in the body. The description is
+// prefixed with the reason, e.g. "token_expired: the access token expired".
+func NewInvalidTokenResponseHTTP(w http.ResponseWriter, reason string, message string) {
+	description := fmt.Sprintf("%s: %s", reason, message)
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer error=%q, error_description=%q", BearerInvalidToken, description))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]string{"code": reason, "message": message})
+}
@@ -2,10 +2,14 @@ package v1
 
 import (
 	"denet-test-task/internal/api/v1/apierrs"
+	apimv "denet-test-task/internal/api/v1/middlewares"
 	"denet-test-task/internal/services/auth"
 	"denet-test-task/pkg/validator"
 	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi/v5"
 )
@@ -19,17 +23,55 @@ type authInput struct {
 	Password string `json:"password"`
 }
 
-func newAuthRoutes(router chi.Router, authService auth.Auth) {
+type signupInput struct {
+	authInput
+	ReferralCode string `json:"referral_code"`
+}
+
+type refreshInput struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+type logoutInput struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type changePasswordInput struct {
+	OldPassword string `json:"old_password" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password"`
+}
+
+type requestPasswordResetInput struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type resetPasswordInput struct {
+	Token       string `json:"token"        validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,password"`
+}
+
+func newAuthRoutes(router chi.Router, authService auth.Auth, authMiddleware *apimv.AuthMiddleware) {
 	routes := &authRoutes{
 		authService: authService,
 	}
 
 	router.Post("/sign-up", routes.handleSignup)
 	router.Post("/sign-in", routes.handleLogin)
+	router.Post("/refresh", routes.handleRefresh)
+	router.Post("/password/reset", routes.handleRequestPasswordReset)
+	router.Post("/password/reset/confirm", routes.handleResetPassword)
+
+	router.Group(func(ar chi.Router) {
+		ar.Use(authMiddleware.UserIdentity)
+
+		ar.Post("/logout", routes.handleLogout)
+		ar.Post("/logout-all", routes.handleLogoutAll)
+		ar.Post("/password/change", routes.handleChangePassword)
+	})
 }
 
 func (a *authRoutes) handleSignup(w http.ResponseWriter, req *http.Request) {
-	var input authInput
+	var input signupInput
 
 	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
 		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
@@ -42,15 +84,20 @@ func (a *authRoutes) handleSignup(w http.ResponseWriter, req *http.Request) {
 	}
 
 	id, err := a.authService.CreateUser(req.Context(), auth.AuthCreateUserInput{
-		Username: input.Username,
-		Password: input.Password,
+		Username:     input.Username,
+		Password:     input.Password,
+		ReferralCode: input.ReferralCode,
+		IP:           apimv.ClientIP(req),
 	})
 	if err != nil {
-		if err == auth.ErrUserAlreadyExists {
+		switch err {
+		case auth.ErrUserAlreadyExists, auth.ErrInvalidReferralCode:
 			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
-			return
+		case auth.ErrReferrerChainTooLong:
+			apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
 		}
-		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
 		return
 	}
 
@@ -76,11 +123,17 @@ func (a *authRoutes) handleLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	token, err := a.authService.GenerateToken(req.Context(), auth.AuthGenerateTokenInput{
+	tokens, err := a.authService.GenerateToken(req.Context(), auth.AuthGenerateTokenInput{
 		Username: input.Username,
 		Password: input.Password,
+		IP:       apimv.ClientIP(req),
 	})
 	if err != nil {
+		var tooMany *auth.TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			writeTooManyAttempts(w, tooMany)
+			return
+		}
 		if err == auth.ErrUserNotFound {
 			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid username or password")
 			return
@@ -89,11 +142,188 @@ func (a *authRoutes) handleLogin(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	type response struct {
-		Token string `json:"token"`
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(newTokensResponse(tokens))
+}
+
+func (a *authRoutes) handleRefresh(w http.ResponseWriter, req *http.Request) {
+	var input refreshInput
+
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	tokens, err := a.authService.RefreshToken(req.Context(), auth.AuthRefreshTokenInput{
+		RefreshToken: input.RefreshToken,
+	})
+	if err != nil {
+		if err == auth.ErrInvalidRefreshToken || err == auth.ErrRefreshTokenReused {
+			apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(response{Token: token})
+	_ = json.NewEncoder(w).Encode(newTokensResponse(tokens))
+}
+
+func (a *authRoutes) handleLogout(w http.ResponseWriter, req *http.Request) {
+	identity, ok := apimv.IdentityFromContext(req.Context())
+	if !ok {
+		apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, apierrs.ErrCannotParseToken.Error())
+		return
+	}
+
+	// the body is optional: without a refresh token only the access token is revoked
+	var input logoutInput
+	if req.ContentLength != 0 {
+		if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+	}
+
+	err := a.authService.Logout(req.Context(), auth.AuthLogoutInput{
+		Identity:     identity,
+		RefreshToken: input.RefreshToken,
+	})
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *authRoutes) handleLogoutAll(w http.ResponseWriter, req *http.Request) {
+	identity, ok := apimv.IdentityFromContext(req.Context())
+	if !ok {
+		apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, apierrs.ErrCannotParseToken.Error())
+		return
+	}
+
+	err := a.authService.LogoutAll(req.Context(), auth.AuthLogoutAllInput{UserId: identity.UserId})
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *authRoutes) handleChangePassword(w http.ResponseWriter, req *http.Request) {
+	identity, ok := apimv.IdentityFromContext(req.Context())
+	if !ok {
+		apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, apierrs.ErrCannotParseToken.Error())
+		return
+	}
+
+	var input changePasswordInput
+
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := a.authService.ChangePassword(req.Context(), auth.AuthChangePasswordInput{
+		UserId:      identity.UserId,
+		OldPassword: input.OldPassword,
+		NewPassword: input.NewPassword,
+	})
+	if err != nil {
+		var tooMany *auth.TooManyAttemptsError
+		if errors.As(err, &tooMany) {
+			writeTooManyAttempts(w, tooMany)
+			return
+		}
+		if err == auth.ErrInvalidPassword {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (a *authRoutes) handleRequestPasswordReset(w http.ResponseWriter, req *http.Request) {
+	var input requestPasswordResetInput
+
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// the response does not depend on whether the email is registered
+	_ = a.authService.RequestPasswordReset(req.Context(), auth.AuthRequestPasswordResetInput{
+		Email: input.Email,
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (a *authRoutes) handleResetPassword(w http.ResponseWriter, req *http.Request) {
+	var input resetPasswordInput
+
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := a.authService.ResetPassword(req.Context(), auth.AuthResetPasswordInput{
+		Token:       input.Token,
+		NewPassword: input.NewPassword,
+	})
+	if err != nil {
+		if err == auth.ErrInvalidResetToken {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeTooManyAttempts responds 429 telling the client when to retry.
+func writeTooManyAttempts(w http.ResponseWriter, tooMany *auth.TooManyAttemptsError) {
+	retryAfter := int(math.Ceil(tooMany.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	apierrs.NewErrorResponseHTTP(w, http.StatusTooManyRequests, auth.ErrTooManyAttempts.Error())
+}
+
+type tokensResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+func newTokensResponse(tokens auth.AuthTokens) tokensResponse {
+	return tokensResponse{Token: tokens.AccessToken, RefreshToken: tokens.RefreshToken}
 }
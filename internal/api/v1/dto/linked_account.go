@@ -0,0 +1,22 @@
+package dto
+
+import (
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// LinkedAccount is the account of the user on the external service verifying
+// tasks of the kind.
+type LinkedAccount struct {
+	Kind     entity.TaskKind `json:"kind"`
+	Account  string          `json:"account"`
+	LinkedAt time.Time       `json:"linked_at"`
+}
+
+func NewLinkedAccount(la entity.LinkedAccount) LinkedAccount {
+	return LinkedAccount{
+		Kind:     la.Kind,
+		Account:  la.Account,
+		LinkedAt: la.LinkedAt,
+	}
+}
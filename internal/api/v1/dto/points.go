@@ -0,0 +1,60 @@
+package dto
+
+import (
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// PointsHistory is a page of the points history. NextCursor is null on the
+// last page.
+type PointsHistory struct {
+	Entries    []PointsEntry `json:"entries"`
+	NextCursor *string       `json:"next_cursor"`
+}
+
+// PointsEntry is an entry of the points history, without its idempotency
+// key.
+type PointsEntry struct {
+	Id         int64               `json:"id"`
+	Delta      int                 `json:"delta"`
+	Type       entity.PointsReason `json:"type"`
+	TaskId     *int                `json:"task_id"`
+	RewardId   *int                `json:"reward_id"`
+	ReferralId *int                `json:"referral_id"`
+	CreatedAt  time.Time           `json:"created_at"`
+}
+
+func NewPointsHistory(history entity.PointsHistory) PointsHistory {
+	entries := make([]PointsEntry, 0, len(history.Entries))
+	for _, entry := range history.Entries {
+		entries = append(entries, PointsEntry{
+			Id:         entry.Id,
+			Delta:      entry.Delta,
+			Type:       entry.Reason,
+			TaskId:     entry.TaskId,
+			RewardId:   entry.RewardId,
+			ReferralId: entry.ReferralId,
+			CreatedAt:  entry.CreatedAt,
+		})
+	}
+
+	page := PointsHistory{Entries: entries}
+	if history.NextCursor != "" {
+		page.NextCursor = &history.NextCursor
+	}
+	return page
+}
+
+// LeaderboardItem is a user with their points in the leaderboard.
+type LeaderboardItem struct {
+	Username string `json:"username"`
+	Points   int    `json:"points"`
+}
+
+func NewLeaderboard(items []entity.LeaderboardItem) []LeaderboardItem {
+	resp := make([]LeaderboardItem, 0, len(items))
+	for _, item := range items {
+		resp = append(resp, LeaderboardItem{Username: item.Username, Points: item.Points})
+	}
+	return resp
+}
@@ -0,0 +1,90 @@
+package dto
+
+import (
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// ReferralTree is the referral tree of a user with the sums of its levels.
+type ReferralTree struct {
+	Levels    []ReferralLevel `json:"levels"`
+	Referrals []Referral      `json:"referrals"`
+}
+
+// ReferralLevel sums up the referrals at a level of the tree.
+type ReferralLevel struct {
+	Level     int     `json:"level"`
+	Percent   float64 `json:"percent"`
+	Referrals int     `json:"referrals"`
+	Earned    int     `json:"earned"`
+}
+
+// Referral is a referral with their own referrals. Earned is what the owner
+// of the tree got for them.
+type Referral struct {
+	Id        int        `json:"id"`
+	Username  string     `json:"username"`
+	Level     int        `json:"level"`
+	Earned    int        `json:"earned"`
+	CreatedAt time.Time  `json:"created_at"`
+	Referrals []Referral `json:"referrals"`
+}
+
+// ReferralReward is a referrer bonus in the anti-fraud review queue.
+type ReferralReward struct {
+	Id         int                         `json:"id"`
+	ReferrerId int                         `json:"referrer_id"`
+	ReferralId int                         `json:"referral_id"`
+	TaskId     int                         `json:"task_id"`
+	Points     int                         `json:"points"`
+	Status     entity.ReferralRewardStatus `json:"status"`
+	Reason     string                      `json:"reason"`
+	CreatedAt  time.Time                   `json:"created_at"`
+	DecidedAt  *time.Time                  `json:"decided_at"`
+}
+
+func NewReferralTree(tree entity.ReferralTree) ReferralTree {
+	levels := make([]ReferralLevel, 0, len(tree.Levels))
+	for _, level := range tree.Levels {
+		levels = append(levels, ReferralLevel{
+			Level:     level.Level,
+			Percent:   level.Percent,
+			Referrals: level.Referrals,
+			Earned:    level.Earned,
+		})
+	}
+	return ReferralTree{Levels: levels, Referrals: newReferrals(tree.Referrals)}
+}
+
+func newReferrals(nodes []entity.ReferralNode) []Referral {
+	referrals := make([]Referral, 0, len(nodes))
+	for _, node := range nodes {
+		referrals = append(referrals, Referral{
+			Id:        node.Id,
+			Username:  node.Username,
+			Level:     node.Level,
+			Earned:    node.Earned,
+			CreatedAt: node.CreatedAt,
+			Referrals: newReferrals(node.Referrals),
+		})
+	}
+	return referrals
+}
+
+func NewReferralRewards(rewards []entity.ReferralReward) []ReferralReward {
+	resp := make([]ReferralReward, 0, len(rewards))
+	for _, reward := range rewards {
+		resp = append(resp, ReferralReward{
+			Id:         reward.Id,
+			ReferrerId: reward.ReferrerId,
+			ReferralId: reward.ReferralId,
+			TaskId:     reward.TaskId,
+			Points:     reward.Points,
+			Status:     reward.Status,
+			Reason:     reward.Reason,
+			CreatedAt:  reward.CreatedAt,
+			DecidedAt:  reward.DecidedAt,
+		})
+	}
+	return resp
+}
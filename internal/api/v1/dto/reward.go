@@ -0,0 +1,33 @@
+package dto
+
+import (
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// Reward is an item users can spend points on. A null Stock is unlimited.
+type Reward struct {
+	Id          int        `json:"id"`
+	Name        string     `json:"name"`
+	Descr       string     `json:"descr"`
+	Cost        int        `json:"cost"`
+	Stock       *int       `json:"stock"`
+	ActiveFrom  *time.Time `json:"active_from"`
+	ActiveUntil *time.Time `json:"active_until"`
+}
+
+func NewRewards(rewards []entity.Reward) []Reward {
+	resp := make([]Reward, 0, len(rewards))
+	for _, reward := range rewards {
+		resp = append(resp, Reward{
+			Id:          reward.Id,
+			Name:        reward.Name,
+			Descr:       reward.Descr,
+			Cost:        reward.Cost,
+			Stock:       reward.Stock,
+			ActiveFrom:  reward.ActiveFrom,
+			ActiveUntil: reward.ActiveUntil,
+		})
+	}
+	return resp
+}
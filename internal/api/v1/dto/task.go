@@ -0,0 +1,69 @@
+package dto
+
+import (
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// Task is a task of the catalog. Metadata holds the kind specific settings,
+// such as the Telegram chat to join.
+type Task struct {
+	Id              int                   `json:"id"`
+	Name            string                `json:"name"`
+	Descr           string                `json:"descr"`
+	Points          int                   `json:"points"`
+	Kind            entity.TaskKind       `json:"kind"`
+	Metadata        map[string]any        `json:"metadata"`
+	StartsAt        *time.Time            `json:"starts_at"`
+	EndsAt          *time.Time            `json:"ends_at"`
+	Recurrence      entity.TaskRecurrence `json:"recurrence"`
+	CompletionLimit int                   `json:"completion_limit"`
+	StreakGroup     string                `json:"streak_group"`
+	ArchivedAt      *time.Time            `json:"archived_at"`
+}
+
+// UserTask is a task with the completions of the user in the current period.
+type UserTask struct {
+	Task
+	Completions int        `json:"completions"`
+	Available   bool       `json:"available"`
+	NextResetAt *time.Time `json:"next_reset_at"`
+}
+
+func NewTask(task entity.Task) Task {
+	return Task{
+		Id:              task.Id,
+		Name:            task.Name,
+		Descr:           task.Descr,
+		Points:          task.Points,
+		Kind:            task.Kind,
+		Metadata:        task.Metadata,
+		StartsAt:        task.StartsAt,
+		EndsAt:          task.EndsAt,
+		Recurrence:      task.Recurrence,
+		CompletionLimit: task.CompletionLimit,
+		StreakGroup:     task.StreakGroup,
+		ArchivedAt:      task.ArchivedAt,
+	}
+}
+
+func NewTasks(tasks []entity.Task) []Task {
+	resp := make([]Task, 0, len(tasks))
+	for _, task := range tasks {
+		resp = append(resp, NewTask(task))
+	}
+	return resp
+}
+
+func NewUserTasks(tasks []entity.UserTask) []UserTask {
+	resp := make([]UserTask, 0, len(tasks))
+	for _, task := range tasks {
+		resp = append(resp, UserTask{
+			Task:        NewTask(task.Task),
+			Completions: task.Completions,
+			Available:   task.Available,
+			NextResetAt: task.NextResetAt,
+		})
+	}
+	return resp
+}
@@ -0,0 +1,60 @@
+// Package dto holds the response bodies of the API. Entities are never
+// encoded directly: a DTO lists the fields a client may see, so secrets and
+// internal fields added to an entity stay out of the responses.
+package dto
+
+import (
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// UserStatus is the public profile of a user. It is visible to any
+// authenticated user and carries neither the password hash, the email nor
+// the signup address.
+type UserStatus struct {
+	Id             int       `json:"id"`
+	Username       string    `json:"username"`
+	CreatedAt      time.Time `json:"created_at"`
+	ReferrerId     *int      `json:"referrer_id"`
+	ReferralCode   string    `json:"referral_code"`
+	EmailVerified  bool      `json:"email_verified"`
+	Timezone       string    `json:"timezone"`
+	Points         int       `json:"points"`
+	Rank           int       `json:"rank"`
+	CompletedTasks int       `json:"completed_tasks"`
+	Streaks        []Streak  `json:"streaks"`
+}
+
+// Streak is a streak of the user, LastDay is a date in the user's timezone.
+type Streak struct {
+	Group   string `json:"group"`
+	Current int    `json:"current"`
+	Longest int    `json:"longest"`
+	LastDay string `json:"last_day"`
+}
+
+func NewUserStatus(status entity.UserStatus) UserStatus {
+	streaks := make([]Streak, 0, len(status.Streaks))
+	for _, streak := range status.Streaks {
+		streaks = append(streaks, Streak{
+			Group:   streak.Group,
+			Current: streak.Current,
+			Longest: streak.Longest,
+			LastDay: streak.LastDay.Format(time.DateOnly),
+		})
+	}
+
+	return UserStatus{
+		Id:             status.Id,
+		Username:       status.Username,
+		CreatedAt:      status.CreatedAt,
+		ReferrerId:     status.Referrer,
+		ReferralCode:   status.ReferralCode,
+		EmailVerified:  status.EmailVerified(),
+		Timezone:       status.Timezone,
+		Points:         status.Points,
+		Rank:           status.Rank,
+		CompletedTasks: status.CompletedTasks,
+		Streaks:        streaks,
+	}
+}
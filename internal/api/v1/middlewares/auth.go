@@ -12,7 +12,8 @@ import (
 type ctxKey string
 
 const (
-	userIdCtx ctxKey = "userId"
+	userIdCtx   ctxKey = "userId"
+	identityCtx ctxKey = "identity"
 )
 
 type AuthMiddleware struct {
@@ -23,25 +24,62 @@ func (h *AuthMiddleware) UserIdentity(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log := logctx.FromContext(r.Context())
 
+		if r.Header.Get("Authorization") == "" {
+			log.Warn("AuthMiddleware.UserIdentity: no Authorization header")
+			apierrs.NewBearerErrorResponseHTTP(w, http.StatusUnauthorized, "", apierrs.ErrInvalidAuthHeader.Error())
+			return
+		}
+
 		token, ok := bearerToken(r)
 		if !ok {
 			log.Warn("AuthMiddleware.UserIdentity: bearerToken", "error", apierrs.ErrInvalidAuthHeader)
-			apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, apierrs.ErrInvalidAuthHeader.Error())
+			apierrs.NewBearerErrorResponseHTTP(w, http.StatusUnauthorized, apierrs.BearerInvalidRequest, apierrs.ErrInvalidAuthHeader.Error())
 			return
 		}
 
-		userId, err := h.AuthService.ParseToken(token)
+		identity, err := h.AuthService.ParseToken(r.Context(), token)
 		if err != nil {
 			log.Warn("AuthMiddleware.UserIdentity: ParseToken", "err", err)
-			apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, apierrs.ErrCannotParseToken.Error())
+			if err == auth.ErrCannotCheckRevocation {
+				apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+				return
+			}
+			reason, description := tokenError(err)
+			apierrs.NewInvalidTokenResponseHTTP(w, reason, description)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), userIdCtx, userId)
+		ctx := context.WithValue(r.Context(), userIdCtx, identity.UserId)
+		ctx = context.WithValue(ctx, identityCtx, identity)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tokenError tells the client why its access token was rejected: the reason
+// code and a description.
+func tokenError(err error) (string, string) {
+	switch err {
+	case auth.ErrTokenExpired:
+		return apierrs.TokenExpired, "the access token expired"
+	case auth.ErrTokenRevoked:
+		return apierrs.TokenRevoked, "the access token has been revoked"
+	case auth.ErrTokenMalformed:
+		return apierrs.TokenMalformed, "the access token is malformed"
+	case auth.ErrTokenSignatureInvalid:
+		return apierrs.TokenBadSignature, "the access token signature is invalid"
+	case auth.ErrTokenInvalidClaims:
+		return apierrs.TokenInvalid, "the access token claims are invalid"
+	default:
+		return apierrs.TokenInvalid, apierrs.ErrCannotParseToken.Error()
+	}
+}
+
+// IdentityFromContext returns the identity stored by UserIdentity.
+func IdentityFromContext(ctx context.Context) (auth.AuthIdentity, bool) {
+	identity, ok := ctx.Value(identityCtx).(auth.AuthIdentity)
+	return identity, ok
+}
+
 func bearerToken(r *http.Request) (string, bool) {
 	const prefix = "Bearer "
 
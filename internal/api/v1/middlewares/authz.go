@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"context"
+	"denet-test-task/internal/api/v1/apierrs"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/services/auth"
+	"denet-test-task/pkg/logctx"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Policy decides whether the authenticated caller may access the request.
+type Policy func(r *http.Request, identity auth.AuthIdentity) bool
+
+// Authorize lets the request through if any of the policies allows it and
+// responds 403 otherwise. It must run after AuthMiddleware.UserIdentity and,
+// for policies reading URL params, be attached to routes (With/Group).
+func Authorize(policies ...Policy) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := IdentityFromContext(r.Context())
+			if !ok {
+				apierrs.NewInvalidTokenResponseHTTP(w, apierrs.TokenInvalid, apierrs.ErrCannotParseToken.Error())
+				return
+			}
+
+			for _, policy := range policies {
+				if policy(r, identity) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			logctx.FromContext(r.Context()).Warn("Authorize: access denied", "user_id", identity.UserId)
+			apierrs.NewBearerErrorResponseHTTP(w, http.StatusForbidden, apierrs.BearerInsufficientScope, apierrs.ErrAccessDenied.Error())
+		})
+	}
+}
+
+// OwnerOf allows callers whose user id equals the user id in the URL param.
+func OwnerOf(param string) Policy {
+	return func(r *http.Request, identity auth.AuthIdentity) bool {
+		ownerId, err := strconv.Atoi(chi.URLParam(r, param))
+		if err != nil {
+			return false
+		}
+		return ownerId == identity.UserId
+	}
+}
+
+// HasRole allows callers having one of the roles.
+func HasRole(roles ...entity.Role) Policy {
+	return func(_ *http.Request, identity auth.AuthIdentity) bool {
+		for _, role := range roles {
+			if identity.Role == role {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// RequireRole restricts the routes to callers having one of the roles.
+func RequireRole(roles ...entity.Role) func(http.Handler) http.Handler {
+	return Authorize(HasRole(roles...))
+}
+
+// UserIdFromContext returns the id of the authenticated caller.
+func UserIdFromContext(ctx context.Context) (int, bool) {
+	userId, ok := ctx.Value(userIdCtx).(int)
+	return userId, ok
+}
@@ -0,0 +1,139 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"denet-test-task/internal/api/v1/apierrs"
+	"denet-test-task/internal/services/idempotency"
+	"denet-test-task/pkg/logctx"
+	"encoding/hex"
+	"io"
+	"net/http"
+
+	chimw "github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	IdempotencyKeyHeader      = "Idempotency-Key"
+	IdempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	maxIdempotentRequestBytes = 1 << 20
+)
+
+type IdempotencyMiddleware struct {
+	IdempotencyService idempotency.Idempotency
+}
+
+// Idempotent processes a request sent with an Idempotency-Key header once per
+// user and key: a retry gets the original response replayed, and reusing the
+// key for a different request is rejected with 422. Server errors and
+// responses with Retry-After are not stored, so the request can be retried
+// with the same key. It must run after
+// AuthMiddleware.UserIdentity.
+func (h *IdempotencyMiddleware) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid Idempotency-Key header")
+			return
+		}
+
+		identity, ok := IdentityFromContext(r.Context())
+		if !ok {
+			apierrs.NewInvalidTokenResponseHTTP(w, apierrs.TokenInvalid, apierrs.ErrCannotParseToken.Error())
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentRequestBytes+1))
+		if err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "cannot read request body")
+			return
+		}
+		if len(body) > maxIdempotentRequestBytes {
+			apierrs.NewErrorResponseHTTP(w, http.StatusRequestEntityTooLarge, "request body too large")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		stored, replay, err := h.IdempotencyService.Begin(r.Context(), idempotency.IdempotencyBeginInput{
+			UserId:      identity.UserId,
+			Key:         key,
+			Fingerprint: requestFingerprint(r, body),
+		})
+		if err != nil {
+			switch err {
+			case idempotency.ErrKeyReused:
+				apierrs.NewErrorResponseHTTP(w, http.StatusUnprocessableEntity, err.Error())
+			case idempotency.ErrRequestInProgress:
+				apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+			default:
+				apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+			}
+			return
+		}
+
+		if replay {
+			if stored.ContentType != "" {
+				w.Header().Set("Content-Type", stored.ContentType)
+			}
+			w.Header().Set(IdempotentReplayedHeader, "true")
+			w.WriteHeader(*stored.Status)
+			_, _ = w.Write(stored.ResponseBody)
+			return
+		}
+
+		release := func() {
+			err := h.IdempotencyService.Release(r.Context(), idempotency.IdempotencyReleaseInput{UserId: identity.UserId, Key: key})
+			if err != nil {
+				logctx.FromContext(r.Context()).Warn("IdempotencyMiddleware.Idempotent: key not released", "err", err)
+			}
+		}
+
+		var response bytes.Buffer
+		ww := chimw.NewWrapResponseWriter(w, r.ProtoMajor)
+		ww.Tee(&response)
+
+		defer func() {
+			if rvr := recover(); rvr != nil {
+				release()
+				panic(rvr)
+			}
+		}()
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// failures and answers asking to retry later are not final
+		if status >= http.StatusInternalServerError || ww.Header().Get("Retry-After") != "" {
+			release()
+			return
+		}
+
+		err = h.IdempotencyService.Complete(r.Context(), idempotency.IdempotencyCompleteInput{
+			UserId:      identity.UserId,
+			Key:         key,
+			Status:      status,
+			ContentType: ww.Header().Get("Content-Type"),
+			Body:        response.Bytes(),
+		})
+		if err != nil {
+			// a key left in progress would block retries until it expires
+			release()
+		}
+	})
+}
+
+// requestFingerprint identifies the request a key was first used with.
+func requestFingerprint(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
@@ -1,12 +1,13 @@
 package middlewares
 
 import (
+	"context"
 	"log/slog"
 	"net"
 	"net/http"
-	"strings"
 	"time"
 
+	"denet-test-task/pkg/clientip"
 	"denet-test-task/pkg/logctx"
 
 	chimw "github.com/go-chi/chi/v5/middleware"
@@ -20,7 +21,7 @@ func SlogRequestContext(next http.Handler) http.Handler {
 			"req_id", reqID,
 			"method", r.Method,
 			"path", r.URL.Path,
-			"remote_ip", getRealIP(r),
+			"remote_ip", ClientIP(r),
 		)
 		next.ServeHTTP(w, r.WithContext(logctx.WithLogger(r.Context(), logger)))
 	})
@@ -42,20 +43,24 @@ func SlogAccessLogger(next http.Handler) http.Handler {
 	})
 }
 
-// getRealIP extracts client IP from common headers or RemoteAddr.
-func getRealIP(r *http.Request) string {
-	// X-Forwarded-For can be a comma-separated list; take the first non-empty
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		parts := strings.Split(xff, ",")
-		if len(parts) > 0 {
-			ip := strings.TrimSpace(parts[0])
-			if ip != "" {
-				return ip
-			}
-		}
+type ctxClientIPKey struct{}
+
+// ClientIPContext resolves the client address once per request, honoring the
+// forwarding headers only from trusted proxies.
+func ClientIPContext(resolver *clientip.Resolver) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), ctxClientIPKey{}, resolver.IP(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
 	}
-	if xrip := r.Header.Get("X-Real-IP"); xrip != "" {
-		return strings.TrimSpace(xrip)
+}
+
+// ClientIP returns the address resolved by ClientIPContext, or the host of
+// RemoteAddr when the middleware did not run.
+func ClientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(ctxClientIPKey{}).(string); ok && ip != "" {
+		return ip
 	}
 	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err == nil && host != "" {
@@ -0,0 +1,36 @@
+package v1
+
+import (
+	"denet-test-task/internal/api/v1/apierrs"
+	"denet-test-task/internal/api/v1/dto"
+	"denet-test-task/internal/services/rewards"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type rewardsRoutes struct {
+	rewardsService rewards.Rewards
+}
+
+func newRewardsRoutes(router chi.Router, rewardsService rewards.Rewards) {
+	routes := &rewardsRoutes{
+		rewardsService: rewardsService,
+	}
+
+	router.Get("/", routes.handleGetRewards)
+}
+
+func (r *rewardsRoutes) handleGetRewards(w http.ResponseWriter, req *http.Request) {
+
+	rewards, err := r.rewardsService.GetAvailableRewards(req.Context())
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(dto.NewRewards(rewards))
+}
@@ -2,16 +2,18 @@ package v1
 
 import (
 	apimv "denet-test-task/internal/api/v1/middlewares"
+	"denet-test-task/internal/entity"
 	service "denet-test-task/internal/services"
+	"denet-test-task/pkg/clientip"
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
 )
 
-func NewRouter(r chi.Router, services *service.Services) {
+func NewRouter(r chi.Router, services *service.Services, clientIP *clientip.Resolver) {
 	r.Use(chimw.RequestID)
-	r.Use(chimw.RealIP)
+	r.Use(apimv.ClientIPContext(clientIP))
 	r.Use(chimw.Recoverer)
 	r.Use(apimv.SlogRequestContext)
 	r.Use(apimv.SlogAccessLogger)
@@ -19,21 +21,35 @@ func NewRouter(r chi.Router, services *service.Services) {
 	r.Get("/health", func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
 	// r.Get("/swagger/*", httpSwagger.WrapHandler)
 
-	r.Route("/auth", func(cr chi.Router) {
-		newAuthRoutes(cr, services.Auth)
+	authMiddleware := &apimv.AuthMiddleware{AuthService: services.Auth}
+	idempotencyMiddleware := &apimv.IdempotencyMiddleware{IdempotencyService: services.Idempotency}
+
+	r.Route("/.well-known", func(wr chi.Router) {
+		newWellKnownRoutes(wr, services.Auth)
 	})
 
-	authMiddleware := &apimv.AuthMiddleware{AuthService: services.Auth}
+	r.Route("/auth", func(cr chi.Router) {
+		newAuthRoutes(cr, services.Auth, authMiddleware)
+	})
 
 	r.Route("/api/v1", func(api chi.Router) {
 		api.Use(authMiddleware.UserIdentity)
 
 		api.Route("/users", func(ur chi.Router) {
-			newUsersRoutes(ur, services.User, services.Tasks)
+			newUsersRoutes(ur, services.User, services.Tasks, services.Rewards, idempotencyMiddleware)
+		})
+
+		api.Route("/rewards", func(rr chi.Router) {
+			newRewardsRoutes(rr, services.Rewards)
 		})
 
 		api.Route("/tasks", func(tr chi.Router) {
 			newTasksRoutes(tr, services.Tasks)
 		})
+
+		api.Route("/admin", func(ar chi.Router) {
+			ar.Use(apimv.RequireRole(entity.RoleAdmin))
+			newAdminRoutes(ar, services.Auth, services.Tasks, services.User)
+		})
 	})
 }
@@ -2,6 +2,8 @@ package v1
 
 import (
 	"denet-test-task/internal/api/v1/apierrs"
+	"denet-test-task/internal/api/v1/dto"
+	apimv "denet-test-task/internal/api/v1/middlewares"
 	"denet-test-task/internal/services/tasks"
 	"encoding/json"
 	"net/http"
@@ -23,7 +25,13 @@ func newTasksRoutes(router chi.Router, tasksService tasks.Tasks) {
 
 func (r *tasksRoutes) handleGetTasks(w http.ResponseWriter, req *http.Request) {
 
-	tasks, err := r.tasksService.GetAllTasks(req.Context())
+	userId, ok := apimv.UserIdFromContext(req.Context())
+	if !ok {
+		apierrs.NewErrorResponseHTTP(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+
+	userTasks, err := r.tasksService.GetUserTasks(req.Context(), tasks.TasksGetUserTasksInput{UserId: userId})
 	if err != nil {
 		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
 		return
@@ -31,5 +39,5 @@ func (r *tasksRoutes) handleGetTasks(w http.ResponseWriter, req *http.Request) {
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(tasks)
+	_ = json.NewEncoder(w).Encode(dto.NewUserTasks(userTasks))
 }
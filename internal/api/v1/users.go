@@ -2,25 +2,52 @@ package v1
 
 import (
 	"denet-test-task/internal/api/v1/apierrs"
+	"denet-test-task/internal/api/v1/dto"
+	apimv "denet-test-task/internal/api/v1/middlewares"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/services/rewards"
 	"denet-test-task/internal/services/tasks"
 	"denet-test-task/internal/services/users"
 	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/validator"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 )
 
+// verificationRetryAfter is the delay, in seconds, suggested to clients
+// polling a task completion that is being verified.
+const verificationRetryAfter = "5"
+
+// defaultHistoryLimit is the size of the history page when no limit is given.
+const defaultHistoryLimit = 50
+
+type setEmailInput struct {
+	Email string `validate:"required,email"`
+}
+
+type verifyEmailInput struct {
+	Code string `validate:"required"`
+}
+
+type setTimezoneInput struct {
+	Timezone string `validate:"required"`
+}
+
 type usersRoutes struct {
-	usersService users.Users
-	tasksService tasks.Tasks
+	usersService   users.Users
+	tasksService   tasks.Tasks
+	rewardsService rewards.Rewards
 }
 
-func newUsersRoutes(router chi.Router, usersService users.Users, tasksService tasks.Tasks) {
+func newUsersRoutes(router chi.Router, usersService users.Users, tasksService tasks.Tasks, rewardsService rewards.Rewards, idempotencyMiddleware *apimv.IdempotencyMiddleware) {
 	routes := &usersRoutes{
-		usersService: usersService,
-		tasksService: tasksService,
+		usersService:   usersService,
+		tasksService:   tasksService,
+		rewardsService: rewardsService,
 	}
 
 	router.Get("/{user_id}/status", routes.handleGetUserStatus)
@@ -28,10 +55,27 @@ func newUsersRoutes(router chi.Router, usersService users.Users, tasksService ta
 	router.Get("/{user_id}/points", routes.handleGetPoints)
 	router.Get("/leaderboard", routes.handleGetLeaderboard)
 
-	router.Post("/{user_id}/referrer", routes.handleSetReferrer)
-	router.Post("/{user_id}/email", routes.handleSetEmail)
+	router.Group(func(or chi.Router) {
+		or.Use(apimv.Authorize(apimv.OwnerOf("user_id"), apimv.HasRole(entity.RoleAdmin)))
 
-	router.Post("/{user_id}/task/complete", routes.handleCompleteTask)
+		or.Get("/{user_id}/referrals", routes.handleGetReferrals)
+	})
+
+	// mutating routes act on behalf of the user and are restricted to the owner
+	router.Group(func(or chi.Router) {
+		or.Use(apimv.Authorize(apimv.OwnerOf("user_id"), apimv.HasRole(entity.RoleAdmin)))
+		or.Use(idempotencyMiddleware.Idempotent)
+
+		or.Post("/{user_id}/referrer", routes.handleSetReferrer)
+		or.Post("/{user_id}/email", routes.handleSetEmail)
+		or.Post("/{user_id}/email/verify", routes.handleVerifyEmail)
+
+		or.Post("/{user_id}/timezone", routes.handleSetTimezone)
+
+		or.Post("/{user_id}/accounts/{kind}", routes.handleLinkAccount)
+		or.Post("/{user_id}/task/complete", routes.handleCompleteTask)
+		or.Post("/{user_id}/rewards/{reward_id}/redeem", routes.handleRedeemReward)
+	})
 }
 
 func (r *usersRoutes) handleGetUserStatus(w http.ResponseWriter, req *http.Request) {
@@ -44,25 +88,34 @@ func (r *usersRoutes) handleGetUserStatus(w http.ResponseWriter, req *http.Reque
 		return
 	}
 
-	user, err := r.usersService.GetInfo(req.Context(), users.UsersGetInfoInput{UserId: userIdInt})
+	status, err := r.usersService.GetInfo(req.Context(), users.UsersGetInfoInput{UserId: userIdInt})
 	if err != nil {
-		logctx.FromContext(req.Context()).Error("usersRoutes.handleGetUserStatus - usersService.GetInfo", "err", err)
-		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		switch err {
+		case users.ErrUserNotFound:
+			apierrs.NewErrorResponseHTTP(w, http.StatusNotFound, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(user)
+	_ = json.NewEncoder(w).Encode(dto.NewUserStatus(status))
 }
 
 func (r *usersRoutes) handleGetHistory(w http.ResponseWriter, req *http.Request) {
 
-	limit := req.URL.Query().Get("limit")
-	limitInt, err := strconv.Atoi(limit)
-	if err != nil || limitInt <= 0 || limitInt > 100 {
-		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid limit")
-		return
+	query := req.URL.Query()
+
+	limitInt := defaultHistoryLimit
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		limitInt, err = strconv.Atoi(limit)
+		if err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
 	}
 
 	userId := chi.URLParam(req, "user_id")
@@ -72,15 +125,48 @@ func (r *usersRoutes) handleGetHistory(w http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	history, err := r.usersService.GetHistory(req.Context(), users.UsersGetHistoryInput{UserId: userIdInt, Limit: limitInt})
+	input := users.UsersGetHistoryInput{
+		UserId: userIdInt,
+		Limit:  limitInt,
+		Cursor: query.Get("cursor"),
+		Reason: entity.PointsReason(query.Get("type")),
+	}
+	if taskId := query.Get("task_id"); taskId != "" {
+		taskIdInt, err := strconv.Atoi(taskId)
+		if err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid task id")
+			return
+		}
+		input.TaskId = &taskIdInt
+	}
+	if from := query.Get("from"); from != "" {
+		if input.From, err = time.Parse(time.RFC3339, from); err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid from")
+			return
+		}
+	}
+	if to := query.Get("to"); to != "" {
+		if input.To, err = time.Parse(time.RFC3339, to); err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid to")
+			return
+		}
+	}
+
+	history, err := r.usersService.GetHistory(req.Context(), input)
 	if err != nil {
-		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		switch err {
+		case users.ErrInvalidHistoryLimit, users.ErrInvalidHistoryCursor, users.ErrInvalidPointsReason,
+			users.ErrInvalidHistoryPeriod:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, "internal server error")
+		}
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(history)
+	_ = json.NewEncoder(w).Encode(dto.NewPointsHistory(history))
 }
 
 func (r *usersRoutes) handleGetPoints(w http.ResponseWriter, req *http.Request) {
@@ -122,7 +208,7 @@ func (r *usersRoutes) handleGetLeaderboard(w http.ResponseWriter, req *http.Requ
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(leaderboard)
+	_ = json.NewEncoder(w).Encode(dto.NewLeaderboard(leaderboard))
 }
 
 func (r *usersRoutes) handleSetReferrer(w http.ResponseWriter, req *http.Request) {
@@ -134,14 +220,44 @@ func (r *usersRoutes) handleSetReferrer(w http.ResponseWriter, req *http.Request
 		return
 	}
 
-	referrer := req.FormValue("referrer")
-	referrerInt, err := strconv.Atoi(referrer)
+	// the referrer is given either by id or by referral code
+	input := users.UsersSetReferrerInput{UserId: userIdInt, ReferralCode: req.FormValue("referral_code")}
+	if input.ReferralCode == "" {
+		input.Referrer, err = strconv.Atoi(req.FormValue("referrer"))
+		if err != nil {
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid referrer")
+			return
+		}
+	}
+
+	err = r.usersService.SetReferrer(req.Context(), input)
+	if err != nil {
+		switch err {
+		case users.ErrReferrerCannotBeTheSameAsUser, users.ErrInvalidReferralCode:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		case users.ErrUserAlreadySetReferrer, users.ErrReferralCycle, users.ErrReferrerChainTooLong:
+			apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(nil)
+}
+
+func (r *usersRoutes) handleGetReferrals(w http.ResponseWriter, req *http.Request) {
+
+	userId := chi.URLParam(req, "user_id")
+	userIdInt, err := strconv.Atoi(userId)
 	if err != nil {
-		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid referrer")
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid user id")
 		return
 	}
 
-	err = r.usersService.SetReferrer(req.Context(), users.UsersSetReferrerInput{UserId: userIdInt, Referrer: referrerInt})
+	tree, err := r.usersService.GetReferrals(req.Context(), users.UsersGetReferralsInput{UserId: userIdInt})
 	if err != nil {
 		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
 		return
@@ -149,7 +265,7 @@ func (r *usersRoutes) handleSetReferrer(w http.ResponseWriter, req *http.Request
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	_ = json.NewEncoder(w).Encode(nil)
+	_ = json.NewEncoder(w).Encode(dto.NewReferralTree(tree))
 }
 
 func (r *usersRoutes) handleSetEmail(w http.ResponseWriter, req *http.Request) {
@@ -161,10 +277,54 @@ func (r *usersRoutes) handleSetEmail(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	email := req.FormValue("email")
-	err = r.usersService.SetEmail(req.Context(), users.UsersSetEmailInput{UserId: userIdInt, Email: email})
+	input := setEmailInput{Email: req.FormValue("email")}
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = r.usersService.SetEmail(req.Context(), users.UsersSetEmailInput{UserId: userIdInt, Email: input.Email})
 	if err != nil {
-		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		switch err {
+		case users.ErrEmailAlreadyUsed:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		case users.ErrVerificationSentRecently:
+			apierrs.NewErrorResponseHTTP(w, http.StatusTooManyRequests, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(w).Encode(nil)
+}
+
+func (r *usersRoutes) handleVerifyEmail(w http.ResponseWriter, req *http.Request) {
+
+	userId := chi.URLParam(req, "user_id")
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	input := verifyEmailInput{Code: req.FormValue("code")}
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = r.usersService.VerifyEmail(req.Context(), users.UsersVerifyEmailInput{UserId: userIdInt, Code: input.Code})
+	if err != nil {
+		switch err {
+		case users.ErrVerificationNotFound, users.ErrInvalidVerificationCode, users.ErrTooManyVerificationAttempts,
+			users.ErrEmailAlreadyUsed:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
@@ -173,6 +333,51 @@ func (r *usersRoutes) handleSetEmail(w http.ResponseWriter, req *http.Request) {
 	_ = json.NewEncoder(w).Encode(nil)
 }
 
+// handleLinkAccount links the account on the external service verifying
+// tasks of the kind. The proof is the form as is, so the data of the
+// Telegram Login Widget can be posted without changes.
+func (r *usersRoutes) handleLinkAccount(w http.ResponseWriter, req *http.Request) {
+
+	userId := chi.URLParam(req, "user_id")
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	if err := req.ParseForm(); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid form")
+		return
+	}
+	proof := make(map[string]string, len(req.Form))
+	for key := range req.Form {
+		proof[key] = req.Form.Get(key)
+	}
+
+	la, err := r.usersService.LinkAccount(req.Context(), users.UsersLinkAccountInput{
+		UserId: userIdInt,
+		Kind:   entity.TaskKind(chi.URLParam(req, "kind")),
+		Proof:  proof,
+	})
+	if err != nil {
+		switch err {
+		case users.ErrAccountNotLinkable, users.ErrInvalidAccountProof:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		case users.ErrAccountAlreadyLinked:
+			apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+		case users.ErrCannotLinkAccount:
+			apierrs.NewErrorResponseHTTP(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(dto.NewLinkedAccount(la))
+}
+
 func (r *usersRoutes) handleCompleteTask(w http.ResponseWriter, req *http.Request) {
 
 	userId := chi.URLParam(req, "user_id")
@@ -189,9 +394,26 @@ func (r *usersRoutes) handleCompleteTask(w http.ResponseWriter, req *http.Reques
 		return
 	}
 
-	err = r.usersService.CompleteTask(req.Context(), users.UsersCompleteTaskInput{UserId: userIdInt, TaskId: taskIdInt})
+	err = r.usersService.CompleteTask(req.Context(), users.UsersCompleteTaskInput{
+		UserId: userIdInt,
+		TaskId: taskIdInt,
+	})
 	if err != nil {
-		apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		switch err {
+		case users.ErrTaskNotFound, users.ErrTaskNotAllowedToComplete:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		case users.ErrTaskAlreadyCompleted, users.ErrTaskNotAvailable, users.ErrTaskNotVerified, users.ErrAccountAlreadyUsed,
+			users.ErrAccountNotLinked:
+			apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+		case users.ErrVerificationPending:
+			// not a final answer, so it is not stored for Idempotency-Key replays
+			w.Header().Set("Retry-After", verificationRetryAfter)
+			apierrs.NewErrorResponseHTTP(w, http.StatusAccepted, err.Error())
+		case users.ErrCannotVerifyTask:
+			apierrs.NewErrorResponseHTTP(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
@@ -199,3 +421,68 @@ func (r *usersRoutes) handleCompleteTask(w http.ResponseWriter, req *http.Reques
 	w.WriteHeader(http.StatusOK)
 	_ = json.NewEncoder(w).Encode(nil)
 }
+
+func (r *usersRoutes) handleSetTimezone(w http.ResponseWriter, req *http.Request) {
+
+	userId := chi.URLParam(req, "user_id")
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	input := setTimezoneInput{Timezone: req.FormValue("timezone")}
+	if err := validator.NewCustomValidator().Validate(input); err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err = r.usersService.SetTimezone(req.Context(), users.UsersSetTimezoneInput{UserId: userIdInt, Timezone: input.Timezone})
+	if err != nil {
+		switch err {
+		case users.ErrInvalidTimezone:
+			apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, err.Error())
+		case users.ErrUserNotFound:
+			apierrs.NewErrorResponseHTTP(w, http.StatusNotFound, err.Error())
+		case users.ErrTimezoneChangedRecently:
+			apierrs.NewErrorResponseHTTP(w, http.StatusTooManyRequests, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *usersRoutes) handleRedeemReward(w http.ResponseWriter, req *http.Request) {
+
+	userId := chi.URLParam(req, "user_id")
+	userIdInt, err := strconv.Atoi(userId)
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid user id")
+		return
+	}
+
+	rewardId := chi.URLParam(req, "reward_id")
+	rewardIdInt, err := strconv.Atoi(rewardId)
+	if err != nil {
+		apierrs.NewErrorResponseHTTP(w, http.StatusBadRequest, "invalid reward id")
+		return
+	}
+
+	err = r.rewardsService.Redeem(req.Context(), rewards.RewardsRedeemInput{UserId: userIdInt, RewardId: rewardIdInt})
+	if err != nil {
+		switch err {
+		case rewards.ErrRewardNotFound:
+			apierrs.NewErrorResponseHTTP(w, http.StatusNotFound, err.Error())
+		case rewards.ErrRewardNotAvailable, rewards.ErrInsufficientPoints:
+			apierrs.NewErrorResponseHTTP(w, http.StatusConflict, err.Error())
+		default:
+			apierrs.NewErrorResponseHTTP(w, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
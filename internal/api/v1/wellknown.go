@@ -0,0 +1,28 @@
+package v1
+
+import (
+	"denet-test-task/internal/services/auth"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type wellKnownRoutes struct {
+	authService auth.Auth
+}
+
+func newWellKnownRoutes(router chi.Router, authService auth.Auth) {
+	routes := &wellKnownRoutes{
+		authService: authService,
+	}
+
+	router.Get("/jwks.json", routes.handleJWKS)
+}
+
+func (r *wellKnownRoutes) handleJWKS(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(w).Encode(r.authService.JWKS())
+}
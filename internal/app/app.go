@@ -5,8 +5,12 @@ import (
 	"denet-test-task/config"
 	v1 "denet-test-task/internal/api/v1"
 	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/memdb"
 	"denet-test-task/internal/services"
-	"denet-test-task/pkg/hasher"
+	"denet-test-task/internal/services/auth"
+	"denet-test-task/internal/services/users"
+	"denet-test-task/internal/services/verification"
+	"denet-test-task/pkg/clientip"
 	"denet-test-task/pkg/httpserver"
 	"denet-test-task/pkg/logctx"
 	"denet-test-task/pkg/migrator"
@@ -44,8 +48,9 @@ func Run(configPath string) {
 
 	// Logger
 	configureLogging()
-	// root context logger
-	ctx := context.Background()
+	// root context logger, cancelled on shutdown to stop background work
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 	ctx = logctx.WithLogger(ctx, slog.With("app", "go-task-tracker"))
 	log := logctx.FromContext(ctx)
 
@@ -69,14 +74,102 @@ func Run(configPath string) {
 	log.Info("Initializing repositories...")
 	repositories := repo.NewRepositories(pg)
 
+	// Login attempts store
+	switch cfg.LoginThrottle.Store {
+	case "postgres":
+	case "memory":
+		repositories.LoginAttempts = memdb.NewLoginAttemptsRepo()
+	default:
+		log.Error("app - Run - unsupported login throttle store", "store", cfg.LoginThrottle.Store)
+		os.Exit(1)
+	}
+
+	// Password hasher
+	passwordHasher, err := newPasswordHasher(cfg.Hasher)
+	if err != nil {
+		log.Error("app - Run - newPasswordHasher", "err", err)
+		os.Exit(1)
+	}
+
+	// Notifier
+	notifier, err := newNotifier(cfg.Notifier)
+	if err != nil {
+		log.Error("app - Run - newNotifier", "err", err)
+		os.Exit(1)
+	}
+
+	// JWT signing keys
+	signingKeys, err := newSigningKeys(cfg.JWT)
+	if err != nil {
+		log.Error("app - Run - newSigningKeys", "err", err)
+		os.Exit(1)
+	}
+
+	// Streaks
+	streakCfg, err := newStreakConfig(cfg.Streak)
+	if err != nil {
+		log.Error("app - Run - newStreakConfig", "err", err)
+		os.Exit(1)
+	}
+
+	// Referrals
+	referralCfg, err := newReferralConfig(cfg.Referral)
+	if err != nil {
+		log.Error("app - Run - newReferralConfig", "err", err)
+		os.Exit(1)
+	}
+	referralRules, err := newReferralRules(cfg.Referral.Antifraud)
+	if err != nil {
+		log.Error("app - Run - newReferralRules", "err", err)
+		os.Exit(1)
+	}
+
 	// Services dependencies
 	log.Info("Initializing services...")
 	deps := services.ServicesDependencies{
 		Repos: repositories,
 		// GDrive:   gdrive.New(cfg.WebAPI.GDriveJSONFilePath),
-		Hasher:   hasher.NewSHA1Hasher(cfg.Hasher.Salt),
-		SignKey:  cfg.JWT.SignKey,
-		TokenTTL: cfg.JWT.TokenTTL,
+		Hasher:          passwordHasher,
+		Notifier:        notifier,
+		SigningKeys:     signingKeys,
+		TokenTTL:        cfg.JWT.TokenTTL,
+		RefreshTokenTTL: cfg.JWT.RefreshTokenTTL,
+		Issuer:          cfg.JWT.Issuer,
+		Audience:        cfg.JWT.Audience,
+		Leeway:          cfg.JWT.Leeway,
+		RequiredClaims:  cfg.JWT.RequiredClaims,
+
+		PasswordResetTokenTTL: cfg.PasswordReset.TokenTTL,
+
+		IdempotencyKeyTTL: cfg.Idempotency.KeyTTL,
+
+		TaskCatalogTTL: cfg.TaskCatalog.TTL,
+
+		Streak:        streakCfg,
+		Referral:      referralCfg,
+		ReferralRules: referralRules,
+
+		Verifiers: newVerifiers(cfg.Verification),
+		Verification: verification.Config{
+			ResultTTL:  cfg.Verification.ResultTTL,
+			PendingTTL: cfg.Verification.PendingTTL,
+		},
+
+		EmailVerification: users.EmailVerificationConfig{
+			CodeTTL:        cfg.EmailVerification.CodeTTL,
+			MaxAttempts:    cfg.EmailVerification.MaxAttempts,
+			ResendInterval: cfg.EmailVerification.ResendInterval,
+		},
+
+		RevocationCacheTTL: cfg.JWT.RevocationCacheTTL,
+
+		LoginThrottle: auth.LoginThrottleConfig{
+			UserFreeAttempts: cfg.LoginThrottle.UserFreeAttempts,
+			IPFreeAttempts:   cfg.LoginThrottle.IPFreeAttempts,
+			BaseLockout:      cfg.LoginThrottle.BaseLockout,
+			MaxLockout:       cfg.LoginThrottle.MaxLockout,
+			Window:           cfg.LoginThrottle.Window,
+		},
 	}
 	services, err := services.NewServices(ctx, deps)
 	if err != nil {
@@ -86,8 +179,13 @@ func Run(configPath string) {
 
 	// Handlers
 	log.Info("Initializing handlers and routes...")
+	clientIP, err := clientip.New(cfg.HTTP.TrustedProxies)
+	if err != nil {
+		log.Error("app - Run - clientip.New", "err", err)
+		os.Exit(1)
+	}
 	r := chi.NewRouter()
-	v1.NewRouter(r, services)
+	v1.NewRouter(r, services, clientIP)
 
 	// HTTP server
 	log.Info("Starting http server...")
@@ -108,6 +206,7 @@ func Run(configPath string) {
 
 	// Graceful shutdown
 	log.Info("Shutting down...")
+	cancel()
 	err = httpServer.Shutdown()
 	if err != nil {
 		log.Error("app - Run - httpServer.Shutdown", "err", err)
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"denet-test-task/config"
+	"denet-test-task/pkg/hasher"
+	"fmt"
+)
+
+// newPasswordHasher builds a hasher that hashes with the configured
+// algorithm and still verifies (and flags for rehash) every other known one,
+// including the legacy salted SHA-1.
+func newPasswordHasher(cfg config.Hasher) (hasher.PasswordHasher, error) {
+	argon2id := hasher.NewArgon2idHasher(hasher.Argon2idParams{
+		Memory:      cfg.Argon2Memory,
+		Iterations:  cfg.Argon2Iterations,
+		Parallelism: cfg.Argon2Parallelism,
+		SaltLength:  hasher.DefaultArgon2idParams.SaltLength,
+		KeyLength:   hasher.DefaultArgon2idParams.KeyLength,
+	})
+	bcrypt := hasher.NewBcryptHasher(cfg.BcryptCost)
+	legacy := hasher.NewSHA1Hasher(cfg.Salt)
+
+	switch cfg.Algorithm {
+	case "argon2id":
+		return hasher.NewChain(argon2id, bcrypt, legacy), nil
+	case "bcrypt":
+		return hasher.NewChain(bcrypt, argon2id, legacy), nil
+	default:
+		return nil, fmt.Errorf("unsupported hasher algorithm: %q", cfg.Algorithm)
+	}
+}
@@ -0,0 +1,51 @@
+package app
+
+import (
+	"denet-test-task/config"
+	"denet-test-task/pkg/jwks"
+	"fmt"
+)
+
+// newSigningKeys builds the JWT key set. HS256 uses the shared JWT_SIGN_KEY
+// secret; RS256/EdDSA load every configured PEM key, sign with the active one
+// and keep the others for verification during their grace period.
+func newSigningKeys(cfg config.JWT) (*jwks.KeySet, error) {
+	switch cfg.Algorithm {
+	case jwks.AlgHS256:
+		if cfg.SignKey == "" {
+			return nil, fmt.Errorf("JWT_SIGN_KEY is required for %s", jwks.AlgHS256)
+		}
+		return jwks.NewKeySet(jwks.NewHMACKey(cfg.ActiveKeyId, []byte(cfg.SignKey)), cfg.KeyGracePeriod)
+	case jwks.AlgRS256, jwks.AlgEdDSA:
+	default:
+		return nil, fmt.Errorf("unsupported JWT algorithm: %q", cfg.Algorithm)
+	}
+
+	var (
+		active   *jwks.Key
+		previous []jwks.Key
+	)
+	for _, keyCfg := range cfg.Keys {
+		algorithm := cfg.Algorithm
+		if keyCfg.Algorithm != "" {
+			algorithm = keyCfg.Algorithm
+		}
+
+		key, err := jwks.LoadPEMKey(keyCfg.Id, algorithm, keyCfg.Path)
+		if err != nil {
+			return nil, fmt.Errorf("load JWT key %q: %w", keyCfg.Id, err)
+		}
+		key.RetiredAt = keyCfg.RetiredAt
+
+		if keyCfg.Id == cfg.ActiveKeyId {
+			active = &key
+			continue
+		}
+		previous = append(previous, key)
+	}
+	if active == nil {
+		return nil, fmt.Errorf("active JWT key %q is not configured", cfg.ActiveKeyId)
+	}
+
+	return jwks.NewKeySet(*active, cfg.KeyGracePeriod, previous...)
+}
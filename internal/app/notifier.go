@@ -0,0 +1,30 @@
+package app
+
+import (
+	"denet-test-task/config"
+	"denet-test-task/pkg/notifier"
+	"fmt"
+)
+
+// newNotifier builds the notifier delivering messages to users.
+func newNotifier(cfg config.Notifier) (notifier.Notifier, error) {
+	switch cfg.Type {
+	case "log":
+		return notifier.NewLogNotifier(), nil
+	case "file":
+		return notifier.NewFileNotifier(cfg.FilePath), nil
+	case "smtp":
+		if cfg.SMTP.Host == "" || cfg.SMTP.From == "" {
+			return nil, fmt.Errorf("smtp notifier requires host and from")
+		}
+		return notifier.NewSMTPNotifier(notifier.SMTPConfig{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+		}), nil
+	default:
+		return nil, fmt.Errorf("unsupported notifier type: %q", cfg.Type)
+	}
+}
@@ -0,0 +1,42 @@
+package app
+
+import (
+	"denet-test-task/config"
+	"denet-test-task/internal/services/antifraud"
+	"denet-test-task/internal/services/users"
+	"fmt"
+)
+
+// newReferralConfig checks the referral settings.
+func newReferralConfig(cfg config.Referral) (users.ReferralConfig, error) {
+	if cfg.MaxChainDepth < 1 {
+		return users.ReferralConfig{}, fmt.Errorf("referral max chain depth must be positive")
+	}
+	if cfg.RecheckInterval <= 0 {
+		return users.ReferralConfig{}, fmt.Errorf("referral recheck interval must be positive")
+	}
+	return users.ReferralConfig{MaxChainDepth: cfg.MaxChainDepth, RecheckInterval: cfg.RecheckInterval}, nil
+}
+
+// newReferralRules builds the anti-fraud rules of referral rewards, leaving
+// out the rules turned off.
+func newReferralRules(cfg config.ReferralAntifraud) ([]antifraud.Rule, error) {
+	if cfg.IPv4Bits < 0 || cfg.IPv4Bits > 32 || cfg.IPv6Bits < 0 || cfg.IPv6Bits > 128 {
+		return nil, fmt.Errorf("invalid referral antifraud network size: /%d, /%d", cfg.IPv4Bits, cfg.IPv6Bits)
+	}
+	if cfg.MinAccountAge < 0 || cfg.DailyCap < 0 || cfg.MinCompletedTasks < 0 {
+		return nil, fmt.Errorf("referral antifraud limits must not be negative")
+	}
+
+	rules := []antifraud.Rule{antifraud.SameNetworkRule{IPv4Bits: cfg.IPv4Bits, IPv6Bits: cfg.IPv6Bits}}
+	if cfg.MinAccountAge > 0 {
+		rules = append(rules, antifraud.AccountAgeRule{MinAge: cfg.MinAccountAge})
+	}
+	if cfg.DailyCap > 0 {
+		rules = append(rules, antifraud.DailyCapRule{Cap: cfg.DailyCap})
+	}
+	if cfg.MinCompletedTasks > 0 {
+		rules = append(rules, antifraud.CompletedTasksRule{MinTasks: cfg.MinCompletedTasks})
+	}
+	return rules, nil
+}
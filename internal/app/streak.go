@@ -0,0 +1,24 @@
+package app
+
+import (
+	"denet-test-task/config"
+	"denet-test-task/internal/services/users"
+	"fmt"
+)
+
+// newStreakConfig checks the streak settings.
+func newStreakConfig(cfg config.Streak) (users.StreakConfig, error) {
+	if cfg.GraceDays < 0 {
+		return users.StreakConfig{}, fmt.Errorf("streak grace days must not be negative")
+	}
+
+	multipliers := make([]users.StreakMultiplier, 0, len(cfg.Multipliers))
+	for _, m := range cfg.Multipliers {
+		if m.Days < 1 || m.Multiplier <= 0 {
+			return users.StreakConfig{}, fmt.Errorf("invalid streak multiplier: %d days, %v", m.Days, m.Multiplier)
+		}
+		multipliers = append(multipliers, users.StreakMultiplier{Days: m.Days, Multiplier: m.Multiplier})
+	}
+
+	return users.StreakConfig{GraceDays: cfg.GraceDays, Multipliers: multipliers}, nil
+}
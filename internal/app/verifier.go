@@ -0,0 +1,30 @@
+package app
+
+import (
+	"denet-test-task/config"
+	"denet-test-task/internal/entity"
+	"denet-test-task/pkg/verifier"
+	"net/http"
+)
+
+// newVerifiers builds the verifiers of the task kinds that are configured.
+// Completions of the other kinds requiring verification are refused.
+func newVerifiers(cfg config.Verification) map[entity.TaskKind]verifier.Verifier {
+	client := &http.Client{Timeout: cfg.Timeout}
+
+	verifiers := make(map[entity.TaskKind]verifier.Verifier)
+	if cfg.Telegram.BotToken != "" {
+		verifiers[entity.TaskKindTelegramSubscription] = verifier.NewTelegramVerifier(verifier.TelegramConfig{
+			APIURL:   cfg.Telegram.APIURL,
+			BotToken: cfg.Telegram.BotToken,
+			LoginTTL: cfg.Telegram.LoginTTL,
+		}, client)
+	}
+	if cfg.Callback.URL != "" {
+		verifiers[entity.TaskKindExternalVerification] = verifier.NewCallbackVerifier(verifier.CallbackConfig{
+			URL:    cfg.Callback.URL,
+			Secret: cfg.Callback.Secret,
+		}, client)
+	}
+	return verifiers
+}
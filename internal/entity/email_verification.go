@@ -0,0 +1,16 @@
+package entity
+
+import "time"
+
+// EmailVerification is a code sent to prove the ownership of an email. Only
+// the latest unused verification of a user is valid.
+type EmailVerification struct {
+	Id        int        `db:"id"`
+	UserId    int        `db:"user_id"`
+	Email     string     `db:"email"`
+	CodeHash  string     `db:"code_hash"`
+	Attempts  int        `db:"attempts"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
@@ -0,0 +1,16 @@
+package entity
+
+import "time"
+
+// IdempotencyKey is the stored outcome of a request sent with an
+// Idempotency-Key header. Status is nil while the request is in progress.
+type IdempotencyKey struct {
+	UserId       int       `db:"user_id"`
+	Key          string    `db:"key"`
+	Fingerprint  string    `db:"fingerprint"`
+	Status       *int      `db:"status"`
+	ContentType  string    `db:"content_type"`
+	ResponseBody []byte    `db:"response_body"`
+	CreatedAt    time.Time `db:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at"`
+}
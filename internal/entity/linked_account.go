@@ -0,0 +1,12 @@
+package entity
+
+import "time"
+
+// LinkedAccount is the account of the user on the external service that
+// verifies tasks of the kind. The user proved they own it at LinkedAt.
+type LinkedAccount struct {
+	UserId   int       `db:"user_id"`
+	Kind     TaskKind  `db:"kind"`
+	Account  string    `db:"account"`
+	LinkedAt time.Time `db:"linked_at"`
+}
@@ -0,0 +1,11 @@
+package entity
+
+import "time"
+
+// LoginAttempt counts recent failed sign-ins for a key (account or client IP).
+type LoginAttempt struct {
+	Key          string     `db:"key"`
+	Failures     int        `db:"failures"`
+	LastFailedAt time.Time  `db:"last_failed_at"`
+	LockedUntil  *time.Time `db:"locked_until"`
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// PasswordResetToken is a single-use token allowing to set a new password
+// without the old one. Only the hash of the token is stored.
+type PasswordResetToken struct {
+	Id        int        `db:"id"`
+	UserId    int        `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	UsedAt    *time.Time `db:"used_at"`
+}
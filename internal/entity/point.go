@@ -2,9 +2,64 @@ package entity
 
 import "time"
 
-type Point struct {
-	UserId int       `db:"user_id"`
-	TaskId int       `db:"task_id"`
-	Points int       `db:"points"`
-	UpdAt  time.Time `db:"upd_at"`
+// PointsReason tells why points were granted or taken.
+type PointsReason string
+
+const (
+	PointsReasonTaskCompleted    PointsReason = "task_completed"
+	PointsReasonReferralGiven    PointsReason = "referral_given"
+	PointsReasonReferralReceived PointsReason = "referral_received"
+	PointsReasonReferralEarnings PointsReason = "referral_earnings"
+	PointsReasonEmailVerified    PointsReason = "email_verified"
+	PointsReasonRewardRedeemed   PointsReason = "reward_redeemed"
+)
+
+// PointsEntry is a row of the append-only points ledger. Delta is negative
+// when points are taken. An entry with an IdempotencyKey already in the
+// ledger is never applied twice. ReferralId is the referral the points of a
+// referrer were earned for.
+type PointsEntry struct {
+	Id             int64        `db:"id"`
+	UserId         int          `db:"user_id"`
+	TaskId         *int         `db:"task_id"`
+	RewardId       *int         `db:"reward_id"`
+	ReferralId     *int         `db:"referral_id"`
+	Delta          int          `db:"delta"`
+	Reason         PointsReason `db:"reason"`
+	IdempotencyKey *string      `db:"idempotency_key"`
+	CreatedAt      time.Time    `db:"created_at"`
+}
+
+func (r PointsReason) Valid() bool {
+	switch r {
+	case PointsReasonTaskCompleted, PointsReasonReferralGiven, PointsReasonReferralReceived,
+		PointsReasonReferralEarnings, PointsReasonEmailVerified, PointsReasonRewardRedeemed:
+		return true
+	}
+	return false
+}
+
+// PointsCursor is the position of an entry in the history of a user, which
+// is ordered newest first.
+type PointsCursor struct {
+	CreatedAt time.Time
+	Id        int64
+}
+
+// PointsHistoryFilter selects the entries of the history of a user. Zero
+// fields do not filter; From is inclusive, To is exclusive. After is the
+// cursor of the last entry of the previous page.
+type PointsHistoryFilter struct {
+	TaskId *int
+	Reason PointsReason
+	From   time.Time
+	To     time.Time
+	After  *PointsCursor
+}
+
+// PointsHistory is a page of the history of a user. NextCursor is empty on
+// the last page.
+type PointsHistory struct {
+	Entries    []PointsEntry
+	NextCursor string
 }
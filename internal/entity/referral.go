@@ -0,0 +1,83 @@
+package entity
+
+import "time"
+
+// ReferralTier is the percent of the task points earned by the referrals at
+// Level paid to the referrer. Level 1 are the direct referrals.
+type ReferralTier struct {
+	Level   int     `db:"level"`
+	Percent float64 `db:"percent"`
+}
+
+// UplineTier is a referrer of the user at the level of the tier.
+type UplineTier struct {
+	ReferrerId int `db:"referrer_id"`
+	ReferralTier
+}
+
+// Referral is a user in the referral tree of a referrer, Level below them.
+// Earned is what the referrer got for the referral.
+type Referral struct {
+	Id         int       `db:"id"`
+	Username   string    `db:"username"`
+	ReferrerId int       `db:"referrer"`
+	Level      int       `db:"level"`
+	Earned     int       `db:"earned"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+// ReferralNode is a referral with their own referrals.
+type ReferralNode struct {
+	Referral
+	Referrals []ReferralNode
+}
+
+// ReferralLevel sums up the referrals at a level of the tree.
+type ReferralLevel struct {
+	Level     int
+	Percent   float64
+	Referrals int
+	Earned    int
+}
+
+// ReferralTree is the referrals of a user down to the deepest paid level.
+type ReferralTree struct {
+	Levels    []ReferralLevel
+	Referrals []ReferralNode
+}
+
+// ReferralRewardStatus tells where a referral reward is in the anti-fraud
+// review.
+type ReferralRewardStatus string
+
+const (
+	// ReferralRewardPending rewards wait for the referral to meet the rules.
+	ReferralRewardPending ReferralRewardStatus = "pending"
+	// ReferralRewardReview rewards wait for an admin.
+	ReferralRewardReview   ReferralRewardStatus = "review"
+	ReferralRewardReleased ReferralRewardStatus = "released"
+	ReferralRewardRejected ReferralRewardStatus = "rejected"
+)
+
+func (s ReferralRewardStatus) Valid() bool {
+	switch s {
+	case ReferralRewardPending, ReferralRewardReview, ReferralRewardReleased, ReferralRewardRejected:
+		return true
+	}
+	return false
+}
+
+// ReferralReward is the bonus of the referral_giver task the referrer gets
+// for the referral once the anti-fraud rules or an admin release it. Reason
+// is the rule that held, flagged or rejected the reward.
+type ReferralReward struct {
+	Id         int                  `db:"id"`
+	ReferrerId int                  `db:"referrer_id"`
+	ReferralId int                  `db:"referral_id"`
+	TaskId     int                  `db:"task_id"`
+	Points     int                  `db:"points"`
+	Status     ReferralRewardStatus `db:"status"`
+	Reason     string               `db:"reason"`
+	CreatedAt  time.Time            `db:"created_at"`
+	DecidedAt  *time.Time           `db:"decided_at"`
+}
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// RefreshToken is a persisted refresh token. Only the hash of the token is stored;
+// tokens issued by rotating one another share the same FamilyId.
+type RefreshToken struct {
+	Id        int        `db:"id"`
+	UserId    int        `db:"user_id"`
+	TokenHash string     `db:"token_hash"`
+	FamilyId  string     `db:"family_id"`
+	ExpiresAt time.Time  `db:"expires_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	RevokedAt *time.Time `db:"revoked_at"`
+}
@@ -0,0 +1,11 @@
+package entity
+
+import "time"
+
+// RevokedToken is an access token revoked before its expiry, identified by its jti.
+type RevokedToken struct {
+	Jti       string    `db:"jti"`
+	UserId    int       `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+	RevokedAt time.Time `db:"revoked_at"`
+}
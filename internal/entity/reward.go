@@ -0,0 +1,26 @@
+package entity
+
+import "time"
+
+// Reward is an item users can spend points on. A nil Stock is unlimited and
+// a nil bound of the active window is open.
+type Reward struct {
+	Id          int        `db:"id"`
+	Name        string     `db:"name"`
+	Descr       string     `db:"descr"`
+	Cost        int        `db:"cost"`
+	Stock       *int       `db:"stock"`
+	ActiveFrom  *time.Time `db:"active_from"`
+	ActiveUntil *time.Time `db:"active_until"`
+}
+
+// ActiveAt reports whether t is within the active window of the reward.
+func (r Reward) ActiveAt(t time.Time) bool {
+	if r.ActiveFrom != nil && t.Before(*r.ActiveFrom) {
+		return false
+	}
+	if r.ActiveUntil != nil && !t.Before(*r.ActiveUntil) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// Streak counts the consecutive days the user completed a task of the streak
+// group on. LastDay is the date of the last completion in the user's
+// timezone, stored as midnight UTC.
+type Streak struct {
+	UserId  int       `db:"user_id"`
+	Group   string    `db:"streak_group"`
+	Current int       `db:"current_streak"`
+	Longest int       `db:"longest_streak"`
+	LastDay time.Time `db:"last_day"`
+}
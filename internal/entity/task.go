@@ -1,8 +1,129 @@
 package entity
 
+import "time"
+
+// TaskKind decides how a task is completed.
+type TaskKind string
+
+const (
+	// TaskKindManual tasks are completed by the user through CompleteTask.
+	TaskKindManual TaskKind = "manual"
+	// TaskKindReferralGiver tasks are granted to the referrer when a user sets them as referrer.
+	TaskKindReferralGiver TaskKind = "referral_giver"
+	// TaskKindReferralReceiver tasks are granted to the user who sets a referrer.
+	TaskKindReferralReceiver TaskKind = "referral_receiver"
+	// TaskKindEmail tasks are granted when the user verifies their email.
+	TaskKindEmail TaskKind = "email"
+	// TaskKindExternalVerification tasks are completed by the user for an
+	// action on an external service, checked by the verification callback.
+	TaskKindExternalVerification TaskKind = "external_verification"
+	// TaskKindTelegramSubscription tasks are completed by the user for
+	// joining the Telegram chat in the "chat" metadata, checked with the Bot API.
+	TaskKindTelegramSubscription TaskKind = "telegram_subscription"
+)
+
+func (k TaskKind) Valid() bool {
+	switch k {
+	case TaskKindManual, TaskKindReferralGiver, TaskKindReferralReceiver, TaskKindEmail, TaskKindExternalVerification,
+		TaskKindTelegramSubscription:
+		return true
+	}
+	return false
+}
+
+// CompletedByUser reports whether users complete tasks of the kind through
+// CompleteTask. Tasks of the other kinds are granted by the flow they belong to.
+func (k TaskKind) CompletedByUser() bool {
+	return k == TaskKindManual || k.RequiresVerification()
+}
+
+// RequiresVerification reports whether a completion of a task of the kind is
+// granted only after an external service confirms it.
+func (k TaskKind) RequiresVerification() bool {
+	return k == TaskKindExternalVerification || k == TaskKindTelegramSubscription
+}
+
+// TaskRecurrence is how often the completions of a task are renewed.
+type TaskRecurrence string
+
+const (
+	TaskRecurrenceOnce   TaskRecurrence = "once"
+	TaskRecurrenceDaily  TaskRecurrence = "daily"
+	TaskRecurrenceWeekly TaskRecurrence = "weekly"
+)
+
+func (r TaskRecurrence) Valid() bool {
+	switch r {
+	case TaskRecurrenceOnce, TaskRecurrenceDaily, TaskRecurrenceWeekly:
+		return true
+	}
+	return false
+}
+
+// Periodic reports whether the completions are renewed every period.
+func (r TaskRecurrence) Periodic() bool {
+	return r == TaskRecurrenceDaily || r == TaskRecurrenceWeekly
+}
+
+// Task is an action users are granted points for. A task with ArchivedAt set
+// is hidden from users and cannot be completed. Metadata holds kind specific
+// settings, such as the channel of an external verification.
+//
+// A task can be completed CompletionLimit times per period of its
+// recurrence, and only within its window; a nil bound of the window is open.
+// Completions of the tasks sharing a non-empty StreakGroup make up a streak.
 type Task struct {
-	Id     int    `db:"id"`
-	Name   string `db:"name"`
-	Descr  string `db:"descr"`
-	Points int    `db:"points"`
+	Id              int            `db:"id"`
+	Name            string         `db:"name"`
+	Descr           string         `db:"descr"`
+	Points          int            `db:"points"`
+	Kind            TaskKind       `db:"kind"`
+	Metadata        map[string]any `db:"metadata"`
+	StartsAt        *time.Time     `db:"starts_at"`
+	EndsAt          *time.Time     `db:"ends_at"`
+	Recurrence      TaskRecurrence `db:"recurrence"`
+	CompletionLimit int            `db:"completion_limit"`
+	StreakGroup     string         `db:"streak_group"`
+	ArchivedAt      *time.Time     `db:"archived_at"`
+}
+
+// ActiveAt reports whether t is within the window of the task.
+func (t Task) ActiveAt(at time.Time) bool {
+	if t.StartsAt != nil && at.Before(*t.StartsAt) {
+		return false
+	}
+	if t.EndsAt != nil && !at.Before(*t.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// Period returns the bounds of the recurrence period containing at, with days
+// starting at midnight in loc and weeks on Monday. A one-time task has a
+// single period covering all time, returned as zero times.
+func (t Task) Period(at time.Time, loc *time.Location) (start time.Time, end time.Time) {
+	y, m, d := at.In(loc).Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, loc)
+
+	switch t.Recurrence {
+	case TaskRecurrenceDaily:
+		return day, day.AddDate(0, 0, 1)
+	case TaskRecurrenceWeekly:
+		// days since Monday
+		offset := (int(day.Weekday()) + 6) % 7
+		start = day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7)
+	}
+	return time.Time{}, time.Time{}
+}
+
+// UserTask is a task as seen by a user: the completions in the current
+// period and whether the user can complete it now.
+type UserTask struct {
+	Task
+	Completions int
+	Available   bool
+	// NextResetAt is the start of the next period of a recurring task, nil
+	// for a one-time task or when the task ends before it
+	NextResetAt *time.Time
 }
@@ -0,0 +1,14 @@
+package entity
+
+import "time"
+
+// TaskAccount binds an account on an external service to the user who
+// verified a task with it. Status is the outcome of the last check of the
+// account, made at CheckedAt: "verified", "rejected" or "pending".
+type TaskAccount struct {
+	TaskId    int       `db:"task_id"`
+	Account   string    `db:"account"`
+	UserId    int       `db:"user_id"`
+	Status    string    `db:"status"`
+	CheckedAt time.Time `db:"checked_at"`
+}
@@ -2,11 +2,61 @@ package entity
 
 import "time"
 
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+func (r Role) Valid() bool {
+	switch r {
+	case RoleUser, RoleModerator, RoleAdmin:
+		return true
+	}
+	return false
+}
+
 type User struct {
-	Id        int       `db:"id"`
-	Username  string    `db:"username"`
-	Password  string    `db:"password"`
-	CreatedAt time.Time `db:"created_at"`
-	Referrer  *string   `db:"referrer"`
-	Email     *string   `db:"email"`
+	Id           int       `db:"id"`
+	Username     string    `db:"username"`
+	Password     string    `db:"password"`
+	CreatedAt    time.Time `db:"created_at"`
+	Referrer     *int      `db:"referrer"`
+	ReferralCode string    `db:"referral_code"`
+	Email        *string   `db:"email"`
+	Role         Role      `db:"role"`
+	TokenVersion int       `db:"token_version"`
+	// EmailVerifiedAt is nil until the owner of Email confirms it
+	EmailVerifiedAt *time.Time `db:"email_verified_at"`
+	// Timezone is the IANA name of the zone periods of recurring tasks are counted in
+	Timezone string `db:"timezone"`
+	// SignupIP is the address the user signed up from, nil for older users
+	SignupIP *string `db:"signup_ip"`
+}
+
+// Location returns the timezone of the user, UTC if it is unknown.
+func (u User) Location() *time.Location {
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// EmailVerified reports whether the owner of Email confirmed it.
+func (u User) EmailVerified() bool {
+	return u.Email != nil && u.EmailVerifiedAt != nil
+}
+
+// UserStatus is the user with their streaks and standing. Rank is the place
+// of the user on the leaderboard, CompletedTasks the number of completions
+// of tasks.
+type UserStatus struct {
+	User
+	Streaks        []Streak
+	Points         int
+	Rank           int
+	CompletedTasks int
 }
@@ -0,0 +1,84 @@
+package memdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"sync"
+	"time"
+)
+
+// LoginAttemptsRepo keeps failed sign-in counters in process memory. Counters
+// are not shared between instances and are lost on restart; use the Postgres
+// implementation when running several instances.
+type LoginAttemptsRepo struct {
+	mu       sync.Mutex
+	attempts map[string]entity.LoginAttempt
+}
+
+func NewLoginAttemptsRepo() *LoginAttemptsRepo {
+	return &LoginAttemptsRepo{attempts: make(map[string]entity.LoginAttempt)}
+}
+
+func (r *LoginAttemptsRepo) GetLoginAttempt(_ context.Context, key string) (entity.LoginAttempt, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok {
+		return entity.LoginAttempt{}, repoerrs.ErrNotFound
+	}
+	return attempt, nil
+}
+
+func (r *LoginAttemptsRepo) RegisterLoginFailure(_ context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok || !attempt.LastFailedAt.After(at.Add(-window)) {
+		attempt = entity.LoginAttempt{Key: key, LockedUntil: attempt.LockedUntil}
+	}
+	attempt.Failures++
+	attempt.LastFailedAt = at
+	r.attempts[key] = attempt
+
+	return attempt.Failures, nil
+}
+
+func (r *LoginAttemptsRepo) LockLogin(_ context.Context, key string, until time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attempt, ok := r.attempts[key]
+	if !ok {
+		return nil
+	}
+	attempt.LockedUntil = &until
+	r.attempts[key] = attempt
+	return nil
+}
+
+func (r *LoginAttemptsRepo) ResetLoginAttempts(_ context.Context, key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.attempts, key)
+	return nil
+}
+
+// DeleteStaleLoginAttempts drops the counters that are neither recent nor
+// locked.
+func (r *LoginAttemptsRepo) DeleteStaleLoginAttempts(_ context.Context, at time.Time, window time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, attempt := range r.attempts {
+		stale := !attempt.LastFailedAt.After(at.Add(-window))
+		locked := attempt.LockedUntil != nil && at.Before(*attempt.LockedUntil)
+		if stale && !locked {
+			delete(r.attempts, key)
+		}
+	}
+	return nil
+}
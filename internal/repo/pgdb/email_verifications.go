@@ -0,0 +1,101 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type EmailVerificationsRepo struct {
+	*postgres.Postgres
+}
+
+func NewEmailVerificationsRepo(pg *postgres.Postgres) *EmailVerificationsRepo {
+	return &EmailVerificationsRepo{pg}
+}
+
+// CreateEmailVerification stores a new verification and invalidates the
+// pending ones of the user, so only the latest code can be used.
+func (r *EmailVerificationsRepo) CreateEmailVerification(ctx context.Context, verification entity.EmailVerification) error {
+	sql, args, _ := r.Builder.
+		Insert("email_verifications").
+		Prefix("WITH invalidated AS (UPDATE email_verifications SET used_at = now() WHERE user_id = ? AND used_at IS NULL)", verification.UserId).
+		Columns("user_id", "email", "code_hash", "expires_at").
+		Values(verification.UserId, verification.Email, verification.CodeHash, verification.ExpiresAt).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("EmailVerificationsRepo.CreateEmailVerification - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *EmailVerificationsRepo) GetPendingEmailVerification(ctx context.Context, userId int) (entity.EmailVerification, error) {
+	sql, args, _ := r.Builder.
+		Select("id, user_id, email, code_hash, attempts, expires_at, created_at, used_at").
+		From("email_verifications").
+		Where("user_id = ? AND used_at IS NULL", userId).
+		OrderBy("id DESC").
+		Limit(1).
+		ToSql()
+
+	var verification entity.EmailVerification
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&verification.Id,
+		&verification.UserId,
+		&verification.Email,
+		&verification.CodeHash,
+		&verification.Attempts,
+		&verification.ExpiresAt,
+		&verification.CreatedAt,
+		&verification.UsedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.EmailVerification{}, repoerrs.ErrNotFound
+		}
+		return entity.EmailVerification{}, fmt.Errorf("EmailVerificationsRepo.GetPendingEmailVerification - r.Pool.QueryRow: %v", err)
+	}
+
+	return verification, nil
+}
+
+func (r *EmailVerificationsRepo) IncrementEmailVerificationAttempts(ctx context.Context, id int) error {
+	sql, args, _ := r.Builder.
+		Update("email_verifications").
+		Set("attempts", squirrel.Expr("attempts + 1")).
+		Where("id = ?", id).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("EmailVerificationsRepo.IncrementEmailVerificationAttempts - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+// UseEmailVerification marks the verification used. It returns
+// repoerrs.ErrNotFound if it has already been used.
+func (r *EmailVerificationsRepo) UseEmailVerification(ctx context.Context, id int) error {
+	sql, args, _ := r.Builder.
+		Update("email_verifications").
+		Set("used_at", squirrel.Expr("now()")).
+		Where("id = ? AND used_at IS NULL", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("EmailVerificationsRepo.UseEmailVerification - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type IdempotencyKeysRepo struct {
+	*postgres.Postgres
+}
+
+func NewIdempotencyKeysRepo(pg *postgres.Postgres) *IdempotencyKeysRepo {
+	return &IdempotencyKeysRepo{pg}
+}
+
+// CreateIdempotencyKey stores the key as in progress. An expired key is
+// replaced; it returns repoerrs.ErrAlreadyExists if the key is still live.
+func (r *IdempotencyKeysRepo) CreateIdempotencyKey(ctx context.Context, key entity.IdempotencyKey) error {
+	sql, args, _ := r.Builder.
+		Insert("idempotency_keys").
+		Columns("user_id", "key", "fingerprint", "expires_at").
+		Values(key.UserId, key.Key, key.Fingerprint, key.ExpiresAt).
+		Suffix(`ON CONFLICT (user_id, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint,
+			status = NULL,
+			content_type = '',
+			response_body = NULL,
+			created_at = now(),
+			expires_at = EXCLUDED.expires_at
+			WHERE idempotency_keys.expires_at <= now()`).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("IdempotencyKeysRepo.CreateIdempotencyKey - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrAlreadyExists
+	}
+	return nil
+}
+
+func (r *IdempotencyKeysRepo) GetIdempotencyKey(ctx context.Context, userId int, key string) (entity.IdempotencyKey, error) {
+	sql, args, _ := r.Builder.
+		Select("user_id, key, fingerprint, status, content_type, response_body, created_at, expires_at").
+		From("idempotency_keys").
+		Where("user_id = ? AND key = ?", userId, key).
+		ToSql()
+
+	var idempotencyKey entity.IdempotencyKey
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&idempotencyKey.UserId,
+		&idempotencyKey.Key,
+		&idempotencyKey.Fingerprint,
+		&idempotencyKey.Status,
+		&idempotencyKey.ContentType,
+		&idempotencyKey.ResponseBody,
+		&idempotencyKey.CreatedAt,
+		&idempotencyKey.ExpiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.IdempotencyKey{}, repoerrs.ErrNotFound
+		}
+		return entity.IdempotencyKey{}, fmt.Errorf("IdempotencyKeysRepo.GetIdempotencyKey - r.Pool.QueryRow: %v", err)
+	}
+
+	return idempotencyKey, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request.
+func (r *IdempotencyKeysRepo) CompleteIdempotencyKey(ctx context.Context, key entity.IdempotencyKey) error {
+	sql, args, _ := r.Builder.
+		Update("idempotency_keys").
+		Set("status", key.Status).
+		Set("content_type", key.ContentType).
+		Set("response_body", key.ResponseBody).
+		Where("user_id = ? AND key = ?", key.UserId, key.Key).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("IdempotencyKeysRepo.CompleteIdempotencyKey - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *IdempotencyKeysRepo) DeleteIdempotencyKey(ctx context.Context, userId int, key string) error {
+	sql, args, _ := r.Builder.
+		Delete("idempotency_keys").
+		Where("user_id = ? AND key = ?", userId, key).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("IdempotencyKeysRepo.DeleteIdempotencyKey - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *IdempotencyKeysRepo) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) error {
+	sql, args, _ := r.Builder.
+		Delete("idempotency_keys").
+		Where("expires_at <= ?", before).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("IdempotencyKeysRepo.DeleteExpiredIdempotencyKeys - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
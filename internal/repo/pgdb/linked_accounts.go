@@ -0,0 +1,66 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+type LinkedAccountsRepo struct {
+	*postgres.Postgres
+}
+
+func NewLinkedAccountsRepo(pg *postgres.Postgres) *LinkedAccountsRepo {
+	return &LinkedAccountsRepo{pg}
+}
+
+func (r *LinkedAccountsRepo) GetLinkedAccount(ctx context.Context, userId int, kind entity.TaskKind) (entity.LinkedAccount, error) {
+	sql, args, _ := r.Builder.
+		Select("user_id, kind, account, linked_at").
+		From("linked_accounts").
+		Where("user_id = ? AND kind = ?", userId, kind).
+		ToSql()
+
+	var la entity.LinkedAccount
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&la.UserId,
+		&la.Kind,
+		&la.Account,
+		&la.LinkedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.LinkedAccount{}, repoerrs.ErrNotFound
+		}
+		return entity.LinkedAccount{}, fmt.Errorf("LinkedAccountsRepo.GetLinkedAccount - r.Pool.QueryRow: %v", err)
+	}
+	return la, nil
+}
+
+// SaveLinkedAccount links the account to the user, replacing the account
+// the user linked for the kind before. It returns repoerrs.ErrAlreadyExists
+// if the account is linked to another user.
+func (r *LinkedAccountsRepo) SaveLinkedAccount(ctx context.Context, la entity.LinkedAccount) error {
+	sql, args, _ := r.Builder.
+		Insert("linked_accounts").
+		Columns("user_id", "kind", "account", "linked_at").
+		Values(la.UserId, la.Kind, la.Account, la.LinkedAt).
+		Suffix("ON CONFLICT (user_id, kind) DO UPDATE SET account = EXCLUDED.account, linked_at = EXCLUDED.linked_at").
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return repoerrs.ErrAlreadyExists
+		}
+		return fmt.Errorf("LinkedAccountsRepo.SaveLinkedAccount - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type LoginAttemptsRepo struct {
+	*postgres.Postgres
+}
+
+func NewLoginAttemptsRepo(pg *postgres.Postgres) *LoginAttemptsRepo {
+	return &LoginAttemptsRepo{pg}
+}
+
+func (r *LoginAttemptsRepo) GetLoginAttempt(ctx context.Context, key string) (entity.LoginAttempt, error) {
+	sql, args, _ := r.Builder.
+		Select("key, failures, last_failed_at, locked_until").
+		From("login_attempts").
+		Where("key = ?", key).
+		ToSql()
+
+	var attempt entity.LoginAttempt
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&attempt.Key,
+		&attempt.Failures,
+		&attempt.LastFailedAt,
+		&attempt.LockedUntil,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.LoginAttempt{}, repoerrs.ErrNotFound
+		}
+		return entity.LoginAttempt{}, fmt.Errorf("LoginAttemptsRepo.GetLoginAttempt - r.Pool.QueryRow: %v", err)
+	}
+
+	return attempt, nil
+}
+
+// RegisterLoginFailure counts a failed attempt in one statement, so concurrent
+// failures are never lost. The counter restarts if the previous failure is
+// older than window.
+func (r *LoginAttemptsRepo) RegisterLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error) {
+	sql, args, _ := r.Builder.
+		Insert("login_attempts").
+		Columns("key", "failures", "last_failed_at").
+		Values(key, 1, at).
+		Suffix(`ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failed_at <= ? THEN 1 ELSE login_attempts.failures + 1 END,
+			last_failed_at = EXCLUDED.last_failed_at
+			RETURNING failures`, at.Add(-window)).
+		ToSql()
+
+	var failures int
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&failures); err != nil {
+		return 0, fmt.Errorf("LoginAttemptsRepo.RegisterLoginFailure - r.Pool.QueryRow: %v", err)
+	}
+	return failures, nil
+}
+
+func (r *LoginAttemptsRepo) LockLogin(ctx context.Context, key string, until time.Time) error {
+	sql, args, _ := r.Builder.
+		Update("login_attempts").
+		Set("locked_until", until).
+		Where("key = ?", key).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("LoginAttemptsRepo.LockLogin - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *LoginAttemptsRepo) ResetLoginAttempts(ctx context.Context, key string) error {
+	sql, args, _ := r.Builder.
+		Delete("login_attempts").
+		Where("key = ?", key).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("LoginAttemptsRepo.ResetLoginAttempts - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+// DeleteStaleLoginAttempts deletes the counters whose last failure is older
+// than window and which are not locked at the time.
+func (r *LoginAttemptsRepo) DeleteStaleLoginAttempts(ctx context.Context, at time.Time, window time.Duration) error {
+	sql, args, _ := r.Builder.
+		Delete("login_attempts").
+		Where("last_failed_at <= ? AND (locked_until IS NULL OR locked_until <= ?)", at.Add(-window), at).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("LoginAttemptsRepo.DeleteStaleLoginAttempts - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type PasswordResetTokensRepo struct {
+	*postgres.Postgres
+}
+
+func NewPasswordResetTokensRepo(pg *postgres.Postgres) *PasswordResetTokensRepo {
+	return &PasswordResetTokensRepo{pg}
+}
+
+func (r *PasswordResetTokensRepo) CreatePasswordResetToken(ctx context.Context, token entity.PasswordResetToken) error {
+	sql, args, _ := r.Builder.
+		Insert("password_reset_tokens").
+		Columns("user_id", "token_hash", "expires_at").
+		Values(token.UserId, token.TokenHash, token.ExpiresAt).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("PasswordResetTokensRepo.CreatePasswordResetToken - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+// ConsumePasswordResetToken marks an unused, unexpired token as used and returns
+// its user id. The check and the update are one statement, so a token can be
+// consumed only once even by concurrent requests. It returns repoerrs.ErrNotFound
+// for unknown, used or expired tokens.
+func (r *PasswordResetTokensRepo) ConsumePasswordResetToken(ctx context.Context, tokenHash string) (int, error) {
+	sql, args, _ := r.Builder.
+		Update("password_reset_tokens").
+		Set("used_at", squirrel.Expr("now()")).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > now()", tokenHash).
+		Suffix("RETURNING user_id").
+		ToSql()
+
+	var userId int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&userId)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, repoerrs.ErrNotFound
+		}
+		return 0, fmt.Errorf("PasswordResetTokensRepo.ConsumePasswordResetToken - r.Pool.QueryRow: %v", err)
+	}
+	return userId, nil
+}
+
+// InvalidateUserPasswordResetTokens marks every unused token of the user as used.
+func (r *PasswordResetTokensRepo) InvalidateUserPasswordResetTokens(ctx context.Context, userId int) error {
+	sql, args, _ := r.Builder.
+		Update("password_reset_tokens").
+		Set("used_at", squirrel.Expr("now()")).
+		Where("user_id = ? AND used_at IS NULL", userId).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("PasswordResetTokensRepo.InvalidateUserPasswordResetTokens - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
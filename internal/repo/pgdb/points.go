@@ -3,11 +3,15 @@ package pgdb
 import (
 	"context"
 	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
 	"denet-test-task/pkg/postgres"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type PointsRepo struct {
@@ -18,36 +22,100 @@ func NewPointsRepo(pg *postgres.Postgres) *PointsRepo {
 	return &PointsRepo{pg}
 }
 
-func (r *PointsRepo) AddPointsByUserId(ctx context.Context, userId int, taskId int, points int) error {
-
-	pointsExpr := squirrel.Expr(
-		"COALESCE((SELECT points FROM points WHERE user_id = ? ORDER BY upd_at DESC LIMIT 1), 0) + ?",
-		userId, points,
-	)
+// AddPoints appends the entry to the ledger and applies its delta to the
+// user's balance in one statement. It returns repoerrs.ErrAlreadyExists if an
+// entry with the same idempotency key is already in the ledger.
+func (r *PointsRepo) AddPoints(ctx context.Context, entry entity.PointsEntry) error {
+	// placeholders are numbered once the entry is embedded in the outer statement
+	entrySql, entryArgs, _ := squirrel.
+		Insert("points_ledger").
+		Columns("user_id", "task_id", "reward_id", "referral_id", "delta", "reason", "idempotency_key").
+		Values(entry.UserId, entry.TaskId, entry.RewardId, entry.ReferralId, entry.Delta, entry.Reason, entry.IdempotencyKey).
+		Suffix("ON CONFLICT (idempotency_key) DO NOTHING RETURNING user_id, delta").
+		ToSql()
 
 	sql, args, _ := r.Builder.
-		Insert("points").
-		Columns("user_id", "task_id", "points").
-		Values(
-			userId,
-			taskId,
-			pointsExpr,
-		).
+		Insert("point_balances").
+		Prefix("WITH entry AS ("+entrySql+")", entryArgs...).
+		Columns("user_id", "balance").
+		Select(squirrel.Select("user_id", "delta").From("entry")).
+		Suffix("ON CONFLICT (user_id) DO UPDATE SET balance = point_balances.balance + EXCLUDED.balance, updated_at = now()").
 		ToSql()
 
-	_, err := r.Pool.Exec(ctx, sql, args...)
+	tag, err := r.Pool.Exec(ctx, sql, args...)
 	if err != nil {
-		return fmt.Errorf("PointsRepo.AddPointsByUserId - r.Pool.Exec: %v", err)
+		return fmt.Errorf("PointsRepo.AddPoints - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrAlreadyExists
 	}
 	return nil
 }
 
-func (r *PointsRepo) GetHistoryByUserId(ctx context.Context, userId int) ([]entity.Point, error) {
+// SpendPoints debits the user's balance by -entry.Delta and appends the entry
+// to the ledger in one statement. It returns repoerrs.ErrInsufficientBalance
+// if the balance would become negative and repoerrs.ErrAlreadyExists if an
+// entry with the same idempotency key is already in the ledger.
+func (r *PointsRepo) SpendPoints(ctx context.Context, entry entity.PointsEntry) error {
+	// the balance row is locked by the update, so concurrent debits are serialized
 	sql, args, _ := r.Builder.
-		Select("user_id, task_id, points, upd_at").
-		From("points").
-		Where("user_id = ?", userId).
-		OrderBy("upd_at DESC").
+		Insert("points_ledger").
+		Prefix(`WITH debited AS (
+			UPDATE point_balances SET balance = balance + ?, updated_at = now()
+			WHERE user_id = ? AND balance + ? >= 0
+			RETURNING user_id)`, entry.Delta, entry.UserId, entry.Delta).
+		Columns("user_id", "task_id", "reward_id", "delta", "reason", "idempotency_key").
+		Select(squirrel.
+			Select("user_id").
+			Column("?::integer", entry.TaskId).
+			Column("?::integer", entry.RewardId).
+			Column("?::integer", entry.Delta).
+			Column("?::text", entry.Reason).
+			Column("?::text", entry.IdempotencyKey).
+			From("debited")).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return repoerrs.ErrAlreadyExists
+		}
+		return fmt.Errorf("PointsRepo.SpendPoints - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrInsufficientBalance
+	}
+	return nil
+}
+
+// GetHistoryByUserId returns up to limit entries of the history of the user
+// matching the filter, newest first.
+func (r *PointsRepo) GetHistoryByUserId(ctx context.Context, userId int, filter entity.PointsHistoryFilter, limit int) ([]entity.PointsEntry, error) {
+	query := r.Builder.
+		Select("id, user_id, task_id, reward_id, referral_id, delta, reason, idempotency_key, created_at").
+		From("points_ledger").
+		Where("user_id = ?", userId)
+
+	if filter.TaskId != nil {
+		query = query.Where("task_id = ?", *filter.TaskId)
+	}
+	if filter.Reason != "" {
+		query = query.Where("reason = ?", filter.Reason)
+	}
+	if !filter.From.IsZero() {
+		query = query.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		query = query.Where("created_at < ?", filter.To)
+	}
+	if filter.After != nil {
+		query = query.Where("(created_at, id) < (?, ?)", filter.After.CreatedAt, filter.After.Id)
+	}
+
+	sql, args, _ := query.
+		OrderBy("created_at DESC", "id DESC").
+		Limit(uint64(limit)).
 		ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
@@ -56,49 +124,110 @@ func (r *PointsRepo) GetHistoryByUserId(ctx context.Context, userId int) ([]enti
 	}
 	defer rows.Close()
 
-	pointsHistory, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Point])
+	pointsHistory, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.PointsEntry])
 	if err != nil {
 		return nil, fmt.Errorf("PointsRepo.GetHistoryByUserId - pgx.CollectRows: %v", err)
 	}
 	return pointsHistory, nil
 }
 
-func (r *PointsRepo) CheckCompletedTask(ctx context.Context, userId int, taskId int) (bool, error) {
+// CountTaskCompletions returns how many times the user was granted each task
+// since the time given for it. Tasks never granted since are counted as 0.
+func (r *PointsRepo) CountTaskCompletions(ctx context.Context, userId int, since map[int]time.Time) (map[int]int, error) {
+	taskIds := make([]int, 0, len(since))
+	times := make([]time.Time, 0, len(since))
+	for taskId, t := range since {
+		taskIds = append(taskIds, taskId)
+		times = append(times, t)
+	}
+
+	sql, args, _ := r.Builder.
+		Select("s.task_id, COUNT(l.id)").
+		Prefix("WITH s(task_id, since) AS (SELECT * FROM unnest(?::int[], ?::timestamptz[]))", taskIds, times).
+		From("s").
+		LeftJoin("points_ledger l ON l.user_id = ? AND l.task_id = s.task_id AND l.created_at >= s.since", userId).
+		GroupBy("s.task_id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("PointsRepo.CountTaskCompletions - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[int]int, len(since))
+	for rows.Next() {
+		var taskId, count int
+		if err := rows.Scan(&taskId, &count); err != nil {
+			return nil, fmt.Errorf("PointsRepo.CountTaskCompletions - rows.Scan: %v", err)
+		}
+		counts[taskId] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("PointsRepo.CountTaskCompletions - rows.Err: %v", err)
+	}
+
+	return counts, nil
+}
+
+// CountCompletedTasks returns how many times the user completed a task, each
+// completion of a recurring task counted.
+func (r *PointsRepo) CountCompletedTasks(ctx context.Context, userId int) (int, error) {
 	sql, args, _ := r.Builder.
-		Select("count(1)").
-		From("points").
-		Where("user_id = ? AND task_id = ?", userId, taskId).
+		Select("COUNT(*)").
+		From("points_ledger").
+		Where("user_id = ? AND reason = ?", userId, entity.PointsReasonTaskCompleted).
 		ToSql()
 
-	var cnt int
-	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&cnt); err != nil {
-		return false, fmt.Errorf("PointsRepo.CheckCompletedTask - r.Pool.QueryRow: %v", err)
+	var count int
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("PointsRepo.CountCompletedTasks - r.Pool.QueryRow: %v", err)
 	}
-	return cnt > 0, nil
+	return count, nil
 }
 
+// GetPointsByUserId returns the materialized balance of the user.
 func (r *PointsRepo) GetPointsByUserId(ctx context.Context, userId int) (int, error) {
 
 	sql, args, _ := r.Builder.
-		Select("COALESCE(SUM(points), 0)").
-		From("points").
+		Select("balance").
+		From("point_balances").
 		Where("user_id = ?", userId).
 		ToSql()
 
 	var points int
 	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&points); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, nil
+		}
 		return 0, fmt.Errorf("PointsRepo.GetPointsByUserId - r.Pool.QueryRow: %v", err)
 	}
 	return points, nil
 }
 
+// GetRankByUserId returns the place of the user on the leaderboard, ordered
+// as GetLeaderboard. A user without points ranks as having 0.
+func (r *PointsRepo) GetRankByUserId(ctx context.Context, userId int) (int, error) {
+	sql, args, _ := r.Builder.
+		Select("COUNT(*) + 1").
+		Prefix("WITH me AS (SELECT COALESCE((SELECT balance FROM point_balances WHERE user_id = ?), 0) AS balance)", userId).
+		From("point_balances b, me").
+		Where("b.balance > me.balance OR (b.balance = me.balance AND b.user_id < ?)", userId).
+		ToSql()
+
+	var rank int
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&rank); err != nil {
+		return 0, fmt.Errorf("PointsRepo.GetRankByUserId - r.Pool.QueryRow: %v", err)
+	}
+	return rank, nil
+}
+
 func (r *PointsRepo) GetLeaderboard(ctx context.Context, limit int) ([]entity.LeaderboardItem, error) {
 	sql, args, _ := r.Builder.
-		Select("u.username AS username, COALESCE(SUM(p.points), 0) AS points").
-		From("points p").
-		Join("users u ON u.id = p.user_id").
-		GroupBy("u.username").
-		OrderBy("points DESC").
+		Select("u.username AS username, b.balance AS points").
+		From("point_balances b").
+		Join("users u ON u.id = b.user_id").
+		OrderBy("b.balance DESC", "u.id").
 		Limit(uint64(limit)).
 		ToSql()
 
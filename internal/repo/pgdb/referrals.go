@@ -0,0 +1,260 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+const referralRewardColumns = "id, referrer_id, referral_id, task_id, points, status, reason, created_at, decided_at"
+
+type ReferralsRepo struct {
+	*postgres.Postgres
+}
+
+func NewReferralsRepo(pg *postgres.Postgres) *ReferralsRepo {
+	return &ReferralsRepo{pg}
+}
+
+func (r *ReferralsRepo) GetReferralTiers(ctx context.Context) ([]entity.ReferralTier, error) {
+	sql, args, _ := r.Builder.
+		Select("level, percent::float8 AS percent").
+		From("referral_tiers").
+		OrderBy("level").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetReferralTiers - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	tiers, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.ReferralTier])
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetReferralTiers - pgx.CollectRows: %v", err)
+	}
+	return tiers, nil
+}
+
+// GetUplineTiers returns the referrers of the user up to the deepest tier,
+// the direct referrer first, with the tier of the level they are at.
+func (r *ReferralsRepo) GetUplineTiers(ctx context.Context, userId int) ([]entity.UplineTier, error) {
+	sql, args, _ := r.Builder.
+		Select("up.referrer_id, t.level, t.percent::float8").
+		Prefix(`WITH RECURSIVE up(referrer_id, level) AS (
+			SELECT referrer, 1 FROM users WHERE id = ? AND referrer IS NOT NULL
+			UNION ALL
+			SELECT u.referrer, up.level + 1 FROM up JOIN users u ON u.id = up.referrer_id
+			WHERE u.referrer IS NOT NULL AND up.level < (SELECT COALESCE(MAX(level), 0) FROM referral_tiers))`, userId).
+		From("up").
+		Join("referral_tiers t ON t.level = up.level").
+		OrderBy("t.level").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetUplineTiers - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	var upline []entity.UplineTier
+	for rows.Next() {
+		var tier entity.UplineTier
+		if err := rows.Scan(&tier.ReferrerId, &tier.Level, &tier.Percent); err != nil {
+			return nil, fmt.Errorf("ReferralsRepo.GetUplineTiers - rows.Scan: %v", err)
+		}
+		upline = append(upline, tier)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetUplineTiers - rows.Err: %v", err)
+	}
+
+	return upline, nil
+}
+
+// GetReferralTree returns the referrals of the user down to depth levels,
+// level by level, with the points the user earned for each of them.
+func (r *ReferralsRepo) GetReferralTree(ctx context.Context, userId int, depth int) ([]entity.Referral, error) {
+	sql, args, _ := r.Builder.
+		Select("t.id, u.username, t.referrer, t.level, u.created_at, COALESCE(SUM(l.delta), 0) AS earned").
+		Prefix(`WITH RECURSIVE t(id, referrer, level) AS (
+			SELECT id, referrer, 1 FROM users WHERE referrer = ?
+			UNION ALL
+			SELECT u.id, u.referrer, t.level + 1 FROM t JOIN users u ON u.referrer = t.id
+			WHERE t.level < ?)`, userId, depth).
+		From("t").
+		Join("users u ON u.id = t.id").
+		LeftJoin("points_ledger l ON l.user_id = ? AND l.referral_id = t.id", userId).
+		GroupBy("t.id", "u.username", "t.referrer", "t.level", "u.created_at").
+		OrderBy("t.level", "t.id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetReferralTree - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	referrals, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Referral])
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetReferralTree - pgx.CollectRows: %v", err)
+	}
+	return referrals, nil
+}
+
+func (r *ReferralsRepo) CreateReferralReward(ctx context.Context, reward entity.ReferralReward) error {
+	sql, args, _ := r.Builder.
+		Insert("referral_rewards").
+		Columns("referrer_id", "referral_id", "task_id", "points", "status", "reason").
+		Values(reward.ReferrerId, reward.ReferralId, reward.TaskId, reward.Points, reward.Status, reward.Reason).
+		ToSql()
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("ReferralsRepo.CreateReferralReward - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *ReferralsRepo) GetReferralRewardById(ctx context.Context, id int) (entity.ReferralReward, error) {
+	sql, args, _ := r.Builder.
+		Select(referralRewardColumns).
+		From("referral_rewards").
+		Where("id = ?", id).
+		ToSql()
+
+	var reward entity.ReferralReward
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&reward.Id,
+		&reward.ReferrerId,
+		&reward.ReferralId,
+		&reward.TaskId,
+		&reward.Points,
+		&reward.Status,
+		&reward.Reason,
+		&reward.CreatedAt,
+		&reward.DecidedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.ReferralReward{}, repoerrs.ErrNotFound
+		}
+		return entity.ReferralReward{}, fmt.Errorf("ReferralsRepo.GetReferralRewardById - r.Pool.QueryRow: %v", err)
+	}
+	return reward, nil
+}
+
+// GetReferralRewardsByReferral returns the rewards with the status paid for
+// the referral.
+func (r *ReferralsRepo) GetReferralRewardsByReferral(ctx context.Context, referralId int, status entity.ReferralRewardStatus) ([]entity.ReferralReward, error) {
+	sql, args, _ := r.Builder.
+		Select(referralRewardColumns).
+		From("referral_rewards").
+		Where("referral_id = ? AND status = ?", referralId, status).
+		OrderBy("id").
+		ToSql()
+
+	return r.getReferralRewards(ctx, "GetReferralRewardsByReferral", sql, args)
+}
+
+// GetReferralRewardsByStatus returns the oldest limit rewards with the
+// status.
+func (r *ReferralsRepo) GetReferralRewardsByStatus(ctx context.Context, status entity.ReferralRewardStatus, limit int) ([]entity.ReferralReward, error) {
+	sql, args, _ := r.Builder.
+		Select(referralRewardColumns).
+		From("referral_rewards").
+		Where("status = ?", status).
+		OrderBy("id").
+		Limit(uint64(limit)).
+		ToSql()
+
+	return r.getReferralRewards(ctx, "GetReferralRewardsByStatus", sql, args)
+}
+
+// GetPendingReferralIds returns the next limit referrals, by id after
+// afterId, with pending rewards.
+func (r *ReferralsRepo) GetPendingReferralIds(ctx context.Context, afterId int, limit int) ([]int, error) {
+	sql, args, _ := r.Builder.
+		Select("DISTINCT referral_id").
+		From("referral_rewards").
+		Where("status = ? AND referral_id > ?", entity.ReferralRewardPending, afterId).
+		OrderBy("referral_id").
+		Limit(uint64(limit)).
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetPendingReferralIds - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	ids, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.GetPendingReferralIds - pgx.CollectRows: %v", err)
+	}
+	return ids, nil
+}
+
+func (r *ReferralsRepo) getReferralRewards(ctx context.Context, method string, sql string, args []any) ([]entity.ReferralReward, error) {
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.%s - r.Pool.Query: %v", method, err)
+	}
+	defer rows.Close()
+
+	rewards, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.ReferralReward])
+	if err != nil {
+		return nil, fmt.Errorf("ReferralsRepo.%s - pgx.CollectRows: %v", method, err)
+	}
+	return rewards, nil
+}
+
+// CountReleasedReferralRewards returns how many rewards of the referrer were
+// released since the time.
+func (r *ReferralsRepo) CountReleasedReferralRewards(ctx context.Context, referrerId int, since time.Time) (int, error) {
+	sql, args, _ := r.Builder.
+		Select("COUNT(*)").
+		From("referral_rewards").
+		Where("referrer_id = ? AND status = ? AND decided_at >= ?", referrerId, entity.ReferralRewardReleased, since).
+		ToSql()
+
+	var count int
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("ReferralsRepo.CountReleasedReferralRewards - r.Pool.QueryRow: %v", err)
+	}
+	return count, nil
+}
+
+// UpdateReferralRewardStatus moves a reward that is not decided yet to the
+// status; released and rejected rewards are decided. It returns
+// repoerrs.ErrNotFound if the reward has already been decided, so concurrent
+// reviews decide it once.
+func (r *ReferralsRepo) UpdateReferralRewardStatus(ctx context.Context, id int, status entity.ReferralRewardStatus, reason string) error {
+	decidedAt := squirrel.Expr("NULL")
+	if status == entity.ReferralRewardReleased || status == entity.ReferralRewardRejected {
+		decidedAt = squirrel.Expr("now()")
+	}
+
+	sql, args, _ := r.Builder.
+		Update("referral_rewards").
+		Set("status", status).
+		Set("reason", reason).
+		Set("decided_at", decidedAt).
+		Where("id = ? AND status IN (?, ?)", id, entity.ReferralRewardPending, entity.ReferralRewardReview).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("ReferralsRepo.UpdateReferralRewardStatus - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type RefreshTokensRepo struct {
+	*postgres.Postgres
+}
+
+func NewRefreshTokensRepo(pg *postgres.Postgres) *RefreshTokensRepo {
+	return &RefreshTokensRepo{pg}
+}
+
+func (r *RefreshTokensRepo) CreateRefreshToken(ctx context.Context, token entity.RefreshToken) error {
+	sql, args, _ := r.Builder.
+		Insert("refresh_tokens").
+		Columns("user_id", "token_hash", "family_id", "expires_at").
+		Values(token.UserId, token.TokenHash, token.FamilyId, token.ExpiresAt).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("RefreshTokensRepo.CreateRefreshToken - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *RefreshTokensRepo) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (entity.RefreshToken, error) {
+	sql, args, _ := r.Builder.
+		Select("id, user_id, token_hash, family_id, expires_at, created_at, revoked_at").
+		From("refresh_tokens").
+		Where("token_hash = ?", tokenHash).
+		ToSql()
+
+	var token entity.RefreshToken
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&token.Id,
+		&token.UserId,
+		&token.TokenHash,
+		&token.FamilyId,
+		&token.ExpiresAt,
+		&token.CreatedAt,
+		&token.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.RefreshToken{}, repoerrs.ErrNotFound
+		}
+		return entity.RefreshToken{}, fmt.Errorf("RefreshTokensRepo.GetRefreshTokenByHash - r.Pool.QueryRow: %v", err)
+	}
+
+	return token, nil
+}
+
+// RevokeRefreshToken revokes a still active token. It returns repoerrs.ErrNotFound
+// if the token has already been revoked, e.g. by a concurrent rotation.
+func (r *RefreshTokensRepo) RevokeRefreshToken(ctx context.Context, id int) error {
+	sql, args, _ := r.Builder.
+		Update("refresh_tokens").
+		Set("revoked_at", squirrel.Expr("now()")).
+		Where("id = ? AND revoked_at IS NULL", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("RefreshTokensRepo.RevokeRefreshToken - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *RefreshTokensRepo) RevokeRefreshTokenFamily(ctx context.Context, familyId string) error {
+	sql, args, _ := r.Builder.
+		Update("refresh_tokens").
+		Set("revoked_at", squirrel.Expr("now()")).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("RefreshTokensRepo.RevokeRefreshTokenFamily - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *RefreshTokensRepo) RevokeUserRefreshTokens(ctx context.Context, userId int) error {
+	sql, args, _ := r.Builder.
+		Update("refresh_tokens").
+		Set("revoked_at", squirrel.Expr("now()")).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("RefreshTokensRepo.RevokeUserRefreshTokens - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/pkg/postgres"
+	"fmt"
+	"time"
+)
+
+type RevokedTokensRepo struct {
+	*postgres.Postgres
+}
+
+func NewRevokedTokensRepo(pg *postgres.Postgres) *RevokedTokensRepo {
+	return &RevokedTokensRepo{pg}
+}
+
+func (r *RevokedTokensRepo) RevokeToken(ctx context.Context, token entity.RevokedToken) error {
+	sql, args, _ := r.Builder.
+		Insert("revoked_tokens").
+		Columns("jti", "user_id", "expires_at").
+		Values(token.Jti, token.UserId, token.ExpiresAt).
+		Suffix("ON CONFLICT (jti) DO NOTHING").
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("RevokedTokensRepo.RevokeToken - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
+func (r *RevokedTokensRepo) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	sql, args, _ := r.Builder.
+		Select("count(1)").
+		From("revoked_tokens").
+		Where("jti = ?", jti).
+		ToSql()
+
+	var cnt int
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&cnt); err != nil {
+		return false, fmt.Errorf("RevokedTokensRepo.IsTokenRevoked - r.Pool.QueryRow: %v", err)
+	}
+	return cnt > 0, nil
+}
+
+// DeleteExpiredRevokedTokens deletes the tokens expired at the given time:
+// they are rejected by their exp claim anyway.
+func (r *RevokedTokensRepo) DeleteExpiredRevokedTokens(ctx context.Context, at time.Time) error {
+	sql, args, _ := r.Builder.
+		Delete("revoked_tokens").
+		Where("expires_at <= ?", at).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("RevokedTokensRepo.DeleteExpiredRevokedTokens - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Masterminds/squirrel"
+	"github.com/jackc/pgx/v5"
+)
+
+type RewardsRepo struct {
+	*postgres.Postgres
+}
+
+func NewRewardsRepo(pg *postgres.Postgres) *RewardsRepo {
+	return &RewardsRepo{pg}
+}
+
+// activeAt matches the rewards whose active window contains at.
+func activeAt(at time.Time) squirrel.Sqlizer {
+	return squirrel.Expr("(active_from IS NULL OR active_from <= ?) AND (active_until IS NULL OR active_until > ?)", at, at)
+}
+
+func (r *RewardsRepo) GetRewardById(ctx context.Context, id int) (entity.Reward, error) {
+	sql, args, _ := r.Builder.
+		Select("id, name, descr, cost, stock, active_from, active_until").
+		From("rewards").
+		Where("id = ?", id).
+		ToSql()
+
+	var reward entity.Reward
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&reward.Id,
+		&reward.Name,
+		&reward.Descr,
+		&reward.Cost,
+		&reward.Stock,
+		&reward.ActiveFrom,
+		&reward.ActiveUntil,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.Reward{}, repoerrs.ErrNotFound
+		}
+		return entity.Reward{}, fmt.Errorf("RewardsRepo.GetRewardById - r.Pool.QueryRow: %v", err)
+	}
+
+	return reward, nil
+}
+
+// GetAvailableRewards returns the rewards active at the given time and in stock.
+func (r *RewardsRepo) GetAvailableRewards(ctx context.Context, at time.Time) ([]entity.Reward, error) {
+	sql, args, _ := r.Builder.
+		Select("id, name, descr, cost, stock, active_from, active_until").
+		From("rewards").
+		Where(activeAt(at)).
+		Where("stock IS NULL OR stock > 0").
+		OrderBy("cost", "id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("RewardsRepo.GetAvailableRewards - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	rewards, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Reward])
+	if err != nil {
+		return nil, fmt.Errorf("RewardsRepo.GetAvailableRewards - pgx.CollectRows: %v", err)
+	}
+
+	return rewards, nil
+}
+
+// TakeRewardStock takes one item of the reward and returns its cost. The row
+// stays locked until the transaction ends, so concurrent redemptions never
+// oversell. It returns repoerrs.ErrNotFound if the reward is out of stock or
+// not active at the given time.
+func (r *RewardsRepo) TakeRewardStock(ctx context.Context, id int, at time.Time) (int, error) {
+	sql, args, _ := r.Builder.
+		Update("rewards").
+		Set("stock", squirrel.Expr("stock - 1")).
+		Where("id = ?", id).
+		Where(activeAt(at)).
+		Where("stock IS NULL OR stock > 0").
+		Suffix("RETURNING cost").
+		ToSql()
+
+	var cost int
+	if err := r.Pool.QueryRow(ctx, sql, args...).Scan(&cost); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, repoerrs.ErrNotFound
+		}
+		return 0, fmt.Errorf("RewardsRepo.TakeRewardStock - r.Pool.QueryRow: %v", err)
+	}
+	return cost, nil
+}
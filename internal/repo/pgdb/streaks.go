@@ -0,0 +1,94 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type StreaksRepo struct {
+	*postgres.Postgres
+}
+
+func NewStreaksRepo(pg *postgres.Postgres) *StreaksRepo {
+	return &StreaksRepo{pg}
+}
+
+// GetStreakForUpdate returns the streak of the user in the group. The row
+// stays locked until the transaction ends, so concurrent completions extend
+// the streak one after another. It returns repoerrs.ErrNotFound if the user
+// has no streak in the group yet.
+func (r *StreaksRepo) GetStreakForUpdate(ctx context.Context, userId int, group string) (entity.Streak, error) {
+	sql, args, _ := r.Builder.
+		Select("user_id, streak_group, current_streak, longest_streak, last_day").
+		From("streaks").
+		Where("user_id = ?", userId).
+		Where("streak_group = ?", group).
+		Suffix("FOR UPDATE").
+		ToSql()
+
+	var streak entity.Streak
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&streak.UserId,
+		&streak.Group,
+		&streak.Current,
+		&streak.Longest,
+		&streak.LastDay,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.Streak{}, repoerrs.ErrNotFound
+		}
+		return entity.Streak{}, fmt.Errorf("StreaksRepo.GetStreakForUpdate - r.Pool.QueryRow: %v", err)
+	}
+
+	return streak, nil
+}
+
+// SaveStreak creates or replaces the streak of the user in the group.
+func (r *StreaksRepo) SaveStreak(ctx context.Context, streak entity.Streak) error {
+	sql, args, _ := r.Builder.
+		Insert("streaks").
+		Columns("user_id, streak_group, current_streak, longest_streak, last_day").
+		Values(streak.UserId, streak.Group, streak.Current, streak.Longest, streak.LastDay).
+		Suffix(`ON CONFLICT (user_id, streak_group) DO UPDATE SET
+			current_streak = EXCLUDED.current_streak,
+			longest_streak = EXCLUDED.longest_streak,
+			last_day = EXCLUDED.last_day,
+			updated_at = now()`).
+		ToSql()
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("StreaksRepo.SaveStreak - r.Pool.Exec: %v", err)
+	}
+
+	return nil
+}
+
+func (r *StreaksRepo) GetStreaksByUserId(ctx context.Context, userId int) ([]entity.Streak, error) {
+	sql, args, _ := r.Builder.
+		Select("user_id, streak_group, current_streak, longest_streak, last_day").
+		From("streaks").
+		Where("user_id = ?", userId).
+		OrderBy("streak_group").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("StreaksRepo.GetStreaksByUserId - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	streaks, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Streak])
+	if err != nil {
+		return nil, fmt.Errorf("StreaksRepo.GetStreaksByUserId - pgx.CollectRows: %v", err)
+	}
+
+	return streaks, nil
+}
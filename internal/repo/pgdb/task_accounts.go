@@ -0,0 +1,66 @@
+package pgdb
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/postgres"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+type TaskAccountsRepo struct {
+	*postgres.Postgres
+}
+
+func NewTaskAccountsRepo(pg *postgres.Postgres) *TaskAccountsRepo {
+	return &TaskAccountsRepo{pg}
+}
+
+func (r *TaskAccountsRepo) GetTaskAccount(ctx context.Context, taskId int, account string) (entity.TaskAccount, error) {
+	sql, args, _ := r.Builder.
+		Select("task_id, account, user_id, status, checked_at").
+		From("task_accounts").
+		Where("task_id = ? AND account = ?", taskId, account).
+		ToSql()
+
+	var ta entity.TaskAccount
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&ta.TaskId,
+		&ta.Account,
+		&ta.UserId,
+		&ta.Status,
+		&ta.CheckedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.TaskAccount{}, repoerrs.ErrNotFound
+		}
+		return entity.TaskAccount{}, fmt.Errorf("TaskAccountsRepo.GetTaskAccount - r.Pool.QueryRow: %v", err)
+	}
+	return ta, nil
+}
+
+// SaveTaskAccount stores the check of the account for the user. It returns
+// repoerrs.ErrAlreadyExists if another user has verified the task with the
+// account; an account another user has not verified is taken over.
+func (r *TaskAccountsRepo) SaveTaskAccount(ctx context.Context, ta entity.TaskAccount) error {
+	sql, args, _ := r.Builder.
+		Insert("task_accounts").
+		Columns("task_id", "account", "user_id", "status", "checked_at").
+		Values(ta.TaskId, ta.Account, ta.UserId, ta.Status, ta.CheckedAt).
+		Suffix("ON CONFLICT (task_id, account) DO UPDATE SET user_id = EXCLUDED.user_id, status = EXCLUDED.status, checked_at = EXCLUDED.checked_at " +
+			"WHERE task_accounts.user_id = EXCLUDED.user_id OR task_accounts.status <> 'verified'").
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("TaskAccountsRepo.SaveTaskAccount - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrAlreadyExists
+	}
+	return nil
+}
@@ -8,9 +8,14 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// tasksChangedChannel is notified by a trigger on every change of the tasks table.
+const tasksChangedChannel = "tasks_changed"
+
 type TasksRepo struct {
 	*postgres.Postgres
 }
@@ -21,7 +26,7 @@ func NewTasksRepo(pg *postgres.Postgres) *TasksRepo {
 
 func (r *TasksRepo) GetTaskById(ctx context.Context, id int) (entity.Task, error) {
 	sql, args, _ := r.Builder.
-		Select("id, name, descr, points").
+		Select("id, name, descr, points, kind, metadata, starts_at, ends_at, recurrence, completion_limit, streak_group, archived_at").
 		From("tasks").
 		Where("id = ?", id).
 		ToSql()
@@ -32,6 +37,14 @@ func (r *TasksRepo) GetTaskById(ctx context.Context, id int) (entity.Task, error
 		&task.Name,
 		&task.Descr,
 		&task.Points,
+		&task.Kind,
+		&task.Metadata,
+		&task.StartsAt,
+		&task.EndsAt,
+		&task.Recurrence,
+		&task.CompletionLimit,
+		&task.StreakGroup,
+		&task.ArchivedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -45,7 +58,7 @@ func (r *TasksRepo) GetTaskById(ctx context.Context, id int) (entity.Task, error
 
 func (r *TasksRepo) GetTaskByName(ctx context.Context, name string) (entity.Task, error) {
 	sql, args, _ := r.Builder.
-		Select("id, name, descr, points").
+		Select("id, name, descr, points, kind, metadata, starts_at, ends_at, recurrence, completion_limit, streak_group, archived_at").
 		From("tasks").
 		Where("name = ?", name).
 		ToSql()
@@ -56,6 +69,14 @@ func (r *TasksRepo) GetTaskByName(ctx context.Context, name string) (entity.Task
 		&task.Name,
 		&task.Descr,
 		&task.Points,
+		&task.Kind,
+		&task.Metadata,
+		&task.StartsAt,
+		&task.EndsAt,
+		&task.Recurrence,
+		&task.CompletionLimit,
+		&task.StreakGroup,
+		&task.ArchivedAt,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -67,10 +88,13 @@ func (r *TasksRepo) GetTaskByName(ctx context.Context, name string) (entity.Task
 	return task, nil
 }
 
+// GetAllTasks returns the tasks that are not archived.
 func (r *TasksRepo) GetAllTasks(ctx context.Context) ([]entity.Task, error) {
 	sql, args, _ := r.Builder.
-		Select("id, name, descr, points").
+		Select("id, name, descr, points, kind, metadata, starts_at, ends_at, recurrence, completion_limit, streak_group, archived_at").
 		From("tasks").
+		Where("archived_at IS NULL").
+		OrderBy("id").
 		ToSql()
 
 	rows, err := r.Pool.Query(ctx, sql, args...)
@@ -86,3 +110,125 @@ func (r *TasksRepo) GetAllTasks(ctx context.Context) ([]entity.Task, error) {
 
 	return tasks, nil
 }
+
+// GetAllTasksWithArchived returns all tasks, archived ones included.
+func (r *TasksRepo) GetAllTasksWithArchived(ctx context.Context) ([]entity.Task, error) {
+	sql, args, _ := r.Builder.
+		Select("id, name, descr, points, kind, metadata, starts_at, ends_at, recurrence, completion_limit, streak_group, archived_at").
+		From("tasks").
+		OrderBy("id").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("TasksRepo.GetAllTasksWithArchived - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	tasks, err := pgx.CollectRows(rows, pgx.RowToStructByName[entity.Task])
+	if err != nil {
+		return nil, fmt.Errorf("TasksRepo.GetAllTasksWithArchived - pgx.CollectRows: %v", err)
+	}
+
+	return tasks, nil
+}
+
+func (r *TasksRepo) CreateTask(ctx context.Context, task entity.Task) (int, error) {
+	sql, args, _ := r.Builder.
+		Insert("tasks").
+		Columns("name, descr, points, kind, metadata, starts_at, ends_at, recurrence, completion_limit, streak_group").
+		Values(task.Name, task.Descr, task.Points, task.Kind, task.Metadata, task.StartsAt, task.EndsAt, task.Recurrence, task.CompletionLimit, task.StreakGroup).
+		Suffix("RETURNING id").
+		ToSql()
+
+	var id int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&id)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return 0, repoerrs.ErrAlreadyExists
+		}
+		return 0, fmt.Errorf("TasksRepo.CreateTask - r.Pool.QueryRow: %v", err)
+	}
+
+	return id, nil
+}
+
+func (r *TasksRepo) UpdateTask(ctx context.Context, task entity.Task) error {
+	sql, args, _ := r.Builder.
+		Update("tasks").
+		Set("name", task.Name).
+		Set("descr", task.Descr).
+		Set("points", task.Points).
+		Set("kind", task.Kind).
+		Set("metadata", task.Metadata).
+		Set("starts_at", task.StartsAt).
+		Set("ends_at", task.EndsAt).
+		Set("recurrence", task.Recurrence).
+		Set("completion_limit", task.CompletionLimit).
+		Set("streak_group", task.StreakGroup).
+		Where("id = ?", task.Id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return repoerrs.ErrAlreadyExists
+		}
+		return fmt.Errorf("TasksRepo.UpdateTask - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+// ArchiveTask archives the task. Archiving an archived task keeps its
+// original archive time.
+func (r *TasksRepo) ArchiveTask(ctx context.Context, id int) error {
+	sql, args, _ := r.Builder.
+		Update("tasks").
+		Set("archived_at", squirrel.Expr("COALESCE(archived_at, now())")).
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("TasksRepo.ArchiveTask - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *TasksRepo) RestoreTask(ctx context.Context, id int) error {
+	sql, args, _ := r.Builder.
+		Update("tasks").
+		Set("archived_at", nil).
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("TasksRepo.RestoreTask - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+// ListenTaskChanges calls fn on every change of the tasks table until ctx is
+// done or the subscription is lost.
+func (r *TasksRepo) ListenTaskChanges(ctx context.Context, fn func()) error {
+	err := r.Listen(ctx, tasksChangedChannel, func(string) { fn() })
+	if err != nil {
+		return fmt.Errorf("TasksRepo.ListenTaskChanges - r.Listen: %v", err)
+	}
+	return nil
+}
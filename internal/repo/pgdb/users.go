@@ -7,9 +7,11 @@ import (
 	"denet-test-task/pkg/postgres"
 	"errors"
 	"fmt"
+	"time"
 
-	"github.com/jackc/pgconn"
+	"github.com/Masterminds/squirrel"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 type UsersRepo struct {
@@ -23,8 +25,8 @@ func NewUsersRepo(pg *postgres.Postgres) *UsersRepo {
 func (r *UsersRepo) CreateUser(ctx context.Context, user entity.User) (int, error) {
 	sql, args, _ := r.Builder.
 		Insert("users").
-		Columns("username", "password").
-		Values(user.Username, user.Password).
+		Columns("username", "password", "referral_code", "signup_ip").
+		Values(user.Username, user.Password, user.ReferralCode, user.SignupIP).
 		Suffix("RETURNING id").
 		ToSql()
 
@@ -33,7 +35,8 @@ func (r *UsersRepo) CreateUser(ctx context.Context, user entity.User) (int, erro
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if ok := errors.As(err, &pgErr); ok {
-			if pgErr.Code == "23505" {
+			// a taken referral code is not a taken username
+			if pgErr.Code == "23505" && pgErr.ConstraintName != "users_referral_code_key" {
 				return 0, repoerrs.ErrAlreadyExists
 			}
 		}
@@ -43,11 +46,11 @@ func (r *UsersRepo) CreateUser(ctx context.Context, user entity.User) (int, erro
 	return id, nil
 }
 
-func (r *UsersRepo) GetUserByUsernameAndPassword(ctx context.Context, username, password string) (entity.User, error) {
+func (r *UsersRepo) GetUserById(ctx context.Context, id int) (entity.User, error) {
 	sql, args, _ := r.Builder.
-		Select("id, username, password, created_at, referrer, email").
+		Select("id, username, password, created_at, referrer, email, role, token_version, email_verified_at, timezone, referral_code, host(signup_ip)").
 		From("users").
-		Where("username = ? AND password = ?", username, password).
+		Where("id = ?", id).
 		ToSql()
 
 	var user entity.User
@@ -58,22 +61,28 @@ func (r *UsersRepo) GetUserByUsernameAndPassword(ctx context.Context, username,
 		&user.CreatedAt,
 		&user.Referrer,
 		&user.Email,
+		&user.Role,
+		&user.TokenVersion,
+		&user.EmailVerifiedAt,
+		&user.Timezone,
+		&user.ReferralCode,
+		&user.SignupIP,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.User{}, repoerrs.ErrNotFound
 		}
-		return entity.User{}, fmt.Errorf("UsersRepo.GetUserByUsernameAndPassword - r.Pool.QueryRow: %v", err)
+		return entity.User{}, fmt.Errorf("UsersRepo.GetUserById - r.Pool.QueryRow: %v", err)
 	}
 
 	return user, nil
 }
 
-func (r *UsersRepo) GetUserById(ctx context.Context, id int) (entity.User, error) {
+func (r *UsersRepo) GetUserByUsername(ctx context.Context, username string) (entity.User, error) {
 	sql, args, _ := r.Builder.
-		Select("id, username, password, created_at, referrer, email").
+		Select("id, username, password, created_at, referrer, email, role, token_version, email_verified_at, timezone, referral_code, host(signup_ip)").
 		From("users").
-		Where("id = ?", id).
+		Where("username = ?", username).
 		ToSql()
 
 	var user entity.User
@@ -84,22 +93,28 @@ func (r *UsersRepo) GetUserById(ctx context.Context, id int) (entity.User, error
 		&user.CreatedAt,
 		&user.Referrer,
 		&user.Email,
+		&user.Role,
+		&user.TokenVersion,
+		&user.EmailVerifiedAt,
+		&user.Timezone,
+		&user.ReferralCode,
+		&user.SignupIP,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.User{}, repoerrs.ErrNotFound
 		}
-		return entity.User{}, fmt.Errorf("UsersRepo.GetUserById - r.Pool.QueryRow: %v", err)
+		return entity.User{}, fmt.Errorf("UsersRepo.GetUserByUsername - r.Pool.QueryRow: %v", err)
 	}
 
 	return user, nil
 }
 
-func (r *UsersRepo) GetUserByUsername(ctx context.Context, username string) (entity.User, error) {
+func (r *UsersRepo) GetUserByEmail(ctx context.Context, email string) (entity.User, error) {
 	sql, args, _ := r.Builder.
-		Select("id, username, password, created_at, referrer, email").
+		Select("id, username, password, created_at, referrer, email, role, token_version, email_verified_at, timezone, referral_code, host(signup_ip)").
 		From("users").
-		Where("username = ?", username).
+		Where("email = ?", email).
 		ToSql()
 
 	var user entity.User
@@ -110,18 +125,97 @@ func (r *UsersRepo) GetUserByUsername(ctx context.Context, username string) (ent
 		&user.CreatedAt,
 		&user.Referrer,
 		&user.Email,
+		&user.Role,
+		&user.TokenVersion,
+		&user.EmailVerifiedAt,
+		&user.Timezone,
+		&user.ReferralCode,
+		&user.SignupIP,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return entity.User{}, repoerrs.ErrNotFound
+		}
+		return entity.User{}, fmt.Errorf("UsersRepo.GetUserByEmail - r.Pool.QueryRow: %v", err)
+	}
+
+	return user, nil
+}
+
+func (r *UsersRepo) GetUserByReferralCode(ctx context.Context, code string) (entity.User, error) {
+	sql, args, _ := r.Builder.
+		Select("id, username, password, created_at, referrer, email, role, token_version, email_verified_at, timezone, referral_code, host(signup_ip)").
+		From("users").
+		Where("referral_code = ?", code).
+		ToSql()
+
+	var user entity.User
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(
+		&user.Id,
+		&user.Username,
+		&user.Password,
+		&user.CreatedAt,
 		&user.Referrer,
+		&user.Email,
+		&user.Role,
+		&user.TokenVersion,
+		&user.EmailVerifiedAt,
+		&user.Timezone,
+		&user.ReferralCode,
+		&user.SignupIP,
 	)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return entity.User{}, repoerrs.ErrNotFound
 		}
-		return entity.User{}, fmt.Errorf("UsersRepo.GetUserByUsername - r.Pool.QueryRow: %v", err)
+		return entity.User{}, fmt.Errorf("UsersRepo.GetUserByReferralCode - r.Pool.QueryRow: %v", err)
 	}
 
 	return user, nil
 }
 
+// GetReferrerChain returns the id of the user followed by the ids of the
+// referrers above them, the direct referrer first. At most maxDepth+1 ids are returned, so a
+// chain longer than maxDepth, or one running into a cycle, is cut there.
+func (r *UsersRepo) GetReferrerChain(ctx context.Context, id int, maxDepth int) ([]int, error) {
+	sql, args, _ := r.Builder.
+		Select("id").
+		Prefix(`WITH RECURSIVE chain(id, referrer, depth) AS (
+			SELECT id, referrer, 1 FROM users WHERE id = ?
+			UNION ALL
+			SELECT u.id, u.referrer, c.depth + 1 FROM chain c JOIN users u ON u.id = c.referrer
+			WHERE c.depth <= ?)`, id, maxDepth).
+		From("chain").
+		OrderBy("depth").
+		ToSql()
+
+	rows, err := r.Pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, fmt.Errorf("UsersRepo.GetReferrerChain - r.Pool.Query: %v", err)
+	}
+	defer rows.Close()
+
+	chain, err := pgx.CollectRows(rows, pgx.RowTo[int])
+	if err != nil {
+		return nil, fmt.Errorf("UsersRepo.GetReferrerChain - pgx.CollectRows: %v", err)
+	}
+	return chain, nil
+}
+
+// referrerChainsLockKey is the advisory lock taken while a referrer is linked.
+const referrerChainsLockKey = 0x72656665
+
+// LockReferrerChains serializes the linking of referrers until the end of the
+// transaction, so two concurrent links cannot close a cycle or grow a chain
+// past its limit that each of them checked alone. It must run in a transaction.
+func (r *UsersRepo) LockReferrerChains(ctx context.Context) error {
+	_, err := r.Pool.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", referrerChainsLockKey)
+	if err != nil {
+		return fmt.Errorf("UsersRepo.LockReferrerChains - r.Pool.Exec: %v", err)
+	}
+	return nil
+}
+
 func (r *UsersRepo) SetUserReferrer(ctx context.Context, id int, referrer int) error {
 	sql, args, _ := r.Builder.
 		Update("users").
@@ -140,16 +234,121 @@ func (r *UsersRepo) SetUserReferrer(ctx context.Context, id int, referrer int) e
 	return nil
 }
 
-func (r *UsersRepo) SetUserEmail(ctx context.Context, id int, email string) error {
+// SetUserEmailVerified sets the verified email of the user. It returns
+// repoerrs.ErrAlreadyExists if another user has the email and
+// repoerrs.ErrNotFound if the user does not exist.
+func (r *UsersRepo) SetUserEmailVerified(ctx context.Context, id int, email string) error {
 	sql, args, _ := r.Builder.
 		Update("users").
 		Set("email", email).
+		Set("email_verified_at", squirrel.Expr("now()")).
 		Where("id = ?", id).
 		ToSql()
 
 	tag, err := r.Pool.Exec(ctx, sql, args...)
 	if err != nil {
-		return fmt.Errorf("UsersRepo.SetUserEmail - r.Pool.Exec: %v", err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			return repoerrs.ErrAlreadyExists
+		}
+		return fmt.Errorf("UsersRepo.SetUserEmailVerified - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *UsersRepo) SetUserPassword(ctx context.Context, id int, password string) error {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("password", password).
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UsersRepo.SetUserPassword - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+func (r *UsersRepo) GetUserTokenVersion(ctx context.Context, id int) (int, error) {
+	sql, args, _ := r.Builder.
+		Select("token_version").
+		From("users").
+		Where("id = ?", id).
+		ToSql()
+
+	var version int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, repoerrs.ErrNotFound
+		}
+		return 0, fmt.Errorf("UsersRepo.GetUserTokenVersion - r.Pool.QueryRow: %v", err)
+	}
+
+	return version, nil
+}
+
+func (r *UsersRepo) IncrementUserTokenVersion(ctx context.Context, id int) (int, error) {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("token_version", squirrel.Expr("token_version + 1")).
+		Where("id = ?", id).
+		Suffix("RETURNING token_version").
+		ToSql()
+
+	var version int
+	err := r.Pool.QueryRow(ctx, sql, args...).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, repoerrs.ErrNotFound
+		}
+		return 0, fmt.Errorf("UsersRepo.IncrementUserTokenVersion - r.Pool.QueryRow: %v", err)
+	}
+
+	return version, nil
+}
+
+func (r *UsersRepo) SetUserRole(ctx context.Context, id int, role entity.Role) error {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("role", role).
+		Where("id = ?", id).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UsersRepo.SetUserRole - r.Pool.Exec: %v", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return repoerrs.ErrNotFound
+	}
+
+	return nil
+}
+
+// SetUserTimezone changes the timezone of the user at the given time unless
+// it was changed less than interval before. It returns repoerrs.ErrNotFound
+// if the user is unknown or the timezone was changed too recently.
+func (r *UsersRepo) SetUserTimezone(ctx context.Context, id int, timezone string, at time.Time, interval time.Duration) error {
+	sql, args, _ := r.Builder.
+		Update("users").
+		Set("timezone", timezone).
+		Set("timezone_changed_at", at).
+		Where("id = ? AND (timezone_changed_at IS NULL OR timezone_changed_at <= ?)", id, at.Add(-interval)).
+		ToSql()
+
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UsersRepo.SetUserTimezone - r.Pool.Exec: %v", err)
 	}
 	if tag.RowsAffected() == 0 {
 		return repoerrs.ErrNotFound
@@ -5,42 +5,180 @@ import (
 	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo/pgdb"
 	"denet-test-task/pkg/postgres"
+	"time"
 )
 
+// Transactor runs fn as one unit of work: repository calls made with the
+// context passed to fn are committed together or rolled back together.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
 type Users interface {
 	CreateUser(ctx context.Context, user entity.User) (int, error)
-	GetUserByUsernameAndPassword(ctx context.Context, username, password string) (entity.User, error)
 	GetUserById(ctx context.Context, id int) (entity.User, error)
 	GetUserByUsername(ctx context.Context, username string) (entity.User, error)
+	GetUserByEmail(ctx context.Context, email string) (entity.User, error)
+	GetUserByReferralCode(ctx context.Context, code string) (entity.User, error)
+	GetReferrerChain(ctx context.Context, id int, maxDepth int) ([]int, error)
+	LockReferrerChains(ctx context.Context) error
 
 	SetUserReferrer(ctx context.Context, id int, referrer int) error
-	SetUserEmail(ctx context.Context, id int, email string) error
+	SetUserEmailVerified(ctx context.Context, id int, email string) error
+	SetUserPassword(ctx context.Context, id int, password string) error
+	SetUserRole(ctx context.Context, id int, role entity.Role) error
+	SetUserTimezone(ctx context.Context, id int, timezone string, at time.Time, interval time.Duration) error
+
+	GetUserTokenVersion(ctx context.Context, id int) (int, error)
+	IncrementUserTokenVersion(ctx context.Context, id int) (int, error)
 }
 
 type Tasks interface {
 	GetTaskById(ctx context.Context, id int) (entity.Task, error)
 	GetTaskByName(ctx context.Context, name string) (entity.Task, error)
 	GetAllTasks(ctx context.Context) ([]entity.Task, error)
+	GetAllTasksWithArchived(ctx context.Context) ([]entity.Task, error)
+
+	CreateTask(ctx context.Context, task entity.Task) (int, error)
+	UpdateTask(ctx context.Context, task entity.Task) error
+	ArchiveTask(ctx context.Context, id int) error
+	RestoreTask(ctx context.Context, id int) error
+
+	ListenTaskChanges(ctx context.Context, fn func()) error
 }
 
 type Points interface {
-	AddPointsByUserId(ctx context.Context, userId int, taskId int, points int) error
+	AddPoints(ctx context.Context, entry entity.PointsEntry) error
+	SpendPoints(ctx context.Context, entry entity.PointsEntry) error
 	GetPointsByUserId(ctx context.Context, userId int) (int, error)
-	GetHistoryByUserId(ctx context.Context, userId int) ([]entity.Point, error)
-	CheckCompletedTask(ctx context.Context, userId int, taskId int) (bool, error)
+	GetHistoryByUserId(ctx context.Context, userId int, filter entity.PointsHistoryFilter, limit int) ([]entity.PointsEntry, error)
+	CountTaskCompletions(ctx context.Context, userId int, since map[int]time.Time) (map[int]int, error)
+	CountCompletedTasks(ctx context.Context, userId int) (int, error)
+	GetRankByUserId(ctx context.Context, userId int) (int, error)
 	GetLeaderboard(ctx context.Context, limit int) ([]entity.LeaderboardItem, error)
 }
 
+type Streaks interface {
+	GetStreakForUpdate(ctx context.Context, userId int, group string) (entity.Streak, error)
+	SaveStreak(ctx context.Context, streak entity.Streak) error
+	GetStreaksByUserId(ctx context.Context, userId int) ([]entity.Streak, error)
+}
+
+type Referrals interface {
+	GetReferralTiers(ctx context.Context) ([]entity.ReferralTier, error)
+	GetUplineTiers(ctx context.Context, userId int) ([]entity.UplineTier, error)
+	GetReferralTree(ctx context.Context, userId int, depth int) ([]entity.Referral, error)
+
+	CreateReferralReward(ctx context.Context, reward entity.ReferralReward) error
+	GetReferralRewardById(ctx context.Context, id int) (entity.ReferralReward, error)
+	GetReferralRewardsByReferral(ctx context.Context, referralId int, status entity.ReferralRewardStatus) ([]entity.ReferralReward, error)
+	GetReferralRewardsByStatus(ctx context.Context, status entity.ReferralRewardStatus, limit int) ([]entity.ReferralReward, error)
+	GetPendingReferralIds(ctx context.Context, afterId int, limit int) ([]int, error)
+	CountReleasedReferralRewards(ctx context.Context, referrerId int, since time.Time) (int, error)
+	UpdateReferralRewardStatus(ctx context.Context, id int, status entity.ReferralRewardStatus, reason string) error
+}
+
+type Rewards interface {
+	GetRewardById(ctx context.Context, id int) (entity.Reward, error)
+	GetAvailableRewards(ctx context.Context, at time.Time) ([]entity.Reward, error)
+	TakeRewardStock(ctx context.Context, id int, at time.Time) (int, error)
+}
+
+type RefreshTokens interface {
+	CreateRefreshToken(ctx context.Context, token entity.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, tokenHash string) (entity.RefreshToken, error)
+	RevokeRefreshToken(ctx context.Context, id int) error
+	RevokeRefreshTokenFamily(ctx context.Context, familyId string) error
+	RevokeUserRefreshTokens(ctx context.Context, userId int) error
+}
+
+type RevokedTokens interface {
+	RevokeToken(ctx context.Context, token entity.RevokedToken) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	DeleteExpiredRevokedTokens(ctx context.Context, at time.Time) error
+}
+
+type LoginAttempts interface {
+	GetLoginAttempt(ctx context.Context, key string) (entity.LoginAttempt, error)
+	RegisterLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (int, error)
+	LockLogin(ctx context.Context, key string, until time.Time) error
+	ResetLoginAttempts(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, at time.Time, window time.Duration) error
+}
+
+type PasswordResetTokens interface {
+	CreatePasswordResetToken(ctx context.Context, token entity.PasswordResetToken) error
+	ConsumePasswordResetToken(ctx context.Context, tokenHash string) (int, error)
+	InvalidateUserPasswordResetTokens(ctx context.Context, userId int) error
+}
+
+type EmailVerifications interface {
+	CreateEmailVerification(ctx context.Context, verification entity.EmailVerification) error
+	GetPendingEmailVerification(ctx context.Context, userId int) (entity.EmailVerification, error)
+	IncrementEmailVerificationAttempts(ctx context.Context, id int) error
+	UseEmailVerification(ctx context.Context, id int) error
+}
+
+type TaskAccounts interface {
+	GetTaskAccount(ctx context.Context, taskId int, account string) (entity.TaskAccount, error)
+	SaveTaskAccount(ctx context.Context, ta entity.TaskAccount) error
+}
+
+type LinkedAccounts interface {
+	GetLinkedAccount(ctx context.Context, userId int, kind entity.TaskKind) (entity.LinkedAccount, error)
+	SaveLinkedAccount(ctx context.Context, la entity.LinkedAccount) error
+}
+
+type IdempotencyKeys interface {
+	CreateIdempotencyKey(ctx context.Context, key entity.IdempotencyKey) error
+	GetIdempotencyKey(ctx context.Context, userId int, key string) (entity.IdempotencyKey, error)
+	CompleteIdempotencyKey(ctx context.Context, key entity.IdempotencyKey) error
+	DeleteIdempotencyKey(ctx context.Context, userId int, key string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) error
+}
+
 type Repositories struct {
+	Transactor
+
 	Users
 	Tasks
 	Points
+	Streaks
+	Referrals
+	Rewards
+	RefreshTokens
+	RevokedTokens
+	LoginAttempts
+	PasswordResetTokens
+	EmailVerifications
+	TaskAccounts
+	LinkedAccounts
+	IdempotencyKeys
 }
 
 func NewRepositories(pg *postgres.Postgres) *Repositories {
 	return &Repositories{
+		Transactor: pg,
+
 		Users:  pgdb.NewUsersRepo(pg),
 		Tasks:  pgdb.NewTasksRepo(pg),
 		Points: pgdb.NewPointsRepo(pg),
+
+		Streaks:   pgdb.NewStreaksRepo(pg),
+		Referrals: pgdb.NewReferralsRepo(pg),
+
+		Rewards: pgdb.NewRewardsRepo(pg),
+
+		RefreshTokens: pgdb.NewRefreshTokensRepo(pg),
+		RevokedTokens: pgdb.NewRevokedTokensRepo(pg),
+		LoginAttempts: pgdb.NewLoginAttemptsRepo(pg),
+
+		PasswordResetTokens: pgdb.NewPasswordResetTokensRepo(pg),
+		EmailVerifications:  pgdb.NewEmailVerificationsRepo(pg),
+
+		TaskAccounts:   pgdb.NewTaskAccountsRepo(pg),
+		LinkedAccounts: pgdb.NewLinkedAccountsRepo(pg),
+
+		IdempotencyKeys: pgdb.NewIdempotencyKeysRepo(pg),
 	}
 }
@@ -5,4 +5,6 @@ import "errors"
 var (
 	ErrNotFound      = errors.New("not found")
 	ErrAlreadyExists = errors.New("already exists")
+
+	ErrInsufficientBalance = errors.New("insufficient balance")
 )
@@ -0,0 +1,79 @@
+package antifraud
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/pkg/logctx"
+)
+
+var _ Antifraud = (*AntifraudService)(nil)
+
+// Verdict is what a rule makes of a referral reward. Verdicts are ordered
+// from the mildest: the pipeline keeps the most severe one.
+type Verdict int
+
+const (
+	// VerdictRelease lets the reward be paid as far as the rule is concerned.
+	VerdictRelease Verdict = iota
+	// VerdictHold keeps the reward pending until the rule is met.
+	VerdictHold
+	// VerdictReview sends the reward to the admin review queue.
+	VerdictReview
+	// VerdictReject refuses the reward.
+	VerdictReject
+)
+
+// Status is the status of a reward given the verdict.
+func (v Verdict) Status() entity.ReferralRewardStatus {
+	switch v {
+	case VerdictHold:
+		return entity.ReferralRewardPending
+	case VerdictReview:
+		return entity.ReferralRewardReview
+	case VerdictReject:
+		return entity.ReferralRewardRejected
+	}
+	return entity.ReferralRewardReleased
+}
+
+// Rule is a check of referral rewards. Rules are plugged into the pipeline
+// in the order they run.
+type Rule interface {
+	// Name is stored as the reason of the rewards the rule holds back.
+	Name() string
+	Check(input AntifraudCheckInput) Verdict
+}
+
+// Decision is the verdict of the pipeline and the rule that gave it; Rule is
+// empty when the reward is released.
+type Decision struct {
+	Verdict Verdict
+	Rule    string
+}
+
+// AntifraudService runs referral rewards through the rules.
+type AntifraudService struct {
+	rules []Rule
+}
+
+func NewAntifraudService(rules []Rule) *AntifraudService {
+	return &AntifraudService{rules: rules}
+}
+
+// Check returns the most severe verdict of the rules; the first rule to give
+// it names the decision. Without rules every reward is released.
+func (s *AntifraudService) Check(ctx context.Context, input AntifraudCheckInput) Decision {
+	var decision Decision
+	for _, rule := range s.rules {
+		verdict := rule.Check(input)
+		if verdict > decision.Verdict {
+			decision = Decision{Verdict: verdict, Rule: rule.Name()}
+		}
+	}
+
+	if decision.Verdict != VerdictRelease {
+		logctx.FromContext(ctx).Info("AntifraudService.Check - reward held back",
+			"reward_id", input.Reward.Id, "rule", decision.Rule, "status", decision.Verdict.Status())
+	}
+	return decision
+}
@@ -0,0 +1,101 @@
+package antifraud
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func ip(s string) *string {
+	return &s
+}
+
+func TestSameNetworkRule(t *testing.T) {
+	rule := SameNetworkRule{IPv4Bits: 24, IPv6Bits: 64}
+
+	cases := []struct {
+		name     string
+		referral *string
+		referrer *string
+		want     Verdict
+	}{
+		{"same address", ip("203.0.113.7"), ip("203.0.113.7"), VerdictReject},
+		{"mapped address", ip("::ffff:203.0.113.7"), ip("203.0.113.7"), VerdictReject},
+		{"same subnet", ip("203.0.113.7"), ip("203.0.113.200"), VerdictReview},
+		{"other subnet", ip("203.0.113.7"), ip("203.0.114.7"), VerdictRelease},
+		{"same ipv6 subnet", ip("2001:db8::1"), ip("2001:db8::2"), VerdictReview},
+		{"other ipv6 subnet", ip("2001:db8::1"), ip("2001:db8:0:1::1"), VerdictRelease},
+		{"other families", ip("203.0.113.7"), ip("2001:db8::1"), VerdictRelease},
+		{"unknown address", nil, ip("203.0.113.7"), VerdictRelease},
+		{"invalid address", ip("unknown"), ip("203.0.113.7"), VerdictRelease},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := rule.Check(AntifraudCheckInput{
+				Referral: entity.User{SignupIP: tc.referral},
+				Referrer: entity.User{SignupIP: tc.referrer},
+			})
+			assert.Equal(t, tc.want, got)
+		})
+	}
+
+	// without network sizes only the addresses are compared
+	input := AntifraudCheckInput{Referral: entity.User{SignupIP: ip("203.0.113.7")}, Referrer: entity.User{SignupIP: ip("203.0.113.8")}}
+	assert.Equal(t, VerdictRelease, SameNetworkRule{}.Check(input))
+}
+
+func TestAccountAgeRule(t *testing.T) {
+	now := time.Now()
+	rule := AccountAgeRule{MinAge: 24 * time.Hour}
+
+	assert.Equal(t, VerdictHold, rule.Check(AntifraudCheckInput{Referral: entity.User{CreatedAt: now.Add(-time.Hour)}, Now: now}))
+	assert.Equal(t, VerdictRelease, rule.Check(AntifraudCheckInput{Referral: entity.User{CreatedAt: now.Add(-24 * time.Hour)}, Now: now}))
+}
+
+func TestDailyCapRule(t *testing.T) {
+	rule := DailyCapRule{Cap: 3}
+
+	assert.Equal(t, VerdictRelease, rule.Check(AntifraudCheckInput{ReleasedToday: 2}))
+	assert.Equal(t, VerdictReview, rule.Check(AntifraudCheckInput{ReleasedToday: 3}))
+}
+
+func TestCompletedTasksRule(t *testing.T) {
+	rule := CompletedTasksRule{MinTasks: 2}
+
+	assert.Equal(t, VerdictHold, rule.Check(AntifraudCheckInput{CompletedTasks: 1}))
+	assert.Equal(t, VerdictRelease, rule.Check(AntifraudCheckInput{CompletedTasks: 2}))
+}
+
+type verdictRule struct {
+	name    string
+	verdict Verdict
+}
+
+func (r verdictRule) Name() string                        { return r.name }
+func (r verdictRule) Check(_ AntifraudCheckInput) Verdict { return r.verdict }
+
+func TestAntifraudService_Check(t *testing.T) {
+	s := NewAntifraudService([]Rule{
+		verdictRule{"pass", VerdictRelease},
+		verdictRule{"hold", VerdictHold},
+		verdictRule{"review", VerdictReview},
+		verdictRule{"review again", VerdictReview},
+		verdictRule{"hold again", VerdictHold},
+	})
+
+	// the most severe verdict wins, named by the first rule giving it
+	decision := s.Check(context.Background(), AntifraudCheckInput{})
+	assert.Equal(t, Decision{Verdict: VerdictReview, Rule: "review"}, decision)
+	assert.Equal(t, entity.ReferralRewardReview, decision.Verdict.Status())
+
+	s = NewAntifraudService(append(s.rules, verdictRule{"reject", VerdictReject}))
+	assert.Equal(t, Decision{Verdict: VerdictReject, Rule: "reject"}, s.Check(context.Background(), AntifraudCheckInput{}))
+
+	// without rules the reward is released
+	decision = NewAntifraudService(nil).Check(context.Background(), AntifraudCheckInput{})
+	assert.Equal(t, Decision{}, decision)
+	assert.Equal(t, entity.ReferralRewardReleased, decision.Verdict.Status())
+}
@@ -0,0 +1,23 @@
+package antifraud
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"time"
+)
+
+// AntifraudCheckInput is what the rules know about a referral reward.
+// ReleasedToday is how many rewards of the referrer were released in the
+// last day, CompletedTasks how many tasks the referral has completed.
+type AntifraudCheckInput struct {
+	Reward         entity.ReferralReward
+	Referral       entity.User
+	Referrer       entity.User
+	ReleasedToday  int
+	CompletedTasks int
+	Now            time.Time
+}
+
+type Antifraud interface {
+	Check(ctx context.Context, input AntifraudCheckInput) Decision
+}
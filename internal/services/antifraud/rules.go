@@ -0,0 +1,106 @@
+package antifraud
+
+import (
+	"net/netip"
+	"time"
+)
+
+// SameNetworkRule rejects the reward when the referral signed up from the
+// address of the referrer and sends it to review when they signed up from
+// the same network. Networks are compared by the first IPv4Bits or IPv6Bits
+// of the addresses; 0 compares the addresses only. Users without a known
+// address pass.
+type SameNetworkRule struct {
+	IPv4Bits int
+	IPv6Bits int
+}
+
+func (r SameNetworkRule) Name() string {
+	return "same_network"
+}
+
+func (r SameNetworkRule) Check(input AntifraudCheckInput) Verdict {
+	if input.Referral.SignupIP == nil || input.Referrer.SignupIP == nil {
+		return VerdictRelease
+	}
+	referral, err := netip.ParseAddr(*input.Referral.SignupIP)
+	if err != nil {
+		return VerdictRelease
+	}
+	referrer, err := netip.ParseAddr(*input.Referrer.SignupIP)
+	if err != nil {
+		return VerdictRelease
+	}
+	referral, referrer = referral.Unmap(), referrer.Unmap()
+
+	if referral == referrer {
+		return VerdictReject
+	}
+	if referral.Is4() != referrer.Is4() {
+		return VerdictRelease
+	}
+
+	bits := r.IPv6Bits
+	if referral.Is4() {
+		bits = r.IPv4Bits
+	}
+	if bits == 0 {
+		return VerdictRelease
+	}
+	network, err := referral.Prefix(bits)
+	if err != nil || !network.Contains(referrer) {
+		return VerdictRelease
+	}
+	return VerdictReview
+}
+
+// AccountAgeRule holds the reward until the account of the referral is
+// MinAge old.
+type AccountAgeRule struct {
+	MinAge time.Duration
+}
+
+func (r AccountAgeRule) Name() string {
+	return "account_age"
+}
+
+func (r AccountAgeRule) Check(input AntifraudCheckInput) Verdict {
+	if input.Now.Sub(input.Referral.CreatedAt) < r.MinAge {
+		return VerdictHold
+	}
+	return VerdictRelease
+}
+
+// DailyCapRule sends the rewards of a referrer who already had Cap rewards
+// released in the last day to review.
+type DailyCapRule struct {
+	Cap int
+}
+
+func (r DailyCapRule) Name() string {
+	return "daily_cap"
+}
+
+func (r DailyCapRule) Check(input AntifraudCheckInput) Verdict {
+	if input.ReleasedToday >= r.Cap {
+		return VerdictReview
+	}
+	return VerdictRelease
+}
+
+// CompletedTasksRule holds the reward until the referral has completed
+// MinTasks tasks.
+type CompletedTasksRule struct {
+	MinTasks int
+}
+
+func (r CompletedTasksRule) Name() string {
+	return "completed_tasks"
+}
+
+func (r CompletedTasksRule) Check(input AntifraudCheckInput) Verdict {
+	if input.CompletedTasks < r.MinTasks {
+		return VerdictHold
+	}
+	return VerdictRelease
+}
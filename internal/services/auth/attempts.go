@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"context"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTooManyAttempts          = fmt.Errorf("too many login attempts")
+	ErrCannotCheckLoginAttempts = fmt.Errorf("cannot check login attempts")
+)
+
+// TooManyAttemptsError is returned by GenerateToken while the account or the
+// client IP is locked out. It matches ErrTooManyAttempts with errors.Is.
+type TooManyAttemptsError struct {
+	RetryAfter time.Duration
+}
+
+func (e *TooManyAttemptsError) Error() string {
+	return fmt.Sprintf("%v, retry after %v", ErrTooManyAttempts, e.RetryAfter)
+}
+
+func (e *TooManyAttemptsError) Is(target error) bool {
+	return target == ErrTooManyAttempts
+}
+
+// LoginThrottleConfig configures the brute-force protection of GenerateToken.
+// Once a key has more failures than allowed, every further failure locks it
+// for BaseLockout doubled per extra failure, capped at MaxLockout.
+type LoginThrottleConfig struct {
+	UserFreeAttempts int
+	IPFreeAttempts   int
+	BaseLockout      time.Duration
+	MaxLockout       time.Duration
+	// Window is how long a failure is remembered after the last one
+	Window time.Duration
+}
+
+type loginAttemptKey struct {
+	key          string
+	freeAttempts int
+}
+
+func (s *AuthService) loginAttemptKeys(input AuthGenerateTokenInput) []loginAttemptKey {
+	keys := []loginAttemptKey{{key: "user:" + input.Username, freeAttempts: s.throttle.UserFreeAttempts}}
+	if input.IP != "" {
+		keys = append(keys, loginAttemptKey{key: "ip:" + input.IP, freeAttempts: s.throttle.IPFreeAttempts})
+	}
+	return keys
+}
+
+// checkLoginAttempts rejects the sign-in while any of the keys is locked.
+func (s *AuthService) checkLoginAttempts(ctx context.Context, keys []loginAttemptKey) error {
+	now := time.Now()
+
+	var retryAfter time.Duration
+	for _, k := range keys {
+		attempt, err := s.loginAttemptsRepo.GetLoginAttempt(ctx, k.key)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				continue
+			}
+			logctx.FromContext(ctx).Error("AuthService.checkLoginAttempts - loginAttemptsRepo.GetLoginAttempt", "err", err)
+			return ErrCannotCheckLoginAttempts
+		}
+
+		if attempt.LockedUntil != nil && now.Before(*attempt.LockedUntil) {
+			retryAfter = max(retryAfter, attempt.LockedUntil.Sub(now))
+		}
+	}
+
+	if retryAfter > 0 {
+		return &TooManyAttemptsError{RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// registerLoginFailure counts the failure for every key and locks the keys
+// that ran out of free attempts. Failures are only logged: the caller already
+// has an error to return.
+func (s *AuthService) registerLoginFailure(ctx context.Context, keys []loginAttemptKey) {
+	now := time.Now()
+	s.purgeLoginAttempts(ctx, now)
+
+	for _, k := range keys {
+		failures, err := s.loginAttemptsRepo.RegisterLoginFailure(ctx, k.key, now, s.throttle.Window)
+		if err != nil {
+			logctx.FromContext(ctx).Warn("AuthService.registerLoginFailure - loginAttemptsRepo.RegisterLoginFailure", "err", err)
+			continue
+		}
+
+		lockout := s.lockout(failures, k.freeAttempts)
+		if lockout == 0 {
+			continue
+		}
+
+		logctx.FromContext(ctx).Warn("AuthService.registerLoginFailure: locked out", "key", k.key, "failures", failures, "lockout", lockout)
+		err = s.loginAttemptsRepo.LockLogin(ctx, k.key, now.Add(lockout))
+		if err != nil {
+			logctx.FromContext(ctx).Warn("AuthService.registerLoginFailure - loginAttemptsRepo.LockLogin", "err", err)
+		}
+	}
+}
+
+// resetLoginAttempts clears the account counter after a successful sign-in.
+// The IP counter is kept: otherwise an attacker could reset it by signing in
+// to an account of their own between guesses.
+func (s *AuthService) resetLoginAttempts(ctx context.Context, keys []loginAttemptKey) {
+	err := s.loginAttemptsRepo.ResetLoginAttempts(ctx, keys[0].key)
+	if err != nil {
+		logctx.FromContext(ctx).Warn("AuthService.resetLoginAttempts - loginAttemptsRepo.ResetLoginAttempts", "err", err)
+	}
+}
+
+// purgeLoginAttempts deletes the stale counters at most once per window.
+func (s *AuthService) purgeLoginAttempts(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastAttemptsPurge) < s.throttle.Window {
+		s.mu.Unlock()
+		return
+	}
+	s.lastAttemptsPurge = now
+	s.mu.Unlock()
+
+	if err := s.loginAttemptsRepo.DeleteStaleLoginAttempts(ctx, now, s.throttle.Window); err != nil {
+		logctx.FromContext(ctx).Warn("AuthService.purgeLoginAttempts - loginAttemptsRepo.DeleteStaleLoginAttempts", "err", err)
+	}
+}
+
+// lockout returns how long a key with the given number of failures is locked.
+func (s *AuthService) lockout(failures, freeAttempts int) time.Duration {
+	extra := failures - freeAttempts
+	if extra <= 0 {
+		return 0
+	}
+
+	lockout := s.throttle.BaseLockout
+	for i := 1; i < extra && lockout < s.throttle.MaxLockout; i++ {
+		lockout *= 2
+	}
+	return min(lockout, s.throttle.MaxLockout)
+}
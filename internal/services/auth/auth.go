@@ -5,13 +5,20 @@ import (
 	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo"
 	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/internal/services/users"
 	"denet-test-task/pkg/hasher"
+	"denet-test-task/pkg/jwks"
 	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/notifier"
+	"denet-test-task/pkg/ttlcache"
 	"errors"
 	"fmt"
+	"net/netip"
+	"strconv"
+	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/golang-jwt/jwt/v5"
 )
 
 var _ Auth = (*AuthService)(nil)
@@ -20,39 +27,155 @@ var (
 	ErrCannotSignToken  = fmt.Errorf("cannot sign token")
 	ErrCannotParseToken = fmt.Errorf("cannot parse token")
 
-	ErrUserAlreadyExists = fmt.Errorf("user already exists")
-	ErrCannotCreateUser  = fmt.Errorf("cannot create user")
-	ErrUserNotFound      = fmt.Errorf("user not found")
-	ErrCannotGetUser     = fmt.Errorf("cannot get user")
+	ErrUserAlreadyExists    = fmt.Errorf("user already exists")
+	ErrInvalidReferralCode  = fmt.Errorf("invalid referral code")
+	ErrReferrerChainTooLong = fmt.Errorf("referrer chain is too long")
+	ErrCannotCreateUser     = fmt.Errorf("cannot create user")
+	ErrUserNotFound         = fmt.Errorf("user not found")
+	ErrCannotGetUser        = fmt.Errorf("cannot get user")
+
+	ErrCannotVerifyPassword = fmt.Errorf("cannot verify password")
+
+	ErrInvalidRefreshToken     = fmt.Errorf("invalid refresh token")
+	ErrRefreshTokenReused      = fmt.Errorf("refresh token reused")
+	ErrCannotIssueRefreshToken = fmt.Errorf("cannot issue refresh token")
+	ErrCannotRefreshToken      = fmt.Errorf("cannot refresh token")
+
+	ErrTokenRevoked          = fmt.Errorf("token revoked")
+	ErrCannotCheckRevocation = fmt.Errorf("cannot check token revocation")
+	ErrCannotRevokeToken     = fmt.Errorf("cannot revoke token")
+
+	ErrInvalidRole   = fmt.Errorf("invalid role")
+	ErrCannotSetRole = fmt.Errorf("cannot set role")
 )
 
-type TokenClaims struct {
-	jwt.StandardClaims
-	UserId int
+// TokenConfig holds the parameters of issued tokens.
+type TokenConfig struct {
+	// Keys signs new tokens with the active key and verifies tokens signed
+	// by any key still accepted
+	Keys            *jwks.KeySet
+	TokenTTL        time.Duration
+	RefreshTokenTTL time.Duration
+	// PasswordResetTokenTTL is how long a password reset token may be used
+	PasswordResetTokenTTL time.Duration
+	// Issuer is set as iss and, when not empty, required from parsed tokens
+	Issuer string
+	// Audience is set as aud; parsed tokens must name at least one of them
+	Audience []string
+	// Leeway tolerates clock skew when checking exp, nbf and iat
+	Leeway time.Duration
+	// RequiredClaims lists registered claims (exp, iat, jti, ...) a token must carry
+	RequiredClaims []string
+	// RevocationCacheTTL bounds how long a revocation made by another
+	// instance may go unnoticed by this one.
+	RevocationCacheTTL time.Duration
 }
 
 type AuthService struct {
-	usersRepo      repo.Users
-	passwordHasher hasher.PasswordHasher
-	signKey        string
-	tokenTTL       time.Duration
+	transactor        repo.Transactor
+	usersRepo         repo.Users
+	refreshTokensRepo repo.RefreshTokens
+	revokedTokensRepo repo.RevokedTokens
+	loginAttemptsRepo repo.LoginAttempts
+	passwordHasher    hasher.PasswordHasher
+	notifier          notifier.Notifier
+	referrer          Referrer
+
+	passwordResetTokensRepo repo.PasswordResetTokens
+	passwordResetTokenTTL   time.Duration
+
+	keys            *jwks.KeySet
+	tokenTTL        time.Duration
+	refreshTokenTTL time.Duration
+	issuer          string
+	audience        []string
+	requiredClaims  []string
+	parser          *jwt.Parser
+	throttle        LoginThrottleConfig
+
+	revokedCache       *ttlcache.Cache[string, bool] // map[jti]revoked
+	tokenVersionsCache *ttlcache.Cache[int, int]     // map[user_id]token_version
+
+	mu                sync.Mutex
+	lastAttemptsPurge time.Time
+	lastRevokedPurge  time.Time
+
+	// dummyHash is verified for unknown usernames, so they take as long to
+	// reject as wrong passwords
+	dummyHashOnce sync.Once
+	dummyHash     string
 }
 
-func NewAuthService(usersRepo repo.Users, passwordHasher hasher.PasswordHasher, signKey string, tokenTTL time.Duration) *AuthService {
+func NewAuthService(transactor repo.Transactor, usersRepo repo.Users, refreshTokensRepo repo.RefreshTokens, revokedTokensRepo repo.RevokedTokens, loginAttemptsRepo repo.LoginAttempts, passwordResetTokensRepo repo.PasswordResetTokens, passwordHasher hasher.PasswordHasher, notifier notifier.Notifier, referrer Referrer, tokenCfg TokenConfig, throttleCfg LoginThrottleConfig) *AuthService {
 	return &AuthService{
-		usersRepo:      usersRepo,
-		passwordHasher: passwordHasher,
-		signKey:        signKey,
-		tokenTTL:       tokenTTL,
+		transactor:        transactor,
+		usersRepo:         usersRepo,
+		refreshTokensRepo: refreshTokensRepo,
+		revokedTokensRepo: revokedTokensRepo,
+		loginAttemptsRepo: loginAttemptsRepo,
+		passwordHasher:    passwordHasher,
+		notifier:          notifier,
+		referrer:          referrer,
+
+		passwordResetTokensRepo: passwordResetTokensRepo,
+		passwordResetTokenTTL:   tokenCfg.PasswordResetTokenTTL,
+
+		keys:               tokenCfg.Keys,
+		tokenTTL:           tokenCfg.TokenTTL,
+		refreshTokenTTL:    tokenCfg.RefreshTokenTTL,
+		issuer:             tokenCfg.Issuer,
+		audience:           tokenCfg.Audience,
+		requiredClaims:     tokenCfg.RequiredClaims,
+		parser:             newTokenParser(tokenCfg),
+		throttle:           throttleCfg,
+		revokedCache:       ttlcache.New[string, bool](tokenCfg.RevocationCacheTTL),
+		tokenVersionsCache: ttlcache.New[int, int](tokenCfg.RevocationCacheTTL),
 	}
 }
 
+// CreateUser creates the user with a new referral code. The user and their
+// referrer are saved together: a sign-up with an invalid code creates nothing.
 func (s *AuthService) CreateUser(ctx context.Context, input AuthCreateUserInput) (int, error) {
+	passwordHash, err := s.passwordHasher.Hash(input.Password)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.CreateUser - passwordHasher.Hash", "err", err)
+		return 0, ErrCannotCreateUser
+	}
+
+	code, err := referralCode()
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.CreateUser - referralCode", "err", err)
+		return 0, ErrCannotCreateUser
+	}
+
 	user := entity.User{
-		Username: input.Username,
-		Password: s.passwordHasher.Hash(input.Password),
+		Username:     input.Username,
+		Password:     passwordHash,
+		ReferralCode: code,
+	}
+	// the address comes from request headers and may be anything
+	if addr, err := netip.ParseAddr(input.IP); err == nil {
+		ip := addr.String()
+		user.SignupIP = &ip
 	}
 
+	var userId int
+	var fnErr error
+	err = s.transactor.WithTx(ctx, func(ctx context.Context) error {
+		userId, fnErr = s.createUser(ctx, user, input.ReferralCode)
+		return fnErr
+	})
+	if fnErr != nil {
+		return 0, fnErr
+	}
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.CreateUser - transactor.WithTx", "err", err)
+		return 0, ErrCannotCreateUser
+	}
+	return userId, nil
+}
+
+func (s *AuthService) createUser(ctx context.Context, user entity.User, referralCode string) (int, error) {
 	userId, err := s.usersRepo.CreateUser(ctx, user)
 	if err != nil {
 		if errors.Is(err, repoerrs.ErrAlreadyExists) {
@@ -61,56 +184,306 @@ func (s *AuthService) CreateUser(ctx context.Context, input AuthCreateUserInput)
 		logctx.FromContext(ctx).Error("AuthService.CreateUser - userRepo.CreateUser", "err", err)
 		return 0, ErrCannotCreateUser
 	}
+
+	if referralCode == "" {
+		return userId, nil
+	}
+	err = s.referrer.SetReferrer(ctx, users.UsersSetReferrerInput{UserId: userId, ReferralCode: referralCode})
+	if err != nil {
+		if errors.Is(err, users.ErrInvalidReferralCode) {
+			return 0, ErrInvalidReferralCode
+		}
+		if errors.Is(err, users.ErrReferrerChainTooLong) {
+			return 0, ErrReferrerChainTooLong
+		}
+		logctx.FromContext(ctx).Error("AuthService.CreateUser - referrer.SetReferrer", "err", err)
+		return 0, ErrCannotCreateUser
+	}
 	return userId, nil
 }
 
-func (s *AuthService) GenerateToken(ctx context.Context, input AuthGenerateTokenInput) (string, error) {
+func (s *AuthService) GenerateToken(ctx context.Context, input AuthGenerateTokenInput) (AuthTokens, error) {
+	// refuse to check passwords while the account or the client is locked out
+	attemptKeys := s.loginAttemptKeys(input)
+	if err := s.checkLoginAttempts(ctx, attemptKeys); err != nil {
+		return AuthTokens{}, err
+	}
+
 	// get user from DB
-	user, err := s.usersRepo.GetUserByUsernameAndPassword(ctx, input.Username, s.passwordHasher.Hash(input.Password))
+	user, err := s.usersRepo.GetUserByUsername(ctx, input.Username)
 	if err != nil {
 		if errors.Is(err, repoerrs.ErrNotFound) {
-			return "", ErrUserNotFound
+			s.verifyDummyPassword(ctx, input.Password)
+			s.registerLoginFailure(ctx, attemptKeys)
+			return AuthTokens{}, ErrUserNotFound
 		}
 		logctx.FromContext(ctx).Error("AuthService.GenerateToken: cannot get user", "err", err)
-		return "", ErrCannotGetUser
+		return AuthTokens{}, ErrCannotGetUser
+	}
+
+	// verify password; a wrong password is reported as ErrUserNotFound
+	// so that callers cannot tell existing usernames apart
+	ok, err := s.passwordHasher.Verify(input.Password, user.Password)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.GenerateToken: cannot verify password", "err", err)
+		return AuthTokens{}, ErrCannotVerifyPassword
+	}
+	if !ok {
+		s.registerLoginFailure(ctx, attemptKeys)
+		return AuthTokens{}, ErrUserNotFound
+	}
+	s.resetLoginAttempts(ctx, attemptKeys)
+
+	// upgrade legacy or weak hashes while the plain password is at hand
+	if s.passwordHasher.NeedsRehash(user.Password) {
+		s.rehashPassword(ctx, user.Id, input.Password)
+	}
+
+	// a sign-in starts a new refresh token family
+	return s.issueTokens(ctx, user, "")
+}
+
+func (s *AuthService) RefreshToken(ctx context.Context, input AuthRefreshTokenInput) (AuthTokens, error) {
+	stored, err := s.refreshTokensRepo.GetRefreshTokenByHash(ctx, hashToken(input.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return AuthTokens{}, ErrInvalidRefreshToken
+		}
+		logctx.FromContext(ctx).Error("AuthService.RefreshToken - refreshTokensRepo.GetRefreshTokenByHash", "err", err)
+		return AuthTokens{}, ErrCannotRefreshToken
+	}
+
+	// a revoked token being presented again means it has leaked:
+	// revoke the whole family so the holder of the newest token is logged out as well
+	if stored.RevokedAt != nil {
+		s.revokeRefreshTokenFamily(ctx, stored.FamilyId)
+		return AuthTokens{}, ErrRefreshTokenReused
+	}
+	if time.Now().After(stored.ExpiresAt) {
+		return AuthTokens{}, ErrInvalidRefreshToken
+	}
+
+	// the old token is revoked only together with the issue of the new
+	// pair, so a failure in between does not log the user out
+	var tokens AuthTokens
+	var fnErr error
+	err = s.transactor.WithTx(ctx, func(ctx context.Context) error {
+		tokens, fnErr = s.rotateRefreshToken(ctx, stored)
+		return fnErr
+	})
+	if fnErr != nil {
+		if errors.Is(fnErr, ErrRefreshTokenReused) {
+			// rotated concurrently by another request; the family is
+			// revoked outside of the rolled back transaction
+			s.revokeRefreshTokenFamily(ctx, stored.FamilyId)
+		}
+		return AuthTokens{}, fnErr
+	}
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.RefreshToken - transactor.WithTx", "err", err)
+		return AuthTokens{}, ErrCannotRefreshToken
+	}
+	return tokens, nil
+}
+
+// rotateRefreshToken revokes the stored token and issues a new pair in its
+// family. It must run in a transaction.
+func (s *AuthService) rotateRefreshToken(ctx context.Context, stored entity.RefreshToken) (AuthTokens, error) {
+	err := s.refreshTokensRepo.RevokeRefreshToken(ctx, stored.Id)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return AuthTokens{}, ErrRefreshTokenReused
+		}
+		logctx.FromContext(ctx).Error("AuthService.RefreshToken - refreshTokensRepo.RevokeRefreshToken", "err", err)
+		return AuthTokens{}, ErrCannotRefreshToken
+	}
+
+	// reload the user so that role and token version changes are picked up
+	user, err := s.usersRepo.GetUserById(ctx, stored.UserId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return AuthTokens{}, ErrInvalidRefreshToken
+		}
+		logctx.FromContext(ctx).Error("AuthService.RefreshToken - usersRepo.GetUserById", "err", err)
+		return AuthTokens{}, ErrCannotGetUser
+	}
+
+	return s.issueTokens(ctx, user, stored.FamilyId)
+}
+
+// issueTokens signs an access token and stores a new refresh token in the given family.
+// An empty familyId starts a new family. The user must be freshly read from DB:
+// a stale token version would produce a token that is rejected right away.
+func (s *AuthService) issueTokens(ctx context.Context, user entity.User, familyId string) (AuthTokens, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.issueTokens - randomToken", "err", err)
+		return AuthTokens{}, ErrCannotSignToken
 	}
 
 	// generate token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &TokenClaims{
-		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Add(s.tokenTTL).Unix(),
-			IssuedAt:  time.Now().Unix(),
+	signingKey := s.keys.Active()
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(signingKey.Algorithm), &TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        jti,
+			Issuer:    s.issuer,
+			Subject:   strconv.Itoa(user.Id),
+			Audience:  s.audience,
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.tokenTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
-		UserId: user.Id,
+		UserId:       user.Id,
+		Role:         user.Role,
+		TokenVersion: user.TokenVersion,
 	})
 
+	if signingKey.Id != "" {
+		token.Header["kid"] = signingKey.Id
+	}
+
 	// sign token
-	tokenString, err := token.SignedString([]byte(s.signKey))
+	tokenString, err := token.SignedString(signingKey.Private)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.issueTokens: cannot sign token", "err", err)
+		return AuthTokens{}, ErrCannotSignToken
+	}
+
+	if familyId == "" {
+		familyId, err = randomToken(16)
+		if err != nil {
+			logctx.FromContext(ctx).Error("AuthService.issueTokens - randomToken", "err", err)
+			return AuthTokens{}, ErrCannotIssueRefreshToken
+		}
+	}
+
+	refreshToken, err := randomToken(32)
 	if err != nil {
-		logctx.FromContext(ctx).Error("AuthService.GenerateToken: cannot sign token", "err", err)
-		return "", ErrCannotSignToken
+		logctx.FromContext(ctx).Error("AuthService.issueTokens - randomToken", "err", err)
+		return AuthTokens{}, ErrCannotIssueRefreshToken
 	}
 
-	return tokenString, nil
+	err = s.refreshTokensRepo.CreateRefreshToken(ctx, entity.RefreshToken{
+		UserId:    user.Id,
+		TokenHash: hashToken(refreshToken),
+		FamilyId:  familyId,
+		ExpiresAt: time.Now().Add(s.refreshTokenTTL),
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.issueTokens - refreshTokensRepo.CreateRefreshToken", "err", err)
+		return AuthTokens{}, ErrCannotIssueRefreshToken
+	}
+
+	return AuthTokens{AccessToken: tokenString, RefreshToken: refreshToken}, nil
 }
 
-func (s *AuthService) ParseToken(accessToken string) (int, error) {
-	token, err := jwt.ParseWithClaims(accessToken, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+func (s *AuthService) revokeRefreshTokenFamily(ctx context.Context, familyId string) {
+	err := s.refreshTokensRepo.RevokeRefreshTokenFamily(ctx, familyId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.revokeRefreshTokenFamily - refreshTokensRepo.RevokeRefreshTokenFamily", "err", err)
+	}
+}
 
-		return []byte(s.signKey), nil
+// verifyDummyPassword checks the password against a hash no user has, so that
+// a sign-in with an unknown username costs the same as one with a wrong
+// password and the timing does not tell usernames apart.
+func (s *AuthService) verifyDummyPassword(ctx context.Context, password string) {
+	s.dummyHashOnce.Do(func() {
+		hash, err := s.passwordHasher.Hash("dummy password of no user")
+		if err != nil {
+			logctx.FromContext(ctx).Warn("AuthService.verifyDummyPassword - passwordHasher.Hash", "err", err)
+			return
+		}
+		s.dummyHash = hash
 	})
+	if s.dummyHash != "" {
+		_, _ = s.passwordHasher.Verify(password, s.dummyHash)
+	}
+}
+
+// rehashPassword stores a fresh hash of the password. Failures are only
+// logged: the user has already been authenticated.
+func (s *AuthService) rehashPassword(ctx context.Context, userId int, password string) {
+	passwordHash, err := s.passwordHasher.Hash(password)
+	if err != nil {
+		logctx.FromContext(ctx).Warn("AuthService.rehashPassword - passwordHasher.Hash", "err", err)
+		return
+	}
+
+	err = s.usersRepo.SetUserPassword(ctx, userId, passwordHash)
+	if err != nil {
+		logctx.FromContext(ctx).Warn("AuthService.rehashPassword - usersRepo.SetUserPassword", "err", err)
+	}
+}
+
+// newTokenParser validates exp, nbf and iat with the configured leeway, and
+// iss and aud when they are configured. exp is always required: revoked
+// tokens are kept until they expire.
+func newTokenParser(tokenCfg TokenConfig) *jwt.Parser {
+	opts := []jwt.ParserOption{
+		jwt.WithLeeway(tokenCfg.Leeway),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	}
+	if tokenCfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(tokenCfg.Issuer))
+	}
+	if len(tokenCfg.Audience) > 0 {
+		opts = append(opts, jwt.WithAudience(tokenCfg.Audience...))
+	}
+	return jwt.NewParser(opts...)
+}
 
+func (s *AuthService) ParseToken(ctx context.Context, accessToken string) (AuthIdentity, error) {
+	token, err := s.parser.ParseWithClaims(accessToken, &TokenClaims{}, s.verificationKey)
 	if err != nil {
-		return 0, ErrCannotParseToken
+		logctx.FromContext(ctx).Debug("AuthService.ParseToken - parser.ParseWithClaims", "err", err)
+		return AuthIdentity{}, tokenError(err)
 	}
 
 	claims, ok := token.Claims.(*TokenClaims)
 	if !ok {
-		return 0, ErrCannotParseToken
+		return AuthIdentity{}, ErrCannotParseToken
+	}
+
+	// jti is always required: revocation is keyed by it
+	if claims.ID == "" {
+		return AuthIdentity{}, ErrTokenInvalidClaims
+	}
+	if name, missing := missingClaim(claims, s.requiredClaims); missing {
+		logctx.FromContext(ctx).Debug("AuthService.ParseToken: required claim missing", "claim", name)
+		return AuthIdentity{}, ErrTokenInvalidClaims
 	}
 
-	return claims.UserId, nil
+	if err := s.checkRevocation(ctx, claims); err != nil {
+		return AuthIdentity{}, err
+	}
+
+	return AuthIdentity{
+		UserId:    claims.UserId,
+		Role:      claims.Role,
+		TokenId:   claims.ID,
+		ExpiresAt: claims.ExpiresAt.Time,
+	}, nil
+}
+
+// verificationKey picks the key by the token's kid. Tokens signed by a
+// retired key are accepted until the key's grace period elapses.
+func (s *AuthService) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := s.keys.Lookup(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %q", kid)
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+func (s *AuthService) JWKS() jwks.JSONWebKeySet {
+	return s.keys.JWKS()
 }
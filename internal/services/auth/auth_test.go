@@ -2,12 +2,21 @@ package auth
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/memdb"
 	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/internal/services/users"
+	"denet-test-task/pkg/jwks"
+	"denet-test-task/pkg/notifier"
 	"errors"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,18 +26,21 @@ type mockUsersRepo struct {
 	createErr     error
 	getUserResp   entity.User
 	getUserErr    error
-	lastGetUser   struct{ Username, Password string }
+	lastGetUser   string
 	getByIDMap    map[int]entity.User
 	getByIDErr    error
 	setReferrerID struct {
 		ID, Referrer int
 	}
-	setReferrerErr error
-	setEmailID     struct {
-		ID    int
-		Email string
-	}
-	setEmailErr error
+	setReferrerErr  error
+	setPasswordID   int
+	setPasswordVal  string
+	setPasswordErr  error
+	tokenVersion    int
+	tokenVersionErr error
+	setRoleID       int
+	setRoleVal      entity.Role
+	setRoleErr      error
 }
 
 func (m *mockUsersRepo) CreateUser(_ context.Context, user entity.User) (int, error) {
@@ -41,10 +53,6 @@ func (m *mockUsersRepo) CreateUser(_ context.Context, user entity.User) (int, er
 	}
 	return m.createUserID, nil
 }
-func (m *mockUsersRepo) GetUserByUsernameAndPassword(_ context.Context, username, password string) (entity.User, error) {
-	m.lastGetUser = struct{ Username, Password string }{Username: username, Password: password}
-	return m.getUserResp, m.getUserErr
-}
 func (m *mockUsersRepo) GetUserById(_ context.Context, id int) (entity.User, error) {
 	if m.getByIDErr != nil {
 		return entity.User{}, m.getByIDErr
@@ -52,38 +60,301 @@ func (m *mockUsersRepo) GetUserById(_ context.Context, id int) (entity.User, err
 	if u, ok := m.getByIDMap[id]; ok {
 		return u, nil
 	}
+	if id != 0 && m.getUserResp.Id == id {
+		return m.getUserResp, nil
+	}
 	return entity.User{}, repoerrs.ErrNotFound
 }
-func (m *mockUsersRepo) GetUserByUsername(_ context.Context, _ string) (entity.User, error) {
+func (m *mockUsersRepo) GetUserByUsername(_ context.Context, username string) (entity.User, error) {
+	m.lastGetUser = username
+	return m.getUserResp, m.getUserErr
+}
+func (m *mockUsersRepo) GetUserByEmail(_ context.Context, email string) (entity.User, error) {
+	if m.getUserResp.Email != nil && *m.getUserResp.Email == email {
+		return m.getUserResp, nil
+	}
 	return entity.User{}, repoerrs.ErrNotFound
 }
+
 func (m *mockUsersRepo) SetUserReferrer(_ context.Context, id int, referrer int) error {
 	m.setReferrerID = struct {
 		ID, Referrer int
 	}{ID: id, Referrer: referrer}
 	return m.setReferrerErr
 }
-func (m *mockUsersRepo) SetUserEmail(_ context.Context, id int, email string) error {
-	m.setEmailID = struct {
-		ID    int
-		Email string
-	}{ID: id, Email: email}
-	return m.setEmailErr
+func (m *mockUsersRepo) SetUserEmailVerified(_ context.Context, _ int, _ string) error {
+	return nil
+}
+
+func (m *mockUsersRepo) SetUserPassword(_ context.Context, id int, password string) error {
+	m.setPasswordID = id
+	m.setPasswordVal = password
+	return m.setPasswordErr
 }
 
+type mockRefreshTokensRepo struct {
+	tokens        []entity.RefreshToken
+	createErr     error
+	revokeErr     error
+	revokedFamily string
+	revokedUser   int
+}
+
+func (m *mockRefreshTokensRepo) CreateRefreshToken(_ context.Context, token entity.RefreshToken) error {
+	if m.createErr != nil {
+		return m.createErr
+	}
+	token.Id = len(m.tokens) + 1
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+func (m *mockRefreshTokensRepo) GetRefreshTokenByHash(_ context.Context, tokenHash string) (entity.RefreshToken, error) {
+	for _, token := range m.tokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return entity.RefreshToken{}, repoerrs.ErrNotFound
+}
+func (m *mockRefreshTokensRepo) RevokeRefreshToken(_ context.Context, id int) error {
+	if m.revokeErr != nil {
+		return m.revokeErr
+	}
+	now := time.Now()
+	m.tokens[id-1].RevokedAt = &now
+	return nil
+}
+func (m *mockRefreshTokensRepo) RevokeRefreshTokenFamily(_ context.Context, familyId string) error {
+	m.revokedFamily = familyId
+	return nil
+}
+func (m *mockRefreshTokensRepo) RevokeUserRefreshTokens(_ context.Context, userId int) error {
+	m.revokedUser = userId
+	return nil
+}
+
+func (m *mockUsersRepo) SetUserRole(_ context.Context, id int, role entity.Role) error {
+	m.setRoleID = id
+	m.setRoleVal = role
+	return m.setRoleErr
+}
+func (m *mockUsersRepo) GetUserTokenVersion(_ context.Context, _ int) (int, error) {
+	return m.tokenVersion, m.tokenVersionErr
+}
+func (m *mockUsersRepo) IncrementUserTokenVersion(_ context.Context, _ int) (int, error) {
+	m.tokenVersion++
+	m.getUserResp.TokenVersion = m.tokenVersion
+	return m.tokenVersion, m.tokenVersionErr
+}
+func (m *mockUsersRepo) SetUserTimezone(_ context.Context, _ int, _ string, _ time.Time, _ time.Duration) error {
+	return nil
+}
+func (m *mockUsersRepo) GetReferrerChain(_ context.Context, id int, _ int) ([]int, error) {
+	return []int{id}, nil
+}
+func (m *mockUsersRepo) LockReferrerChains(_ context.Context) error { return nil }
+func (m *mockUsersRepo) GetUserByReferralCode(_ context.Context, _ string) (entity.User, error) {
+	return entity.User{}, repoerrs.ErrNotFound
+}
+
+type mockTransactor struct {
+	committed  int
+	rolledBack int
+}
+
+func (m *mockTransactor) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := fn(ctx); err != nil {
+		m.rolledBack++
+		return err
+	}
+	m.committed++
+	return nil
+}
+
+type mockReferrer struct {
+	inputs []users.UsersSetReferrerInput
+	err    error
+}
+
+func (m *mockReferrer) SetReferrer(_ context.Context, input users.UsersSetReferrerInput) error {
+	m.inputs = append(m.inputs, input)
+	return m.err
+}
+
+type mockRevokedTokensRepo struct {
+	revoked    map[string]bool
+	checkCalls int
+	checkErr   error
+	purges     int
+}
+
+func (m *mockRevokedTokensRepo) RevokeToken(_ context.Context, token entity.RevokedToken) error {
+	if m.revoked == nil {
+		m.revoked = map[string]bool{}
+	}
+	m.revoked[token.Jti] = true
+	return nil
+}
+func (m *mockRevokedTokensRepo) IsTokenRevoked(_ context.Context, jti string) (bool, error) {
+	m.checkCalls++
+	return m.revoked[jti], m.checkErr
+}
+func (m *mockRevokedTokensRepo) DeleteExpiredRevokedTokens(_ context.Context, _ time.Time) error {
+	m.purges++
+	return nil
+}
+
+type mockPasswordResetTokensRepo struct {
+	tokens      []entity.PasswordResetToken
+	invalidated int
+}
+
+func (m *mockPasswordResetTokensRepo) CreatePasswordResetToken(_ context.Context, token entity.PasswordResetToken) error {
+	token.Id = len(m.tokens) + 1
+	m.tokens = append(m.tokens, token)
+	return nil
+}
+func (m *mockPasswordResetTokensRepo) ConsumePasswordResetToken(_ context.Context, tokenHash string) (int, error) {
+	for i, token := range m.tokens {
+		if token.TokenHash == tokenHash && token.UsedAt == nil && time.Now().Before(token.ExpiresAt) {
+			now := time.Now()
+			m.tokens[i].UsedAt = &now
+			return token.UserId, nil
+		}
+	}
+	return 0, repoerrs.ErrNotFound
+}
+func (m *mockPasswordResetTokensRepo) InvalidateUserPasswordResetTokens(_ context.Context, userId int) error {
+	m.invalidated = userId
+	return nil
+}
+
+type mockNotifier struct {
+	sent []notifier.Message
+	err  error
+}
+
+func (m *mockNotifier) Notify(_ context.Context, msg notifier.Message) error {
+	m.sent = append(m.sent, msg)
+	return m.err
+}
+
+func mustKeySet(active jwks.Key, previous ...jwks.Key) *jwks.KeySet {
+	set, err := jwks.NewKeySet(active, time.Hour, previous...)
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+func newEd25519Key(t *testing.T, kid string) jwks.Key {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	return jwks.Key{Id: kid, Algorithm: jwks.AlgEdDSA, Private: private, Public: public}
+}
+
+var testTokenConfig = TokenConfig{
+	Keys:            mustKeySet(jwks.NewHMACKey("", []byte("secret-key"))),
+	TokenTTL:        time.Hour,
+	RefreshTokenTTL: 24 * time.Hour,
+	Issuer:          "test-issuer",
+	Audience:        []string{"test-api"},
+	RequiredClaims:  []string{"exp", "iat", "jti", "sub"},
+
+	PasswordResetTokenTTL: 30 * time.Minute,
+
+	RevocationCacheTTL: time.Minute,
+}
+
+var testThrottleConfig = LoginThrottleConfig{
+	UserFreeAttempts: 3,
+	IPFreeAttempts:   5,
+	BaseLockout:      time.Minute,
+	MaxLockout:       time.Hour,
+	Window:           time.Hour,
+}
+
+type failingLoginAttemptsRepo struct {
+	*memdb.LoginAttemptsRepo
+}
+
+func (failingLoginAttemptsRepo) GetLoginAttempt(_ context.Context, _ string) (entity.LoginAttempt, error) {
+	return entity.LoginAttempt{}, errors.New("db")
+}
+
+type purgeCountingLoginAttemptsRepo struct {
+	*memdb.LoginAttemptsRepo
+	purges int
+}
+
+func (r *purgeCountingLoginAttemptsRepo) DeleteStaleLoginAttempts(ctx context.Context, at time.Time, window time.Duration) error {
+	r.purges++
+	return r.LoginAttemptsRepo.DeleteStaleLoginAttempts(ctx, at, window)
+}
+
+// signTestClaims signs claims with the key of testTokenConfig.
+func signTestClaims(t *testing.T, claims *TokenClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret-key"))
+	assert.NoError(t, err)
+	return token
+}
+
+func validTestClaims() *TokenClaims {
+	now := time.Now()
+	return &TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        "jti-1",
+			Issuer:    "test-issuer",
+			Subject:   "7",
+			Audience:  jwt.ClaimStrings{"test-api"},
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(now),
+		},
+		UserId: 7,
+	}
+}
+
+// mockHasher accepts the password "pwd" for the hash stored in valid.
 type mockHasher struct {
-	out string
+	out       string
+	valid     string
+	verifyErr error
+	rehash    bool
 }
 
-func (m mockHasher) Hash(password string) string {
+func (m mockHasher) Hash(password string) (string, error) {
 	_ = password
-	return m.out
+	return m.out, nil
+}
+
+func (m mockHasher) Verify(password, encodedHash string) (bool, error) {
+	if m.verifyErr != nil {
+		return false, m.verifyErr
+	}
+	return password == "pwd" && encodedHash == m.valid, nil
+}
+
+func (m mockHasher) NeedsRehash(_ string) bool {
+	return m.rehash
+}
+
+// countingHasher counts the hashes it verifies.
+type countingHasher struct {
+	mockHasher
+	verified *[]string
+}
+
+func (h countingHasher) Verify(password, encodedHash string) (bool, error) {
+	*h.verified = append(*h.verified, encodedHash)
+	return h.mockHasher.Verify(password, encodedHash)
 }
 
 func TestAuthService_CreateUser_Success(t *testing.T) {
 	repoMock := &mockUsersRepo{}
 	h := mockHasher{out: "HPASS"}
-	svc := NewAuthService(repoMock, h, "key", time.Hour)
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, h, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
 
 	id, err := svc.CreateUser(context.Background(), AuthCreateUserInput{
 		Username: "john", Password: "secret",
@@ -92,18 +363,52 @@ func TestAuthService_CreateUser_Success(t *testing.T) {
 	assert.NotZero(t, id)
 	assert.Equal(t, "john", repoMock.createdUser.Username)
 	assert.Equal(t, "HPASS", repoMock.createdUser.Password)
+	assert.Len(t, repoMock.createdUser.ReferralCode, 10)
+}
+
+func TestAuthService_CreateUser_ReferralCode(t *testing.T) {
+	repoMock := &mockUsersRepo{createUserID: 42}
+	referrer := &mockReferrer{}
+	transactor := &mockTransactor{}
+	svc := NewAuthService(transactor, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "HPASS"}, &mockNotifier{}, referrer, testTokenConfig, testThrottleConfig)
+
+	// without a code the referrer is left unset
+	_, err := svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "john", Password: "secret"})
+	assert.NoError(t, err)
+	assert.Empty(t, referrer.inputs)
+
+	id, err := svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "jane", Password: "secret", ReferralCode: "abc"})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, id)
+	assert.Equal(t, []users.UsersSetReferrerInput{{UserId: 42, ReferralCode: "abc"}}, referrer.inputs)
+	assert.Equal(t, 2, transactor.committed)
+
+	// the user is not created with an unknown code
+	referrer.err = users.ErrInvalidReferralCode
+	_, err = svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "jim", Password: "secret", ReferralCode: "nope"})
+	assert.ErrorIs(t, err, ErrInvalidReferralCode)
+	assert.Equal(t, 1, transactor.rolledBack)
+
+	referrer.err = users.ErrReferrerChainTooLong
+	_, err = svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "jim", Password: "secret", ReferralCode: "abc"})
+	assert.ErrorIs(t, err, ErrReferrerChainTooLong)
+
+	referrer.err = users.ErrCannotSetReferrer
+	_, err = svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "jim", Password: "secret", ReferralCode: "abc"})
+	assert.ErrorIs(t, err, ErrCannotCreateUser)
+	assert.Equal(t, 3, transactor.rolledBack)
 }
 
 func TestAuthService_CreateUser_AlreadyExists(t *testing.T) {
 	repoMock := &mockUsersRepo{createErr: repoerrs.ErrAlreadyExists}
-	svc := NewAuthService(repoMock, mockHasher{out: "x"}, "key", time.Hour)
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "x"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
 	_, err := svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "a", Password: "b"})
 	assert.ErrorIs(t, err, ErrUserAlreadyExists)
 }
 
 func TestAuthService_CreateUser_InternalError(t *testing.T) {
 	repoMock := &mockUsersRepo{createErr: errors.New("db down")}
-	svc := NewAuthService(repoMock, mockHasher{out: "x"}, "key", time.Hour)
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "x"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
 	_, err := svc.CreateUser(context.Background(), AuthCreateUserInput{Username: "a", Password: "b"})
 	assert.ErrorIs(t, err, ErrCannotCreateUser)
 }
@@ -111,33 +416,583 @@ func TestAuthService_CreateUser_InternalError(t *testing.T) {
 func TestAuthService_GenerateToken_Errors(t *testing.T) {
 	// not found
 	repoMock := &mockUsersRepo{getUserErr: repoerrs.ErrNotFound}
-	svc := NewAuthService(repoMock, mockHasher{out: "HPASS"}, "key", time.Hour)
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
 	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "a", Password: "b"})
 	assert.ErrorIs(t, err, ErrUserNotFound)
 	// internal
 	repoMock2 := &mockUsersRepo{getUserErr: errors.New("db")}
-	svc2 := NewAuthService(repoMock2, mockHasher{out: "HPASS"}, "key", time.Hour)
+	svc2 := NewAuthService(&mockTransactor{}, repoMock2, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
 	_, err = svc2.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "a", Password: "b"})
 	assert.ErrorIs(t, err, ErrCannotGetUser)
 }
 
+func TestAuthService_GenerateToken_WrongPassword(t *testing.T) {
+	repoMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "bad"})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestAuthService_GenerateToken_VerifyError(t *testing.T) {
+	repoMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{verifyErr: errors.New("malformed")}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.ErrorIs(t, err, ErrCannotVerifyPassword)
+}
+
 func TestAuthService_GenerateAndParseToken_Success(t *testing.T) {
 	repoMock := &mockUsersRepo{
-		getUserResp: entity.User{Id: 42, Username: "john"},
+		getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"},
 	}
-	svc := NewAuthService(repoMock, mockHasher{out: "HPASS"}, "secret-key", time.Hour)
-	token, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
 	assert.NoError(t, err)
-	id, err := svc.ParseToken(token)
+	identity, err := svc.ParseToken(context.Background(), tokens.AccessToken)
 	assert.NoError(t, err)
-	assert.Equal(t, 42, id)
-	assert.Equal(t, "john", repoMock.lastGetUser.Username)
-	assert.Equal(t, "HPASS", repoMock.lastGetUser.Password)
+	assert.Equal(t, 42, identity.UserId)
+	assert.NotEmpty(t, identity.TokenId)
+	assert.Equal(t, "john", repoMock.lastGetUser)
+	assert.Zero(t, repoMock.setPasswordID, "current hash must not be rewritten")
+}
+
+func TestAuthService_GenerateToken_RehashesLegacyPassword(t *testing.T) {
+	repoMock := &mockUsersRepo{
+		getUserResp: entity.User{Id: 42, Username: "john", Password: "LEGACY"},
+	}
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "NEWHASH", valid: "LEGACY", rehash: true}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, repoMock.setPasswordID)
+	assert.Equal(t, "NEWHASH", repoMock.setPasswordVal)
+}
+
+func TestAuthService_GenerateToken_RehashFailureDoesNotBlockLogin(t *testing.T) {
+	repoMock := &mockUsersRepo{
+		getUserResp:    entity.User{Id: 42, Username: "john", Password: "LEGACY"},
+		setPasswordErr: errors.New("db"),
+	}
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "NEWHASH", valid: "LEGACY", rehash: true}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.AccessToken)
 }
 
 func TestAuthService_ParseToken_Invalid(t *testing.T) {
 	repoMock := &mockUsersRepo{}
-	svc := NewAuthService(repoMock, mockHasher{out: "x"}, "secret", time.Hour)
-	_, err := svc.ParseToken("not-a-token")
-	assert.ErrorIs(t, err, ErrCannotParseToken)
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "x"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	_, err := svc.ParseToken(context.Background(), "not-a-token")
+	assert.ErrorIs(t, err, ErrTokenMalformed)
+}
+
+func TestAuthService_GenerateToken_IssuesHashedRefreshToken(t *testing.T) {
+	repoMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	refreshMock := &mockRefreshTokensRepo{}
+	svc := NewAuthService(&mockTransactor{}, repoMock, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, tokens.RefreshToken)
+	if assert.Len(t, refreshMock.tokens, 1) {
+		stored := refreshMock.tokens[0]
+		assert.Equal(t, 42, stored.UserId)
+		assert.Equal(t, hashToken(tokens.RefreshToken), stored.TokenHash)
+		assert.NotEqual(t, tokens.RefreshToken, stored.TokenHash)
+		assert.NotEmpty(t, stored.FamilyId)
+	}
+}
+
+func TestAuthService_GenerateToken_RefreshTokenStoreError(t *testing.T) {
+	repoMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, repoMock, &mockRefreshTokensRepo{createErr: errors.New("db")}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.ErrorIs(t, err, ErrCannotIssueRefreshToken)
+}
+
+func TestAuthService_RefreshToken_Rotates(t *testing.T) {
+	repoMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	refreshMock := &mockRefreshTokensRepo{}
+	svc := NewAuthService(&mockTransactor{}, repoMock, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	first, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.NoError(t, err)
+
+	second, err := svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: first.RefreshToken})
+	assert.NoError(t, err)
+	assert.NotEqual(t, first.RefreshToken, second.RefreshToken)
+	identity, err := svc.ParseToken(context.Background(), second.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, identity.UserId)
+
+	if assert.Len(t, refreshMock.tokens, 2) {
+		assert.NotNil(t, refreshMock.tokens[0].RevokedAt, "used token must be revoked")
+		assert.Nil(t, refreshMock.tokens[1].RevokedAt)
+		assert.Equal(t, refreshMock.tokens[0].FamilyId, refreshMock.tokens[1].FamilyId)
+	}
+}
+
+func TestAuthService_RefreshToken_ReuseRevokesFamily(t *testing.T) {
+	repoMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	refreshMock := &mockRefreshTokensRepo{}
+	svc := NewAuthService(&mockTransactor{}, repoMock, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	first, _ := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	_, err := svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: first.RefreshToken})
+	assert.NoError(t, err)
+
+	_, err = svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: first.RefreshToken})
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.Equal(t, refreshMock.tokens[0].FamilyId, refreshMock.revokedFamily)
+}
+
+func TestAuthService_RefreshToken_ConcurrentRotationRevokesFamily(t *testing.T) {
+	refreshMock := &mockRefreshTokensRepo{
+		tokens:    []entity.RefreshToken{{Id: 1, UserId: 42, TokenHash: hashToken("rt"), FamilyId: "fam", ExpiresAt: time.Now().Add(time.Hour)}},
+		revokeErr: repoerrs.ErrNotFound,
+	}
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	_, err := svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: "rt"})
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+	assert.Equal(t, "fam", refreshMock.revokedFamily)
+}
+
+func TestAuthService_RefreshToken_IssueErrorRollsBack(t *testing.T) {
+	refreshMock := &mockRefreshTokensRepo{
+		tokens:    []entity.RefreshToken{{Id: 1, UserId: 42, TokenHash: hashToken("rt"), FamilyId: "fam", ExpiresAt: time.Now().Add(time.Hour)}},
+		createErr: errors.New("db"),
+	}
+	transactor := &mockTransactor{}
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john"}}
+	svc := NewAuthService(transactor, usersMock, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	_, err := svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: "rt"})
+	assert.ErrorIs(t, err, ErrCannotIssueRefreshToken)
+	// the revocation of the old token is rolled back with the failed issue
+	assert.Equal(t, 1, transactor.rolledBack)
+	assert.Zero(t, transactor.committed)
+	assert.Empty(t, refreshMock.revokedFamily)
+}
+
+func TestAuthService_RefreshToken_Invalid(t *testing.T) {
+	refreshMock := &mockRefreshTokensRepo{
+		tokens: []entity.RefreshToken{{Id: 1, UserId: 42, TokenHash: hashToken("expired"), FamilyId: "fam", ExpiresAt: time.Now().Add(-time.Minute)}},
+	}
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	_, err := svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: "unknown"})
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	_, err = svc.RefreshToken(context.Background(), AuthRefreshTokenInput{RefreshToken: "expired"})
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+	assert.Empty(t, refreshMock.revokedFamily)
+}
+
+func newLoggedInService(t *testing.T) (*AuthService, *mockUsersRepo, *mockRefreshTokensRepo, *mockRevokedTokensRepo, AuthTokens) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "john", Password: "HPASS"}}
+	refreshMock := &mockRefreshTokensRepo{}
+	revokedMock := &mockRevokedTokensRepo{}
+	svc := NewAuthService(&mockTransactor{}, usersMock, refreshMock, revokedMock, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.NoError(t, err)
+	return svc, usersMock, refreshMock, revokedMock, tokens
+}
+
+func TestAuthService_Logout_RevokesAccessAndRefreshTokens(t *testing.T) {
+	svc, _, refreshMock, revokedMock, tokens := newLoggedInService(t)
+	identity, err := svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+
+	err = svc.Logout(context.Background(), AuthLogoutInput{Identity: identity, RefreshToken: tokens.RefreshToken})
+	assert.NoError(t, err)
+	assert.True(t, revokedMock.revoked[identity.TokenId])
+	assert.Equal(t, refreshMock.tokens[0].FamilyId, refreshMock.revokedFamily)
+
+	_, err = svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestAuthService_Logout_PurgesExpiredRevokedTokensOncePerTTL(t *testing.T) {
+	svc, _, _, revokedMock, tokens := newLoggedInService(t)
+	identity, err := svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = svc.Logout(context.Background(), AuthLogoutInput{Identity: identity})
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, revokedMock.purges)
+}
+
+func TestAuthService_Logout_IgnoresForeignRefreshToken(t *testing.T) {
+	svc, _, refreshMock, _, tokens := newLoggedInService(t)
+	err := svc.Logout(context.Background(), AuthLogoutInput{
+		Identity:     AuthIdentity{UserId: 7, TokenId: "other", ExpiresAt: time.Now().Add(time.Hour)},
+		RefreshToken: tokens.RefreshToken,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, refreshMock.revokedFamily)
+}
+
+func TestAuthService_LogoutAll_InvalidatesIssuedTokens(t *testing.T) {
+	svc, _, refreshMock, _, tokens := newLoggedInService(t)
+
+	err := svc.LogoutAll(context.Background(), AuthLogoutAllInput{UserId: 42})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, refreshMock.revokedUser)
+
+	_, err = svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+
+	// tokens issued after the logout carry the new version
+	fresh, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "john", Password: "pwd"})
+	assert.NoError(t, err)
+	_, err = svc.ParseToken(context.Background(), fresh.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestAuthService_ParseToken_CachesRevocationChecks(t *testing.T) {
+	svc, _, _, revokedMock, tokens := newLoggedInService(t)
+	for i := 0; i < 3; i++ {
+		_, err := svc.ParseToken(context.Background(), tokens.AccessToken)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, revokedMock.checkCalls)
+}
+
+func TestAuthService_ParseToken_RevocationCheckError(t *testing.T) {
+	svc, _, _, revokedMock, tokens := newLoggedInService(t)
+	revokedMock.checkErr = errors.New("db")
+	_, err := svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrCannotCheckRevocation)
+}
+
+func TestAuthService_GenerateToken_EmbedsRole(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 42, Username: "root", Password: "HPASS", Role: entity.RoleAdmin}}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "root", Password: "pwd"})
+	assert.NoError(t, err)
+
+	identity, err := svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, entity.RoleAdmin, identity.Role)
+}
+
+func TestAuthService_SetRole_InvalidRole(t *testing.T) {
+	usersMock := &mockUsersRepo{}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	err := svc.SetRole(context.Background(), AuthSetRoleInput{UserId: 1, Role: "root"})
+	assert.ErrorIs(t, err, ErrInvalidRole)
+	assert.Zero(t, usersMock.setRoleID)
+}
+
+func TestAuthService_SetRole_NotFound(t *testing.T) {
+	usersMock := &mockUsersRepo{setRoleErr: repoerrs.ErrNotFound}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	err := svc.SetRole(context.Background(), AuthSetRoleInput{UserId: 1, Role: entity.RoleModerator})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestAuthService_SetRole_RevokesIssuedTokens(t *testing.T) {
+	svc, usersMock, refreshMock, _, tokens := newLoggedInService(t)
+
+	err := svc.SetRole(context.Background(), AuthSetRoleInput{UserId: 42, Role: entity.RoleModerator})
+	assert.NoError(t, err)
+	assert.Equal(t, 42, usersMock.setRoleID)
+	assert.Equal(t, entity.RoleModerator, usersMock.setRoleVal)
+	assert.Equal(t, 42, refreshMock.revokedUser)
+
+	_, err = svc.ParseToken(context.Background(), tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestAuthService_Tokens_SignedWithActiveKeyAndVerifiedAfterRotation(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 9, Username: "bob", Password: "HPASS"}}
+	oldKey := newEd25519Key(t, "2025-01")
+
+	cfg := testTokenConfig
+	cfg.Keys = mustKeySet(oldKey)
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, cfg, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+	assert.NoError(t, err)
+
+	// rotate: the old key is retired but still within its grace period
+	retired := oldKey
+	retired.RetiredAt = time.Now()
+	cfg.Keys = mustKeySet(newEd25519Key(t, "2025-02"), retired)
+	rotated := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, cfg, testThrottleConfig)
+
+	identity, err := rotated.ParseToken(context.Background(), tokens.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 9, identity.UserId)
+
+	set := rotated.JWKS()
+	assert.Len(t, set.Keys, 2)
+	assert.Equal(t, "2025-02", set.Keys[0].Kid)
+}
+
+func TestAuthService_ParseToken_RejectsUnknownKey(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 9, Username: "bob", Password: "HPASS"}}
+
+	cfg := testTokenConfig
+	cfg.Keys = mustKeySet(newEd25519Key(t, "2025-01"))
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, cfg, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+	assert.NoError(t, err)
+
+	cfg.Keys = mustKeySet(newEd25519Key(t, "2025-02"))
+	other := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, cfg, testThrottleConfig)
+	_, err = other.ParseToken(context.Background(), tokens.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenSignatureInvalid)
+
+	// an HS256 token must not be accepted by an asymmetric key set
+	hmacTokens, err := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig).
+		GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+	assert.NoError(t, err)
+	_, err = svc.ParseToken(context.Background(), hmacTokens.AccessToken)
+	assert.ErrorIs(t, err, ErrTokenSignatureInvalid)
+}
+
+func TestAuthService_ParseToken_ValidatesRegisteredClaims(t *testing.T) {
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	_, err := svc.ParseToken(context.Background(), signTestClaims(t, validTestClaims()))
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		modify func(c *TokenClaims)
+		want   error
+	}{
+		{"expired", func(c *TokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute)) }, ErrTokenExpired},
+		{"no exp", func(c *TokenClaims) { c.ExpiresAt = nil }, ErrTokenInvalidClaims},
+		{"not valid yet", func(c *TokenClaims) { c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute)) }, ErrTokenInvalidClaims},
+		{"wrong issuer", func(c *TokenClaims) { c.Issuer = "someone-else" }, ErrTokenInvalidClaims},
+		{"wrong audience", func(c *TokenClaims) { c.Audience = jwt.ClaimStrings{"other-api"} }, ErrTokenInvalidClaims},
+		{"missing required claim", func(c *TokenClaims) { c.Subject = "" }, ErrTokenInvalidClaims},
+		{"no jti", func(c *TokenClaims) { c.ID = "" }, ErrTokenInvalidClaims},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := validTestClaims()
+			tt.modify(claims)
+			_, err := svc.ParseToken(context.Background(), signTestClaims(t, claims))
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestAuthService_ParseToken_Leeway(t *testing.T) {
+	cfg := testTokenConfig
+	cfg.Leeway = time.Minute
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, cfg, testThrottleConfig)
+
+	claims := validTestClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-30 * time.Second))
+	_, err := svc.ParseToken(context.Background(), signTestClaims(t, claims))
+	assert.NoError(t, err)
+}
+
+func TestAuthService_GenerateToken_SetsRegisteredClaims(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 9, Username: "bob", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+	tokens, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+	assert.NoError(t, err)
+
+	claims := &TokenClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(tokens.AccessToken, claims)
+	assert.NoError(t, err)
+	assert.Equal(t, "test-issuer", claims.Issuer)
+	assert.Equal(t, "9", claims.Subject)
+	assert.Equal(t, jwt.ClaimStrings{"test-api"}, claims.Audience)
+}
+
+func TestCheckRequiredClaims(t *testing.T) {
+	assert.NoError(t, CheckRequiredClaims([]string{"exp", "iat", "jti", "sub", "nbf", "iss", "aud"}))
+	assert.ErrorIs(t, CheckRequiredClaims([]string{"exp", "scope"}), ErrUnknownClaim)
+}
+
+func TestAuthService_GenerateToken_UnknownUserVerifiesDummyHash(t *testing.T) {
+	var verified []string
+	h := countingHasher{mockHasher: mockHasher{out: "DUMMY"}, verified: &verified}
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{getUserErr: repoerrs.ErrNotFound}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, h, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	for i := 0; i < 2; i++ {
+		_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "ghost", Password: "pwd"})
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	}
+	assert.Equal(t, []string{"DUMMY", "DUMMY"}, verified)
+}
+
+func TestAuthService_GenerateToken_LocksAccountAfterFailedAttempts(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 1, Username: "bob", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	for i := 0; i < testThrottleConfig.UserFreeAttempts+1; i++ {
+		_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "wrong"})
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	}
+
+	// even the right password is refused while locked
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+	var tooMany *TooManyAttemptsError
+	assert.ErrorAs(t, err, &tooMany)
+	assert.InDelta(t, time.Minute.Seconds(), tooMany.RetryAfter.Seconds(), 1)
+}
+
+func TestAuthService_GenerateToken_SuccessResetsAccountCounter(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 1, Username: "bob", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < testThrottleConfig.UserFreeAttempts; i++ {
+			_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "wrong"})
+			assert.ErrorIs(t, err, ErrUserNotFound)
+		}
+		_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+		assert.NoError(t, err)
+	}
+}
+
+func TestAuthService_GenerateToken_LocksIPAcrossUsernames(t *testing.T) {
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{getUserErr: repoerrs.ErrNotFound}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	for i := 0; i < testThrottleConfig.IPFreeAttempts+1; i++ {
+		_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: fmt.Sprintf("user%d", i), Password: "pwd", IP: "10.0.0.1"})
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	}
+
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "fresh", Password: "pwd", IP: "10.0.0.1"})
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+
+	_, err = svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "fresh", Password: "pwd", IP: "10.0.0.2"})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestAuthService_GenerateToken_LoginAttemptsStoreError(t *testing.T) {
+	attempts := failingLoginAttemptsRepo{memdb.NewLoginAttemptsRepo()}
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, attempts, &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: "bob", Password: "pwd"})
+	assert.ErrorIs(t, err, ErrCannotCheckLoginAttempts)
+}
+
+func TestAuthService_GenerateToken_PurgesStaleAttemptsOncePerWindow(t *testing.T) {
+	attempts := &purgeCountingLoginAttemptsRepo{LoginAttemptsRepo: memdb.NewLoginAttemptsRepo()}
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{getUserErr: repoerrs.ErrNotFound}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, attempts, &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.GenerateToken(context.Background(), AuthGenerateTokenInput{Username: fmt.Sprintf("user%d", i), Password: "pwd"})
+		assert.ErrorIs(t, err, ErrUserNotFound)
+	}
+	assert.Equal(t, 1, attempts.purges)
+}
+
+func TestAuthService_Lockout_GrowsExponentially(t *testing.T) {
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	assert.Equal(t, time.Duration(0), svc.lockout(3, 3))
+	assert.Equal(t, time.Minute, svc.lockout(4, 3))
+	assert.Equal(t, 2*time.Minute, svc.lockout(5, 3))
+	assert.Equal(t, 32*time.Minute, svc.lockout(9, 3))
+	assert.Equal(t, time.Hour, svc.lockout(10, 3))
+	assert.Equal(t, time.Hour, svc.lockout(1000, 3))
+}
+
+func TestAuthService_ChangePassword_Success(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 5, Username: "bob", Password: "HPASS"}}
+	refreshMock := &mockRefreshTokensRepo{}
+	svc := NewAuthService(&mockTransactor{}, usersMock, refreshMock, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "NEWHASH", valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	err := svc.ChangePassword(context.Background(), AuthChangePasswordInput{UserId: 5, OldPassword: "pwd", NewPassword: "N3w!pass"})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, usersMock.setPasswordID)
+	assert.Equal(t, "NEWHASH", usersMock.setPasswordVal)
+	// every session is ended
+	assert.Equal(t, 1, usersMock.tokenVersion)
+	assert.Equal(t, 5, refreshMock.revokedUser)
+}
+
+func TestAuthService_ChangePassword_WrongOldPassword(t *testing.T) {
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 5, Username: "bob", Password: "HPASS"}}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), &mockPasswordResetTokensRepo{}, mockHasher{out: "NEWHASH", valid: "HPASS"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	for i := 0; i < testThrottleConfig.UserFreeAttempts+1; i++ {
+		err := svc.ChangePassword(context.Background(), AuthChangePasswordInput{UserId: 5, OldPassword: "wrong", NewPassword: "N3w!pass"})
+		assert.ErrorIs(t, err, ErrInvalidPassword)
+	}
+	assert.Empty(t, usersMock.setPasswordVal)
+
+	err := svc.ChangePassword(context.Background(), AuthChangePasswordInput{UserId: 5, OldPassword: "pwd", NewPassword: "N3w!pass"})
+	assert.ErrorIs(t, err, ErrTooManyAttempts)
+}
+
+func TestAuthService_PasswordReset_Flow(t *testing.T) {
+	email := "bob@example.com"
+	verifiedAt := time.Now()
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 5, Username: "bob", Password: "HPASS", Email: &email, EmailVerifiedAt: &verifiedAt}}
+	resetMock := &mockPasswordResetTokensRepo{}
+	notifierMock := &mockNotifier{}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), resetMock, mockHasher{out: "NEWHASH"}, notifierMock, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	err := svc.RequestPasswordReset(context.Background(), AuthRequestPasswordResetInput{Email: email})
+	assert.NoError(t, err)
+	if !assert.Len(t, notifierMock.sent, 1) || !assert.Len(t, resetMock.tokens, 1) {
+		return
+	}
+	assert.Equal(t, email, notifierMock.sent[0].To)
+
+	// only the hash is stored; the plain token is in the message
+	stored := resetMock.tokens[0]
+	assert.Equal(t, 5, stored.UserId)
+	assert.WithinDuration(t, time.Now().Add(30*time.Minute), stored.ExpiresAt, time.Minute)
+	var token string
+	for _, field := range strings.Fields(notifierMock.sent[0].Body) {
+		if hashToken(field) == stored.TokenHash {
+			token = field
+		}
+	}
+	assert.NotEmpty(t, token)
+
+	err = svc.ResetPassword(context.Background(), AuthResetPasswordInput{Token: token, NewPassword: "N3w!pass"})
+	assert.NoError(t, err)
+	assert.Equal(t, "NEWHASH", usersMock.setPasswordVal)
+	assert.Equal(t, 5, resetMock.invalidated)
+	assert.Equal(t, 1, usersMock.tokenVersion)
+
+	// single use
+	err = svc.ResetPassword(context.Background(), AuthResetPasswordInput{Token: token, NewPassword: "N3w!pass"})
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+}
+
+func TestAuthService_RequestPasswordReset_UnknownEmail(t *testing.T) {
+	resetMock := &mockPasswordResetTokensRepo{}
+	notifierMock := &mockNotifier{}
+	svc := NewAuthService(&mockTransactor{}, &mockUsersRepo{}, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), resetMock, mockHasher{}, notifierMock, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	err := svc.RequestPasswordReset(context.Background(), AuthRequestPasswordResetInput{Email: "nobody@example.com"})
+	assert.NoError(t, err)
+	assert.Empty(t, resetMock.tokens)
+	assert.Empty(t, notifierMock.sent)
+}
+
+func TestAuthService_RequestPasswordReset_UnverifiedEmail(t *testing.T) {
+	email := "bob@example.com"
+	usersMock := &mockUsersRepo{getUserResp: entity.User{Id: 5, Username: "bob", Password: "HPASS", Email: &email}}
+	resetMock := &mockPasswordResetTokensRepo{}
+	notifierMock := &mockNotifier{}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), resetMock, mockHasher{}, notifierMock, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	err := svc.RequestPasswordReset(context.Background(), AuthRequestPasswordResetInput{Email: email})
+	assert.NoError(t, err)
+	assert.Empty(t, resetMock.tokens)
+	assert.Empty(t, notifierMock.sent)
+}
+
+func TestAuthService_ResetPassword_ExpiredToken(t *testing.T) {
+	resetMock := &mockPasswordResetTokensRepo{tokens: []entity.PasswordResetToken{
+		{Id: 1, UserId: 5, TokenHash: hashToken("old"), ExpiresAt: time.Now().Add(-time.Minute)},
+	}}
+	usersMock := &mockUsersRepo{}
+	svc := NewAuthService(&mockTransactor{}, usersMock, &mockRefreshTokensRepo{}, &mockRevokedTokensRepo{}, memdb.NewLoginAttemptsRepo(), resetMock, mockHasher{out: "NEWHASH"}, &mockNotifier{}, &mockReferrer{}, testTokenConfig, testThrottleConfig)
+
+	err := svc.ResetPassword(context.Background(), AuthResetPasswordInput{Token: "old", NewPassword: "N3w!pass"})
+	assert.ErrorIs(t, err, ErrInvalidResetToken)
+	assert.Empty(t, usersMock.setPasswordVal)
 }
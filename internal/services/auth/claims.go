@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"denet-test-task/internal/entity"
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrTokenMalformed        = fmt.Errorf("token is malformed")
+	ErrTokenExpired          = fmt.Errorf("token is expired")
+	ErrTokenSignatureInvalid = fmt.Errorf("token signature is invalid")
+	ErrTokenInvalidClaims    = fmt.Errorf("token has invalid claims")
+
+	ErrUnknownClaim = fmt.Errorf("unknown registered claim")
+)
+
+type TokenClaims struct {
+	jwt.RegisteredClaims
+	UserId       int
+	Role         entity.Role
+	TokenVersion int
+}
+
+// registeredClaims are the claim names that may be listed as required.
+var registeredClaims = map[string]func(c *TokenClaims) bool{
+	"iss": func(c *TokenClaims) bool { return c.Issuer != "" },
+	"sub": func(c *TokenClaims) bool { return c.Subject != "" },
+	"aud": func(c *TokenClaims) bool { return len(c.Audience) > 0 },
+	"exp": func(c *TokenClaims) bool { return c.ExpiresAt != nil },
+	"nbf": func(c *TokenClaims) bool { return c.NotBefore != nil },
+	"iat": func(c *TokenClaims) bool { return c.IssuedAt != nil },
+	"jti": func(c *TokenClaims) bool { return c.ID != "" },
+}
+
+// CheckRequiredClaims reports an error if a name is not a registered claim.
+func CheckRequiredClaims(names []string) error {
+	for _, name := range names {
+		if _, ok := registeredClaims[name]; !ok {
+			return fmt.Errorf("%w: %q", ErrUnknownClaim, name)
+		}
+	}
+	return nil
+}
+
+// missingClaim returns the first required claim absent from the token.
+func missingClaim(claims *TokenClaims, required []string) (string, bool) {
+	for _, name := range required {
+		present, ok := registeredClaims[name]
+		if !ok || !present(claims) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// tokenError maps a jwt parsing error to one of the typed token errors.
+func tokenError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return ErrTokenMalformed
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+		return ErrTokenSignatureInvalid
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return ErrTokenExpired
+	case errors.Is(err, jwt.ErrTokenInvalidClaims):
+		return ErrTokenInvalidClaims
+	default:
+		return ErrCannotParseToken
+	}
+}
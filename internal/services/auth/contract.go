@@ -2,20 +2,90 @@ package auth
 
 import (
 	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/services/users"
+	"denet-test-task/pkg/jwks"
+	"time"
 )
 
+// AuthCreateUserInput holds the new user. The owner of ReferralCode, if it
+// is set, becomes the referrer of the user.
 type AuthCreateUserInput struct {
-	Username string
-	Password string
+	Username     string
+	Password     string
+	ReferralCode string
+	// IP of the client, kept to check referral rewards
+	IP string
 }
 
 type AuthGenerateTokenInput struct {
 	Username string
 	Password string
+	// IP of the client, used to throttle failed attempts per address
+	IP string
+}
+
+type AuthRefreshTokenInput struct {
+	RefreshToken string
+}
+
+type AuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+}
+
+// AuthIdentity is the caller identity extracted from a valid access token.
+type AuthIdentity struct {
+	UserId    int
+	Role      entity.Role
+	TokenId   string
+	ExpiresAt time.Time
+}
+
+type AuthLogoutInput struct {
+	Identity     AuthIdentity
+	RefreshToken string
+}
+
+type AuthLogoutAllInput struct {
+	UserId int
+}
+
+type AuthChangePasswordInput struct {
+	UserId      int
+	OldPassword string
+	NewPassword string
+}
+
+type AuthRequestPasswordResetInput struct {
+	Email string
+}
+
+type AuthResetPasswordInput struct {
+	Token       string
+	NewPassword string
+}
+
+type AuthSetRoleInput struct {
+	UserId int
+	Role   entity.Role
+}
+
+// Referrer sets the referrer of a user signing up with a referral code.
+type Referrer interface {
+	SetReferrer(ctx context.Context, input users.UsersSetReferrerInput) error
 }
 
 type Auth interface {
 	CreateUser(ctx context.Context, input AuthCreateUserInput) (int, error)
-	GenerateToken(ctx context.Context, input AuthGenerateTokenInput) (string, error)
-	ParseToken(token string) (int, error)
+	GenerateToken(ctx context.Context, input AuthGenerateTokenInput) (AuthTokens, error)
+	RefreshToken(ctx context.Context, input AuthRefreshTokenInput) (AuthTokens, error)
+	ParseToken(ctx context.Context, token string) (AuthIdentity, error)
+	Logout(ctx context.Context, input AuthLogoutInput) error
+	LogoutAll(ctx context.Context, input AuthLogoutAllInput) error
+	SetRole(ctx context.Context, input AuthSetRoleInput) error
+	ChangePassword(ctx context.Context, input AuthChangePasswordInput) error
+	RequestPasswordReset(ctx context.Context, input AuthRequestPasswordResetInput) error
+	ResetPassword(ctx context.Context, input AuthResetPasswordInput) error
+	JWKS() jwks.JSONWebKeySet
 }
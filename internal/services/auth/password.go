@@ -0,0 +1,134 @@
+package auth
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/notifier"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidPassword      = fmt.Errorf("invalid password")
+	ErrCannotChangePassword = fmt.Errorf("cannot change password")
+	ErrInvalidResetToken    = fmt.Errorf("invalid password reset token")
+)
+
+// ChangePassword sets a new password after checking the current one. Every
+// session of the user is ended, so the caller has to sign in again.
+func (s *AuthService) ChangePassword(ctx context.Context, input AuthChangePasswordInput) error {
+	user, err := s.usersRepo.GetUserById(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		logctx.FromContext(ctx).Error("AuthService.ChangePassword - usersRepo.GetUserById", "err", err)
+		return ErrCannotGetUser
+	}
+
+	// guessing the current password with a stolen access token is throttled
+	// like guessing it on sign-in
+	attemptKeys := s.loginAttemptKeys(AuthGenerateTokenInput{Username: user.Username})
+	if err := s.checkLoginAttempts(ctx, attemptKeys); err != nil {
+		return err
+	}
+
+	ok, err := s.passwordHasher.Verify(input.OldPassword, user.Password)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.ChangePassword - passwordHasher.Verify", "err", err)
+		return ErrCannotVerifyPassword
+	}
+	if !ok {
+		s.registerLoginFailure(ctx, attemptKeys)
+		return ErrInvalidPassword
+	}
+
+	return s.setPassword(ctx, user.Id, input.NewPassword)
+}
+
+// RequestPasswordReset sends a reset token to the user owning the verified
+// email. To not reveal which emails are registered it succeeds for unknown
+// and unverified emails too.
+func (s *AuthService) RequestPasswordReset(ctx context.Context, input AuthRequestPasswordResetInput) error {
+	user, err := s.usersRepo.GetUserByEmail(ctx, input.Email)
+	if err != nil {
+		if !errors.Is(err, repoerrs.ErrNotFound) {
+			logctx.FromContext(ctx).Error("AuthService.RequestPasswordReset - usersRepo.GetUserByEmail", "err", err)
+		}
+		return nil
+	}
+	// reset tokens are only sent to addresses the user proved to own
+	if user.EmailVerifiedAt == nil {
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.RequestPasswordReset - randomToken", "err", err)
+		return nil
+	}
+
+	err = s.passwordResetTokensRepo.CreatePasswordResetToken(ctx, entity.PasswordResetToken{
+		UserId:    user.Id,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.passwordResetTokenTTL),
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.RequestPasswordReset - passwordResetTokensRepo.CreatePasswordResetToken", "err", err)
+		return nil
+	}
+
+	err = s.notifier.Notify(ctx, notifier.Message{
+		To:      input.Email,
+		Subject: "Password reset",
+		Body: fmt.Sprintf("Use this token to set a new password for %s: %s\nIt expires in %v. If you did not ask for a reset, ignore this message.",
+			user.Username, token, s.passwordResetTokenTTL),
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.RequestPasswordReset - notifier.Notify", "err", err)
+	}
+	return nil
+}
+
+// ResetPassword consumes a reset token and sets the new password. Every
+// session of the user and every other reset token are invalidated.
+func (s *AuthService) ResetPassword(ctx context.Context, input AuthResetPasswordInput) error {
+	userId, err := s.passwordResetTokensRepo.ConsumePasswordResetToken(ctx, hashToken(input.Token))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		logctx.FromContext(ctx).Error("AuthService.ResetPassword - passwordResetTokensRepo.ConsumePasswordResetToken", "err", err)
+		return ErrCannotChangePassword
+	}
+
+	err = s.passwordResetTokensRepo.InvalidateUserPasswordResetTokens(ctx, userId)
+	if err != nil {
+		logctx.FromContext(ctx).Warn("AuthService.ResetPassword - passwordResetTokensRepo.InvalidateUserPasswordResetTokens", "err", err)
+	}
+
+	return s.setPassword(ctx, userId, input.NewPassword)
+}
+
+// setPassword stores the new password hash and ends every session of the user.
+func (s *AuthService) setPassword(ctx context.Context, userId int, password string) error {
+	passwordHash, err := s.passwordHasher.Hash(password)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.setPassword - passwordHasher.Hash", "err", err)
+		return ErrCannotChangePassword
+	}
+
+	err = s.usersRepo.SetUserPassword(ctx, userId, passwordHash)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		logctx.FromContext(ctx).Error("AuthService.setPassword - usersRepo.SetUserPassword", "err", err)
+		return ErrCannotChangePassword
+	}
+
+	return s.LogoutAll(ctx, AuthLogoutAllInput{UserId: userId})
+}
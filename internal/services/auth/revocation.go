@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"time"
+)
+
+func (s *AuthService) Logout(ctx context.Context, input AuthLogoutInput) error {
+	err := s.revokedTokensRepo.RevokeToken(ctx, entity.RevokedToken{
+		Jti:       input.Identity.TokenId,
+		UserId:    input.Identity.UserId,
+		ExpiresAt: input.Identity.ExpiresAt,
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.Logout - revokedTokensRepo.RevokeToken", "err", err)
+		return ErrCannotRevokeToken
+	}
+	s.revokedCache.Set(input.Identity.TokenId, true)
+	s.purgeRevokedTokens(ctx, time.Now())
+
+	if input.RefreshToken == "" {
+		return nil
+	}
+
+	stored, err := s.refreshTokensRepo.GetRefreshTokenByHash(ctx, hashToken(input.RefreshToken))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return nil
+		}
+		logctx.FromContext(ctx).Error("AuthService.Logout - refreshTokensRepo.GetRefreshTokenByHash", "err", err)
+		return ErrCannotRevokeToken
+	}
+	// never let a caller revoke somebody else's session
+	if stored.UserId != input.Identity.UserId {
+		return nil
+	}
+
+	err = s.refreshTokensRepo.RevokeRefreshTokenFamily(ctx, stored.FamilyId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.Logout - refreshTokensRepo.RevokeRefreshTokenFamily", "err", err)
+		return ErrCannotRevokeToken
+	}
+	return nil
+}
+
+// purgeRevokedTokens deletes the expired revoked tokens at most once per
+// access token TTL, the longest a revoked token can stay valid.
+func (s *AuthService) purgeRevokedTokens(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastRevokedPurge) < s.tokenTTL {
+		s.mu.Unlock()
+		return
+	}
+	s.lastRevokedPurge = now
+	s.mu.Unlock()
+
+	if err := s.revokedTokensRepo.DeleteExpiredRevokedTokens(ctx, now); err != nil {
+		logctx.FromContext(ctx).Warn("AuthService.purgeRevokedTokens - revokedTokensRepo.DeleteExpiredRevokedTokens", "err", err)
+	}
+}
+
+func (s *AuthService) LogoutAll(ctx context.Context, input AuthLogoutAllInput) error {
+	version, err := s.usersRepo.IncrementUserTokenVersion(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		logctx.FromContext(ctx).Error("AuthService.LogoutAll - usersRepo.IncrementUserTokenVersion", "err", err)
+		return ErrCannotRevokeToken
+	}
+	s.tokenVersionsCache.Set(input.UserId, version)
+
+	err = s.refreshTokensRepo.RevokeUserRefreshTokens(ctx, input.UserId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.LogoutAll - refreshTokensRepo.RevokeUserRefreshTokens", "err", err)
+		return ErrCannotRevokeToken
+	}
+	return nil
+}
+
+// checkRevocation rejects tokens revoked by jti or issued before the user's
+// current token version. Lookups are cached to keep the DB off the hot path.
+func (s *AuthService) checkRevocation(ctx context.Context, claims *TokenClaims) error {
+	revoked, ok := s.revokedCache.Get(claims.ID)
+	if !ok {
+		var err error
+		revoked, err = s.revokedTokensRepo.IsTokenRevoked(ctx, claims.ID)
+		if err != nil {
+			logctx.FromContext(ctx).Error("AuthService.checkRevocation - revokedTokensRepo.IsTokenRevoked", "err", err)
+			return ErrCannotCheckRevocation
+		}
+		s.revokedCache.Set(claims.ID, revoked)
+	}
+	if revoked {
+		return ErrTokenRevoked
+	}
+
+	version, ok := s.tokenVersionsCache.Get(claims.UserId)
+	if !ok {
+		var err error
+		version, err = s.usersRepo.GetUserTokenVersion(ctx, claims.UserId)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return ErrTokenRevoked
+			}
+			logctx.FromContext(ctx).Error("AuthService.checkRevocation - usersRepo.GetUserTokenVersion", "err", err)
+			return ErrCannotCheckRevocation
+		}
+		s.tokenVersionsCache.Set(claims.UserId, version)
+	}
+	if claims.TokenVersion < version {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package auth
+
+import (
+	"context"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+)
+
+// SetRole changes the user's role and invalidates every token issued before,
+// so that no token keeps carrying the previous role.
+func (s *AuthService) SetRole(ctx context.Context, input AuthSetRoleInput) error {
+	if !input.Role.Valid() {
+		return ErrInvalidRole
+	}
+
+	err := s.usersRepo.SetUserRole(ctx, input.UserId, input.Role)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrUserNotFound
+		}
+		logctx.FromContext(ctx).Error("AuthService.SetRole - usersRepo.SetUserRole", "err", err)
+		return ErrCannotSetRole
+	}
+
+	err = s.LogoutAll(ctx, AuthLogoutAllInput{UserId: input.UserId})
+	if err != nil {
+		logctx.FromContext(ctx).Error("AuthService.SetRole - LogoutAll", "err", err)
+		return ErrCannotSetRole
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package idempotency
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+)
+
+type IdempotencyBeginInput struct {
+	UserId      int
+	Key         string
+	Fingerprint string
+}
+
+type IdempotencyCompleteInput struct {
+	UserId      int
+	Key         string
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+type IdempotencyReleaseInput struct {
+	UserId int
+	Key    string
+}
+
+type Idempotency interface {
+	Begin(ctx context.Context, input IdempotencyBeginInput) (entity.IdempotencyKey, bool, error)
+	Complete(ctx context.Context, input IdempotencyCompleteInput) error
+	Release(ctx context.Context, input IdempotencyReleaseInput) error
+}
@@ -0,0 +1,111 @@
+package idempotency
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var _ Idempotency = (*IdempotencyService)(nil)
+
+var (
+	ErrKeyReused           = fmt.Errorf("idempotency key was used with a different request")
+	ErrRequestInProgress   = fmt.Errorf("a request with this idempotency key is in progress")
+	ErrCannotCheckKey      = fmt.Errorf("cannot check idempotency key")
+	ErrCannotStoreResponse = fmt.Errorf("cannot store idempotent response")
+)
+
+type IdempotencyService struct {
+	idempotencyKeysRepo repo.IdempotencyKeys
+	ttl                 time.Duration
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+func NewIdempotencyService(idempotencyKeysRepo repo.IdempotencyKeys, ttl time.Duration) *IdempotencyService {
+	return &IdempotencyService{idempotencyKeysRepo: idempotencyKeysRepo, ttl: ttl}
+}
+
+// Begin claims the key for the request. It reports true with the stored
+// response if the request has already been processed; the caller must then
+// replay it instead of processing the request again.
+func (s *IdempotencyService) Begin(ctx context.Context, input IdempotencyBeginInput) (entity.IdempotencyKey, bool, error) {
+	now := time.Now()
+	s.purgeExpired(ctx, now)
+
+	err := s.idempotencyKeysRepo.CreateIdempotencyKey(ctx, entity.IdempotencyKey{
+		UserId:      input.UserId,
+		Key:         input.Key,
+		Fingerprint: input.Fingerprint,
+		ExpiresAt:   now.Add(s.ttl),
+	})
+	if err == nil {
+		return entity.IdempotencyKey{}, false, nil
+	}
+	if !errors.Is(err, repoerrs.ErrAlreadyExists) {
+		logctx.FromContext(ctx).Error("IdempotencyService.Begin - idempotencyKeysRepo.CreateIdempotencyKey", "err", err)
+		return entity.IdempotencyKey{}, false, ErrCannotCheckKey
+	}
+
+	stored, err := s.idempotencyKeysRepo.GetIdempotencyKey(ctx, input.UserId, input.Key)
+	if err != nil {
+		logctx.FromContext(ctx).Error("IdempotencyService.Begin - idempotencyKeysRepo.GetIdempotencyKey", "err", err)
+		return entity.IdempotencyKey{}, false, ErrCannotCheckKey
+	}
+	if stored.Fingerprint != input.Fingerprint {
+		return entity.IdempotencyKey{}, false, ErrKeyReused
+	}
+	if stored.Status == nil {
+		return entity.IdempotencyKey{}, false, ErrRequestInProgress
+	}
+	return stored, true, nil
+}
+
+// Complete stores the response to replay for the key.
+func (s *IdempotencyService) Complete(ctx context.Context, input IdempotencyCompleteInput) error {
+	err := s.idempotencyKeysRepo.CompleteIdempotencyKey(ctx, entity.IdempotencyKey{
+		UserId:       input.UserId,
+		Key:          input.Key,
+		Status:       &input.Status,
+		ContentType:  input.ContentType,
+		ResponseBody: input.Body,
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("IdempotencyService.Complete - idempotencyKeysRepo.CompleteIdempotencyKey", "err", err)
+		return ErrCannotStoreResponse
+	}
+	return nil
+}
+
+// Release forgets the key, so the request can be retried with it. It is used
+// when the request failed for a reason a retry may fix.
+func (s *IdempotencyService) Release(ctx context.Context, input IdempotencyReleaseInput) error {
+	err := s.idempotencyKeysRepo.DeleteIdempotencyKey(ctx, input.UserId, input.Key)
+	if err != nil {
+		logctx.FromContext(ctx).Error("IdempotencyService.Release - idempotencyKeysRepo.DeleteIdempotencyKey", "err", err)
+		return ErrCannotStoreResponse
+	}
+	return nil
+}
+
+// purgeExpired deletes the expired keys at most once per ttl.
+func (s *IdempotencyService) purgeExpired(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPurge) < s.ttl {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurge = now
+	s.mu.Unlock()
+
+	if err := s.idempotencyKeysRepo.DeleteExpiredIdempotencyKeys(ctx, now); err != nil {
+		logctx.FromContext(ctx).Error("IdempotencyService.purgeExpired - idempotencyKeysRepo.DeleteExpiredIdempotencyKeys", "err", err)
+	}
+}
@@ -0,0 +1,131 @@
+package idempotency
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockIdempotencyKeysRepo struct {
+	keys      map[string]entity.IdempotencyKey
+	createErr error
+	purged    int
+}
+
+func newMockIdempotencyKeysRepo() *mockIdempotencyKeysRepo {
+	return &mockIdempotencyKeysRepo{keys: make(map[string]entity.IdempotencyKey)}
+}
+
+func (m *mockIdempotencyKeysRepo) CreateIdempotencyKey(_ context.Context, key entity.IdempotencyKey) error {
+	if m.createErr != nil {
+		return m.createErr
+	}
+	if stored, ok := m.keys[key.Key]; ok && stored.ExpiresAt.After(time.Now()) {
+		return repoerrs.ErrAlreadyExists
+	}
+	m.keys[key.Key] = key
+	return nil
+}
+func (m *mockIdempotencyKeysRepo) GetIdempotencyKey(_ context.Context, _ int, key string) (entity.IdempotencyKey, error) {
+	stored, ok := m.keys[key]
+	if !ok {
+		return entity.IdempotencyKey{}, repoerrs.ErrNotFound
+	}
+	return stored, nil
+}
+func (m *mockIdempotencyKeysRepo) CompleteIdempotencyKey(_ context.Context, key entity.IdempotencyKey) error {
+	stored := m.keys[key.Key]
+	stored.Status = key.Status
+	stored.ContentType = key.ContentType
+	stored.ResponseBody = key.ResponseBody
+	m.keys[key.Key] = stored
+	return nil
+}
+func (m *mockIdempotencyKeysRepo) DeleteIdempotencyKey(_ context.Context, _ int, key string) error {
+	delete(m.keys, key)
+	return nil
+}
+func (m *mockIdempotencyKeysRepo) DeleteExpiredIdempotencyKeys(_ context.Context, _ time.Time) error {
+	m.purged++
+	return nil
+}
+
+var _ repo.IdempotencyKeys = (*mockIdempotencyKeysRepo)(nil)
+
+func TestIdempotencyService_ReplaysCompletedRequest(t *testing.T) {
+	s := NewIdempotencyService(newMockIdempotencyKeysRepo(), time.Hour)
+	ctx := context.Background()
+	input := IdempotencyBeginInput{UserId: 1, Key: "k1", Fingerprint: "fp"}
+
+	_, replay, err := s.Begin(ctx, input)
+	assert.NoError(t, err)
+	assert.False(t, replay)
+
+	err = s.Complete(ctx, IdempotencyCompleteInput{UserId: 1, Key: "k1", Status: 200, ContentType: "application/json", Body: []byte("null\n")})
+	assert.NoError(t, err)
+
+	stored, replay, err := s.Begin(ctx, input)
+	assert.NoError(t, err)
+	assert.True(t, replay)
+	assert.Equal(t, 200, *stored.Status)
+	assert.Equal(t, "application/json", stored.ContentType)
+	assert.Equal(t, []byte("null\n"), stored.ResponseBody)
+}
+
+func TestIdempotencyService_RejectsDifferentPayload(t *testing.T) {
+	s := NewIdempotencyService(newMockIdempotencyKeysRepo(), time.Hour)
+	ctx := context.Background()
+
+	_, _, err := s.Begin(ctx, IdempotencyBeginInput{UserId: 1, Key: "k1", Fingerprint: "fp1"})
+	assert.NoError(t, err)
+	_, _, err = s.Begin(ctx, IdempotencyBeginInput{UserId: 1, Key: "k1", Fingerprint: "fp2"})
+	assert.ErrorIs(t, err, ErrKeyReused)
+}
+
+func TestIdempotencyService_InProgress(t *testing.T) {
+	s := NewIdempotencyService(newMockIdempotencyKeysRepo(), time.Hour)
+	ctx := context.Background()
+	input := IdempotencyBeginInput{UserId: 1, Key: "k1", Fingerprint: "fp"}
+
+	_, _, err := s.Begin(ctx, input)
+	assert.NoError(t, err)
+	_, _, err = s.Begin(ctx, input)
+	assert.ErrorIs(t, err, ErrRequestInProgress)
+}
+
+func TestIdempotencyService_ReleaseAllowsRetry(t *testing.T) {
+	s := NewIdempotencyService(newMockIdempotencyKeysRepo(), time.Hour)
+	ctx := context.Background()
+	input := IdempotencyBeginInput{UserId: 1, Key: "k1", Fingerprint: "fp"}
+
+	_, _, err := s.Begin(ctx, input)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Release(ctx, IdempotencyReleaseInput{UserId: 1, Key: "k1"}))
+
+	_, replay, err := s.Begin(ctx, input)
+	assert.NoError(t, err)
+	assert.False(t, replay)
+}
+
+func TestIdempotencyService_RepoError(t *testing.T) {
+	keysRepo := newMockIdempotencyKeysRepo()
+	keysRepo.createErr = errors.New("db")
+	s := NewIdempotencyService(keysRepo, time.Hour)
+	_, _, err := s.Begin(context.Background(), IdempotencyBeginInput{UserId: 1, Key: "k1", Fingerprint: "fp"})
+	assert.ErrorIs(t, err, ErrCannotCheckKey)
+}
+
+func TestIdempotencyService_PurgesExpiredOncePerTTL(t *testing.T) {
+	keysRepo := newMockIdempotencyKeysRepo()
+	s := NewIdempotencyService(keysRepo, time.Hour)
+	for i := 0; i < 3; i++ {
+		_, _, _ = s.Begin(context.Background(), IdempotencyBeginInput{UserId: 1, Key: "k", Fingerprint: "fp"})
+	}
+	assert.Equal(t, 1, keysRepo.purged)
+}
@@ -0,0 +1,16 @@
+package rewards
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+)
+
+type RewardsRedeemInput struct {
+	UserId   int
+	RewardId int
+}
+
+type Rewards interface {
+	GetAvailableRewards(ctx context.Context) ([]entity.Reward, error)
+	Redeem(ctx context.Context, input RewardsRedeemInput) error
+}
@@ -0,0 +1,96 @@
+package rewards
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ Rewards = (*RewardsService)(nil)
+
+var (
+	ErrRewardNotFound     = fmt.Errorf("reward not found")
+	ErrRewardNotAvailable = fmt.Errorf("reward is not available")
+	ErrInsufficientPoints = fmt.Errorf("not enough points")
+	ErrCannotGetRewards   = fmt.Errorf("cannot get rewards")
+	ErrCannotRedeemReward = fmt.Errorf("cannot redeem reward")
+)
+
+type RewardsService struct {
+	transactor  repo.Transactor
+	rewardsRepo repo.Rewards
+	pointsRepo  repo.Points
+}
+
+func NewRewardsService(transactor repo.Transactor, rewardsRepo repo.Rewards, pointsRepo repo.Points) *RewardsService {
+	return &RewardsService{transactor: transactor, rewardsRepo: rewardsRepo, pointsRepo: pointsRepo}
+}
+
+func (s *RewardsService) GetAvailableRewards(ctx context.Context) ([]entity.Reward, error) {
+	rewards, err := s.rewardsRepo.GetAvailableRewards(ctx, time.Now())
+	if err != nil {
+		logctx.FromContext(ctx).Error("RewardsService.GetAvailableRewards - rewardsRepo.GetAvailableRewards", "err", err)
+		return nil, ErrCannotGetRewards
+	}
+	return rewards, nil
+}
+
+// Redeem takes one item of the reward and debits its cost from the user's
+// balance in one transaction. The redemption is recorded in the user's points
+// history.
+func (s *RewardsService) Redeem(ctx context.Context, input RewardsRedeemInput) error {
+	_, err := s.rewardsRepo.GetRewardById(ctx, input.RewardId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrRewardNotFound
+		}
+		logctx.FromContext(ctx).Error("RewardsService.Redeem - rewardsRepo.GetRewardById", "err", err)
+		return ErrCannotRedeemReward
+	}
+
+	var fnErr error
+	err = s.transactor.WithTx(ctx, func(ctx context.Context) error {
+		fnErr = s.redeem(ctx, input)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		logctx.FromContext(ctx).Error("RewardsService.Redeem - transactor.WithTx", "err", err)
+		return ErrCannotRedeemReward
+	}
+	return nil
+}
+
+func (s *RewardsService) redeem(ctx context.Context, input RewardsRedeemInput) error {
+	// the cost is read with the stock so a concurrent price change cannot slip in
+	cost, err := s.rewardsRepo.TakeRewardStock(ctx, input.RewardId, time.Now())
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrRewardNotAvailable
+		}
+		logctx.FromContext(ctx).Error("RewardsService.Redeem - rewardsRepo.TakeRewardStock", "err", err)
+		return ErrCannotRedeemReward
+	}
+
+	err = s.pointsRepo.SpendPoints(ctx, entity.PointsEntry{
+		UserId:   input.UserId,
+		RewardId: &input.RewardId,
+		Delta:    -cost,
+		Reason:   entity.PointsReasonRewardRedeemed,
+	})
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrInsufficientBalance) {
+			return ErrInsufficientPoints
+		}
+		logctx.FromContext(ctx).Error("RewardsService.Redeem - pointsRepo.SpendPoints", "err", err)
+		return ErrCannotRedeemReward
+	}
+	return nil
+}
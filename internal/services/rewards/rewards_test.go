@@ -0,0 +1,208 @@
+package rewards
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockTransactor struct {
+	committed  int
+	rolledBack int
+}
+
+func (m *mockTransactor) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := fn(ctx); err != nil {
+		m.rolledBack++
+		return err
+	}
+	m.committed++
+	return nil
+}
+
+var _ repo.Transactor = (*mockTransactor)(nil)
+
+type mockRewardsRepo struct {
+	rewards map[int]entity.Reward
+	listErr error
+	taken   int
+}
+
+func (m *mockRewardsRepo) GetRewardById(_ context.Context, id int) (entity.Reward, error) {
+	if r, ok := m.rewards[id]; ok {
+		return r, nil
+	}
+	return entity.Reward{}, repoerrs.ErrNotFound
+}
+func (m *mockRewardsRepo) GetAvailableRewards(_ context.Context, at time.Time) ([]entity.Reward, error) {
+	if m.listErr != nil {
+		return nil, m.listErr
+	}
+	var available []entity.Reward
+	for _, r := range m.rewards {
+		if r.ActiveAt(at) && (r.Stock == nil || *r.Stock > 0) {
+			available = append(available, r)
+		}
+	}
+	return available, nil
+}
+func (m *mockRewardsRepo) TakeRewardStock(_ context.Context, id int, at time.Time) (int, error) {
+	r, ok := m.rewards[id]
+	if !ok || !r.ActiveAt(at) || (r.Stock != nil && *r.Stock == 0) {
+		return 0, repoerrs.ErrNotFound
+	}
+	if r.Stock != nil {
+		stock := *r.Stock - 1
+		r.Stock = &stock
+		m.rewards[id] = r
+	}
+	m.taken++
+	return r.Cost, nil
+}
+
+var _ repo.Rewards = (*mockRewardsRepo)(nil)
+
+type mockPointsRepo struct {
+	balance  int
+	spent    []entity.PointsEntry
+	spendErr error
+}
+
+func (m *mockPointsRepo) AddPoints(_ context.Context, _ entity.PointsEntry) error {
+	return nil
+}
+func (m *mockPointsRepo) SpendPoints(_ context.Context, entry entity.PointsEntry) error {
+	if m.spendErr != nil {
+		return m.spendErr
+	}
+	if m.balance+entry.Delta < 0 {
+		return repoerrs.ErrInsufficientBalance
+	}
+	m.balance += entry.Delta
+	m.spent = append(m.spent, entry)
+	return nil
+}
+func (m *mockPointsRepo) GetPointsByUserId(_ context.Context, _ int) (int, error) {
+	return m.balance, nil
+}
+func (m *mockPointsRepo) GetHistoryByUserId(_ context.Context, _ int, _ entity.PointsHistoryFilter, _ int) ([]entity.PointsEntry, error) {
+	return m.spent, nil
+}
+func (m *mockPointsRepo) CountTaskCompletions(_ context.Context, _ int, _ map[int]time.Time) (map[int]int, error) {
+	return nil, nil
+}
+func (m *mockPointsRepo) CountCompletedTasks(_ context.Context, _ int) (int, error) {
+	return 0, nil
+}
+func (m *mockPointsRepo) GetRankByUserId(_ context.Context, _ int) (int, error) {
+	return 0, nil
+}
+func (m *mockPointsRepo) GetLeaderboard(_ context.Context, _ int) ([]entity.LeaderboardItem, error) {
+	return nil, nil
+}
+
+var _ repo.Points = (*mockPointsRepo)(nil)
+
+func intPtr(i int) *int { return &i }
+
+func timePtr(t time.Time) *time.Time { return &t }
+
+func TestRewardsService_GetAvailableRewards(t *testing.T) {
+	rewardsRepo := &mockRewardsRepo{rewards: map[int]entity.Reward{
+		1: {Id: 1, Name: "mug", Cost: 10},
+		2: {Id: 2, Name: "sold out", Cost: 10, Stock: intPtr(0)},
+		3: {Id: 3, Name: "expired", Cost: 10, ActiveUntil: timePtr(time.Now().Add(-time.Hour))},
+		4: {Id: 4, Name: "upcoming", Cost: 10, ActiveFrom: timePtr(time.Now().Add(time.Hour))},
+	}}
+	s := NewRewardsService(&mockTransactor{}, rewardsRepo, &mockPointsRepo{})
+	got, err := s.GetAvailableRewards(context.Background())
+	assert.NoError(t, err)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "mug", got[0].Name)
+	}
+
+	rewardsRepo.listErr = errors.New("db")
+	_, err = s.GetAvailableRewards(context.Background())
+	assert.ErrorIs(t, err, ErrCannotGetRewards)
+}
+
+func TestRewardsService_Redeem_Success(t *testing.T) {
+	rewardsRepo := &mockRewardsRepo{rewards: map[int]entity.Reward{
+		1: {Id: 1, Name: "mug", Cost: 30, Stock: intPtr(2)},
+	}}
+	points := &mockPointsRepo{balance: 50}
+	transactor := &mockTransactor{}
+	s := NewRewardsService(transactor, rewardsRepo, points)
+
+	err := s.Redeem(context.Background(), RewardsRedeemInput{UserId: 7, RewardId: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 20, points.balance)
+	assert.Equal(t, 1, *rewardsRepo.rewards[1].Stock)
+	assert.Equal(t, 1, transactor.committed)
+	if assert.Len(t, points.spent, 1) {
+		entry := points.spent[0]
+		assert.Equal(t, 7, entry.UserId)
+		assert.Equal(t, -30, entry.Delta)
+		assert.Equal(t, entity.PointsReasonRewardRedeemed, entry.Reason)
+		assert.Equal(t, 1, *entry.RewardId)
+	}
+}
+
+func TestRewardsService_Redeem_InsufficientPoints(t *testing.T) {
+	rewardsRepo := &mockRewardsRepo{rewards: map[int]entity.Reward{
+		1: {Id: 1, Name: "mug", Cost: 30, Stock: intPtr(2)},
+	}}
+	points := &mockPointsRepo{balance: 29}
+	transactor := &mockTransactor{}
+	s := NewRewardsService(transactor, rewardsRepo, points)
+
+	err := s.Redeem(context.Background(), RewardsRedeemInput{UserId: 7, RewardId: 1})
+	assert.ErrorIs(t, err, ErrInsufficientPoints)
+	assert.Equal(t, 29, points.balance)
+	// the stock taken in the transaction is given back by the rollback
+	assert.Equal(t, 1, transactor.rolledBack)
+	assert.Equal(t, 0, transactor.committed)
+}
+
+func TestRewardsService_Redeem_OutOfStock(t *testing.T) {
+	rewardsRepo := &mockRewardsRepo{rewards: map[int]entity.Reward{
+		1: {Id: 1, Name: "mug", Cost: 10, Stock: intPtr(1)},
+	}}
+	points := &mockPointsRepo{balance: 100}
+	s := NewRewardsService(&mockTransactor{}, rewardsRepo, points)
+
+	assert.NoError(t, s.Redeem(context.Background(), RewardsRedeemInput{UserId: 7, RewardId: 1}))
+	err := s.Redeem(context.Background(), RewardsRedeemInput{UserId: 8, RewardId: 1})
+	assert.ErrorIs(t, err, ErrRewardNotAvailable)
+	assert.Len(t, points.spent, 1)
+}
+
+func TestRewardsService_Redeem_Inactive(t *testing.T) {
+	rewardsRepo := &mockRewardsRepo{rewards: map[int]entity.Reward{
+		1: {Id: 1, Name: "mug", Cost: 10, ActiveUntil: timePtr(time.Now().Add(-time.Minute))},
+	}}
+	s := NewRewardsService(&mockTransactor{}, rewardsRepo, &mockPointsRepo{balance: 100})
+	err := s.Redeem(context.Background(), RewardsRedeemInput{UserId: 7, RewardId: 1})
+	assert.ErrorIs(t, err, ErrRewardNotAvailable)
+}
+
+func TestRewardsService_Redeem_NotFound(t *testing.T) {
+	s := NewRewardsService(&mockTransactor{}, &mockRewardsRepo{}, &mockPointsRepo{})
+	err := s.Redeem(context.Background(), RewardsRedeemInput{UserId: 7, RewardId: 42})
+	assert.ErrorIs(t, err, ErrRewardNotFound)
+}
+
+func TestRewardsService_Redeem_SpendError(t *testing.T) {
+	rewardsRepo := &mockRewardsRepo{rewards: map[int]entity.Reward{
+		1: {Id: 1, Name: "mug", Cost: 10},
+	}}
+	s := NewRewardsService(&mockTransactor{}, rewardsRepo, &mockPointsRepo{balance: 100, spendErr: errors.New("db")})
+	err := s.Redeem(context.Background(), RewardsRedeemInput{UserId: 7, RewardId: 1})
+	assert.ErrorIs(t, err, ErrCannotRedeemReward)
+}
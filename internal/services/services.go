@@ -2,41 +2,103 @@ package services
 
 import (
 	"context"
+	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo"
+	"denet-test-task/internal/services/antifraud"
 	"denet-test-task/internal/services/auth"
+	"denet-test-task/internal/services/idempotency"
+	"denet-test-task/internal/services/rewards"
 	"denet-test-task/internal/services/tasks"
 	"denet-test-task/internal/services/users"
+	"denet-test-task/internal/services/verification"
 	"denet-test-task/pkg/hasher"
+	"denet-test-task/pkg/jwks"
 	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/notifier"
+	"denet-test-task/pkg/verifier"
 	"time"
 )
 
 type Services struct {
-	Auth  auth.Auth
-	User  users.Users
-	Tasks tasks.Tasks
+	Auth    auth.Auth
+	User    users.Users
+	Tasks   tasks.Tasks
+	Rewards rewards.Rewards
+
+	Idempotency idempotency.Idempotency
 }
 
 type ServicesDependencies struct {
 	Repos *repo.Repositories
 	// GDrive webapi.GDrive
-	Hasher hasher.PasswordHasher
+	Hasher   hasher.PasswordHasher
+	Notifier notifier.Notifier
+
+	SigningKeys     *jwks.KeySet
+	TokenTTL        time.Duration
+	RefreshTokenTTL time.Duration
+	Issuer          string
+	Audience        []string
+	Leeway          time.Duration
+	RequiredClaims  []string
+
+	PasswordResetTokenTTL time.Duration
+
+	EmailVerification users.EmailVerificationConfig
+
+	IdempotencyKeyTTL time.Duration
+
+	TaskCatalogTTL time.Duration
+
+	Streak   users.StreakConfig
+	Referral users.ReferralConfig
+	// ReferralRules are the anti-fraud rules referral rewards are released by
+	ReferralRules []antifraud.Rule
 
-	SignKey  string
-	TokenTTL time.Duration
+	Verifiers    map[entity.TaskKind]verifier.Verifier
+	Verification verification.Config
+
+	RevocationCacheTTL time.Duration
+
+	LoginThrottle auth.LoginThrottleConfig
 }
 
+// NewServices builds the services. Background work started here, such as
+// the task catalog refresh and the recheck of referral rewards, runs until
+// ctx is done.
 func NewServices(ctx context.Context, deps ServicesDependencies) (*Services, error) {
+	if err := auth.CheckRequiredClaims(deps.RequiredClaims); err != nil {
+		logctx.FromContext(ctx).Error("Services.NewServices - auth.CheckRequiredClaims", "err", err)
+		return nil, err
+	}
 
-	userService, err := users.NewUsersService(ctx, deps.Repos.Users, deps.Repos.Points, deps.Repos.Tasks)
+	taskCatalog, err := tasks.NewTasksCatalog(ctx, deps.Repos.Tasks, deps.TaskCatalogTTL)
 	if err != nil {
-		logctx.FromContext(ctx).Error("Services.NewServices - users.NewUsersService", "err", err)
+		logctx.FromContext(ctx).Error("Services.NewServices - tasks.NewTasksCatalog", "err", err)
 		return nil, err
 	}
+	go taskCatalog.Watch(ctx)
+
+	usersService := users.NewUsersService(deps.Repos.Transactor, deps.Repos.Users, deps.Repos.Points, deps.Repos.Streaks, deps.Repos.Referrals, taskCatalog, verification.NewVerificationService(deps.Verifiers, deps.Repos.TaskAccounts, deps.Repos.LinkedAccounts, deps.Verification), antifraud.NewAntifraudService(deps.ReferralRules), deps.Repos.EmailVerifications, deps.Notifier, deps.EmailVerification, deps.Streak, deps.Referral)
+	go usersService.WatchReferralRewards(ctx)
 
 	return &Services{
-		Auth:  auth.NewAuthService(deps.Repos.Users, deps.Hasher, deps.SignKey, deps.TokenTTL),
-		User:  userService,
-		Tasks: tasks.NewTasksService(deps.Repos.Tasks),
+		Auth: auth.NewAuthService(deps.Repos.Transactor, deps.Repos.Users, deps.Repos.RefreshTokens, deps.Repos.RevokedTokens, deps.Repos.LoginAttempts, deps.Repos.PasswordResetTokens, deps.Hasher, deps.Notifier, usersService, auth.TokenConfig{
+			Keys:               deps.SigningKeys,
+			TokenTTL:           deps.TokenTTL,
+			RefreshTokenTTL:    deps.RefreshTokenTTL,
+			Issuer:             deps.Issuer,
+			Audience:           deps.Audience,
+			Leeway:             deps.Leeway,
+			RequiredClaims:     deps.RequiredClaims,
+			RevocationCacheTTL: deps.RevocationCacheTTL,
+
+			PasswordResetTokenTTL: deps.PasswordResetTokenTTL,
+		}, deps.LoginThrottle),
+		User:    usersService,
+		Tasks:   tasks.NewTasksService(deps.Repos.Tasks, deps.Repos.Users, deps.Repos.Points, taskCatalog),
+		Rewards: rewards.NewRewardsService(deps.Repos.Transactor, deps.Repos.Rewards, deps.Repos.Points),
+
+		Idempotency: idempotency.NewIdempotencyService(deps.Repos.IdempotencyKeys, deps.IdempotencyKeyTTL),
 	}, nil
 }
@@ -0,0 +1,129 @@
+package tasks
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/pkg/logctx"
+	"sync"
+	"time"
+)
+
+var _ Catalog = (*TasksCatalog)(nil)
+
+// TasksCatalog keeps the active tasks in memory. Reads are safe for
+// concurrent use; the tasks are reloaded by Reload and by Watch.
+type TasksCatalog struct {
+	tasksRepo repo.Tasks
+	ttl       time.Duration
+
+	reloadMu sync.Mutex // serializes reloads so an older snapshot never replaces a newer one
+
+	mu     sync.RWMutex
+	all    []entity.Task
+	tasks  map[int]entity.Task
+	byKind map[entity.TaskKind][]entity.Task
+}
+
+// NewTasksCatalog loads the tasks, failing if they cannot be loaded.
+func NewTasksCatalog(ctx context.Context, tasksRepo repo.Tasks, ttl time.Duration) (*TasksCatalog, error) {
+	c := &TasksCatalog{
+		tasksRepo: tasksRepo,
+		ttl:       ttl,
+	}
+	if err := c.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *TasksCatalog) GetAllTasks() []entity.Task {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.all
+}
+
+func (c *TasksCatalog) GetTask(id int) (entity.Task, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	task, ok := c.tasks[id]
+	return task, ok
+}
+
+func (c *TasksCatalog) GetTasksByKind(kind entity.TaskKind) []entity.Task {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.byKind[kind]
+}
+
+func (c *TasksCatalog) Reload(ctx context.Context) error {
+	c.reloadMu.Lock()
+	defer c.reloadMu.Unlock()
+
+	tasks, err := c.tasksRepo.GetAllTasks(ctx)
+	if err != nil {
+		logctx.FromContext(ctx).Error("TasksCatalog.Reload - tasksRepo.GetAllTasks", "err", err)
+		return ErrCannotGetTasks
+	}
+
+	byId := make(map[int]entity.Task, len(tasks))
+	byKind := make(map[entity.TaskKind][]entity.Task)
+	for _, task := range tasks {
+		byId[task.Id] = task
+		byKind[task.Kind] = append(byKind[task.Kind], task)
+	}
+
+	c.mu.Lock()
+	c.all = tasks
+	c.tasks = byId
+	c.byKind = byKind
+	c.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the catalog every ttl and whenever the tasks table changes,
+// until ctx is done. A lost change subscription is restored after ttl; the
+// periodic reload picks up the changes made meanwhile.
+func (c *TasksCatalog) Watch(ctx context.Context) {
+	changed := make(chan struct{}, 1)
+	go c.listen(ctx, changed)
+
+	ticker := time.NewTicker(c.ttl)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changed:
+		}
+		_ = c.Reload(ctx)
+	}
+}
+
+func (c *TasksCatalog) listen(ctx context.Context, changed chan<- struct{}) {
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+
+	for {
+		err := c.tasksRepo.ListenTaskChanges(ctx, notify)
+		if ctx.Err() != nil {
+			return
+		}
+		logctx.FromContext(ctx).Error("TasksCatalog.listen - tasksRepo.ListenTaskChanges", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.ttl):
+		}
+	}
+}
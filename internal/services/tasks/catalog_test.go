@@ -0,0 +1,82 @@
+package tasks
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewTasksCatalog_LoadError(t *testing.T) {
+	_, err := NewTasksCatalog(context.Background(), &mockTasksRepo{err: errors.New("boom")}, time.Minute)
+	assert.ErrorIs(t, err, ErrCannotGetTasks)
+}
+
+func TestTasksCatalog_Reload(t *testing.T) {
+	r := &mockTasksRepo{allTasks: []entity.Task{{Id: 1, Name: "a", Points: 10}}}
+	c, err := NewTasksCatalog(context.Background(), r, time.Minute)
+	assert.NoError(t, err)
+
+	task, ok := c.GetTask(1)
+	assert.True(t, ok)
+	assert.Equal(t, 10, task.Points)
+
+	r.allTasks = []entity.Task{{Id: 1, Name: "a", Points: 15}, {Id: 2, Name: "b", Points: 5}}
+	assert.NoError(t, c.Reload(context.Background()))
+
+	task, ok = c.GetTask(1)
+	assert.True(t, ok)
+	assert.Equal(t, 15, task.Points)
+	_, ok = c.GetTask(2)
+	assert.True(t, ok)
+}
+
+func TestTasksCatalog_GetTasksByKind(t *testing.T) {
+	r := &mockTasksRepo{allTasks: []entity.Task{
+		{Id: 1, Name: "give_referral", Kind: entity.TaskKindReferralGiver},
+		{Id: 3, Name: "subscribe_telegram", Kind: entity.TaskKindExternalVerification},
+		{Id: 6, Name: "campaign_referral", Kind: entity.TaskKindReferralGiver},
+	}}
+	c, err := NewTasksCatalog(context.Background(), r, time.Minute)
+	assert.NoError(t, err)
+
+	got := c.GetTasksByKind(entity.TaskKindReferralGiver)
+	if assert.Len(t, got, 2) {
+		assert.Equal(t, 1, got[0].Id)
+		assert.Equal(t, 6, got[1].Id)
+	}
+	assert.Empty(t, c.GetTasksByKind(entity.TaskKindEmail))
+}
+
+func TestTasksCatalog_ReloadErrorKeepsTasks(t *testing.T) {
+	r := &mockTasksRepo{allTasks: []entity.Task{{Id: 1, Name: "a", Points: 10}}}
+	c, err := NewTasksCatalog(context.Background(), r, time.Minute)
+	assert.NoError(t, err)
+
+	r.err = errors.New("db")
+	assert.ErrorIs(t, c.Reload(context.Background()), ErrCannotGetTasks)
+	_, ok := c.GetTask(1)
+	assert.True(t, ok)
+}
+
+func TestTasksCatalog_WatchReloadsOnChange(t *testing.T) {
+	r := &mockTasksRepo{allTasks: []entity.Task{{Id: 1, Name: "a", Points: 10}}, changes: make(chan struct{})}
+	c, err := NewTasksCatalog(context.Background(), r, time.Hour)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Watch(ctx)
+
+	// tasks are replaced before the notification is delivered
+	r.allTasks = []entity.Task{{Id: 1, Name: "a", Points: 10}, {Id: 2, Name: "b", Points: 5}}
+	r.changes <- struct{}{}
+
+	assert.Eventually(t, func() bool {
+		_, ok := c.GetTask(2)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+}
@@ -3,8 +3,68 @@ package tasks
 import (
 	"context"
 	"denet-test-task/internal/entity"
+	"time"
 )
 
+type TasksCreateInput struct {
+	Name            string
+	Descr           string
+	Points          int
+	Kind            entity.TaskKind
+	Metadata        map[string]any
+	StartsAt        *time.Time
+	EndsAt          *time.Time
+	Recurrence      entity.TaskRecurrence
+	CompletionLimit int
+	StreakGroup     string
+}
+
+type TasksUpdateInput struct {
+	TaskId          int
+	Name            string
+	Descr           string
+	Points          int
+	Kind            entity.TaskKind
+	Metadata        map[string]any
+	StartsAt        *time.Time
+	EndsAt          *time.Time
+	Recurrence      entity.TaskRecurrence
+	CompletionLimit int
+	StreakGroup     string
+}
+
+type TasksGetUserTasksInput struct {
+	UserId int
+}
+
+type TasksArchiveInput struct {
+	TaskId int
+}
+
+type TasksRestoreInput struct {
+	TaskId int
+}
+
 type Tasks interface {
 	GetAllTasks(ctx context.Context) ([]entity.Task, error)
+	GetAllTasksWithArchived(ctx context.Context) ([]entity.Task, error)
+	GetUserTasks(ctx context.Context, input TasksGetUserTasksInput) ([]entity.UserTask, error)
+
+	Create(ctx context.Context, input TasksCreateInput) (int, error)
+	Update(ctx context.Context, input TasksUpdateInput) error
+	Archive(ctx context.Context, input TasksArchiveInput) error
+	Restore(ctx context.Context, input TasksRestoreInput) error
+
+	Reload(ctx context.Context) error
+}
+
+// Catalog serves the active tasks from memory, so reading a task does not
+// need a database round trip.
+type Catalog interface {
+	// GetAllTasks returns the tasks ordered by id.
+	GetAllTasks() []entity.Task
+	GetTask(id int) (entity.Task, bool)
+	// GetTasksByKind returns the tasks of the kind ordered by id.
+	GetTasksByKind(kind entity.TaskKind) []entity.Task
+	Reload(ctx context.Context) error
 }
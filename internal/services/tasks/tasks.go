@@ -4,18 +4,270 @@ import (
 	"context"
 	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+	"unicode/utf8"
 )
 
 var _ Tasks = (*TasksService)(nil)
 
+const (
+	taskDescrMaxLength = 512
+	taskMaxPoints      = 1_000_000
+
+	taskMaxCompletionLimit = 100
+)
+
+var taskNameRegexp = regexp.MustCompile(`^[a-z0-9_]{1,64}$`)
+
+var (
+	ErrTaskNotFound          = fmt.Errorf("task not found")
+	ErrTaskAlreadyExists     = fmt.Errorf("task with this name already exists")
+	ErrInvalidTaskName       = fmt.Errorf("task name must be 1 to 64 lowercase letters, digits or underscores")
+	ErrInvalidTaskDescr      = fmt.Errorf("task description must be at most %d characters", taskDescrMaxLength)
+	ErrInvalidTaskPoints     = fmt.Errorf("task points must be between 1 and %d", taskMaxPoints)
+	ErrInvalidTaskKind       = fmt.Errorf("unknown task kind")
+	ErrInvalidTaskWindow     = fmt.Errorf("task must start before it ends")
+	ErrInvalidTaskRecurrence = fmt.Errorf("unknown task recurrence")
+	ErrInvalidTaskLimit      = fmt.Errorf("task completion limit must be between 1 and %d", taskMaxCompletionLimit)
+	ErrTaskNotRepeatable     = fmt.Errorf("only tasks completed by users can be repeated")
+	ErrInvalidTaskMetadata   = fmt.Errorf("task metadata lacks the settings of its kind")
+	ErrInvalidStreakGroup    = fmt.Errorf("streak group must be up to 64 lowercase letters, digits or underscores")
+	ErrTaskNotInStreak       = fmt.Errorf("only tasks completed by users can be part of a streak")
+	ErrCannotGetTasks        = fmt.Errorf("cannot get tasks")
+	ErrCannotCreateTask      = fmt.Errorf("cannot create task")
+	ErrCannotUpdateTask      = fmt.Errorf("cannot update task")
+	ErrCannotArchiveTask     = fmt.Errorf("cannot archive task")
+	ErrCannotRestoreTask     = fmt.Errorf("cannot restore task")
+)
+
 type TasksService struct {
-	tasksRepo repo.Tasks
+	tasksRepo  repo.Tasks
+	usersRepo  repo.Users
+	pointsRepo repo.Points
+	catalog    Catalog
 }
 
-func NewTasksService(tasksRepo repo.Tasks) *TasksService {
-	return &TasksService{tasksRepo: tasksRepo}
+func NewTasksService(tasksRepo repo.Tasks, usersRepo repo.Users, pointsRepo repo.Points, catalog Catalog) *TasksService {
+	return &TasksService{
+		tasksRepo:  tasksRepo,
+		usersRepo:  usersRepo,
+		pointsRepo: pointsRepo,
+		catalog:    catalog,
+	}
 }
 
 func (s *TasksService) GetAllTasks(ctx context.Context) ([]entity.Task, error) {
 	return s.tasksRepo.GetAllTasks(ctx)
 }
+
+func (s *TasksService) GetAllTasksWithArchived(ctx context.Context) ([]entity.Task, error) {
+	tasks, err := s.tasksRepo.GetAllTasksWithArchived(ctx)
+	if err != nil {
+		logctx.FromContext(ctx).Error("TasksService.GetAllTasksWithArchived - tasksRepo.GetAllTasksWithArchived", "err", err)
+		return nil, ErrCannotGetTasks
+	}
+	return tasks, nil
+}
+
+// GetUserTasks returns the tasks with the user's completions in the current
+// period of each task, counted in the user's timezone.
+func (s *TasksService) GetUserTasks(ctx context.Context, input TasksGetUserTasksInput) ([]entity.UserTask, error) {
+	user, err := s.usersRepo.GetUserById(ctx, input.UserId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("TasksService.GetUserTasks - usersRepo.GetUserById", "err", err)
+		return nil, ErrCannotGetTasks
+	}
+	loc := user.Location()
+	now := time.Now()
+
+	tasks := s.catalog.GetAllTasks()
+	since := make(map[int]time.Time, len(tasks))
+	for _, task := range tasks {
+		since[task.Id], _ = task.Period(now, loc)
+	}
+
+	counts, err := s.pointsRepo.CountTaskCompletions(ctx, input.UserId, since)
+	if err != nil {
+		logctx.FromContext(ctx).Error("TasksService.GetUserTasks - pointsRepo.CountTaskCompletions", "err", err)
+		return nil, ErrCannotGetTasks
+	}
+
+	userTasks := make([]entity.UserTask, 0, len(tasks))
+	for _, task := range tasks {
+		userTask := entity.UserTask{
+			Task:        task,
+			Completions: counts[task.Id],
+		}
+		userTask.Available = task.ActiveAt(now) && userTask.Completions < max(task.CompletionLimit, 1)
+
+		if _, end := task.Period(now, loc); !end.IsZero() && (task.EndsAt == nil || end.Before(*task.EndsAt)) {
+			userTask.NextResetAt = &end
+		}
+		userTasks = append(userTasks, userTask)
+	}
+	return userTasks, nil
+}
+
+func (s *TasksService) Create(ctx context.Context, input TasksCreateInput) (int, error) {
+	task, err := newTask(input)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := s.tasksRepo.CreateTask(ctx, task)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			return 0, ErrTaskAlreadyExists
+		}
+		logctx.FromContext(ctx).Error("TasksService.Create - tasksRepo.CreateTask", "err", err)
+		return 0, ErrCannotCreateTask
+	}
+
+	s.reloadCatalog(ctx)
+	return id, nil
+}
+
+func (s *TasksService) Update(ctx context.Context, input TasksUpdateInput) error {
+	task, err := newTask(TasksCreateInput{
+		Name:            input.Name,
+		Descr:           input.Descr,
+		Points:          input.Points,
+		Kind:            input.Kind,
+		Metadata:        input.Metadata,
+		StartsAt:        input.StartsAt,
+		EndsAt:          input.EndsAt,
+		Recurrence:      input.Recurrence,
+		CompletionLimit: input.CompletionLimit,
+		StreakGroup:     input.StreakGroup,
+	})
+	if err != nil {
+		return err
+	}
+	task.Id = input.TaskId
+
+	err = s.tasksRepo.UpdateTask(ctx, task)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrTaskNotFound
+		}
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			return ErrTaskAlreadyExists
+		}
+		logctx.FromContext(ctx).Error("TasksService.Update - tasksRepo.UpdateTask", "err", err)
+		return ErrCannotUpdateTask
+	}
+
+	s.reloadCatalog(ctx)
+	return nil
+}
+
+func (s *TasksService) Archive(ctx context.Context, input TasksArchiveInput) error {
+	err := s.tasksRepo.ArchiveTask(ctx, input.TaskId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrTaskNotFound
+		}
+		logctx.FromContext(ctx).Error("TasksService.Archive - tasksRepo.ArchiveTask", "err", err)
+		return ErrCannotArchiveTask
+	}
+
+	s.reloadCatalog(ctx)
+	return nil
+}
+
+func (s *TasksService) Restore(ctx context.Context, input TasksRestoreInput) error {
+	err := s.tasksRepo.RestoreTask(ctx, input.TaskId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrTaskNotFound
+		}
+		logctx.FromContext(ctx).Error("TasksService.Restore - tasksRepo.RestoreTask", "err", err)
+		return ErrCannotRestoreTask
+	}
+
+	s.reloadCatalog(ctx)
+	return nil
+}
+
+func (s *TasksService) Reload(ctx context.Context) error {
+	return s.catalog.Reload(ctx)
+}
+
+// reloadCatalog applies a change made through the service to the catalog at
+// once. The change is already stored, so a failed reload is left to the
+// periodic one.
+func (s *TasksService) reloadCatalog(ctx context.Context) {
+	_ = s.catalog.Reload(ctx)
+}
+
+// newTask validates the task fields. An empty kind is manual, an empty
+// recurrence is once and a zero completion limit is 1. An empty streak group
+// keeps the task out of streaks.
+func newTask(input TasksCreateInput) (entity.Task, error) {
+	if !taskNameRegexp.MatchString(input.Name) {
+		return entity.Task{}, ErrInvalidTaskName
+	}
+	if utf8.RuneCountInString(input.Descr) > taskDescrMaxLength {
+		return entity.Task{}, ErrInvalidTaskDescr
+	}
+	if input.Points < 1 || input.Points > taskMaxPoints {
+		return entity.Task{}, ErrInvalidTaskPoints
+	}
+
+	task := entity.Task{
+		Name:            input.Name,
+		Descr:           input.Descr,
+		Points:          input.Points,
+		Kind:            input.Kind,
+		Metadata:        input.Metadata,
+		StartsAt:        input.StartsAt,
+		EndsAt:          input.EndsAt,
+		Recurrence:      input.Recurrence,
+		CompletionLimit: input.CompletionLimit,
+		StreakGroup:     input.StreakGroup,
+	}
+	if task.Kind == "" {
+		task.Kind = entity.TaskKindManual
+	}
+	if !task.Kind.Valid() {
+		return entity.Task{}, ErrInvalidTaskKind
+	}
+	if task.Metadata == nil {
+		task.Metadata = map[string]any{}
+	}
+	if chat, _ := task.Metadata["chat"].(string); task.Kind == entity.TaskKindTelegramSubscription && chat == "" {
+		return entity.Task{}, ErrInvalidTaskMetadata
+	}
+	if task.StartsAt != nil && task.EndsAt != nil && !task.StartsAt.Before(*task.EndsAt) {
+		return entity.Task{}, ErrInvalidTaskWindow
+	}
+	if task.Recurrence == "" {
+		task.Recurrence = entity.TaskRecurrenceOnce
+	}
+	if !task.Recurrence.Valid() {
+		return entity.Task{}, ErrInvalidTaskRecurrence
+	}
+	if task.CompletionLimit == 0 {
+		task.CompletionLimit = 1
+	}
+	if task.CompletionLimit < 1 || task.CompletionLimit > taskMaxCompletionLimit {
+		return entity.Task{}, ErrInvalidTaskLimit
+	}
+	// the other kinds are granted once by the flow they belong to
+	if !task.Kind.CompletedByUser() && (task.Recurrence != entity.TaskRecurrenceOnce || task.CompletionLimit != 1) {
+		return entity.Task{}, ErrTaskNotRepeatable
+	}
+	if task.StreakGroup != "" && !taskNameRegexp.MatchString(task.StreakGroup) {
+		return entity.Task{}, ErrInvalidStreakGroup
+	}
+	if task.StreakGroup != "" && !task.Kind.CompletedByUser() {
+		return entity.Task{}, ErrTaskNotInStreak
+	}
+
+	return task, nil
+}
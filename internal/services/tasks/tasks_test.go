@@ -4,7 +4,11 @@ import (
 	"context"
 	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"errors"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -12,6 +16,14 @@ import (
 type mockTasksRepo struct {
 	allTasks []entity.Task
 	err      error
+
+	created  []entity.Task
+	updated  []entity.Task
+	archived []int
+	restored []int
+	writeErr error
+
+	changes chan struct{} // each value is delivered as a change notification
 }
 
 func (m *mockTasksRepo) GetTaskById(_ context.Context, _ int) (entity.Task, error) {
@@ -29,9 +41,127 @@ func (m *mockTasksRepo) GetTaskByName(_ context.Context, _ string) (entity.Task,
 func (m *mockTasksRepo) GetAllTasks(_ context.Context) ([]entity.Task, error) {
 	return m.allTasks, m.err
 }
+func (m *mockTasksRepo) GetAllTasksWithArchived(_ context.Context) ([]entity.Task, error) {
+	return m.allTasks, m.err
+}
+func (m *mockTasksRepo) CreateTask(_ context.Context, task entity.Task) (int, error) {
+	if m.writeErr != nil {
+		return 0, m.writeErr
+	}
+	m.created = append(m.created, task)
+	return len(m.created), nil
+}
+func (m *mockTasksRepo) UpdateTask(_ context.Context, task entity.Task) error {
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	m.updated = append(m.updated, task)
+	return nil
+}
+func (m *mockTasksRepo) ArchiveTask(_ context.Context, id int) error {
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	m.archived = append(m.archived, id)
+	return nil
+}
+func (m *mockTasksRepo) RestoreTask(_ context.Context, id int) error {
+	if m.writeErr != nil {
+		return m.writeErr
+	}
+	m.restored = append(m.restored, id)
+	return nil
+}
+
+func (m *mockTasksRepo) ListenTaskChanges(ctx context.Context, fn func()) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-m.changes:
+			fn()
+		}
+	}
+}
 
 var _ repo.Tasks = (*mockTasksRepo)(nil)
 
+type mockUsersRepo struct {
+	user   entity.User
+	getErr error
+}
+
+func (m *mockUsersRepo) CreateUser(_ context.Context, _ entity.User) (int, error) { return 0, nil }
+func (m *mockUsersRepo) GetUserById(_ context.Context, _ int) (entity.User, error) {
+	return m.user, m.getErr
+}
+func (m *mockUsersRepo) GetUserByUsername(_ context.Context, _ string) (entity.User, error) {
+	return entity.User{}, nil
+}
+func (m *mockUsersRepo) GetUserByEmail(_ context.Context, _ string) (entity.User, error) {
+	return entity.User{}, nil
+}
+func (m *mockUsersRepo) GetUserByReferralCode(_ context.Context, _ string) (entity.User, error) {
+	return entity.User{}, nil
+}
+func (m *mockUsersRepo) GetReferrerChain(_ context.Context, id int, _ int) ([]int, error) {
+	return []int{id}, nil
+}
+func (m *mockUsersRepo) LockReferrerChains(_ context.Context) error                    { return nil }
+func (m *mockUsersRepo) SetUserReferrer(_ context.Context, _ int, _ int) error         { return nil }
+func (m *mockUsersRepo) SetUserEmailVerified(_ context.Context, _ int, _ string) error { return nil }
+func (m *mockUsersRepo) SetUserPassword(_ context.Context, _ int, _ string) error      { return nil }
+func (m *mockUsersRepo) SetUserRole(_ context.Context, _ int, _ entity.Role) error     { return nil }
+func (m *mockUsersRepo) SetUserTimezone(_ context.Context, _ int, _ string, _ time.Time, _ time.Duration) error {
+	return nil
+}
+func (m *mockUsersRepo) GetUserTokenVersion(_ context.Context, _ int) (int, error) { return 0, nil }
+func (m *mockUsersRepo) IncrementUserTokenVersion(_ context.Context, _ int) (int, error) {
+	return 1, nil
+}
+
+var _ repo.Users = (*mockUsersRepo)(nil)
+
+type mockPointsRepo struct {
+	completions map[int]int
+	since       map[int]time.Time
+	countErr    error
+}
+
+func (m *mockPointsRepo) AddPoints(_ context.Context, _ entity.PointsEntry) error   { return nil }
+func (m *mockPointsRepo) SpendPoints(_ context.Context, _ entity.PointsEntry) error { return nil }
+func (m *mockPointsRepo) GetPointsByUserId(_ context.Context, _ int) (int, error)   { return 0, nil }
+func (m *mockPointsRepo) GetHistoryByUserId(_ context.Context, _ int, _ entity.PointsHistoryFilter, _ int) ([]entity.PointsEntry, error) {
+	return nil, nil
+}
+func (m *mockPointsRepo) CountTaskCompletions(_ context.Context, _ int, since map[int]time.Time) (map[int]int, error) {
+	m.since = since
+	return m.completions, m.countErr
+}
+func (m *mockPointsRepo) CountCompletedTasks(_ context.Context, _ int) (int, error) {
+	return 0, nil
+}
+func (m *mockPointsRepo) GetRankByUserId(_ context.Context, _ int) (int, error) {
+	return 0, nil
+}
+func (m *mockPointsRepo) GetLeaderboard(_ context.Context, _ int) ([]entity.LeaderboardItem, error) {
+	return nil, nil
+}
+
+var _ repo.Points = (*mockPointsRepo)(nil)
+
+type mockCatalog struct {
+	allTasks []entity.Task
+	reloads  int
+}
+
+func (m *mockCatalog) GetTask(_ int) (entity.Task, bool)              { return entity.Task{}, false }
+func (m *mockCatalog) GetTasksByKind(_ entity.TaskKind) []entity.Task { return nil }
+func (m *mockCatalog) GetAllTasks() []entity.Task                     { return m.allTasks }
+func (m *mockCatalog) Reload(_ context.Context) error                 { m.reloads++; return nil }
+
+var _ Catalog = (*mockCatalog)(nil)
+
 func TestTasksService_GetAllTasks(t *testing.T) {
 	r := &mockTasksRepo{
 		allTasks: []entity.Task{
@@ -39,10 +169,135 @@ func TestTasksService_GetAllTasks(t *testing.T) {
 			{Id: 2, Name: "B", Points: 20},
 		},
 	}
-	s := NewTasksService(r)
+	s := NewTasksService(r, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
 	got, err := s.GetAllTasks(context.Background())
 	assert.NoError(t, err)
 	assert.Len(t, got, 2)
 	assert.Equal(t, "A", got[0].Name)
 	assert.Equal(t, 20, got[1].Points)
 }
+
+func TestTasksService_Create(t *testing.T) {
+	r := &mockTasksRepo{}
+	catalog := &mockCatalog{}
+	s := NewTasksService(r, &mockUsersRepo{}, &mockPointsRepo{}, catalog)
+	id, err := s.Create(context.Background(), TasksCreateInput{Name: "join_discord", Descr: "Join Discord", Points: 40})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, id)
+	assert.Equal(t, []entity.Task{{Name: "join_discord", Descr: "Join Discord", Points: 40, Kind: entity.TaskKindManual, Metadata: map[string]any{}, Recurrence: entity.TaskRecurrenceOnce, CompletionLimit: 1}}, r.created)
+	assert.Equal(t, 1, catalog.reloads)
+}
+
+func TestTasksService_Create_Validation(t *testing.T) {
+	windowStart := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	windowEnd := windowStart.AddDate(0, 1, 0)
+	cases := []struct {
+		name  string
+		input TasksCreateInput
+		err   error
+	}{
+		{"empty name", TasksCreateInput{Name: "", Points: 10}, ErrInvalidTaskName},
+		{"uppercase name", TasksCreateInput{Name: "Join", Points: 10}, ErrInvalidTaskName},
+		{"long descr", TasksCreateInput{Name: "join", Descr: strings.Repeat("a", taskDescrMaxLength+1), Points: 10}, ErrInvalidTaskDescr},
+		{"zero points", TasksCreateInput{Name: "join", Points: 0}, ErrInvalidTaskPoints},
+		{"too many points", TasksCreateInput{Name: "join", Points: taskMaxPoints + 1}, ErrInvalidTaskPoints},
+		{"unknown kind", TasksCreateInput{Name: "join", Points: 10, Kind: "quiz"}, ErrInvalidTaskKind},
+		{"empty window", TasksCreateInput{Name: "join", Points: 10, StartsAt: &windowEnd, EndsAt: &windowStart}, ErrInvalidTaskWindow},
+		{"unknown recurrence", TasksCreateInput{Name: "join", Points: 10, Recurrence: "monthly"}, ErrInvalidTaskRecurrence},
+		{"negative limit", TasksCreateInput{Name: "join", Points: 10, CompletionLimit: -1}, ErrInvalidTaskLimit},
+		{"too high limit", TasksCreateInput{Name: "join", Points: 10, CompletionLimit: taskMaxCompletionLimit + 1}, ErrInvalidTaskLimit},
+		{"recurring email task", TasksCreateInput{Name: "join", Points: 10, Kind: entity.TaskKindEmail, Recurrence: entity.TaskRecurrenceDaily}, ErrTaskNotRepeatable},
+		{"telegram task without chat", TasksCreateInput{Name: "join", Points: 10, Kind: entity.TaskKindTelegramSubscription}, ErrInvalidTaskMetadata},
+		{"invalid streak group", TasksCreateInput{Name: "join", Points: 10, StreakGroup: "Daily"}, ErrInvalidStreakGroup},
+		{"email task in streak", TasksCreateInput{Name: "join", Points: 10, Kind: entity.TaskKindEmail, StreakGroup: "daily"}, ErrTaskNotInStreak},
+		{"repeated referral task", TasksCreateInput{Name: "join", Points: 10, Kind: entity.TaskKindReferralGiver, CompletionLimit: 2}, ErrTaskNotRepeatable},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &mockTasksRepo{}
+			_, err := NewTasksService(r, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{}).Create(context.Background(), tc.input)
+			assert.ErrorIs(t, err, tc.err)
+			assert.Empty(t, r.created)
+		})
+	}
+}
+
+func TestTasksService_Create_Duplicate(t *testing.T) {
+	s := NewTasksService(&mockTasksRepo{writeErr: repoerrs.ErrAlreadyExists}, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
+	_, err := s.Create(context.Background(), TasksCreateInput{Name: "join", Points: 10})
+	assert.ErrorIs(t, err, ErrTaskAlreadyExists)
+}
+
+func TestTasksService_Update(t *testing.T) {
+	r := &mockTasksRepo{}
+	s := NewTasksService(r, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
+	metadata := map[string]any{"chat": "@denet"}
+	err := s.Update(context.Background(), TasksUpdateInput{TaskId: 3, Name: "subscribe_telegram", Points: 35, Kind: entity.TaskKindExternalVerification, Metadata: metadata})
+	assert.NoError(t, err)
+	assert.Equal(t, []entity.Task{{Id: 3, Name: "subscribe_telegram", Points: 35, Kind: entity.TaskKindExternalVerification, Metadata: metadata, Recurrence: entity.TaskRecurrenceOnce, CompletionLimit: 1}}, r.updated)
+
+	s = NewTasksService(&mockTasksRepo{writeErr: repoerrs.ErrNotFound}, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
+	err = s.Update(context.Background(), TasksUpdateInput{TaskId: 99, Name: "x", Points: 1})
+	assert.ErrorIs(t, err, ErrTaskNotFound)
+}
+
+func TestTasksService_ArchiveRestore(t *testing.T) {
+	r := &mockTasksRepo{}
+	s := NewTasksService(r, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
+	assert.NoError(t, s.Archive(context.Background(), TasksArchiveInput{TaskId: 4}))
+	assert.NoError(t, s.Restore(context.Background(), TasksRestoreInput{TaskId: 4}))
+	assert.Equal(t, []int{4}, r.archived)
+	assert.Equal(t, []int{4}, r.restored)
+
+	s = NewTasksService(&mockTasksRepo{writeErr: repoerrs.ErrNotFound}, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
+	assert.ErrorIs(t, s.Archive(context.Background(), TasksArchiveInput{TaskId: 99}), ErrTaskNotFound)
+	assert.ErrorIs(t, s.Restore(context.Background(), TasksRestoreInput{TaskId: 99}), ErrTaskNotFound)
+
+	s = NewTasksService(&mockTasksRepo{writeErr: errors.New("db")}, &mockUsersRepo{}, &mockPointsRepo{}, &mockCatalog{})
+	assert.ErrorIs(t, s.Archive(context.Background(), TasksArchiveInput{TaskId: 4}), ErrCannotArchiveTask)
+}
+
+func TestTasksService_GetUserTasks(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	catalog := &mockCatalog{allTasks: []entity.Task{
+		{Id: 1, Name: "once", Recurrence: entity.TaskRecurrenceOnce, CompletionLimit: 1},
+		{Id: 2, Name: "daily", Recurrence: entity.TaskRecurrenceDaily, CompletionLimit: 3},
+		{Id: 3, Name: "ended", Recurrence: entity.TaskRecurrenceWeekly, CompletionLimit: 1, EndsAt: &past},
+	}}
+	points := &mockPointsRepo{completions: map[int]int{1: 1, 2: 2}}
+	users := &mockUsersRepo{user: entity.User{Id: 7, Timezone: "Asia/Tokyo"}}
+	s := NewTasksService(&mockTasksRepo{}, users, points, catalog)
+
+	got, err := s.GetUserTasks(context.Background(), TasksGetUserTasksInput{UserId: 7})
+	assert.NoError(t, err)
+	if !assert.Len(t, got, 3) {
+		return
+	}
+
+	assert.Equal(t, 1, got[0].Completions)
+	assert.False(t, got[0].Available)
+	assert.Nil(t, got[0].NextResetAt)
+
+	assert.Equal(t, 2, got[1].Completions)
+	assert.True(t, got[1].Available)
+	if assert.NotNil(t, got[1].NextResetAt) {
+		tokyo, _ := time.LoadLocation("Asia/Tokyo")
+		next := got[1].NextResetAt.In(tokyo)
+		assert.Equal(t, 0, next.Hour())
+		assert.Equal(t, next.AddDate(0, 0, -1), points.since[2])
+	}
+
+	assert.False(t, got[2].Available)
+	assert.Nil(t, got[2].NextResetAt)
+	assert.True(t, points.since[1].IsZero())
+}
+
+func TestTasksService_GetUserTasks_Errors(t *testing.T) {
+	s := NewTasksService(&mockTasksRepo{}, &mockUsersRepo{getErr: errors.New("db")}, &mockPointsRepo{}, &mockCatalog{})
+	_, err := s.GetUserTasks(context.Background(), TasksGetUserTasksInput{UserId: 1})
+	assert.ErrorIs(t, err, ErrCannotGetTasks)
+
+	s = NewTasksService(&mockTasksRepo{}, &mockUsersRepo{}, &mockPointsRepo{countErr: errors.New("db")}, &mockCatalog{})
+	_, err = s.GetUserTasks(context.Background(), TasksGetUserTasksInput{UserId: 1})
+	assert.ErrorIs(t, err, ErrCannotGetTasks)
+}
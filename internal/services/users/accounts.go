@@ -0,0 +1,39 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/services/verification"
+	"fmt"
+)
+
+var (
+	ErrAccountNotLinkable   = fmt.Errorf("tasks of the kind need no account")
+	ErrInvalidAccountProof  = fmt.Errorf("invalid proof of the account on the external service")
+	ErrAccountAlreadyLinked = fmt.Errorf("account on the external service is linked to another user")
+	ErrCannotLinkAccount    = fmt.Errorf("cannot link account")
+)
+
+// LinkAccount links the account on the external service verifying tasks of
+// the kind to the user, once the service proves the user owns it. The tasks
+// of the kind are then verified with this account, replacing the one the
+// user linked before.
+func (s *UsersService) LinkAccount(ctx context.Context, input UsersLinkAccountInput) (entity.LinkedAccount, error) {
+	la, err := s.verification.Link(ctx, verification.VerificationLinkInput{
+		UserId: input.UserId,
+		Kind:   input.Kind,
+		Proof:  input.Proof,
+	})
+	switch err {
+	case nil:
+		return la, nil
+	case verification.ErrNotLinkable:
+		return entity.LinkedAccount{}, ErrAccountNotLinkable
+	case verification.ErrInvalidProof:
+		return entity.LinkedAccount{}, ErrInvalidAccountProof
+	case verification.ErrAccountLinked:
+		return entity.LinkedAccount{}, ErrAccountAlreadyLinked
+	default:
+		return entity.LinkedAccount{}, ErrCannotLinkAccount
+	}
+}
@@ -3,15 +3,37 @@ package users
 import (
 	"context"
 	"denet-test-task/internal/entity"
+	"time"
 )
 
 type UsersGetInfoInput struct {
 	UserId int
 }
 
+// UsersSetReferrerInput holds the referrer of the user, given by id or, if
+// ReferralCode is set, by their referral code.
 type UsersSetReferrerInput struct {
-	UserId   int
-	Referrer int
+	UserId       int
+	Referrer     int
+	ReferralCode string
+}
+
+type UsersGetReferralsInput struct {
+	UserId int
+}
+
+// UsersGetReferralRewardsInput selects the oldest Limit referral rewards with
+// the status.
+type UsersGetReferralRewardsInput struct {
+	Status entity.ReferralRewardStatus
+	Limit  int
+}
+
+// UsersReviewReferralRewardInput holds the decision of an admin on a referral
+// reward: it is paid if Release is set and rejected otherwise.
+type UsersReviewReferralRewardInput struct {
+	RewardId int
+	Release  bool
 }
 
 type UsersSetEmailInput struct {
@@ -19,14 +41,42 @@ type UsersSetEmailInput struct {
 	Email  string
 }
 
+type UsersVerifyEmailInput struct {
+	UserId int
+	Code   string
+}
+
+// UsersCompleteTaskInput holds the task to complete. Tasks requiring
+// verification are checked with the account the user linked to their kind.
 type UsersCompleteTaskInput struct {
 	UserId int
 	TaskId int
 }
 
+// UsersLinkAccountInput holds the proof that the user owns an account on the
+// external service verifying tasks of the kind.
+type UsersLinkAccountInput struct {
+	UserId int
+	Kind   entity.TaskKind
+	Proof  map[string]string
+}
+
+type UsersSetTimezoneInput struct {
+	UserId   int
+	Timezone string
+}
+
+// UsersGetHistoryInput selects a page of the history. Cursor is the
+// NextCursor of the previous page, empty for the first one; the other fields
+// are filters, zero ones do not filter.
 type UsersGetHistoryInput struct {
 	UserId int
 	Limit  int
+	Cursor string
+	TaskId *int
+	Reason entity.PointsReason
+	From   time.Time
+	To     time.Time
 }
 
 type UsersGetPointsInput struct {
@@ -38,11 +88,17 @@ type UsersGetLeaderboardInput struct {
 }
 
 type Users interface {
-	GetInfo(ctx context.Context, input UsersGetInfoInput) (entity.User, error)
+	GetInfo(ctx context.Context, input UsersGetInfoInput) (entity.UserStatus, error)
 	SetReferrer(ctx context.Context, input UsersSetReferrerInput) error
+	GetReferrals(ctx context.Context, input UsersGetReferralsInput) (entity.ReferralTree, error)
+	GetReferralRewards(ctx context.Context, input UsersGetReferralRewardsInput) ([]entity.ReferralReward, error)
+	ReviewReferralReward(ctx context.Context, input UsersReviewReferralRewardInput) error
 	SetEmail(ctx context.Context, input UsersSetEmailInput) error
+	VerifyEmail(ctx context.Context, input UsersVerifyEmailInput) error
 	CompleteTask(ctx context.Context, input UsersCompleteTaskInput) error
-	GetHistory(ctx context.Context, input UsersGetHistoryInput) ([]entity.Point, error)
+	LinkAccount(ctx context.Context, input UsersLinkAccountInput) (entity.LinkedAccount, error)
+	SetTimezone(ctx context.Context, input UsersSetTimezoneInput) error
+	GetHistory(ctx context.Context, input UsersGetHistoryInput) (entity.PointsHistory, error)
 	GetPoints(ctx context.Context, input UsersGetPointsInput) (int, error)
 	GetLeaderboard(ctx context.Context, input UsersGetLeaderboardInput) ([]entity.LeaderboardItem, error)
 }
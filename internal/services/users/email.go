@@ -0,0 +1,172 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/notifier"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var (
+	ErrEmailAlreadyUsed            = fmt.Errorf("email already used")
+	ErrCannotSetEmail              = fmt.Errorf("cannot set email")
+	ErrCannotSendVerification      = fmt.Errorf("cannot send verification code")
+	ErrVerificationSentRecently    = fmt.Errorf("verification code was sent recently, retry later")
+	ErrVerificationNotFound        = fmt.Errorf("no pending email verification")
+	ErrInvalidVerificationCode     = fmt.Errorf("invalid verification code")
+	ErrTooManyVerificationAttempts = fmt.Errorf("too many verification attempts, set the email again")
+	ErrCannotVerifyEmail           = fmt.Errorf("cannot verify email")
+)
+
+// EmailVerificationConfig holds the parameters of the email verification codes.
+type EmailVerificationConfig struct {
+	CodeTTL     time.Duration
+	MaxAttempts int
+	// ResendInterval is how long a user waits before a new code is sent, so
+	// the attempts of a code cannot be renewed at will
+	ResendInterval time.Duration
+}
+
+// SetEmail sends a verification code to the email. The email is kept on the
+// verification and becomes the email of the user only once VerifyEmail
+// confirms it, so an unverified address never occupies users.email.
+func (s *UsersService) SetEmail(ctx context.Context, input UsersSetEmailInput) error {
+	owner, err := s.usersRepo.GetUserByEmail(ctx, input.Email)
+	if err == nil && owner.Id != input.UserId {
+		return ErrEmailAlreadyUsed
+	}
+	if err != nil && !errors.Is(err, repoerrs.ErrNotFound) {
+		logctx.FromContext(ctx).Error("UsersService.SetEmail - usersRepo.GetUserByEmail", "err", err)
+		return ErrCannotSetEmail
+	}
+
+	pending, err := s.emailVerificationsRepo.GetPendingEmailVerification(ctx, input.UserId)
+	if err == nil && time.Since(pending.CreatedAt) < s.emailVerification.ResendInterval {
+		return ErrVerificationSentRecently
+	}
+	if err != nil && !errors.Is(err, repoerrs.ErrNotFound) {
+		logctx.FromContext(ctx).Error("UsersService.SetEmail - emailVerificationsRepo.GetPendingEmailVerification", "err", err)
+		return ErrCannotSetEmail
+	}
+
+	code, err := verificationCode()
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.SetEmail - verificationCode", "err", err)
+		return ErrCannotSendVerification
+	}
+
+	err = s.emailVerificationsRepo.CreateEmailVerification(ctx, entity.EmailVerification{
+		UserId:    input.UserId,
+		Email:     input.Email,
+		CodeHash:  hashCode(code),
+		ExpiresAt: time.Now().Add(s.emailVerification.CodeTTL),
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.SetEmail - emailVerificationsRepo.CreateEmailVerification", "err", err)
+		return ErrCannotSetEmail
+	}
+
+	err = s.notifier.Notify(ctx, notifier.Message{
+		To:      input.Email,
+		Subject: "Confirm your email",
+		Body:    fmt.Sprintf("Your verification code: %s\nIt expires in %v.", code, s.emailVerification.CodeTTL),
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.SetEmail - notifier.Notify", "err", err)
+		return ErrCannotSendVerification
+	}
+
+	return nil
+}
+
+// VerifyEmail checks the code sent by SetEmail. On success the code is used up,
+// the email of the verification becomes the verified email of the user and
+// the points of the email tasks are granted in one transaction.
+func (s *UsersService) VerifyEmail(ctx context.Context, input UsersVerifyEmailInput) error {
+	verification, err := s.emailVerificationsRepo.GetPendingEmailVerification(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrVerificationNotFound
+		}
+		logctx.FromContext(ctx).Error("UsersService.VerifyEmail - emailVerificationsRepo.GetPendingEmailVerification", "err", err)
+		return ErrCannotVerifyEmail
+	}
+
+	if time.Now().After(verification.ExpiresAt) {
+		return ErrVerificationNotFound
+	}
+	if verification.Attempts >= s.emailVerification.MaxAttempts {
+		return ErrTooManyVerificationAttempts
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashCode(input.Code)), []byte(verification.CodeHash)) != 1 {
+		err = s.emailVerificationsRepo.IncrementEmailVerificationAttempts(ctx, verification.Id)
+		if err != nil {
+			logctx.FromContext(ctx).Error("UsersService.VerifyEmail - emailVerificationsRepo.IncrementEmailVerificationAttempts", "err", err)
+			return ErrCannotVerifyEmail
+		}
+		return ErrInvalidVerificationCode
+	}
+
+	// the email is verified even if no email task is active; only the
+	// points of the active ones are granted
+	tasksForEmail := activeTasks(s.tasksCatalog.GetTasksByKind(entity.TaskKindEmail), time.Now())
+
+	return s.withTx(ctx, "VerifyEmail", ErrCannotVerifyEmail, func(ctx context.Context) error {
+		// fails if the code has been used concurrently
+		err := s.emailVerificationsRepo.UseEmailVerification(ctx, verification.Id)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return ErrVerificationNotFound
+			}
+			logctx.FromContext(ctx).Error("UsersService.VerifyEmail - emailVerificationsRepo.UseEmailVerification", "err", err)
+			return ErrCannotVerifyEmail
+		}
+
+		// fails if another user has verified the email in the meantime
+		err = s.usersRepo.SetUserEmailVerified(ctx, verification.UserId, verification.Email)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrAlreadyExists) {
+				return ErrEmailAlreadyUsed
+			}
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return ErrVerificationNotFound
+			}
+			logctx.FromContext(ctx).Error("UsersService.VerifyEmail - usersRepo.SetUserEmailVerified", "err", err)
+			return ErrCannotVerifyEmail
+		}
+
+		// a user verifying a changed email is not rewarded again
+		for _, task := range tasksForEmail {
+			err = s.pointsRepo.AddPoints(ctx, taskEntry(verification.UserId, task.Id, task.Points, entity.PointsReasonEmailVerified))
+			if err != nil && !errors.Is(err, repoerrs.ErrAlreadyExists) {
+				logctx.FromContext(ctx).Error("UsersService.VerifyEmail - pointsRepo.AddPoints", "err", err)
+				return ErrCannotAddPoints
+			}
+		}
+		return nil
+	})
+}
+
+// verificationCode returns a random 6-digit code.
+func verificationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
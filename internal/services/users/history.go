@@ -0,0 +1,93 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/pkg/logctx"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxHistoryLimit is the largest page of the history.
+const MaxHistoryLimit = 100
+
+var (
+	ErrInvalidHistoryLimit  = fmt.Errorf("history limit must be between 1 and %d", MaxHistoryLimit)
+	ErrInvalidHistoryCursor = fmt.Errorf("invalid history cursor")
+	ErrInvalidPointsReason  = fmt.Errorf("invalid points entry type")
+	ErrInvalidHistoryPeriod = fmt.Errorf("history period start must be before its end")
+	ErrCannotGetHistory     = fmt.Errorf("cannot get history")
+)
+
+// GetHistory returns a page of the history of the user, newest first. The
+// pages are keyset-paginated on (created_at, id), so entries added while a
+// client walks the history do not shift the pages.
+func (s *UsersService) GetHistory(ctx context.Context, input UsersGetHistoryInput) (entity.PointsHistory, error) {
+	if input.Limit < 1 || input.Limit > MaxHistoryLimit {
+		return entity.PointsHistory{}, ErrInvalidHistoryLimit
+	}
+	if input.Reason != "" && !input.Reason.Valid() {
+		return entity.PointsHistory{}, ErrInvalidPointsReason
+	}
+	if !input.From.IsZero() && !input.To.IsZero() && !input.From.Before(input.To) {
+		return entity.PointsHistory{}, ErrInvalidHistoryPeriod
+	}
+
+	filter := entity.PointsHistoryFilter{
+		TaskId: input.TaskId,
+		Reason: input.Reason,
+		From:   input.From,
+		To:     input.To,
+	}
+	if input.Cursor != "" {
+		after, err := parseHistoryCursor(input.Cursor)
+		if err != nil {
+			return entity.PointsHistory{}, ErrInvalidHistoryCursor
+		}
+		filter.After = &after
+	}
+
+	// one entry past the page tells whether there is a next one
+	entries, err := s.pointsRepo.GetHistoryByUserId(ctx, input.UserId, filter, input.Limit+1)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.GetHistory - pointsRepo.GetHistoryByUserId", "err", err)
+		return entity.PointsHistory{}, ErrCannotGetHistory
+	}
+
+	history := entity.PointsHistory{Entries: entries}
+	if len(entries) > input.Limit {
+		history.Entries = entries[:input.Limit]
+		last := history.Entries[input.Limit-1]
+		history.NextCursor = formatHistoryCursor(entity.PointsCursor{CreatedAt: last.CreatedAt, Id: last.Id})
+	}
+	return history, nil
+}
+
+// formatHistoryCursor encodes the cursor as an opaque URL-safe string.
+func formatHistoryCursor(c entity.PointsCursor) string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "," + strconv.FormatInt(c.Id, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func parseHistoryCursor(s string) (entity.PointsCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return entity.PointsCursor{}, err
+	}
+	createdAt, id, ok := strings.Cut(string(raw), ",")
+	if !ok {
+		return entity.PointsCursor{}, fmt.Errorf("malformed cursor")
+	}
+
+	var c entity.PointsCursor
+	if c.CreatedAt, err = time.Parse(time.RFC3339Nano, createdAt); err != nil {
+		return entity.PointsCursor{}, err
+	}
+	if c.Id, err = strconv.ParseInt(id, 10, 64); err != nil {
+		return entity.PointsCursor{}, err
+	}
+	return c, nil
+}
@@ -0,0 +1,92 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsersService_GetHistory_Pages(t *testing.T) {
+	now := time.Now().UTC()
+	// entries 3 and 2 share the timestamp and are told apart by id
+	points := &mockPointsRepo{historyResp: []entity.PointsEntry{
+		{Id: 4, Delta: 4, CreatedAt: now},
+		{Id: 3, Delta: 3, CreatedAt: now.Add(-time.Hour)},
+		{Id: 2, Delta: 2, CreatedAt: now.Add(-time.Hour)},
+		{Id: 1, Delta: 1, CreatedAt: now.Add(-2 * time.Hour)},
+	}}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	var ids []int64
+	input := UsersGetHistoryInput{UserId: 10, Limit: 2}
+	for page := 0; ; page++ {
+		history, err := svc.GetHistory(context.Background(), input)
+		assert.NoError(t, err)
+		for _, e := range history.Entries {
+			ids = append(ids, e.Id)
+		}
+		if history.NextCursor == "" || page > 2 {
+			break
+		}
+		input.Cursor = history.NextCursor
+	}
+	assert.Equal(t, []int64{4, 3, 2, 1}, ids)
+	// the last page is full but has no next one; it starts past entry 3,
+	// which shares the timestamp of entry 2
+	assert.Equal(t, &entity.PointsCursor{CreatedAt: now.Add(-time.Hour), Id: 3}, points.historyFilter.After)
+}
+
+func TestUsersService_GetHistory_InvalidLimit(t *testing.T) {
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	for _, limit := range []int{-1, 0, MaxHistoryLimit + 1} {
+		_, err := svc.GetHistory(context.Background(), UsersGetHistoryInput{UserId: 10, Limit: limit})
+		assert.ErrorIs(t, err, ErrInvalidHistoryLimit, limit)
+	}
+	// the repo is not queried
+	assert.Zero(t, points.historyLimit)
+}
+
+func TestUsersService_GetHistory_Filters(t *testing.T) {
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	taskId := 5
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+	history, err := svc.GetHistory(context.Background(), UsersGetHistoryInput{
+		UserId: 10, Limit: 10, TaskId: &taskId, Reason: entity.PointsReasonTaskCompleted, From: from, To: to,
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, history.Entries)
+	assert.Empty(t, history.NextCursor)
+	assert.Equal(t, entity.PointsHistoryFilter{TaskId: &taskId, Reason: entity.PointsReasonTaskCompleted, From: from, To: to}, points.historyFilter)
+
+	_, err = svc.GetHistory(context.Background(), UsersGetHistoryInput{UserId: 10, Limit: 10, Reason: "unknown"})
+	assert.ErrorIs(t, err, ErrInvalidPointsReason)
+
+	_, err = svc.GetHistory(context.Background(), UsersGetHistoryInput{UserId: 10, Limit: 10, From: to, To: from})
+	assert.ErrorIs(t, err, ErrInvalidHistoryPeriod)
+
+	for _, cursor := range []string{"!", "bm90LWEtY3Vyc29y", formatHistoryCursor(entity.PointsCursor{})[:4]} {
+		_, err = svc.GetHistory(context.Background(), UsersGetHistoryInput{UserId: 10, Limit: 10, Cursor: cursor})
+		assert.ErrorIs(t, err, ErrInvalidHistoryCursor, cursor)
+	}
+
+	points.historyErr = errors.New("db")
+	_, err = svc.GetHistory(context.Background(), UsersGetHistoryInput{UserId: 10, Limit: 10})
+	assert.ErrorIs(t, err, ErrCannotGetHistory)
+}
+
+func TestHistoryCursor(t *testing.T) {
+	c := entity.PointsCursor{CreatedAt: time.Date(2026, 3, 4, 5, 6, 7, 123456000, time.UTC), Id: 42}
+	parsed, err := parseHistoryCursor(formatHistoryCursor(c))
+	assert.NoError(t, err)
+	assert.True(t, c.CreatedAt.Equal(parsed.CreatedAt))
+	assert.Equal(t, c.Id, parsed.Id)
+}
@@ -0,0 +1,198 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/internal/services/antifraud"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrInvalidReferralRewardStatus = fmt.Errorf("invalid referral reward status")
+	ErrReferralRewardNotFound      = fmt.Errorf("referral reward not found")
+	ErrReferralRewardDecided       = fmt.Errorf("referral reward is already released or rejected")
+	ErrCannotGetReferralRewards    = fmt.Errorf("cannot get referral rewards")
+	ErrCannotReviewReferralReward  = fmt.Errorf("cannot review referral reward")
+)
+
+// reviewReason is the reason of the rewards decided by an admin.
+const reviewReason = "admin"
+
+// recheckBatchSize is how many referrals RecheckReferralRewards loads at once.
+const recheckBatchSize = 100
+
+// checkReferralRewards runs the pending rewards paid for the referral
+// through the anti-fraud rules and releases, rejects or flags them for
+// review. Rewards the rules hold back stay pending until the next check; so
+// do the rewards a failed check could not decide.
+func (s *UsersService) checkReferralRewards(ctx context.Context, referralId int) {
+	rewards, err := s.referralsRepo.GetReferralRewardsByReferral(ctx, referralId, entity.ReferralRewardPending)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.checkReferralRewards - referralsRepo.GetReferralRewardsByReferral", "err", err)
+		return
+	}
+	if len(rewards) == 0 {
+		return
+	}
+
+	referral, err := s.usersRepo.GetUserById(ctx, referralId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.checkReferralRewards - usersRepo.GetUserById", "err", err)
+		return
+	}
+	completed, err := s.pointsRepo.CountCompletedTasks(ctx, referralId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.checkReferralRewards - pointsRepo.CountCompletedTasks", "err", err)
+		return
+	}
+
+	now := time.Now()
+	for _, reward := range rewards {
+		referrer, err := s.usersRepo.GetUserById(ctx, reward.ReferrerId)
+		if err != nil {
+			logctx.FromContext(ctx).Error("UsersService.checkReferralRewards - usersRepo.GetUserById", "err", err)
+			return
+		}
+		released, err := s.referralsRepo.CountReleasedReferralRewards(ctx, reward.ReferrerId, now.Add(-24*time.Hour))
+		if err != nil {
+			logctx.FromContext(ctx).Error("UsersService.checkReferralRewards - referralsRepo.CountReleasedReferralRewards", "err", err)
+			return
+		}
+
+		decision := s.antifraud.Check(ctx, antifraud.AntifraudCheckInput{
+			Reward:         reward,
+			Referral:       referral,
+			Referrer:       referrer,
+			ReleasedToday:  released,
+			CompletedTasks: completed,
+			Now:            now,
+		})
+		if decision.Verdict == antifraud.VerdictHold && decision.Rule == reward.Reason {
+			continue
+		}
+
+		err = s.decideReferralReward(ctx, reward, decision.Verdict.Status(), decision.Rule)
+		if err != nil && !errors.Is(err, ErrReferralRewardDecided) {
+			return
+		}
+	}
+}
+
+// RecheckReferralRewards runs the pending rewards of all referrals through
+// the rules again. The rules holding a reward back, like the age of the
+// account, may pass without the referral doing anything that checks them.
+func (s *UsersService) RecheckReferralRewards(ctx context.Context) error {
+	afterId := 0
+	for {
+		ids, err := s.referralsRepo.GetPendingReferralIds(ctx, afterId, recheckBatchSize)
+		if err != nil {
+			logctx.FromContext(ctx).Error("UsersService.RecheckReferralRewards - referralsRepo.GetPendingReferralIds", "err", err)
+			return ErrCannotReviewReferralReward
+		}
+		for _, id := range ids {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			s.checkReferralRewards(ctx, id)
+		}
+		if len(ids) < recheckBatchSize {
+			return nil
+		}
+		afterId = ids[len(ids)-1]
+	}
+}
+
+// WatchReferralRewards rechecks the pending rewards every RecheckInterval of
+// the referral config until ctx is done.
+func (s *UsersService) WatchReferralRewards(ctx context.Context) {
+	ticker := time.NewTicker(s.referral.RecheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		_ = s.RecheckReferralRewards(ctx)
+	}
+}
+
+// decideReferralReward moves the reward to the status with the reason and
+// pays the referrer if it is released. The referrer is paid for every
+// referral; the entry already being in the ledger means the reward was paid.
+func (s *UsersService) decideReferralReward(ctx context.Context, reward entity.ReferralReward, status entity.ReferralRewardStatus, reason string) error {
+	return s.withTx(ctx, "decideReferralReward", ErrCannotReviewReferralReward, func(ctx context.Context) error {
+		err := s.referralsRepo.UpdateReferralRewardStatus(ctx, reward.Id, status, reason)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return ErrReferralRewardDecided
+			}
+			logctx.FromContext(ctx).Error("UsersService.decideReferralReward - referralsRepo.UpdateReferralRewardStatus", "err", err)
+			return ErrCannotReviewReferralReward
+		}
+		if status != entity.ReferralRewardReleased {
+			return nil
+		}
+
+		err = s.pointsRepo.AddPoints(ctx, referralRewardEntry(reward))
+		if err != nil && !errors.Is(err, repoerrs.ErrAlreadyExists) {
+			logctx.FromContext(ctx).Error("UsersService.decideReferralReward - pointsRepo.AddPoints", "err", err)
+			return ErrCannotAddPoints
+		}
+		return nil
+	})
+}
+
+// referralRewardEntry is the ledger entry paying the reward to the referrer.
+// Unlike taskEntry, its idempotency key is per referral, so the referrer is
+// paid the task once for each of their referrals.
+func referralRewardEntry(reward entity.ReferralReward) entity.PointsEntry {
+	key := fmt.Sprintf("referral:%d:task:%d", reward.ReferralId, reward.TaskId)
+	return entity.PointsEntry{
+		UserId:         reward.ReferrerId,
+		TaskId:         &reward.TaskId,
+		ReferralId:     &reward.ReferralId,
+		Delta:          reward.Points,
+		Reason:         entity.PointsReasonReferralGiven,
+		IdempotencyKey: &key,
+	}
+}
+
+// GetReferralRewards returns the review queue: the oldest rewards with the
+// status.
+func (s *UsersService) GetReferralRewards(ctx context.Context, input UsersGetReferralRewardsInput) ([]entity.ReferralReward, error) {
+	if !input.Status.Valid() {
+		return nil, ErrInvalidReferralRewardStatus
+	}
+
+	rewards, err := s.referralsRepo.GetReferralRewardsByStatus(ctx, input.Status, input.Limit)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.GetReferralRewards - referralsRepo.GetReferralRewardsByStatus", "err", err)
+		return nil, ErrCannotGetReferralRewards
+	}
+	return rewards, nil
+}
+
+// ReviewReferralReward releases or rejects a pending or flagged reward,
+// whatever the rules make of it.
+func (s *UsersService) ReviewReferralReward(ctx context.Context, input UsersReviewReferralRewardInput) error {
+	reward, err := s.referralsRepo.GetReferralRewardById(ctx, input.RewardId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return ErrReferralRewardNotFound
+		}
+		logctx.FromContext(ctx).Error("UsersService.ReviewReferralReward - referralsRepo.GetReferralRewardById", "err", err)
+		return ErrCannotReviewReferralReward
+	}
+
+	status := entity.ReferralRewardRejected
+	if input.Release {
+		status = entity.ReferralRewardReleased
+	}
+	return s.decideReferralReward(ctx, reward, status, reviewReason)
+}
@@ -0,0 +1,177 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/services/antifraud"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsersService_SetReferrer_HoldsReward(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2},
+		},
+	}
+	points := &mockPointsRepo{}
+	referrals := &mockReferralsRepo{released: 4}
+	rules := &mockAntifraud{decision: antifraud.Decision{Verdict: antifraud.VerdictHold, Rule: "completed_tasks"}}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, referrals, &mockTaskCatalog{allTasks: referralTasks}, &mockVerification{}, rules, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.NoError(t, err)
+	// the referral is paid right away, the referrer is not
+	if assert.Len(t, points.addEntries, 1) {
+		assert.Equal(t, entity.PointsReasonReferralReceived, points.addEntries[0].Reason)
+	}
+	if assert.Len(t, referrals.rewards, 1) {
+		reward := referrals.rewards[0]
+		assert.Equal(t, entity.ReferralReward{Id: 1, ReferrerId: 2, ReferralId: 1, TaskId: 1, Points: 5, Status: entity.ReferralRewardPending, Reason: "completed_tasks"}, reward)
+	}
+	if assert.Len(t, rules.inputs, 1) {
+		assert.Equal(t, 1, rules.inputs[0].Referral.Id)
+		assert.Equal(t, 2, rules.inputs[0].Referrer.Id)
+		assert.Equal(t, 4, rules.inputs[0].ReleasedToday)
+	}
+
+	// the referral completes a task and meets the rules
+	task := entity.Task{Id: 300, Kind: entity.TaskKindManual, Points: 10}
+	svc.tasksCatalog = &mockTaskCatalog{allTasks: []entity.Task{referralTasks[0], referralTasks[1], task}}
+	points.completedTasks = 1
+	rules.decision = antifraud.Decision{}
+	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 300})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rules.inputs[1].CompletedTasks)
+	assert.Equal(t, entity.ReferralRewardReleased, referrals.rewards[0].Status)
+	if assert.Len(t, points.addEntries, 3) {
+		entry := points.addEntries[2]
+		assert.Equal(t, 2, entry.UserId)
+		assert.Equal(t, 5, entry.Delta)
+		assert.Equal(t, entity.PointsReasonReferralGiven, entry.Reason)
+		assert.Equal(t, 1, *entry.ReferralId)
+	}
+
+	// released rewards are not checked again
+	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 300})
+	assert.NoError(t, err)
+	assert.Len(t, rules.inputs, 2)
+}
+
+func TestUsersService_SetReferrer_RejectsReward(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2},
+		},
+	}
+	points := &mockPointsRepo{}
+	referrals := &mockReferralsRepo{}
+	rules := &mockAntifraud{decision: antifraud.Decision{Verdict: antifraud.VerdictReject, Rule: "same_network"}}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, referrals, &mockTaskCatalog{allTasks: referralTasks}, &mockVerification{}, rules, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.NoError(t, err)
+	assert.Len(t, points.addEntries, 1)
+	assert.Equal(t, entity.ReferralRewardRejected, referrals.rewards[0].Status)
+	assert.Equal(t, "same_network", referrals.rewards[0].Reason)
+
+	// the referral is saved even if the rewards cannot be checked
+	uRepo = &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			3: {Id: 3},
+			2: {Id: 2},
+		},
+	}
+	points = &mockPointsRepo{countErr: errors.New("db")}
+	referrals = &mockReferralsRepo{}
+	svc = NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, referrals, &mockTaskCatalog{allTasks: referralTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 3, Referrer: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ReferralRewardPending, referrals.rewards[0].Status)
+}
+
+func TestUsersService_ReviewReferralReward(t *testing.T) {
+	points := &mockPointsRepo{}
+	referrals := &mockReferralsRepo{rewards: []entity.ReferralReward{
+		{Id: 1, ReferrerId: 2, ReferralId: 1, TaskId: 1, Points: 5, Status: entity.ReferralRewardReview, Reason: "daily_cap"},
+		{Id: 2, ReferrerId: 2, ReferralId: 3, TaskId: 1, Points: 5, Status: entity.ReferralRewardPending, Reason: "account_age"},
+		{Id: 3, ReferrerId: 2, ReferralId: 4, TaskId: 1, Points: 5, Status: entity.ReferralRewardReview, Reason: "daily_cap"},
+	}}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, referrals, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	queue, err := svc.GetReferralRewards(context.Background(), UsersGetReferralRewardsInput{Status: entity.ReferralRewardReview, Limit: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, []entity.ReferralReward{referrals.rewards[0], referrals.rewards[2]}, queue)
+
+	err = svc.ReviewReferralReward(context.Background(), UsersReviewReferralRewardInput{RewardId: 1, Release: true})
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ReferralRewardReleased, referrals.rewards[0].Status)
+	if assert.Len(t, points.addEntries, 1) {
+		assert.Equal(t, 2, points.addEntries[0].UserId)
+		assert.Equal(t, 5, points.addEntries[0].Delta)
+		assert.Equal(t, "referral:1:task:1", *points.addEntries[0].IdempotencyKey)
+	}
+
+	err = svc.ReviewReferralReward(context.Background(), UsersReviewReferralRewardInput{RewardId: 1})
+	assert.ErrorIs(t, err, ErrReferralRewardDecided)
+
+	// pending rewards may be decided before the rules are met
+	err = svc.ReviewReferralReward(context.Background(), UsersReviewReferralRewardInput{RewardId: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, entity.ReferralRewardRejected, referrals.rewards[1].Status)
+	assert.Equal(t, "admin", referrals.rewards[1].Reason)
+	assert.Len(t, points.addEntries, 1)
+
+	// the same task is paid again for another referral
+	err = svc.ReviewReferralReward(context.Background(), UsersReviewReferralRewardInput{RewardId: 3, Release: true})
+	assert.NoError(t, err)
+	if assert.Len(t, points.addEntries, 2) {
+		assert.Equal(t, 2, points.addEntries[1].UserId)
+		assert.Equal(t, 4, *points.addEntries[1].ReferralId)
+		assert.Equal(t, "referral:4:task:1", *points.addEntries[1].IdempotencyKey)
+	}
+
+	err = svc.ReviewReferralReward(context.Background(), UsersReviewReferralRewardInput{RewardId: 4})
+	assert.ErrorIs(t, err, ErrReferralRewardNotFound)
+
+	_, err = svc.GetReferralRewards(context.Background(), UsersGetReferralRewardsInput{Status: "unknown", Limit: 10})
+	assert.ErrorIs(t, err, ErrInvalidReferralRewardStatus)
+
+	referrals.rewardsErr = errors.New("db")
+	_, err = svc.GetReferralRewards(context.Background(), UsersGetReferralRewardsInput{Status: entity.ReferralRewardReview, Limit: 10})
+	assert.ErrorIs(t, err, ErrCannotGetReferralRewards)
+	err = svc.ReviewReferralReward(context.Background(), UsersReviewReferralRewardInput{RewardId: 2})
+	assert.ErrorIs(t, err, ErrCannotReviewReferralReward)
+}
+
+func TestUsersService_RecheckReferralRewards(t *testing.T) {
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{}}
+	var rewards []entity.ReferralReward
+	for i := 1; i <= recheckBatchSize+1; i++ {
+		uRepo.usersByID[i] = entity.User{Id: i}
+		rewards = append(rewards, entity.ReferralReward{Id: i, ReferrerId: 1, ReferralId: i, TaskId: 1, Points: 5, Status: entity.ReferralRewardPending, Reason: "account_age"})
+	}
+	// decided rewards are not checked again
+	rewards[0].Status = entity.ReferralRewardRejected
+	points := &mockPointsRepo{}
+	referrals := &mockReferralsRepo{rewards: rewards}
+	rules := &mockAntifraud{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, referrals, &mockTaskCatalog{}, &mockVerification{}, rules, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	// the account age hold is over for all of them, across the batches
+	err := svc.RecheckReferralRewards(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, rules.inputs, recheckBatchSize)
+	for _, reward := range referrals.rewards[1:] {
+		assert.Equal(t, entity.ReferralRewardReleased, reward.Status)
+	}
+	assert.Len(t, points.addEntries, recheckBatchSize)
+
+	referrals.rewardsErr = errors.New("db")
+	err = svc.RecheckReferralRewards(context.Background())
+	assert.ErrorIs(t, err, ErrCannotReviewReferralReward)
+}
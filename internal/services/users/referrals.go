@@ -0,0 +1,249 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidReferralCode  = fmt.Errorf("invalid referral code")
+	ErrReferralCycle        = fmt.Errorf("referrer is already referred by the user")
+	ErrReferrerChainTooLong = fmt.Errorf("referrer chain is too long")
+	ErrCannotGetReferrals   = fmt.Errorf("cannot get referrals")
+)
+
+// ReferralConfig holds the parameters of the referral program.
+type ReferralConfig struct {
+	// MaxChainDepth is the most referrers a user may have above them
+	MaxChainDepth int
+	// RecheckInterval is how often the pending rewards of referrers are run
+	// through the anti-fraud rules again
+	RecheckInterval time.Duration
+}
+
+// SetReferrer makes the referrer given by id or by referral code the referrer
+// of the user.
+func (s *UsersService) SetReferrer(ctx context.Context, input UsersSetReferrerInput) error {
+
+	referrer, err := s.getReferrer(ctx, input)
+	if err != nil {
+		return err
+	}
+	input.Referrer = referrer.Id
+
+	if referrer.Id == input.UserId {
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - referrer cannot be the same as user")
+		return ErrReferrerCannotBeTheSameAsUser
+	}
+
+	user, err := s.usersRepo.GetUserById(ctx, input.UserId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.GetUserById", "err", err)
+		return err
+	}
+	if user.Referrer != nil {
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - user already has a referrer")
+		return ErrUserAlreadySetReferrer
+	}
+
+	// the referrer is linked even if no referral task is active; only the
+	// rewards of the active ones are paid
+	now := time.Now()
+	tasksForReferrer := activeTasks(s.tasksCatalog.GetTasksByKind(entity.TaskKindReferralGiver), now)
+	tasksForUser := activeTasks(s.tasksCatalog.GetTasksByKind(entity.TaskKindReferralReceiver), now)
+
+	err = s.withTx(ctx, "SetReferrer", ErrCannotSetReferrer, func(ctx context.Context) error {
+		// the chain is checked under the lock so that a concurrent link
+		// cannot change it before this one is committed
+		if err := s.usersRepo.LockReferrerChains(ctx); err != nil {
+			logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.LockReferrerChains", "err", err)
+			return ErrCannotSetReferrer
+		}
+		if err := s.checkReferrerChain(ctx, input.UserId, input.Referrer); err != nil {
+			return err
+		}
+
+		err := s.usersRepo.SetUserReferrer(ctx, input.UserId, input.Referrer)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return ErrUserAlreadySetReferrer
+			}
+			logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.SetUserReferrer", "err", err)
+			return ErrCannotSetReferrer
+		}
+
+		// the reward of the referrer waits for the anti-fraud rules
+		for _, task := range tasksForReferrer {
+			err = s.referralsRepo.CreateReferralReward(ctx, entity.ReferralReward{
+				ReferrerId: input.Referrer,
+				ReferralId: input.UserId,
+				TaskId:     task.Id,
+				Points:     task.Points,
+				Status:     entity.ReferralRewardPending,
+			})
+			if err != nil {
+				logctx.FromContext(ctx).Error("UsersService.SetReferrer - referralsRepo.CreateReferralReward", "err", err)
+				return ErrCannotSetReferrer
+			}
+		}
+
+		for _, task := range tasksForUser {
+			err = s.pointsRepo.AddPoints(ctx, taskEntry(input.UserId, task.Id, task.Points, entity.PointsReasonReferralReceived))
+			if err != nil {
+				logctx.FromContext(ctx).Error("UsersService.SetReferrer - pointsRepo.AddPoints", "err", err)
+				return ErrCannotAddPoints
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.checkReferralRewards(ctx, input.UserId)
+	return nil
+}
+
+// checkReferrerChain makes sure the referrer is not referred by the user,
+// directly or through other referrers, and that the chain of referrers the
+// user would get is not longer than allowed.
+func (s *UsersService) checkReferrerChain(ctx context.Context, userId int, referrerId int) error {
+	chain, err := s.usersRepo.GetReferrerChain(ctx, referrerId, s.referral.MaxChainDepth)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.GetReferrerChain", "err", err)
+		return ErrCannotSetReferrer
+	}
+	if slices.Contains(chain, userId) {
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - referral cycle", "chain", chain)
+		return ErrReferralCycle
+	}
+	if len(chain) > s.referral.MaxChainDepth {
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - referrer chain too long")
+		return ErrReferrerChainTooLong
+	}
+	return nil
+}
+
+// getReferrer returns the user owning the referral code of the input, or the
+// user with the referrer id if there is no code.
+func (s *UsersService) getReferrer(ctx context.Context, input UsersSetReferrerInput) (entity.User, error) {
+	if input.ReferralCode == "" {
+		referrer, err := s.usersRepo.GetUserById(ctx, input.Referrer)
+		if err != nil {
+			logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.GetUserById", "err", err)
+			return entity.User{}, err
+		}
+		return referrer, nil
+	}
+
+	referrer, err := s.usersRepo.GetUserByReferralCode(ctx, normalizeReferralCode(input.ReferralCode))
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.User{}, ErrInvalidReferralCode
+		}
+		logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.GetUserByReferralCode", "err", err)
+		return entity.User{}, ErrCannotSetReferrer
+	}
+	return referrer, nil
+}
+
+// normalizeReferralCode returns the code the way it is stored: codes are
+// case-insensitive.
+func normalizeReferralCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// payReferralEarnings pays the referrers of the user their tier of the points
+// of the entry. The entry of a referrer has the key of the entry it is paid
+// for, so it is paid once.
+func (s *UsersService) payReferralEarnings(ctx context.Context, entry entity.PointsEntry) error {
+	upline, err := s.referralsRepo.GetUplineTiers(ctx, entry.UserId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.payReferralEarnings - referralsRepo.GetUplineTiers", "err", err)
+		return ErrCannotAddPoints
+	}
+
+	for _, tier := range upline {
+		points := int(float64(entry.Delta) * tier.Percent / 100)
+		if points <= 0 {
+			continue
+		}
+
+		key := fmt.Sprintf("referral:%d:%s", tier.ReferrerId, *entry.IdempotencyKey)
+		err = s.pointsRepo.AddPoints(ctx, entity.PointsEntry{
+			UserId:         tier.ReferrerId,
+			ReferralId:     &entry.UserId,
+			Delta:          points,
+			Reason:         entity.PointsReasonReferralEarnings,
+			IdempotencyKey: &key,
+		})
+		if err != nil && !errors.Is(err, repoerrs.ErrAlreadyExists) {
+			logctx.FromContext(ctx).Error("UsersService.payReferralEarnings - pointsRepo.AddPoints", "err", err)
+			return ErrCannotAddPoints
+		}
+	}
+	return nil
+}
+
+// GetReferrals returns the referral tree of the user down to the deepest
+// paid level, at least the direct referrals.
+func (s *UsersService) GetReferrals(ctx context.Context, input UsersGetReferralsInput) (entity.ReferralTree, error) {
+	tiers, err := s.referralsRepo.GetReferralTiers(ctx)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.GetReferrals - referralsRepo.GetReferralTiers", "err", err)
+		return entity.ReferralTree{}, ErrCannotGetReferrals
+	}
+
+	depth := 1
+	percents := make(map[int]float64, len(tiers))
+	for _, tier := range tiers {
+		depth = max(depth, tier.Level)
+		percents[tier.Level] = tier.Percent
+	}
+
+	referrals, err := s.referralsRepo.GetReferralTree(ctx, input.UserId, depth)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.GetReferrals - referralsRepo.GetReferralTree", "err", err)
+		return entity.ReferralTree{}, ErrCannotGetReferrals
+	}
+
+	levels := make([]entity.ReferralLevel, depth)
+	for i := range levels {
+		levels[i] = entity.ReferralLevel{Level: i + 1, Percent: percents[i+1]}
+	}
+	byReferrer := make(map[int][]entity.Referral)
+	for _, referral := range referrals {
+		levels[referral.Level-1].Referrals++
+		levels[referral.Level-1].Earned += referral.Earned
+		byReferrer[referral.ReferrerId] = append(byReferrer[referral.ReferrerId], referral)
+	}
+
+	return entity.ReferralTree{
+		Levels:    levels,
+		Referrals: referralNodes(byReferrer, input.UserId, 1),
+	}, nil
+}
+
+// referralNodes returns the referrals of the referrer at the level with their
+// subtrees. Matching the level keeps the recursion bounded by the depth of
+// the tree.
+func referralNodes(byReferrer map[int][]entity.Referral, referrerId int, level int) []entity.ReferralNode {
+	var nodes []entity.ReferralNode
+	for _, referral := range byReferrer[referrerId] {
+		if referral.Level != level {
+			continue
+		}
+		nodes = append(nodes, entity.ReferralNode{
+			Referral:  referral,
+			Referrals: referralNodes(byReferrer, referral.Id, level+1),
+		})
+	}
+	return nodes
+}
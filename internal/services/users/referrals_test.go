@@ -0,0 +1,180 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var referralTasks = []entity.Task{
+	{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+	{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
+}
+
+func TestUsersService_SetReferrer_ByCode(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2, ReferralCode: "ABCDEFGHJK"},
+		},
+	}
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: referralTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	// codes are case-insensitive
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, ReferralCode: " abcdefghjk "})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, uRepo.setRefUserID)
+	assert.Equal(t, 2, uRepo.setRefReferrer)
+	if assert.Len(t, points.addEntries, 2) {
+		// the referrer's bonus, released after the referral is saved, counts
+		// as earned for the referral
+		assert.Nil(t, points.addEntries[0].ReferralId)
+		assert.Equal(t, entity.PointsReasonReferralGiven, points.addEntries[1].Reason)
+		assert.Equal(t, 1, *points.addEntries[1].ReferralId)
+	}
+
+	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, ReferralCode: "UNKNOWN"})
+	assert.ErrorIs(t, err, ErrInvalidReferralCode)
+}
+
+// referrerChain returns users 1..n, each referred by the next one; user n
+// has no referrer.
+func referrerChain(n int) map[int]entity.User {
+	users := make(map[int]entity.User, n)
+	for i := 1; i <= n; i++ {
+		u := entity.User{Id: i}
+		if i < n {
+			u.Referrer = intPtr(i + 1)
+		}
+		users[i] = u
+	}
+	return users
+}
+
+func TestUsersService_SetReferrer_Chain(t *testing.T) {
+	cases := []struct {
+		name  string
+		chain int
+		user  int
+		want  error
+	}{
+		{"A-B-C-A", 3, 3, ErrReferralCycle},
+		{"cycle at the max depth", 5, 5, ErrReferralCycle},
+		{"cycle past the max depth", 7, 7, ErrReferrerChainTooLong},
+		{"chain at the max depth", 5, 6, nil},
+		{"chain past the max depth", 6, 7, ErrReferrerChainTooLong},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// the user takes user 1, the bottom of the chain, as referrer
+			users := referrerChain(tc.chain)
+			if _, ok := users[tc.user]; !ok {
+				users[tc.user] = entity.User{Id: tc.user}
+			}
+			uRepo := &mockUsersRepo{usersByID: users}
+			points := &mockPointsRepo{}
+			svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: referralTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+			err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: tc.user, Referrer: 1})
+			if tc.want == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, 1, uRepo.setRefReferrer)
+				assert.True(t, uRepo.chainCheckedLocked)
+				return
+			}
+			assert.ErrorIs(t, err, tc.want)
+			assert.Zero(t, uRepo.setRefUserID)
+			assert.Empty(t, points.addEntries)
+		})
+	}
+}
+
+func TestUsersService_SetReferrer_ChainError(t *testing.T) {
+	uRepo := &mockUsersRepo{usersByID: referrerChain(2), chainErr: errors.New("db")}
+	uRepo.usersByID[3] = entity.User{Id: 3}
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: referralTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 3, Referrer: 1})
+	assert.ErrorIs(t, err, ErrCannotSetReferrer)
+}
+
+func TestUsersService_CompleteTask_PaysReferralEarnings(t *testing.T) {
+	task := entity.Task{Id: 300, Kind: entity.TaskKindManual, Points: 25}
+	points := &mockPointsRepo{}
+	referrals := &mockReferralsRepo{upline: []entity.UplineTier{
+		{ReferrerId: 20, ReferralTier: entity.ReferralTier{Level: 1, Percent: 20}},
+		{ReferrerId: 30, ReferralTier: entity.ReferralTier{Level: 2, Percent: 10}},
+		{ReferrerId: 40, ReferralTier: entity.ReferralTier{Level: 3, Percent: 1}},
+	}}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, referrals, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 300})
+	assert.NoError(t, err)
+	// 1% of 25 points rounds down to nothing and is not paid
+	if assert.Len(t, points.addEntries, 3) {
+		for i, want := range []struct{ userId, delta int }{{10, 25}, {20, 5}, {30, 2}} {
+			assert.Equal(t, want.userId, points.addEntries[i].UserId)
+			assert.Equal(t, want.delta, points.addEntries[i].Delta)
+		}
+		earnings := points.addEntries[1]
+		assert.Equal(t, entity.PointsReasonReferralEarnings, earnings.Reason)
+		assert.Equal(t, 10, *earnings.ReferralId)
+		assert.Nil(t, earnings.TaskId)
+		assert.Equal(t, "referral:20:task:300:user:10", *earnings.IdempotencyKey)
+	}
+}
+
+func TestUsersService_CompleteTask_ReferralEarningsError(t *testing.T) {
+	task := entity.Task{Id: 300, Kind: entity.TaskKindManual, Points: 25}
+	transactor := &mockTransactor{}
+	svc := NewUsersService(transactor, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{err: errors.New("db")}, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	// the completion is rolled back with the earnings of the referrers
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 300})
+	assert.ErrorIs(t, err, ErrCannotAddPoints)
+	assert.Equal(t, 1, transactor.rolledBack)
+}
+
+func TestUsersService_GetReferrals(t *testing.T) {
+	referrals := &mockReferralsRepo{
+		tiers: []entity.ReferralTier{{Level: 1, Percent: 20}, {Level: 2, Percent: 10}},
+		referrals: []entity.Referral{
+			{Id: 2, ReferrerId: 1, Level: 1, Earned: 15},
+			{Id: 3, ReferrerId: 1, Level: 1, Earned: 5},
+			{Id: 4, ReferrerId: 2, Level: 2, Earned: 3},
+		},
+	}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, referrals, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	tree, err := svc.GetReferrals(context.Background(), UsersGetReferralsInput{UserId: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, referrals.depth)
+	assert.Equal(t, []entity.ReferralLevel{
+		{Level: 1, Percent: 20, Referrals: 2, Earned: 20},
+		{Level: 2, Percent: 10, Referrals: 1, Earned: 3},
+	}, tree.Levels)
+	if assert.Len(t, tree.Referrals, 2) {
+		assert.Equal(t, 2, tree.Referrals[0].Id)
+		if assert.Len(t, tree.Referrals[0].Referrals, 1) {
+			assert.Equal(t, 4, tree.Referrals[0].Referrals[0].Id)
+			assert.Empty(t, tree.Referrals[0].Referrals[0].Referrals)
+		}
+		assert.Empty(t, tree.Referrals[1].Referrals)
+	}
+
+	// the direct referrals are listed without tiers
+	referrals.tiers = nil
+	referrals.referrals = referrals.referrals[:2]
+	tree, err = svc.GetReferrals(context.Background(), UsersGetReferralsInput{UserId: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, referrals.depth)
+	assert.Equal(t, []entity.ReferralLevel{{Level: 1, Referrals: 2, Earned: 20}}, tree.Levels)
+
+	referrals.err = errors.New("db")
+	_, err = svc.GetReferrals(context.Background(), UsersGetReferralsInput{UserId: 1})
+	assert.ErrorIs(t, err, ErrCannotGetReferrals)
+}
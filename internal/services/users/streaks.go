@@ -0,0 +1,105 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+var (
+	ErrCannotGetStreaks   = fmt.Errorf("cannot get streaks")
+	ErrCannotUpdateStreak = fmt.Errorf("cannot update streak")
+)
+
+// StreakConfig holds the parameters of the streaks.
+type StreakConfig struct {
+	// GraceDays is how many days without completions keep a streak going
+	GraceDays   int
+	Multipliers []StreakMultiplier
+}
+
+// StreakMultiplier multiplies the points of a completion extending a streak
+// to at least Days days.
+type StreakMultiplier struct {
+	Days       int
+	Multiplier float64
+}
+
+// multiplier returns the multiplier of the longest tier reached by a streak
+// of the given length, 1 if none is.
+func (c StreakConfig) multiplier(days int) float64 {
+	m, tier := 1.0, 0
+	for _, sm := range c.Multipliers {
+		if sm.Days <= days && sm.Days > tier {
+			m, tier = sm.Multiplier, sm.Days
+		}
+	}
+	return m
+}
+
+// extendStreak records a completion of a task of the group on the given day
+// and returns the points of the completion multiplied for the streak.
+func (s *UsersService) extendStreak(ctx context.Context, userId int, group string, day time.Time, points int) (int, error) {
+	streak, err := s.streaksRepo.GetStreakForUpdate(ctx, userId, group)
+	if err != nil {
+		if !errors.Is(err, repoerrs.ErrNotFound) {
+			logctx.FromContext(ctx).Error("UsersService.extendStreak - streaksRepo.GetStreakForUpdate", "err", err)
+			return 0, ErrCannotUpdateStreak
+		}
+		streak = entity.Streak{UserId: userId, Group: group}
+	}
+
+	streak = nextStreak(streak, day, s.streak.GraceDays)
+	if err := s.streaksRepo.SaveStreak(ctx, streak); err != nil {
+		logctx.FromContext(ctx).Error("UsersService.extendStreak - streaksRepo.SaveStreak", "err", err)
+		return 0, ErrCannotUpdateStreak
+	}
+
+	return int(math.Round(float64(points) * s.streak.multiplier(streak.Current))), nil
+}
+
+// nextStreak returns the streak after a completion on day. Further
+// completions on the same day leave it as is; a completion after more than
+// graceDays missed days starts a new streak.
+func nextStreak(streak entity.Streak, day time.Time, graceDays int) entity.Streak {
+	gap := daysBetween(streak.LastDay, day)
+	switch {
+	case streak.Current == 0 || gap > graceDays+1:
+		streak.Current = 1
+	case gap <= 0:
+		return streak
+	default:
+		streak.Current++
+	}
+
+	streak.LastDay = day
+	streak.Longest = max(streak.Longest, streak.Current)
+	return streak
+}
+
+// streakAt returns the streak as seen on day: a streak that can no longer be
+// extended has no current length.
+func streakAt(streak entity.Streak, day time.Time, graceDays int) entity.Streak {
+	if daysBetween(streak.LastDay, day) > graceDays+1 {
+		streak.Current = 0
+	}
+	return streak
+}
+
+// streakDay returns the date of at in loc as midnight UTC, the way the days
+// of streaks are stored.
+func streakDay(at time.Time, loc *time.Location) time.Time {
+	y, m, d := at.In(loc).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// daysBetween returns the number of days from one date to another, both at
+// midnight UTC.
+func daysBetween(from time.Time, to time.Time) int {
+	return int(math.Round(to.Sub(from).Hours() / 24))
+}
@@ -0,0 +1,143 @@
+package users
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo/repoerrs"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func day(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestNextStreak(t *testing.T) {
+	last := day(2026, 3, 10)
+	streak := entity.Streak{Current: 4, Longest: 6, LastDay: last}
+
+	cases := []struct {
+		name    string
+		streak  entity.Streak
+		day     time.Time
+		current int
+		longest int
+	}{
+		{"first completion", entity.Streak{}, last, 1, 1},
+		{"same day", streak, last, 4, 6},
+		{"next day", streak, last.AddDate(0, 0, 1), 5, 6},
+		{"one missed day is a grace day", streak, last.AddDate(0, 0, 2), 5, 6},
+		{"two missed days break the streak", streak, last.AddDate(0, 0, 3), 1, 6},
+		{"new longest", entity.Streak{Current: 6, Longest: 6, LastDay: last}, last.AddDate(0, 0, 1), 7, 7},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := nextStreak(tc.streak, tc.day, 1)
+			assert.Equal(t, tc.current, got.Current)
+			assert.Equal(t, tc.longest, got.Longest)
+			if tc.current != tc.streak.Current {
+				assert.Equal(t, tc.day, got.LastDay)
+			}
+		})
+	}
+
+	// without grace days a missed day breaks the streak
+	assert.Equal(t, 1, nextStreak(streak, last.AddDate(0, 0, 2), 0).Current)
+}
+
+func TestStreakConfig_Multiplier(t *testing.T) {
+	assert.Equal(t, 1.0, testStreakConfig.multiplier(1))
+	assert.Equal(t, 1.0, testStreakConfig.multiplier(2))
+	assert.Equal(t, 1.5, testStreakConfig.multiplier(3))
+	assert.Equal(t, 1.5, testStreakConfig.multiplier(6))
+	assert.Equal(t, 2.0, testStreakConfig.multiplier(30))
+	assert.Equal(t, 1.0, StreakConfig{}.multiplier(30))
+}
+
+func TestUsersService_CompleteTask_ExtendsStreak(t *testing.T) {
+	task := entity.Task{Id: 200, Kind: entity.TaskKindManual, Points: 10, Recurrence: entity.TaskRecurrenceDaily, CompletionLimit: 1, StreakGroup: "daily"}
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{10: {Id: 10, Timezone: "UTC"}}}
+	today := streakDay(time.Now(), time.UTC)
+	streaks := &mockStreaksRepo{streaks: map[string]entity.Streak{
+		"daily": {UserId: 10, Group: "daily", Current: 2, Longest: 2, LastDay: today.AddDate(0, 0, -1)},
+	}}
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, streaks, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 200})
+	assert.NoError(t, err)
+	if assert.Len(t, streaks.saved, 1) {
+		assert.Equal(t, 3, streaks.saved[0].Current)
+		assert.Equal(t, 3, streaks.saved[0].Longest)
+		assert.Equal(t, today, streaks.saved[0].LastDay)
+	}
+	if assert.Len(t, points.addEntries, 1) {
+		assert.Equal(t, 15, points.addEntries[0].Delta)
+	}
+}
+
+func TestUsersService_CompleteTask_StreakErrors(t *testing.T) {
+	task := entity.Task{Id: 201, Kind: entity.TaskKindManual, Points: 10, StreakGroup: "daily"}
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{10: {Id: 10}}}
+
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{getErr: errors.New("db")}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 201})
+	assert.ErrorIs(t, err, ErrCannotUpdateStreak)
+	assert.Empty(t, points.addEntries)
+
+	// the streak is saved in the transaction the duplicate entry rolls back
+	transactor := &mockTransactor{}
+	points = &mockPointsRepo{addErr: errors.New("db")}
+	svc = NewUsersService(transactor, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 201})
+	assert.ErrorIs(t, err, ErrCannotAddPoints)
+	assert.Equal(t, 1, transactor.rolledBack)
+}
+
+func TestUsersService_GetInfo_Streaks(t *testing.T) {
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{10: {Id: 10, Timezone: "UTC"}}}
+	today := streakDay(time.Now(), time.UTC)
+	streaks := &mockStreaksRepo{streaks: map[string]entity.Streak{
+		"daily": {UserId: 10, Group: "daily", Current: 4, Longest: 9, LastDay: today.AddDate(0, 0, -3)},
+	}}
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, streaks, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	status, err := svc.GetInfo(context.Background(), UsersGetInfoInput{UserId: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 10, status.Id)
+	if assert.Len(t, status.Streaks, 1) {
+		// broken: two days were missed with a single grace day
+		assert.Equal(t, 0, status.Streaks[0].Current)
+		assert.Equal(t, 9, status.Streaks[0].Longest)
+	}
+
+	streaks.getErr = errors.New("db")
+	_, err = svc.GetInfo(context.Background(), UsersGetInfoInput{UserId: 10})
+	assert.ErrorIs(t, err, ErrCannotGetStreaks)
+}
+
+func TestUsersService_GetInfo(t *testing.T) {
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{10: {Id: 10, Referrer: intPtr(7)}}}
+	points := &mockPointsRepo{pointsByUserResp: 120, rankResp: 3, completedTasks: 4}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	status, err := svc.GetInfo(context.Background(), UsersGetInfoInput{UserId: 10})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, *status.Referrer)
+	assert.Equal(t, 120, status.Points)
+	assert.Equal(t, 3, status.Rank)
+	assert.Equal(t, 4, status.CompletedTasks)
+
+	uRepo.getByIDErr = repoerrs.ErrNotFound
+	_, err = svc.GetInfo(context.Background(), UsersGetInfoInput{UserId: 10})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+
+	uRepo.getByIDErr = nil
+	points.pointsByUserErr = errors.New("db")
+	_, err = svc.GetInfo(context.Background(), UsersGetInfoInput{UserId: 10})
+	assert.ErrorIs(t, err, ErrCannotGetInfo)
+}
@@ -4,9 +4,17 @@ import (
 	"context"
 	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/internal/services/antifraud"
+	"denet-test-task/internal/services/tasks"
+	"denet-test-task/internal/services/verification"
 	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/notifier"
+	"denet-test-task/pkg/verifier"
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
 )
 
 var _ Users = (*UsersService)(nil)
@@ -16,143 +24,295 @@ var (
 	ErrTaskAlreadyCompleted          = fmt.Errorf("task already completed")
 	ErrCannotCheckCompletedTask      = fmt.Errorf("cannot check if task is completed")
 	ErrCannotAddPoints               = fmt.Errorf("cannot add points")
-	ErrCannotGetTasks                = fmt.Errorf("cannot get tasks")
 	ErrUserAlreadySetReferrer        = fmt.Errorf("user already has a referrer")
+	ErrCannotSetReferrer             = fmt.Errorf("cannot set referrer")
 	ErrTaskNotAllowedToComplete      = fmt.Errorf("task not allowed to complete")
+	ErrTaskNotAvailable              = fmt.Errorf("task is not available now")
+	ErrAccountNotLinked              = fmt.Errorf("link the account on the external service first")
+	ErrAccountAlreadyUsed            = fmt.Errorf("account on the external service is used by another user")
+	ErrTaskNotVerified               = fmt.Errorf("task completion is not confirmed")
+	ErrVerificationPending           = fmt.Errorf("task completion is being checked, retry later")
+	ErrCannotVerifyTask              = fmt.Errorf("cannot verify task completion")
+	ErrInvalidTimezone               = fmt.Errorf("unknown timezone")
+	ErrCannotSetTimezone             = fmt.Errorf("cannot set timezone")
+	ErrTimezoneChangedRecently       = fmt.Errorf("timezone can be changed once a day")
 	ErrReferrerCannotBeTheSameAsUser = fmt.Errorf("referrer cannot be the same as user")
-)
-
-const (
-	TaskGiveReferral = iota + 1
-	TaskGetReferral
-	TaskSubscribeTelegram
-	TaskSubscribeTwitter
-	TaskCompleteEmail
+	ErrUserNotFound                  = fmt.Errorf("user not found")
+	ErrCannotGetInfo                 = fmt.Errorf("cannot get user info")
 )
 
 type UsersService struct {
-	usersRepo  repo.Users
-	pointsRepo repo.Points
-	tasksRepo  repo.Tasks
+	transactor    repo.Transactor
+	usersRepo     repo.Users
+	pointsRepo    repo.Points
+	streaksRepo   repo.Streaks
+	referralsRepo repo.Referrals
+	tasksCatalog  tasks.Catalog
+	verification  verification.Verification
+	antifraud     antifraud.Antifraud
+	streak        StreakConfig
+	referral      ReferralConfig
 
-	tasksList map[int]int // map[task_id]points
+	emailVerificationsRepo repo.EmailVerifications
+	notifier               notifier.Notifier
+	emailVerification      EmailVerificationConfig
 }
 
-func NewUsersService(ctx context.Context, userRepo repo.Users, pointRepo repo.Points, tasksRepo repo.Tasks) (*UsersService, error) {
-
-	service := &UsersService{usersRepo: userRepo, pointsRepo: pointRepo, tasksRepo: tasksRepo}
+func NewUsersService(transactor repo.Transactor, userRepo repo.Users, pointRepo repo.Points, streaksRepo repo.Streaks, referralsRepo repo.Referrals, tasksCatalog tasks.Catalog, verification verification.Verification, antifraud antifraud.Antifraud, emailVerificationsRepo repo.EmailVerifications, notifier notifier.Notifier, emailVerificationCfg EmailVerificationConfig, streakCfg StreakConfig, referralCfg ReferralConfig) *UsersService {
+	return &UsersService{
+		transactor:    transactor,
+		usersRepo:     userRepo,
+		pointsRepo:    pointRepo,
+		streaksRepo:   streaksRepo,
+		referralsRepo: referralsRepo,
+		tasksCatalog:  tasksCatalog,
+		verification:  verification,
+		antifraud:     antifraud,
+		streak:        streakCfg,
+		referral:      referralCfg,
 
-	tasks, err := tasksRepo.GetAllTasks(ctx)
-	if err != nil {
-		logctx.FromContext(ctx).Error("UsersService.NewUsersService - tasksRepo.GetAllTasks", "err", err)
-		return nil, ErrCannotGetTasks
+		emailVerificationsRepo: emailVerificationsRepo,
+		notifier:               notifier,
+		emailVerification:      emailVerificationCfg,
 	}
-
-	service.tasksList = make(map[int]int, len(tasks))
-	for _, task := range tasks {
-		service.tasksList[task.Id] = task.Points
-	}
-	return service, nil
 }
 
 func (s *UsersService) GetLeaderboard(ctx context.Context, input UsersGetLeaderboardInput) ([]entity.LeaderboardItem, error) {
 	return s.pointsRepo.GetLeaderboard(ctx, input.Limit)
 }
 
-func (s *UsersService) GetInfo(ctx context.Context, input UsersGetInfoInput) (entity.User, error) {
-	return s.usersRepo.GetUserById(ctx, input.UserId)
-}
-
-func (s *UsersService) SetEmail(ctx context.Context, input UsersSetEmailInput) error {
-
-	pointsForEmail, ok := s.tasksList[TaskCompleteEmail]
-	if !ok {
-		logctx.FromContext(ctx).Error("UsersService.SetEmail - task not found")
+// GetInfo returns the user with their points, leaderboard rank, number of
+// completed tasks and streaks as of today in the user's timezone.
+func (s *UsersService) GetInfo(ctx context.Context, input UsersGetInfoInput) (entity.UserStatus, error) {
+	user, err := s.usersRepo.GetUserById(ctx, input.UserId)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return entity.UserStatus{}, ErrUserNotFound
+		}
+		logctx.FromContext(ctx).Error("UsersService.GetInfo - usersRepo.GetUserById", "err", err)
+		return entity.UserStatus{}, ErrCannotGetInfo
 	}
 
-	err := s.pointsRepo.AddPointsByUserId(ctx, input.UserId, TaskCompleteEmail, pointsForEmail)
+	points, err := s.pointsRepo.GetPointsByUserId(ctx, input.UserId)
 	if err != nil {
-		logctx.FromContext(ctx).Error("UsersService.SetEmail - pointsRepo.AddPointsByUserId", "err", err)
-		return ErrCannotAddPoints
+		logctx.FromContext(ctx).Error("UsersService.GetInfo - pointsRepo.GetPointsByUserId", "err", err)
+		return entity.UserStatus{}, ErrCannotGetInfo
 	}
-
-	return s.usersRepo.SetUserEmail(ctx, input.UserId, input.Email)
-}
-
-func (s *UsersService) SetReferrer(ctx context.Context, input UsersSetReferrerInput) error {
-
-	referrer, err := s.usersRepo.GetUserById(ctx, input.Referrer)
+	rank, err := s.pointsRepo.GetRankByUserId(ctx, input.UserId)
 	if err != nil {
-		logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.GetUserById", "err", err)
-		return err
+		logctx.FromContext(ctx).Error("UsersService.GetInfo - pointsRepo.GetRankByUserId", "err", err)
+		return entity.UserStatus{}, ErrCannotGetInfo
 	}
-	if referrer.Referrer != nil && *referrer.Referrer == strconv.Itoa(input.UserId) {
-		logctx.FromContext(ctx).Error("UsersService.SetReferrer - referrer cannot be the same as user")
-		return ErrReferrerCannotBeTheSameAsUser
+	completed, err := s.pointsRepo.CountCompletedTasks(ctx, input.UserId)
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService.GetInfo - pointsRepo.CountCompletedTasks", "err", err)
+		return entity.UserStatus{}, ErrCannotGetInfo
 	}
 
-	user, err := s.usersRepo.GetUserById(ctx, input.UserId)
+	streaks, err := s.streaksRepo.GetStreaksByUserId(ctx, input.UserId)
 	if err != nil {
-		logctx.FromContext(ctx).Error("UsersService.SetReferrer - usersRepo.GetUserById", "err", err)
-		return err
-	}
-	if user.Referrer != nil {
-		logctx.FromContext(ctx).Error("UsersService.SetReferrer - user already has a referrer")
-		return ErrUserAlreadySetReferrer
+		logctx.FromContext(ctx).Error("UsersService.GetInfo - streaksRepo.GetStreaksByUserId", "err", err)
+		return entity.UserStatus{}, ErrCannotGetStreaks
 	}
 
-	pointsForReferrer, ok := s.tasksList[TaskGiveReferral]
-	if !ok {
-		logctx.FromContext(ctx).Error("UsersService.SetReferrer - task not found")
-		return ErrTaskNotFound
-	}
-	pointsForUser, ok := s.tasksList[TaskGetReferral]
-	if !ok {
-		logctx.FromContext(ctx).Error("UsersService.SetReferrer - task not found")
-		return ErrTaskNotFound
+	today := streakDay(time.Now(), user.Location())
+	for i := range streaks {
+		streaks[i] = streakAt(streaks[i], today, s.streak.GraceDays)
 	}
 
-	s.pointsRepo.AddPointsByUserId(ctx, input.Referrer, TaskGiveReferral, pointsForReferrer)
-	s.pointsRepo.AddPointsByUserId(ctx, input.UserId, TaskGetReferral, pointsForUser)
-	return s.usersRepo.SetUserReferrer(ctx, input.UserId, input.Referrer)
+	return entity.UserStatus{
+		User:           user,
+		Streaks:        streaks,
+		Points:         points,
+		Rank:           rank,
+		CompletedTasks: completed,
+	}, nil
 }
 
 func (s *UsersService) CompleteTask(ctx context.Context, input UsersCompleteTaskInput) error {
 
-	if input.TaskId == TaskCompleteEmail || input.TaskId == TaskGetReferral || input.TaskId == TaskGiveReferral {
-		logctx.FromContext(ctx).Error("UsersService.CompleteTask - task not allowed to complete")
-		return ErrTaskNotAllowedToComplete
-	}
-
-	points, ok := s.tasksList[input.TaskId]
+	task, ok := s.tasksCatalog.GetTask(input.TaskId)
 	if !ok {
 		logctx.FromContext(ctx).Error("UsersService.CompleteTask - task not found")
 		return ErrTaskNotFound
 	}
+	if !task.Kind.CompletedByUser() {
+		logctx.FromContext(ctx).Error("UsersService.CompleteTask - task not allowed to complete", "kind", task.Kind)
+		return ErrTaskNotAllowedToComplete
+	}
+
+	now := time.Now()
+	if !task.ActiveAt(now) {
+		logctx.FromContext(ctx).Error("UsersService.CompleteTask - task not available")
+		return ErrTaskNotAvailable
+	}
 
-	completed, err := s.pointsRepo.CheckCompletedTask(ctx, input.UserId, input.TaskId)
+	// periods of recurring tasks and days of streaks start at midnight in the
+	// user's timezone
+	loc := time.UTC
+	if task.Recurrence.Periodic() || task.StreakGroup != "" {
+		user, err := s.usersRepo.GetUserById(ctx, input.UserId)
+		if err != nil {
+			logctx.FromContext(ctx).Error("UsersService.CompleteTask - usersRepo.GetUserById", "err", err)
+			return ErrCannotCheckCompletedTask
+		}
+		loc = user.Location()
+	}
+	periodStart, _ := task.Period(now, loc)
+
+	counts, err := s.pointsRepo.CountTaskCompletions(ctx, input.UserId, map[int]time.Time{task.Id: periodStart})
 	if err != nil {
-		logctx.FromContext(ctx).Error("UsersService.CompleteTask - pointsRepo.CheckCompletedTask", "err", err)
+		logctx.FromContext(ctx).Error("UsersService.CompleteTask - pointsRepo.CountTaskCompletions", "err", err)
 		return ErrCannotCheckCompletedTask
 	}
-	if completed {
+	completions := counts[task.Id]
+	if completions >= max(task.CompletionLimit, 1) {
 		logctx.FromContext(ctx).Error("UsersService.CompleteTask - task already completed")
 		return ErrTaskAlreadyCompleted
 	}
 
-	err = s.pointsRepo.AddPointsByUserId(ctx, input.UserId, input.TaskId, points)
+	status, err := s.verification.Verify(ctx, verification.VerificationVerifyInput{UserId: input.UserId, Task: task})
 	if err != nil {
-		logctx.FromContext(ctx).Error("UsersService.CompleteTask - pointsRepo.AddPointsByUserId", "err", err)
-		return ErrCannotAddPoints
+		if errors.Is(err, verification.ErrAccountNotLinked) {
+			return ErrAccountNotLinked
+		}
+		if errors.Is(err, verification.ErrAccountClaimed) {
+			return ErrAccountAlreadyUsed
+		}
+		logctx.FromContext(ctx).Error("UsersService.CompleteTask - verification.Verify", "err", err)
+		return ErrCannotVerifyTask
+	}
+	switch status {
+	case verifier.StatusPending:
+		return ErrVerificationPending
+	case verifier.StatusRejected:
+		return ErrTaskNotVerified
 	}
 
+	err = s.withTx(ctx, "CompleteTask", ErrCannotAddPoints, func(ctx context.Context) error {
+		entry := completionEntry(input.UserId, task, periodStart, completions+1)
+		if task.StreakGroup != "" {
+			entry.Delta, err = s.extendStreak(ctx, input.UserId, task.StreakGroup, streakDay(now, loc), task.Points)
+			if err != nil {
+				return err
+			}
+		}
+
+		// a duplicate entry rolls back the streak extended for it
+		err = s.pointsRepo.AddPoints(ctx, entry)
+		if err != nil {
+			if errors.Is(err, repoerrs.ErrAlreadyExists) {
+				return ErrTaskAlreadyCompleted
+			}
+			logctx.FromContext(ctx).Error("UsersService.CompleteTask - pointsRepo.AddPoints", "err", err)
+			return ErrCannotAddPoints
+		}
+		return s.payReferralEarnings(ctx, entry)
+	})
+	if err != nil {
+		return err
+	}
+
+	// the completion may be what the rewards paid for the user wait for
+	s.checkReferralRewards(ctx, input.UserId)
 	return nil
 }
 
-func (s *UsersService) GetHistory(ctx context.Context, input UsersGetHistoryInput) ([]entity.Point, error) {
-	return s.pointsRepo.GetHistoryByUserId(ctx, input.UserId)
+// timezoneChangeInterval is how often a user may change their timezone. The
+// periods of recurring tasks start at midnight in the timezone, so switching
+// zones freely would open new periods early.
+const timezoneChangeInterval = 24 * time.Hour
+
+func (s *UsersService) SetTimezone(ctx context.Context, input UsersSetTimezoneInput) error {
+	// "Local" would mean the timezone of the server
+	if input.Timezone == "" || input.Timezone == "Local" {
+		return ErrInvalidTimezone
+	}
+	if _, err := time.LoadLocation(input.Timezone); err != nil {
+		return ErrInvalidTimezone
+	}
+
+	err := s.usersRepo.SetUserTimezone(ctx, input.UserId, input.Timezone, time.Now(), timezoneChangeInterval)
+	if err != nil {
+		if !errors.Is(err, repoerrs.ErrNotFound) {
+			logctx.FromContext(ctx).Error("UsersService.SetTimezone - usersRepo.SetUserTimezone", "err", err)
+			return ErrCannotSetTimezone
+		}
+		// nothing was updated: either there is no such user or the zone
+		// was changed too recently
+		if _, err := s.usersRepo.GetUserById(ctx, input.UserId); err != nil {
+			if errors.Is(err, repoerrs.ErrNotFound) {
+				return ErrUserNotFound
+			}
+			logctx.FromContext(ctx).Error("UsersService.SetTimezone - usersRepo.GetUserById", "err", err)
+			return ErrCannotSetTimezone
+		}
+		return ErrTimezoneChangedRecently
+	}
+	return nil
 }
 
 func (s *UsersService) GetPoints(ctx context.Context, input UsersGetPointsInput) (int, error) {
 	return s.pointsRepo.GetPointsByUserId(ctx, input.UserId)
 }
+
+// taskEntry is the ledger entry granting the points of a task. Its idempotency
+// key lets the task be granted once per user.
+func taskEntry(userId int, taskId int, points int, reason entity.PointsReason) entity.PointsEntry {
+	key := fmt.Sprintf("task:%d:user:%d", taskId, userId)
+	return entity.PointsEntry{
+		UserId:         userId,
+		TaskId:         &taskId,
+		Delta:          points,
+		Reason:         reason,
+		IdempotencyKey: &key,
+	}
+}
+
+// completionEntry is the ledger entry for the n-th completion of the task in
+// the period starting at periodStart. Its idempotency key makes concurrent
+// completions of the same slot conflict. The first completion of a one-time
+// task uses the key of taskEntry.
+func completionEntry(userId int, task entity.Task, periodStart time.Time, n int) entity.PointsEntry {
+	entry := taskEntry(userId, task.Id, task.Points, entity.PointsReasonTaskCompleted)
+	if periodStart.IsZero() && n == 1 {
+		return entry
+	}
+
+	key := *entry.IdempotencyKey
+	if !periodStart.IsZero() {
+		key += ":period:" + periodStart.Format(time.DateOnly)
+	}
+	key += ":" + strconv.Itoa(n)
+	entry.IdempotencyKey = &key
+	return entry
+}
+
+// activeTasks returns the tasks whose window contains now.
+func activeTasks(tasks []entity.Task, now time.Time) []entity.Task {
+	var active []entity.Task
+	for _, task := range tasks {
+		if task.ActiveAt(now) {
+			active = append(active, task)
+		}
+	}
+	return active
+}
+
+// withTx runs fn in a transaction. fn returns the service errors itself; a
+// failure to begin or commit the transaction is logged and reported as fallback.
+func (s *UsersService) withTx(ctx context.Context, method string, fallback error, fn func(ctx context.Context) error) error {
+	var fnErr error
+	err := s.transactor.WithTx(ctx, func(ctx context.Context) error {
+		fnErr = fn(ctx)
+		return fnErr
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		logctx.FromContext(ctx).Error("UsersService."+method+" - transactor.WithTx", "err", err)
+		return fallback
+	}
+	return nil
+}
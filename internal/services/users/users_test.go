@@ -4,9 +4,17 @@ import (
 	"context"
 	"denet-test-task/internal/entity"
 	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/internal/services/antifraud"
+	"denet-test-task/internal/services/tasks"
+	"denet-test-task/internal/services/verification"
+	"denet-test-task/pkg/notifier"
+	"denet-test-task/pkg/verifier"
 	"errors"
-	"strconv"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -17,17 +25,24 @@ type mockUsersRepo struct {
 	setRefUserID   int
 	setRefReferrer int
 	setReferrerErr error
-	setEmailUserID int
-	setEmailEmail  string
-	setEmailErr    error
+	chainErr       error
+	chainsLocked   bool
+	// chainCheckedLocked is whether the chain was read under the lock
+	chainCheckedLocked bool
+	usersByEmail       map[string]entity.User
+	verifiedUserID     int
+	verifiedEmail      string
+	setVerifiedErr     error
+
+	setTimezoneUserID   int
+	setTimezone         string
+	setTimezoneErr      error
+	setTimezoneInterval time.Duration
 }
 
 func (m *mockUsersRepo) CreateUser(_ context.Context, _ entity.User) (int, error) {
 	return 0, nil
 }
-func (m *mockUsersRepo) GetUserByUsernameAndPassword(_ context.Context, _, _ string) (entity.User, error) {
-	return entity.User{}, nil
-}
 func (m *mockUsersRepo) GetUserById(_ context.Context, id int) (entity.User, error) {
 	if m.getByIDErr != nil {
 		return entity.User{}, m.getByIDErr
@@ -40,44 +55,143 @@ func (m *mockUsersRepo) GetUserById(_ context.Context, id int) (entity.User, err
 func (m *mockUsersRepo) GetUserByUsername(_ context.Context, _ string) (entity.User, error) {
 	return entity.User{}, nil
 }
+func (m *mockUsersRepo) GetUserByEmail(_ context.Context, email string) (entity.User, error) {
+	if u, ok := m.usersByEmail[email]; ok {
+		return u, nil
+	}
+	return entity.User{}, repoerrs.ErrNotFound
+}
+func (m *mockUsersRepo) GetUserByReferralCode(_ context.Context, code string) (entity.User, error) {
+	for _, u := range m.usersByID {
+		if u.ReferralCode == code {
+			return u, nil
+		}
+	}
+	return entity.User{}, repoerrs.ErrNotFound
+}
+
+// GetReferrerChain follows the referrers of usersByID.
+func (m *mockUsersRepo) GetReferrerChain(_ context.Context, id int, maxDepth int) ([]int, error) {
+	m.chainCheckedLocked = m.chainsLocked
+	if m.chainErr != nil {
+		return nil, m.chainErr
+	}
+	chain := []int{id}
+	for len(chain) <= maxDepth {
+		u, ok := m.usersByID[chain[len(chain)-1]]
+		if !ok || u.Referrer == nil {
+			break
+		}
+		chain = append(chain, *u.Referrer)
+	}
+	return chain, nil
+}
+func (m *mockUsersRepo) LockReferrerChains(_ context.Context) error {
+	m.chainsLocked = true
+	return nil
+}
 func (m *mockUsersRepo) SetUserReferrer(_ context.Context, id int, referrer int) error {
 	m.setRefUserID = id
 	m.setRefReferrer = referrer
 	return m.setReferrerErr
 }
-func (m *mockUsersRepo) SetUserEmail(_ context.Context, id int, email string) error {
-	m.setEmailUserID = id
-	m.setEmailEmail = email
-	return m.setEmailErr
+
+func (m *mockUsersRepo) SetUserEmailVerified(_ context.Context, id int, email string) error {
+	m.verifiedUserID = id
+	m.verifiedEmail = email
+	return m.setVerifiedErr
+}
+
+func (m *mockUsersRepo) SetUserPassword(_ context.Context, _ int, _ string) error {
+	return nil
+}
+
+func (m *mockUsersRepo) SetUserRole(_ context.Context, _ int, _ entity.Role) error {
+	return nil
+}
+func (m *mockUsersRepo) GetUserTokenVersion(_ context.Context, _ int) (int, error) {
+	return 0, nil
+}
+func (m *mockUsersRepo) IncrementUserTokenVersion(_ context.Context, _ int) (int, error) {
+	return 1, nil
+}
+func (m *mockUsersRepo) SetUserTimezone(_ context.Context, id int, timezone string, _ time.Time, interval time.Duration) error {
+	m.setTimezoneUserID = id
+	m.setTimezone = timezone
+	m.setTimezoneInterval = interval
+	return m.setTimezoneErr
 }
 
 var _ repo.Users = (*mockUsersRepo)(nil)
 
 type mockPointsRepo struct {
-	addCalls           []struct{ UserID, TaskID, Points int }
-	addErr             error
-	checkCompletedResp bool
-	checkCompletedErr  error
-	leaderboardResp    []entity.LeaderboardItem
-	leaderboardErr     error
-	historyResp        []entity.Point
-	historyErr         error
-	pointsByUserResp   int
-	pointsByUserErr    error
-}
-
-func (m *mockPointsRepo) AddPointsByUserId(_ context.Context, userId int, taskId int, points int) error {
-	m.addCalls = append(m.addCalls, struct{ UserID, TaskID, Points int }{userId, taskId, points})
+	addCalls         []struct{ UserID, TaskID, Points int }
+	addEntries       []entity.PointsEntry
+	addErr           error
+	addErrByTask     map[int]error
+	completions      map[int]int
+	countSince       map[int]time.Time
+	countErr         error
+	leaderboardResp  []entity.LeaderboardItem
+	leaderboardErr   error
+	historyResp      []entity.PointsEntry
+	historyErr       error
+	historyFilter    entity.PointsHistoryFilter
+	historyLimit     int
+	pointsByUserResp int
+	pointsByUserErr  error
+	completedTasks   int
+	rankResp         int
+}
+
+func (m *mockPointsRepo) AddPoints(_ context.Context, entry entity.PointsEntry) error {
+	m.addEntries = append(m.addEntries, entry)
+	if entry.TaskId == nil {
+		return m.addErr
+	}
+	m.addCalls = append(m.addCalls, struct{ UserID, TaskID, Points int }{entry.UserId, *entry.TaskId, entry.Delta})
+	if err, ok := m.addErrByTask[*entry.TaskId]; ok {
+		return err
+	}
 	return m.addErr
 }
+func (m *mockPointsRepo) SpendPoints(_ context.Context, _ entity.PointsEntry) error {
+	return nil
+}
 func (m *mockPointsRepo) GetPointsByUserId(_ context.Context, _ int) (int, error) {
 	return m.pointsByUserResp, m.pointsByUserErr
 }
-func (m *mockPointsRepo) GetHistoryByUserId(_ context.Context, _ int) ([]entity.Point, error) {
-	return m.historyResp, m.historyErr
+func (m *mockPointsRepo) GetHistoryByUserId(_ context.Context, _ int, filter entity.PointsHistoryFilter, limit int) ([]entity.PointsEntry, error) {
+	m.historyFilter = filter
+	m.historyLimit = limit
+	if m.historyErr != nil {
+		return nil, m.historyErr
+	}
+	var entries []entity.PointsEntry
+	for _, e := range m.historyResp {
+		after := filter.After
+		if after == nil || e.CreatedAt.Before(after.CreatedAt) || e.CreatedAt.Equal(after.CreatedAt) && e.Id < after.Id {
+			entries = append(entries, e)
+		}
+	}
+	return entries[:min(limit, len(entries))], nil
+}
+func (m *mockPointsRepo) CountTaskCompletions(_ context.Context, _ int, since map[int]time.Time) (map[int]int, error) {
+	m.countSince = since
+	if m.countErr != nil {
+		return nil, m.countErr
+	}
+	counts := make(map[int]int, len(since))
+	for taskId := range since {
+		counts[taskId] = m.completions[taskId]
+	}
+	return counts, nil
+}
+func (m *mockPointsRepo) CountCompletedTasks(_ context.Context, _ int) (int, error) {
+	return m.completedTasks, m.countErr
 }
-func (m *mockPointsRepo) CheckCompletedTask(_ context.Context, _ int, _ int) (bool, error) {
-	return m.checkCompletedResp, m.checkCompletedErr
+func (m *mockPointsRepo) GetRankByUserId(_ context.Context, _ int) (int, error) {
+	return m.rankResp, m.pointsByUserErr
 }
 func (m *mockPointsRepo) GetLeaderboard(_ context.Context, _ int) ([]entity.LeaderboardItem, error) {
 	return m.leaderboardResp, m.leaderboardErr
@@ -85,151 +199,481 @@ func (m *mockPointsRepo) GetLeaderboard(_ context.Context, _ int) ([]entity.Lead
 
 var _ repo.Points = (*mockPointsRepo)(nil)
 
-type mockTasksRepo struct {
+type mockStreaksRepo struct {
+	streaks map[string]entity.Streak
+	saved   []entity.Streak
+	getErr  error
+	saveErr error
+}
+
+func (m *mockStreaksRepo) GetStreakForUpdate(_ context.Context, _ int, group string) (entity.Streak, error) {
+	if m.getErr != nil {
+		return entity.Streak{}, m.getErr
+	}
+	if streak, ok := m.streaks[group]; ok {
+		return streak, nil
+	}
+	return entity.Streak{}, repoerrs.ErrNotFound
+}
+func (m *mockStreaksRepo) SaveStreak(_ context.Context, streak entity.Streak) error {
+	if m.saveErr != nil {
+		return m.saveErr
+	}
+	if m.streaks == nil {
+		m.streaks = map[string]entity.Streak{}
+	}
+	m.streaks[streak.Group] = streak
+	m.saved = append(m.saved, streak)
+	return nil
+}
+func (m *mockStreaksRepo) GetStreaksByUserId(_ context.Context, _ int) ([]entity.Streak, error) {
+	if m.getErr != nil {
+		return nil, m.getErr
+	}
+	var streaks []entity.Streak
+	for _, streak := range m.streaks {
+		streaks = append(streaks, streak)
+	}
+	return streaks, nil
+}
+
+var _ repo.Streaks = (*mockStreaksRepo)(nil)
+
+type mockReferralsRepo struct {
+	tiers      []entity.ReferralTier
+	upline     []entity.UplineTier
+	referrals  []entity.Referral
+	depth      int
+	err        error
+	rewards    []entity.ReferralReward
+	released   int
+	rewardsErr error
+}
+
+func (m *mockReferralsRepo) GetReferralTiers(_ context.Context) ([]entity.ReferralTier, error) {
+	return m.tiers, m.err
+}
+func (m *mockReferralsRepo) GetUplineTiers(_ context.Context, _ int) ([]entity.UplineTier, error) {
+	return m.upline, m.err
+}
+func (m *mockReferralsRepo) GetReferralTree(_ context.Context, _ int, depth int) ([]entity.Referral, error) {
+	m.depth = depth
+	return m.referrals, m.err
+}
+func (m *mockReferralsRepo) CreateReferralReward(_ context.Context, reward entity.ReferralReward) error {
+	if m.rewardsErr != nil {
+		return m.rewardsErr
+	}
+	reward.Id = len(m.rewards) + 1
+	m.rewards = append(m.rewards, reward)
+	return nil
+}
+func (m *mockReferralsRepo) GetReferralRewardById(_ context.Context, id int) (entity.ReferralReward, error) {
+	if m.rewardsErr != nil {
+		return entity.ReferralReward{}, m.rewardsErr
+	}
+	for _, reward := range m.rewards {
+		if reward.Id == id {
+			return reward, nil
+		}
+	}
+	return entity.ReferralReward{}, repoerrs.ErrNotFound
+}
+func (m *mockReferralsRepo) GetReferralRewardsByReferral(_ context.Context, referralId int, status entity.ReferralRewardStatus) ([]entity.ReferralReward, error) {
+	var rewards []entity.ReferralReward
+	for _, reward := range m.rewards {
+		if reward.ReferralId == referralId && reward.Status == status {
+			rewards = append(rewards, reward)
+		}
+	}
+	return rewards, m.rewardsErr
+}
+func (m *mockReferralsRepo) GetReferralRewardsByStatus(_ context.Context, status entity.ReferralRewardStatus, limit int) ([]entity.ReferralReward, error) {
+	var rewards []entity.ReferralReward
+	for _, reward := range m.rewards {
+		if reward.Status == status && len(rewards) < limit {
+			rewards = append(rewards, reward)
+		}
+	}
+	return rewards, m.rewardsErr
+}
+func (m *mockReferralsRepo) GetPendingReferralIds(_ context.Context, afterId int, limit int) ([]int, error) {
+	var ids []int
+	for _, reward := range m.rewards {
+		if reward.Status == entity.ReferralRewardPending && reward.ReferralId > afterId && !slices.Contains(ids, reward.ReferralId) {
+			ids = append(ids, reward.ReferralId)
+		}
+	}
+	slices.Sort(ids)
+	return ids[:min(limit, len(ids))], m.rewardsErr
+}
+func (m *mockReferralsRepo) CountReleasedReferralRewards(_ context.Context, _ int, _ time.Time) (int, error) {
+	return m.released, m.rewardsErr
+}
+func (m *mockReferralsRepo) UpdateReferralRewardStatus(_ context.Context, id int, status entity.ReferralRewardStatus, reason string) error {
+	if m.rewardsErr != nil {
+		return m.rewardsErr
+	}
+	for i, reward := range m.rewards {
+		if reward.Id != id {
+			continue
+		}
+		if reward.Status == entity.ReferralRewardReleased || reward.Status == entity.ReferralRewardRejected {
+			return repoerrs.ErrNotFound
+		}
+		m.rewards[i].Status = status
+		m.rewards[i].Reason = reason
+		return nil
+	}
+	return repoerrs.ErrNotFound
+}
+
+var _ repo.Referrals = (*mockReferralsRepo)(nil)
+
+type mockTaskCatalog struct {
 	allTasks []entity.Task
-	err      error
 }
 
-func (m *mockTasksRepo) GetTaskById(_ context.Context, _ int) (entity.Task, error) {
-	if len(m.allTasks) > 0 {
-		return m.allTasks[0], m.err
+func (m *mockTaskCatalog) GetTask(id int) (entity.Task, bool) {
+	for _, task := range m.allTasks {
+		if task.Id == id {
+			return task, true
+		}
+	}
+	return entity.Task{}, false
+}
+func (m *mockTaskCatalog) GetTasksByKind(kind entity.TaskKind) []entity.Task {
+	var tasks []entity.Task
+	for _, task := range m.allTasks {
+		if task.Kind == kind {
+			tasks = append(tasks, task)
+		}
+	}
+	return tasks
+}
+func (m *mockTaskCatalog) GetAllTasks() []entity.Task     { return m.allTasks }
+func (m *mockTaskCatalog) Reload(_ context.Context) error { return nil }
+
+var _ tasks.Catalog = (*mockTaskCatalog)(nil)
+
+type mockVerification struct {
+	status     verifier.Status
+	err        error
+	inputs     []verification.VerificationVerifyInput
+	linkInputs []verification.VerificationLinkInput
+}
+
+func (m *mockVerification) Link(_ context.Context, input verification.VerificationLinkInput) (entity.LinkedAccount, error) {
+	m.linkInputs = append(m.linkInputs, input)
+	if m.err != nil {
+		return entity.LinkedAccount{}, m.err
+	}
+	return entity.LinkedAccount{UserId: input.UserId, Kind: input.Kind, Account: input.Proof["id"]}, nil
+}
+
+func (m *mockVerification) Verify(_ context.Context, input verification.VerificationVerifyInput) (verifier.Status, error) {
+	m.inputs = append(m.inputs, input)
+	if m.status == "" {
+		return verifier.StatusVerified, m.err
+	}
+	return m.status, m.err
+}
+
+var _ verification.Verification = (*mockVerification)(nil)
+
+type mockAntifraud struct {
+	decision antifraud.Decision
+	inputs   []antifraud.AntifraudCheckInput
+}
+
+func (m *mockAntifraud) Check(_ context.Context, input antifraud.AntifraudCheckInput) antifraud.Decision {
+	m.inputs = append(m.inputs, input)
+	return m.decision
+}
+
+var _ antifraud.Antifraud = (*mockAntifraud)(nil)
+
+type mockTransactor struct {
+	committed  int
+	rolledBack int
+	commitErr  error
+}
+
+func (m *mockTransactor) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := fn(ctx); err != nil {
+		m.rolledBack++
+		return err
+	}
+	if m.commitErr != nil {
+		m.rolledBack++
+		return m.commitErr
+	}
+	m.committed++
+	return nil
+}
+
+var _ repo.Transactor = (*mockTransactor)(nil)
+
+type mockEmailVerificationsRepo struct {
+	created []entity.EmailVerification
+}
+
+func (m *mockEmailVerificationsRepo) CreateEmailVerification(_ context.Context, verification entity.EmailVerification) error {
+	// only the latest code of the user stays valid
+	now := time.Now()
+	for i := range m.created {
+		if m.created[i].UserId == verification.UserId && m.created[i].UsedAt == nil {
+			m.created[i].UsedAt = &now
+		}
 	}
-	return entity.Task{}, m.err
+	verification.Id = len(m.created) + 1
+	verification.CreatedAt = now
+	m.created = append(m.created, verification)
+	return nil
 }
-func (m *mockTasksRepo) GetTaskByName(_ context.Context, _ string) (entity.Task, error) {
-	if len(m.allTasks) > 0 {
-		return m.allTasks[0], m.err
+func (m *mockEmailVerificationsRepo) GetPendingEmailVerification(_ context.Context, userId int) (entity.EmailVerification, error) {
+	for i := len(m.created) - 1; i >= 0; i-- {
+		if m.created[i].UserId == userId && m.created[i].UsedAt == nil {
+			return m.created[i], nil
+		}
 	}
-	return entity.Task{}, m.err
+	return entity.EmailVerification{}, repoerrs.ErrNotFound
 }
-func (m *mockTasksRepo) GetAllTasks(_ context.Context) ([]entity.Task, error) {
-	return m.allTasks, m.err
+func (m *mockEmailVerificationsRepo) IncrementEmailVerificationAttempts(_ context.Context, id int) error {
+	m.created[id-1].Attempts++
+	return nil
+}
+func (m *mockEmailVerificationsRepo) UseEmailVerification(_ context.Context, id int) error {
+	if m.created[id-1].UsedAt != nil {
+		return repoerrs.ErrNotFound
+	}
+	now := time.Now()
+	m.created[id-1].UsedAt = &now
+	return nil
+}
+
+var _ repo.EmailVerifications = (*mockEmailVerificationsRepo)(nil)
+
+type mockNotifier struct {
+	sent []notifier.Message
+	err  error
+}
+
+func (m *mockNotifier) Notify(_ context.Context, msg notifier.Message) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.sent = append(m.sent, msg)
+	return nil
 }
 
-var _ repo.Tasks = (*mockTasksRepo)(nil)
+var testEmailVerificationConfig = EmailVerificationConfig{CodeTTL: time.Hour, MaxAttempts: 3, ResendInterval: time.Minute}
 
-func TestNewUsersService_ErrorOnTasksFetch(t *testing.T) {
-	_, err := NewUsersService(context.Background(), &mockUsersRepo{}, &mockPointsRepo{}, &mockTasksRepo{err: errors.New("boom")})
-	assert.ErrorIs(t, err, ErrCannotGetTasks)
+var testStreakConfig = StreakConfig{
+	GraceDays: 1,
+	Multipliers: []StreakMultiplier{
+		{Days: 3, Multiplier: 1.5},
+		{Days: 7, Multiplier: 2},
+	},
 }
 
+var testReferralConfig = ReferralConfig{MaxChainDepth: 5}
+
 func TestUsersService_CompleteTask_Restricted(t *testing.T) {
-	svc, err := NewUsersService(context.Background(),
+	svc := NewUsersService(
+		&mockTransactor{},
 		&mockUsersRepo{},
 		&mockPointsRepo{},
-		&mockTasksRepo{allTasks: []entity.Task{}},
+		&mockStreaksRepo{},
+		&mockReferralsRepo{},
+		&mockTaskCatalog{allTasks: []entity.Task{
+			{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+			{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
+			{Id: 5, Kind: entity.TaskKindEmail, Points: 11},
+		}},
+		&mockVerification{},
+		&mockAntifraud{},
+		&mockEmailVerificationsRepo{},
+		&mockNotifier{},
+		testEmailVerificationConfig,
+		testStreakConfig,
+		testReferralConfig,
 	)
-	assert.NoError(t, err)
-	for _, restricted := range []int{TaskCompleteEmail, TaskGetReferral, TaskGiveReferral} {
+	for _, restricted := range []int{1, 2, 5} {
 		err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: restricted})
 		assert.ErrorIs(t, err, ErrTaskNotAllowedToComplete)
 	}
 }
 
 func TestUsersService_CompleteTask_NotFound(t *testing.T) {
-	svc, err := NewUsersService(context.Background(),
+	svc := NewUsersService(
+		&mockTransactor{},
 		&mockUsersRepo{},
 		&mockPointsRepo{},
-		&mockTasksRepo{allTasks: []entity.Task{}},
+		&mockStreaksRepo{},
+		&mockReferralsRepo{},
+		&mockTaskCatalog{allTasks: []entity.Task{}},
+		&mockVerification{},
+		&mockAntifraud{},
+		&mockEmailVerificationsRepo{},
+		&mockNotifier{},
+		testEmailVerificationConfig,
+		testStreakConfig,
+		testReferralConfig,
 	)
-	assert.NoError(t, err)
-	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 999})
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 999})
 	assert.ErrorIs(t, err, ErrTaskNotFound)
 }
 
 func TestUsersService_CompleteTask_CheckError(t *testing.T) {
-	points := &mockPointsRepo{checkCompletedErr: errors.New("db")}
-	svc, err := NewUsersService(context.Background(),
+	points := &mockPointsRepo{countErr: errors.New("db")}
+	svc := NewUsersService(
+		&mockTransactor{},
 		&mockUsersRepo{},
 		points,
-		&mockTasksRepo{allTasks: []entity.Task{{Id: 100, Points: 15}}},
+		&mockStreaksRepo{},
+		&mockReferralsRepo{},
+		&mockTaskCatalog{allTasks: []entity.Task{{Id: 100, Kind: entity.TaskKindManual, Points: 15}}},
+		&mockVerification{},
+		&mockAntifraud{},
+		&mockEmailVerificationsRepo{},
+		&mockNotifier{},
+		testEmailVerificationConfig,
+		testStreakConfig,
+		testReferralConfig,
 	)
-	assert.NoError(t, err)
-	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 100})
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 100})
 	assert.ErrorIs(t, err, ErrCannotCheckCompletedTask)
 }
 
 func TestUsersService_CompleteTask_AlreadyCompleted(t *testing.T) {
-	points := &mockPointsRepo{checkCompletedResp: true}
-	svc, err := NewUsersService(context.Background(),
+	points := &mockPointsRepo{completions: map[int]int{101: 1}}
+	svc := NewUsersService(
+		&mockTransactor{},
 		&mockUsersRepo{},
 		points,
-		&mockTasksRepo{allTasks: []entity.Task{{Id: 101, Points: 7}}},
+		&mockStreaksRepo{},
+		&mockReferralsRepo{},
+		&mockTaskCatalog{allTasks: []entity.Task{{Id: 101, Kind: entity.TaskKindManual, Points: 7}}},
+		&mockVerification{},
+		&mockAntifraud{},
+		&mockEmailVerificationsRepo{},
+		&mockNotifier{},
+		testEmailVerificationConfig,
+		testStreakConfig,
+		testReferralConfig,
 	)
-	assert.NoError(t, err)
-	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 101})
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 101})
 	assert.ErrorIs(t, err, ErrTaskAlreadyCompleted)
 }
 
 func TestUsersService_CompleteTask_AddPointsError(t *testing.T) {
-	points := &mockPointsRepo{checkCompletedResp: false, addErr: errors.New("db")}
-	svc, err := NewUsersService(context.Background(),
+	points := &mockPointsRepo{addErr: errors.New("db")}
+	svc := NewUsersService(
+		&mockTransactor{},
 		&mockUsersRepo{},
 		points,
-		&mockTasksRepo{allTasks: []entity.Task{{Id: 102, Points: 13}}},
+		&mockStreaksRepo{},
+		&mockReferralsRepo{},
+		&mockTaskCatalog{allTasks: []entity.Task{{Id: 102, Kind: entity.TaskKindManual, Points: 13}}},
+		&mockVerification{},
+		&mockAntifraud{},
+		&mockEmailVerificationsRepo{},
+		&mockNotifier{},
+		testEmailVerificationConfig,
+		testStreakConfig,
+		testReferralConfig,
 	)
-	assert.NoError(t, err)
-	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 102})
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 102})
 	assert.ErrorIs(t, err, ErrCannotAddPoints)
 }
 
 func TestUsersService_CompleteTask_Success(t *testing.T) {
-	points := &mockPointsRepo{checkCompletedResp: false}
-	svc, err := NewUsersService(context.Background(),
+	points := &mockPointsRepo{}
+	svc := NewUsersService(
+		&mockTransactor{},
 		&mockUsersRepo{},
 		points,
-		&mockTasksRepo{allTasks: []entity.Task{{Id: 103, Points: 33}}},
+		&mockStreaksRepo{},
+		&mockReferralsRepo{},
+		&mockTaskCatalog{allTasks: []entity.Task{{Id: 103, Kind: entity.TaskKindManual, Points: 33}}},
+		&mockVerification{},
+		&mockAntifraud{},
+		&mockEmailVerificationsRepo{},
+		&mockNotifier{},
+		testEmailVerificationConfig,
+		testStreakConfig,
+		testReferralConfig,
 	)
-	assert.NoError(t, err)
-	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 103})
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 103})
 	assert.NoError(t, err)
 	assert.Len(t, points.addCalls, 1)
 	call := points.addCalls[0]
 	assert.Equal(t, 10, call.UserID)
 	assert.Equal(t, 103, call.TaskID)
 	assert.Equal(t, 33, call.Points)
+	entry := points.addEntries[0]
+	assert.Equal(t, entity.PointsReasonTaskCompleted, entry.Reason)
+	if assert.NotNil(t, entry.IdempotencyKey) {
+		assert.Equal(t, "task:103:user:10", *entry.IdempotencyKey)
+	}
+}
+
+func TestUsersService_CompleteTask_DuplicateEntry(t *testing.T) {
+	// a concurrent request granted the task between the check and the insert
+	points := &mockPointsRepo{addErr: repoerrs.ErrAlreadyExists}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{{Id: 104, Kind: entity.TaskKindManual, Points: 1}}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 104})
+	assert.ErrorIs(t, err, ErrTaskAlreadyCompleted)
 }
 
 func TestUsersService_SetReferrer_SelfReferrerError(t *testing.T) {
 	uRepo := &mockUsersRepo{
 		usersByID: map[int]entity.User{
 			1: {Id: 1},
-			2: {Id: 2, Referrer: strPtr(strconv.Itoa(1))},
+			2: {Id: 2, Referrer: intPtr(1)},
 		},
 	}
-	svc, err := NewUsersService(context.Background(), uRepo, &mockPointsRepo{}, &mockTasksRepo{allTasks: []entity.Task{}})
-	assert.NoError(t, err)
-	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 1})
 	assert.ErrorIs(t, err, ErrReferrerCannotBeTheSameAsUser)
+
+	// referred by the user
+	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.ErrorIs(t, err, ErrReferralCycle)
 }
 
 func TestUsersService_SetReferrer_UserAlreadyHasReferrer(t *testing.T) {
-	referrer := 2
-	refStr := strconv.Itoa(referrer)
 	uRepo := &mockUsersRepo{
 		usersByID: map[int]entity.User{
-			1: {Id: 1, Referrer: &refStr},
+			1: {Id: 1, Referrer: intPtr(2)},
 			2: {Id: 2},
 		},
 	}
-	svc, err := NewUsersService(context.Background(), uRepo, &mockPointsRepo{}, &mockTasksRepo{allTasks: []entity.Task{}})
-	assert.NoError(t, err)
-	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: referrer})
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
 	assert.ErrorIs(t, err, ErrUserAlreadySetReferrer)
 }
 
-func TestUsersService_SetReferrer_TaskMappingMissing(t *testing.T) {
+func TestUsersService_SetReferrer_NoActiveTasks(t *testing.T) {
 	uRepo := &mockUsersRepo{
 		usersByID: map[int]entity.User{
 			1: {Id: 1},
 			2: {Id: 2},
 		},
 	}
-	// No tasks provided -> mapping missing
-	svc, err := NewUsersService(context.Background(), uRepo, &mockPointsRepo{}, &mockTasksRepo{allTasks: []entity.Task{}})
+	// the giver task is over, there is no receiver task: the referrer is
+	// linked without rewards
+	ended := time.Now().Add(-time.Hour)
+	catalog := &mockTaskCatalog{allTasks: []entity.Task{{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5, EndsAt: &ended}}}
+	points := &mockPointsRepo{}
+	referrals := &mockReferralsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, referrals, catalog, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
 	assert.NoError(t, err)
-	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
-	assert.ErrorIs(t, err, ErrTaskNotFound)
+	assert.Equal(t, 2, uRepo.setRefReferrer)
+	assert.Empty(t, points.addEntries)
+	assert.Empty(t, referrals.rewards)
 }
 
 func TestUsersService_SetReferrer_Success(t *testing.T) {
@@ -241,43 +685,408 @@ func TestUsersService_SetReferrer_Success(t *testing.T) {
 	}
 	points := &mockPointsRepo{}
 	// Provide both referral tasks
-	tasks := []entity.Task{
-		{Id: TaskGiveReferral, Points: 5},
-		{Id: TaskGetReferral, Points: 7},
+	allTasks := []entity.Task{
+		{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+		{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
 	}
-	svc, err := NewUsersService(context.Background(), uRepo, points, &mockTasksRepo{allTasks: tasks})
-	assert.NoError(t, err)
-	err = svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
 	assert.NoError(t, err)
 	assert.Len(t, points.addCalls, 2)
 	assert.Equal(t, 1, uRepo.setRefUserID)
 	assert.Equal(t, 2, uRepo.setRefReferrer)
 }
 
-func TestUsersService_SetEmail(t *testing.T) {
-	points := &mockPointsRepo{addErr: nil}
+func TestUsersService_SetReferrer_AwardsEveryTaskOfKind(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2},
+		},
+	}
+	points := &mockPointsRepo{}
+	// a campaign task added next to the seeded one needs no code changes
+	allTasks := []entity.Task{
+		{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+		{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
+		{Id: 42, Kind: entity.TaskKindReferralGiver, Points: 50},
+		{Id: 3, Kind: entity.TaskKindExternalVerification, Points: 30},
+	}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, points.addCalls, 3) {
+		assert.Equal(t, 2, points.addCalls[0].TaskID)
+		assert.Equal(t, 1, points.addCalls[1].TaskID)
+		assert.Equal(t, 42, points.addCalls[2].TaskID)
+		assert.Equal(t, 50, points.addCalls[2].Points)
+	}
+}
+
+func TestUsersService_SetReferrer_ReferrerAlreadyRewarded(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2},
+		},
+	}
+	points := &mockPointsRepo{addErrByTask: map[int]error{1: repoerrs.ErrAlreadyExists}}
+	transactor := &mockTransactor{}
+	allTasks := []entity.Task{
+		{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+		{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
+	}
+	svc := NewUsersService(transactor, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.NoError(t, err)
+	assert.Len(t, points.addCalls, 2)
+	// the referral and the release of the reward
+	assert.Equal(t, 2, transactor.committed)
+}
+
+func TestUsersService_SetReferrer_AddPointsErrorRollsBack(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2},
+		},
+	}
+	points := &mockPointsRepo{addErrByTask: map[int]error{2: errors.New("db")}}
+	transactor := &mockTransactor{}
+	allTasks := []entity.Task{
+		{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+		{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
+	}
+	svc := NewUsersService(transactor, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.ErrorIs(t, err, ErrCannotAddPoints)
+	assert.Equal(t, 1, transactor.rolledBack)
+	assert.Equal(t, 0, transactor.committed)
+}
+
+func TestUsersService_SetReferrer_CommitError(t *testing.T) {
+	uRepo := &mockUsersRepo{
+		usersByID: map[int]entity.User{
+			1: {Id: 1},
+			2: {Id: 2},
+		},
+	}
+	allTasks := []entity.Task{
+		{Id: 1, Kind: entity.TaskKindReferralGiver, Points: 5},
+		{Id: 2, Kind: entity.TaskKindReferralReceiver, Points: 7},
+	}
+	svc := NewUsersService(&mockTransactor{commitErr: errors.New("conn lost")}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetReferrer(context.Background(), UsersSetReferrerInput{UserId: 1, Referrer: 2})
+	assert.ErrorIs(t, err, ErrCannotSetReferrer)
+}
+
+func TestUsersService_SetEmail_SendsCode(t *testing.T) {
+	points := &mockPointsRepo{}
 	uRepo := &mockUsersRepo{}
-	tasks := []entity.Task{{Id: TaskCompleteEmail, Points: 11}}
-	svc, err := NewUsersService(context.Background(), uRepo, points, &mockTasksRepo{allTasks: tasks})
+	verifications := &mockEmailVerificationsRepo{}
+	notifierMock := &mockNotifier{}
+	allTasks := []entity.Task{{Id: 5, Kind: entity.TaskKindEmail, Points: 11}}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, verifications, notifierMock, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 99, Email: "x@y.z"})
+	assert.NoError(t, err)
+	// the email is stored on the user and points are granted only once it is verified
+	assert.Zero(t, uRepo.verifiedUserID)
+	assert.Empty(t, points.addCalls)
+
+	if !assert.Len(t, verifications.created, 1) || !assert.Len(t, notifierMock.sent, 1) {
+		return
+	}
+	created := verifications.created[0]
+	assert.Equal(t, 99, created.UserId)
+	assert.Equal(t, "x@y.z", created.Email)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), created.ExpiresAt, time.Minute)
+	assert.Equal(t, "x@y.z", notifierMock.sent[0].To)
+	assert.NotEmpty(t, codeFromMessage(notifierMock.sent[0].Body, created.CodeHash))
+}
+
+func TestUsersService_SetEmail_AlreadyUsed(t *testing.T) {
+	uRepo := &mockUsersRepo{usersByEmail: map[string]entity.User{"a@b.c": {Id: 2}}}
+	verifications := &mockEmailVerificationsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, verifications, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 1, Email: "a@b.c"})
+	assert.ErrorIs(t, err, ErrEmailAlreadyUsed)
+	assert.Empty(t, verifications.created)
+}
+
+func TestUsersService_SetEmail_ResendInterval(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{}
+	notifierMock := &mockNotifier{}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, verifications, notifierMock, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 1, Email: "a@b.c"})
 	assert.NoError(t, err)
-	err = svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 99, Email: "x@y.z"})
+
+	// a new code, which would bring new attempts, waits for the interval
+	err = svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 1, Email: "d@e.f"})
+	assert.ErrorIs(t, err, ErrVerificationSentRecently)
+	assert.Len(t, verifications.created, 1)
+	assert.Len(t, notifierMock.sent, 1)
+
+	verifications.created[0].CreatedAt = time.Now().Add(-2 * time.Minute)
+	err = svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 1, Email: "d@e.f"})
 	assert.NoError(t, err)
-	assert.Len(t, points.addCalls, 1)
-	assert.Equal(t, 99, points.addCalls[0].UserID)
-	assert.Equal(t, TaskCompleteEmail, points.addCalls[0].TaskID)
+	assert.Len(t, verifications.created, 2)
+}
+
+func TestUsersService_VerifyEmail_TakenMeanwhile(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{created: []entity.EmailVerification{
+		{Id: 1, UserId: 7, Email: "a@b.c", CodeHash: hashCode("123456"), ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+	transactor := &mockTransactor{}
+	svc := NewUsersService(transactor, &mockUsersRepo{setVerifiedErr: repoerrs.ErrAlreadyExists}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, verifications, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: "123456"})
+	assert.ErrorIs(t, err, ErrEmailAlreadyUsed)
+	assert.Equal(t, 1, transactor.rolledBack)
+}
+
+func TestUsersService_SetEmail_NotifyError(t *testing.T) {
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{err: errors.New("smtp")}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 1, Email: "a@b.c"})
+	assert.ErrorIs(t, err, ErrCannotSendVerification)
+}
+
+func TestUsersService_VerifyEmail_Success(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{}
+	notifierMock := &mockNotifier{}
+	allTasks := []entity.Task{{Id: 5, Kind: entity.TaskKindEmail, Points: 11}}
+	uRepo := &mockUsersRepo{}
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, verifications, notifierMock, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 7, Email: "a@b.c"})
+	assert.NoError(t, err)
+	code := codeFromMessage(notifierMock.sent[0].Body, verifications.created[0].CodeHash)
+
+	err = svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: code})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, uRepo.verifiedUserID)
+	assert.Equal(t, "a@b.c", uRepo.verifiedEmail)
+	if !assert.Len(t, points.addCalls, 1) {
+		return
+	}
+	assert.Equal(t, 5, points.addCalls[0].TaskID)
 	assert.Equal(t, 11, points.addCalls[0].Points)
-	assert.Equal(t, 99, uRepo.setEmailUserID)
-	assert.Equal(t, "x@y.z", uRepo.setEmailEmail)
+
+	// the code is single use
+	err = svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: code})
+	assert.ErrorIs(t, err, ErrVerificationNotFound)
 }
 
-func TestUsersService_SetEmail_AddPointsError(t *testing.T) {
-	points := &mockPointsRepo{addErr: errors.New("db")}
+func TestUsersService_VerifyEmail_NoActiveTask(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{created: []entity.EmailVerification{
+		{Id: 1, UserId: 7, Email: "a@b.c", CodeHash: hashCode("123456"), ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+	ended := time.Now().Add(-time.Hour)
+	allTasks := []entity.Task{{Id: 5, Kind: entity.TaskKindEmail, Points: 11, EndsAt: &ended}}
 	uRepo := &mockUsersRepo{}
-	tasks := []entity.Task{{Id: TaskCompleteEmail, Points: 11}}
-	svc, err := NewUsersService(context.Background(), uRepo, points, &mockTasksRepo{allTasks: tasks})
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, verifications, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	// the email is verified without points
+	err := svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: "123456"})
 	assert.NoError(t, err)
-	err = svc.SetEmail(context.Background(), UsersSetEmailInput{UserId: 1, Email: "a@b.c"})
-	assert.ErrorIs(t, err, ErrCannotAddPoints)
+	assert.Equal(t, 7, uRepo.verifiedUserID)
+	assert.Empty(t, points.addEntries)
+}
+
+func TestUsersService_VerifyEmail_WrongCode(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{created: []entity.EmailVerification{
+		{Id: 1, UserId: 7, Email: "a@b.c", CodeHash: hashCode("123456"), ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, verifications, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	for i := 0; i < testEmailVerificationConfig.MaxAttempts; i++ {
+		err := svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: "000000"})
+		assert.ErrorIs(t, err, ErrInvalidVerificationCode)
+	}
+	// the right code no longer helps once the attempts are spent
+	err := svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: "123456"})
+	assert.ErrorIs(t, err, ErrTooManyVerificationAttempts)
+	assert.Nil(t, verifications.created[0].UsedAt)
+}
+
+func TestUsersService_VerifyEmail_UserNotFound(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{created: []entity.EmailVerification{
+		{Id: 1, UserId: 7, Email: "a@b.c", CodeHash: hashCode("123456"), ExpiresAt: time.Now().Add(time.Hour)},
+	}}
+	points := &mockPointsRepo{}
+	transactor := &mockTransactor{}
+	allTasks := []entity.Task{{Id: 5, Kind: entity.TaskKindEmail, Points: 11}}
+	svc := NewUsersService(transactor, &mockUsersRepo{setVerifiedErr: repoerrs.ErrNotFound}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: allTasks}, &mockVerification{}, &mockAntifraud{}, verifications, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: "123456"})
+	assert.ErrorIs(t, err, ErrVerificationNotFound)
+	assert.Empty(t, points.addCalls)
+	assert.Equal(t, 1, transactor.rolledBack)
+}
+
+func TestUsersService_VerifyEmail_Expired(t *testing.T) {
+	verifications := &mockEmailVerificationsRepo{created: []entity.EmailVerification{
+		{Id: 1, UserId: 7, Email: "a@b.c", CodeHash: hashCode("123456"), ExpiresAt: time.Now().Add(-time.Minute)},
+	}}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, verifications, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.VerifyEmail(context.Background(), UsersVerifyEmailInput{UserId: 7, Code: "123456"})
+	assert.ErrorIs(t, err, ErrVerificationNotFound)
+}
+
+// codeFromMessage finds the code whose hash was stored in the message body.
+func codeFromMessage(body, codeHash string) string {
+	for _, field := range strings.Fields(body) {
+		if hashCode(field) == codeHash {
+			return field
+		}
+	}
+	return ""
+}
+
+func intPtr(i int) *int { return &i }
+
+func TestUsersService_CompleteTask_NotAvailable(t *testing.T) {
+	ended := time.Now().Add(-time.Minute)
+	points := &mockPointsRepo{}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{{Id: 105, Kind: entity.TaskKindManual, Points: 5, EndsAt: &ended}}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 105})
+	assert.ErrorIs(t, err, ErrTaskNotAvailable)
+	assert.Empty(t, points.addCalls)
+}
+
+func TestUsersService_CompleteTask_Recurring(t *testing.T) {
+	task := entity.Task{Id: 106, Kind: entity.TaskKindManual, Points: 3, Recurrence: entity.TaskRecurrenceDaily, CompletionLimit: 2}
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{10: {Id: 10, Timezone: "America/New_York"}}}
+	points := &mockPointsRepo{completions: map[int]int{106: 1}}
+	svc := NewUsersService(&mockTransactor{}, uRepo, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 106})
+	assert.NoError(t, err)
+
+	newYork, _ := time.LoadLocation("America/New_York")
+	periodStart := points.countSince[106]
+	assert.Equal(t, 0, periodStart.In(newYork).Hour())
+	if assert.Len(t, points.addEntries, 1) && assert.NotNil(t, points.addEntries[0].IdempotencyKey) {
+		want := "task:106:user:10:period:" + periodStart.In(newYork).Format(time.DateOnly) + ":2"
+		assert.Equal(t, want, *points.addEntries[0].IdempotencyKey)
+	}
+
+	// the limit of the period is reached
+	points.completions[106] = 2
+	err = svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 106})
+	assert.ErrorIs(t, err, ErrTaskAlreadyCompleted)
+	assert.Len(t, points.addEntries, 1)
+}
+
+func TestUsersService_CompleteTask_RecurringUserError(t *testing.T) {
+	task := entity.Task{Id: 107, Kind: entity.TaskKindManual, Points: 3, Recurrence: entity.TaskRecurrenceWeekly, CompletionLimit: 1}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{getByIDErr: errors.New("db")}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 10, TaskId: 107})
+	assert.ErrorIs(t, err, ErrCannotCheckCompletedTask)
+}
+
+func TestUsersService_SetTimezone(t *testing.T) {
+	uRepo := &mockUsersRepo{}
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.SetTimezone(context.Background(), UsersSetTimezoneInput{UserId: 3, Timezone: "Europe/Berlin"})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, uRepo.setTimezoneUserID)
+	assert.Equal(t, "Europe/Berlin", uRepo.setTimezone)
+	assert.Equal(t, 24*time.Hour, uRepo.setTimezoneInterval)
+
+	for _, tz := range []string{"", "Local", "Mars/Olympus"} {
+		err = svc.SetTimezone(context.Background(), UsersSetTimezoneInput{UserId: 3, Timezone: tz})
+		assert.ErrorIs(t, err, ErrInvalidTimezone, tz)
+	}
+
+	uRepo.setTimezoneErr = errors.New("db")
+	err = svc.SetTimezone(context.Background(), UsersSetTimezoneInput{UserId: 3, Timezone: "UTC"})
+	assert.ErrorIs(t, err, ErrCannotSetTimezone)
+}
+
+func TestUsersService_SetTimezone_ChangedRecently(t *testing.T) {
+	uRepo := &mockUsersRepo{usersByID: map[int]entity.User{3: {Id: 3}}, setTimezoneErr: repoerrs.ErrNotFound}
+	svc := NewUsersService(&mockTransactor{}, uRepo, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, &mockVerification{}, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	err := svc.SetTimezone(context.Background(), UsersSetTimezoneInput{UserId: 3, Timezone: "Asia/Tokyo"})
+	assert.ErrorIs(t, err, ErrTimezoneChangedRecently)
+
+	uRepo.getByIDErr = repoerrs.ErrNotFound
+	err = svc.SetTimezone(context.Background(), UsersSetTimezoneInput{UserId: 4, Timezone: "Asia/Tokyo"})
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestUsersService_CompleteTask_Verification(t *testing.T) {
+	task := entity.Task{Id: 4, Kind: entity.TaskKindTelegramSubscription, Points: 25, Metadata: map[string]any{"chat": "@denet"}}
+	newService := func(points *mockPointsRepo, v *mockVerification) *UsersService {
+		return NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, v, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	}
+
+	points := &mockPointsRepo{}
+	v := &mockVerification{}
+	err := newService(points, v).CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 4})
+	assert.NoError(t, err)
+	assert.Equal(t, []verification.VerificationVerifyInput{{UserId: 1, Task: task}}, v.inputs)
+	assert.Len(t, points.addEntries, 1)
+
+	cases := []struct {
+		name string
+		v    *mockVerification
+		err  error
+	}{
+		{"rejected", &mockVerification{status: verifier.StatusRejected}, ErrTaskNotVerified},
+		{"pending", &mockVerification{status: verifier.StatusPending}, ErrVerificationPending},
+		{"verifier error", &mockVerification{err: errors.New("timeout")}, ErrCannotVerifyTask},
+		{"account of another user", &mockVerification{err: verification.ErrAccountClaimed}, ErrAccountAlreadyUsed},
+		{"no linked account", &mockVerification{err: verification.ErrAccountNotLinked}, ErrAccountNotLinked},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			points := &mockPointsRepo{}
+			err := newService(points, tc.v).CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 4})
+			assert.ErrorIs(t, err, tc.err)
+			assert.Empty(t, points.addEntries)
+		})
+	}
+}
+
+func TestUsersService_LinkAccount(t *testing.T) {
+	v := &mockVerification{}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, &mockPointsRepo{}, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{}, v, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+
+	proof := map[string]string{"id": "42", "hash": "abc"}
+	la, err := svc.LinkAccount(context.Background(), UsersLinkAccountInput{UserId: 1, Kind: entity.TaskKindTelegramSubscription, Proof: proof})
+	assert.NoError(t, err)
+	assert.Equal(t, "42", la.Account)
+	assert.Equal(t, []verification.VerificationLinkInput{{UserId: 1, Kind: entity.TaskKindTelegramSubscription, Proof: proof}}, v.linkInputs)
+
+	cases := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"not linkable", verification.ErrNotLinkable, ErrAccountNotLinkable},
+		{"invalid proof", verification.ErrInvalidProof, ErrInvalidAccountProof},
+		{"linked to another user", verification.ErrAccountLinked, ErrAccountAlreadyLinked},
+		{"no verifier", verification.ErrNoVerifier, ErrCannotLinkAccount},
+		{"store error", verification.ErrCannotLink, ErrCannotLinkAccount},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v.err = tc.err
+			_, err := svc.LinkAccount(context.Background(), UsersLinkAccountInput{UserId: 1, Kind: entity.TaskKindTelegramSubscription, Proof: proof})
+			assert.ErrorIs(t, err, tc.want)
+		})
+	}
 }
 
-func strPtr(s string) *string { return &s }
+func TestUsersService_CompleteTask_VerifiesAfterLimit(t *testing.T) {
+	// a completed task is not checked with the external service again
+	task := entity.Task{Id: 4, Kind: entity.TaskKindExternalVerification, Points: 25}
+	v := &mockVerification{}
+	points := &mockPointsRepo{completions: map[int]int{4: 1}}
+	svc := NewUsersService(&mockTransactor{}, &mockUsersRepo{}, points, &mockStreaksRepo{}, &mockReferralsRepo{}, &mockTaskCatalog{allTasks: []entity.Task{task}}, v, &mockAntifraud{}, &mockEmailVerificationsRepo{}, &mockNotifier{}, testEmailVerificationConfig, testStreakConfig, testReferralConfig)
+	err := svc.CompleteTask(context.Background(), UsersCompleteTaskInput{UserId: 1, TaskId: 4})
+	assert.ErrorIs(t, err, ErrTaskAlreadyCompleted)
+	assert.Empty(t, v.inputs)
+}
@@ -0,0 +1,25 @@
+package verification
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/pkg/verifier"
+)
+
+type VerificationVerifyInput struct {
+	UserId int
+	Task   entity.Task
+}
+
+// VerificationLinkInput holds the proof that the user owns an account on the
+// external service of the task kind, as the verifier of the kind expects it.
+type VerificationLinkInput struct {
+	UserId int
+	Kind   entity.TaskKind
+	Proof  map[string]string
+}
+
+type Verification interface {
+	Link(ctx context.Context, input VerificationLinkInput) (entity.LinkedAccount, error)
+	Verify(ctx context.Context, input VerificationVerifyInput) (verifier.Status, error)
+}
@@ -0,0 +1,184 @@
+package verification
+
+import (
+	"context"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/logctx"
+	"denet-test-task/pkg/verifier"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var _ Verification = (*VerificationService)(nil)
+
+var (
+	ErrNoVerifier     = fmt.Errorf("task cannot be verified now")
+	ErrCannotVerify   = fmt.Errorf("cannot verify task")
+	ErrAccountClaimed = fmt.Errorf("account already verified the task for another user")
+
+	ErrNotLinkable      = fmt.Errorf("tasks of the kind need no account")
+	ErrInvalidProof     = fmt.Errorf("invalid account proof")
+	ErrAccountLinked    = fmt.Errorf("account is linked to another user")
+	ErrAccountNotLinked = fmt.Errorf("account is not linked")
+	ErrCannotLink       = fmt.Errorf("cannot link account")
+)
+
+// Config holds how long the results of the verifiers are reused. A pending
+// check is not repeated until PendingTTL passes.
+type Config struct {
+	ResultTTL  time.Duration
+	PendingTTL time.Duration
+}
+
+// VerificationService checks completions of tasks with the verifier of the
+// task kind, for the account the user linked to the kind. The last check of
+// each account is stored with the user it was made for, so an account
+// verifies a task for one user only and the results are shared by all
+// instances.
+type VerificationService struct {
+	verifiers          map[entity.TaskKind]verifier.Verifier
+	taskAccountsRepo   repo.TaskAccounts
+	linkedAccountsRepo repo.LinkedAccounts
+	cfg                Config
+}
+
+func NewVerificationService(verifiers map[entity.TaskKind]verifier.Verifier, taskAccountsRepo repo.TaskAccounts, linkedAccountsRepo repo.LinkedAccounts, cfg Config) *VerificationService {
+	return &VerificationService{
+		verifiers:          verifiers,
+		taskAccountsRepo:   taskAccountsRepo,
+		linkedAccountsRepo: linkedAccountsRepo,
+		cfg:                cfg,
+	}
+}
+
+// Link links the account the proof is for to the user, once the verifier of
+// the kind accepts the proof. An account is linked to one user at a time.
+func (s *VerificationService) Link(ctx context.Context, input VerificationLinkInput) (entity.LinkedAccount, error) {
+	if !input.Kind.RequiresVerification() {
+		return entity.LinkedAccount{}, ErrNotLinkable
+	}
+
+	v, ok := s.verifiers[input.Kind]
+	if !ok {
+		logctx.FromContext(ctx).Error("VerificationService.Link - no verifier", "kind", input.Kind)
+		return entity.LinkedAccount{}, ErrNoVerifier
+	}
+
+	account, err := v.Link(ctx, input.UserId, input.Proof)
+	if err != nil {
+		if errors.Is(err, verifier.ErrInvalidProof) {
+			logctx.FromContext(ctx).Warn("VerificationService.Link - verifier.Link", "kind", input.Kind, "err", err)
+			return entity.LinkedAccount{}, ErrInvalidProof
+		}
+		logctx.FromContext(ctx).Error("VerificationService.Link - verifier.Link", "kind", input.Kind, "err", err)
+		return entity.LinkedAccount{}, ErrCannotLink
+	}
+
+	la := entity.LinkedAccount{
+		UserId:   input.UserId,
+		Kind:     input.Kind,
+		Account:  account,
+		LinkedAt: time.Now(),
+	}
+	err = s.linkedAccountsRepo.SaveLinkedAccount(ctx, la)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			return entity.LinkedAccount{}, ErrAccountLinked
+		}
+		logctx.FromContext(ctx).Error("VerificationService.Link - linkedAccountsRepo.SaveLinkedAccount", "err", err)
+		return entity.LinkedAccount{}, ErrCannotLink
+	}
+	return la, nil
+}
+
+// Verify reports whether the user did what the task asks for, checking the
+// account the user linked to the task kind. Tasks of kinds that do not
+// require verification are always verified.
+func (s *VerificationService) Verify(ctx context.Context, input VerificationVerifyInput) (verifier.Status, error) {
+	if !input.Task.Kind.RequiresVerification() {
+		return verifier.StatusVerified, nil
+	}
+
+	v, ok := s.verifiers[input.Task.Kind]
+	if !ok {
+		logctx.FromContext(ctx).Error("VerificationService.Verify - no verifier", "kind", input.Task.Kind)
+		return "", ErrNoVerifier
+	}
+
+	linked, err := s.linkedAccountsRepo.GetLinkedAccount(ctx, input.UserId, input.Task.Kind)
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrNotFound) {
+			return "", ErrAccountNotLinked
+		}
+		logctx.FromContext(ctx).Error("VerificationService.Verify - linkedAccountsRepo.GetLinkedAccount", "err", err)
+		return "", ErrCannotVerify
+	}
+	account := linked.Account
+
+	now := time.Now()
+	last, err := s.taskAccountsRepo.GetTaskAccount(ctx, input.Task.Id, account)
+	switch {
+	case err == nil:
+		if status, ok := s.reuse(last, input.UserId, now); ok {
+			return status, nil
+		}
+		if last.UserId != input.UserId && verifier.Status(last.Status) == verifier.StatusVerified {
+			return "", ErrAccountClaimed
+		}
+	case !errors.Is(err, repoerrs.ErrNotFound):
+		logctx.FromContext(ctx).Error("VerificationService.Verify - taskAccountsRepo.GetTaskAccount", "err", err)
+		return "", ErrCannotVerify
+	}
+
+	status, err := v.Verify(ctx, verifier.Request{
+		UserId:  input.UserId,
+		TaskId:  input.Task.Id,
+		Account: account,
+		Params:  input.Task.Metadata,
+	})
+	if err != nil {
+		logctx.FromContext(ctx).Error("VerificationService.Verify - verifier.Verify", "kind", input.Task.Kind, "err", err)
+		return "", ErrCannotVerify
+	}
+	if !status.Valid() {
+		logctx.FromContext(ctx).Error("VerificationService.Verify - verifier.Verify: unknown status", "kind", input.Task.Kind, "status", status)
+		return "", ErrCannotVerify
+	}
+
+	// the account may have been verified for another user meanwhile
+	err = s.taskAccountsRepo.SaveTaskAccount(ctx, entity.TaskAccount{
+		TaskId:    input.Task.Id,
+		Account:   account,
+		UserId:    input.UserId,
+		Status:    string(status),
+		CheckedAt: now,
+	})
+	if err != nil {
+		if errors.Is(err, repoerrs.ErrAlreadyExists) {
+			return "", ErrAccountClaimed
+		}
+		logctx.FromContext(ctx).Error("VerificationService.Verify - taskAccountsRepo.SaveTaskAccount", "err", err)
+		return "", ErrCannotVerify
+	}
+	return status, nil
+}
+
+// reuse returns the status of the last check of the account if it was made
+// for the user and has not expired.
+func (s *VerificationService) reuse(last entity.TaskAccount, userId int, now time.Time) (verifier.Status, bool) {
+	if last.UserId != userId {
+		return "", false
+	}
+	status := verifier.Status(last.Status)
+	ttl := s.cfg.ResultTTL
+	if status == verifier.StatusPending {
+		ttl = s.cfg.PendingTTL
+	}
+	if now.Sub(last.CheckedAt) >= ttl {
+		return "", false
+	}
+	return status, true
+}
@@ -0,0 +1,284 @@
+package verification
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"denet-test-task/internal/entity"
+	"denet-test-task/internal/repo"
+	"denet-test-task/internal/repo/repoerrs"
+	"denet-test-task/pkg/verifier"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCallbackServer stands in for the verification service, answering with
+// the status by account and counting the checks.
+func newCallbackServer(t *testing.T, statuses map[string]string, calls *atomic.Int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		var req struct {
+			Account string `json:"account"`
+		}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+
+		status, ok := statuses[req.Account]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"` + status + `"}`))
+	}))
+}
+
+type mockTaskAccountsRepo struct {
+	accounts map[string]entity.TaskAccount
+	err      error
+}
+
+func (m *mockTaskAccountsRepo) GetTaskAccount(_ context.Context, taskId int, account string) (entity.TaskAccount, error) {
+	if m.err != nil {
+		return entity.TaskAccount{}, m.err
+	}
+	ta, ok := m.accounts[fmt.Sprintf("%d:%s", taskId, account)]
+	if !ok {
+		return entity.TaskAccount{}, repoerrs.ErrNotFound
+	}
+	return ta, nil
+}
+
+func (m *mockTaskAccountsRepo) SaveTaskAccount(_ context.Context, ta entity.TaskAccount) error {
+	if m.err != nil {
+		return m.err
+	}
+	key := fmt.Sprintf("%d:%s", ta.TaskId, ta.Account)
+	if last, ok := m.accounts[key]; ok && last.UserId != ta.UserId && last.Status == string(verifier.StatusVerified) {
+		return repoerrs.ErrAlreadyExists
+	}
+	m.accounts[key] = ta
+	return nil
+}
+
+var _ repo.TaskAccounts = (*mockTaskAccountsRepo)(nil)
+
+type mockLinkedAccountsRepo struct {
+	accounts map[int]string
+	err      error
+}
+
+func (m *mockLinkedAccountsRepo) GetLinkedAccount(_ context.Context, userId int, kind entity.TaskKind) (entity.LinkedAccount, error) {
+	if m.err != nil {
+		return entity.LinkedAccount{}, m.err
+	}
+	account, ok := m.accounts[userId]
+	if !ok {
+		return entity.LinkedAccount{}, repoerrs.ErrNotFound
+	}
+	return entity.LinkedAccount{UserId: userId, Kind: kind, Account: account}, nil
+}
+
+func (m *mockLinkedAccountsRepo) SaveLinkedAccount(_ context.Context, la entity.LinkedAccount) error {
+	if m.err != nil {
+		return m.err
+	}
+	for userId, account := range m.accounts {
+		if account == la.Account && userId != la.UserId {
+			return repoerrs.ErrAlreadyExists
+		}
+	}
+	m.accounts[la.UserId] = la.Account
+	return nil
+}
+
+var _ repo.LinkedAccounts = (*mockLinkedAccountsRepo)(nil)
+
+const testSecret = "secret"
+
+func newTestService(srv *httptest.Server) (*VerificationService, *mockTaskAccountsRepo, *mockLinkedAccountsRepo) {
+	accounts := &mockTaskAccountsRepo{accounts: map[string]entity.TaskAccount{}}
+	linked := &mockLinkedAccountsRepo{accounts: map[int]string{}}
+	return NewVerificationService(map[entity.TaskKind]verifier.Verifier{
+		entity.TaskKindExternalVerification: verifier.NewCallbackVerifier(verifier.CallbackConfig{URL: srv.URL, Secret: testSecret}, srv.Client()),
+	}, accounts, linked, Config{ResultTTL: time.Minute, PendingTTL: time.Minute}), accounts, linked
+}
+
+// linkProof returns the link token the verification service issues for the
+// user and the account.
+func linkProof(userId int, account string) map[string]string {
+	expiresAt := time.Now().Add(time.Minute).Unix()
+	mac := hmac.New(sha256.New, []byte(testSecret))
+	fmt.Fprintf(mac, "%d:%s:%d", userId, account, expiresAt)
+	return map[string]string{
+		"account":    account,
+		"expires_at": strconv.FormatInt(expiresAt, 10),
+		"signature":  hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+var externalTask = entity.Task{Id: 5, Kind: entity.TaskKindExternalVerification, Points: 30}
+
+func TestVerificationService_CachesResults(t *testing.T) {
+	var calls atomic.Int32
+	srv := newCallbackServer(t, map[string]string{"ok": "verified", "no": "rejected"}, &calls)
+	defer srv.Close()
+	s, _, linked := newTestService(srv)
+	linked.accounts[1] = "ok"
+	linked.accounts[2] = "no"
+
+	for i := 0; i < 2; i++ {
+		status, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+		assert.NoError(t, err)
+		assert.Equal(t, verifier.StatusVerified, status)
+
+		status, err = s.Verify(context.Background(), VerificationVerifyInput{UserId: 2, Task: externalTask})
+		assert.NoError(t, err)
+		assert.Equal(t, verifier.StatusRejected, status)
+	}
+	assert.Equal(t, int32(2), calls.Load())
+
+	// a verified account is bound to its user, even once linked to another
+	linked.accounts[1] = "other"
+	linked.accounts[2] = "ok"
+	_, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 2, Task: externalTask})
+	assert.ErrorIs(t, err, ErrAccountClaimed)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// a rejected one is not and is checked again for another user
+	linked.accounts[2] = "other"
+	linked.accounts[3] = "no"
+	status, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 3, Task: externalTask})
+	assert.NoError(t, err)
+	assert.Equal(t, verifier.StatusRejected, status)
+	assert.Equal(t, int32(3), calls.Load())
+
+	// accounts are bound per task
+	linked.accounts[3] = "ok"
+	otherTask := externalTask
+	otherTask.Id = 6
+	status, err = s.Verify(context.Background(), VerificationVerifyInput{UserId: 3, Task: otherTask})
+	assert.NoError(t, err)
+	assert.Equal(t, verifier.StatusVerified, status)
+}
+
+func TestVerificationService_Link(t *testing.T) {
+	var calls atomic.Int32
+	srv := newCallbackServer(t, map[string]string{"ok": "verified"}, &calls)
+	defer srv.Close()
+	s, _, linked := newTestService(srv)
+
+	// the task is not checked before the account is linked
+	_, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.ErrorIs(t, err, ErrAccountNotLinked)
+	assert.Equal(t, int32(0), calls.Load())
+
+	la, err := s.Link(context.Background(), VerificationLinkInput{UserId: 1, Kind: entity.TaskKindExternalVerification, Proof: linkProof(1, "ok")})
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", la.Account)
+	assert.Equal(t, "ok", linked.accounts[1])
+
+	status, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.NoError(t, err)
+	assert.Equal(t, verifier.StatusVerified, status)
+
+	// a proof issued for another user links nothing
+	_, err = s.Link(context.Background(), VerificationLinkInput{UserId: 2, Kind: entity.TaskKindExternalVerification, Proof: linkProof(1, "ok")})
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	_, err = s.Link(context.Background(), VerificationLinkInput{UserId: 2, Kind: entity.TaskKindExternalVerification, Proof: linkProof(2, "ok")})
+	assert.ErrorIs(t, err, ErrAccountLinked)
+
+	_, err = s.Link(context.Background(), VerificationLinkInput{UserId: 2, Kind: entity.TaskKindManual, Proof: linkProof(2, "ok")})
+	assert.ErrorIs(t, err, ErrNotLinkable)
+
+	_, err = s.Link(context.Background(), VerificationLinkInput{UserId: 2, Kind: entity.TaskKindTelegramSubscription, Proof: map[string]string{}})
+	assert.ErrorIs(t, err, ErrNoVerifier)
+
+	linked.err = errors.New("db")
+	_, err = s.Link(context.Background(), VerificationLinkInput{UserId: 2, Kind: entity.TaskKindExternalVerification, Proof: linkProof(2, "new")})
+	assert.ErrorIs(t, err, ErrCannotLink)
+	_, err = s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.ErrorIs(t, err, ErrCannotVerify)
+}
+
+func TestVerificationService_Pending(t *testing.T) {
+	var calls atomic.Int32
+	srv := newCallbackServer(t, map[string]string{"later": "pending"}, &calls)
+	defer srv.Close()
+	s, _, linked := newTestService(srv)
+	linked.accounts[1] = "later"
+
+	// the pending check is not repeated until PendingTTL passes
+	for i := 0; i < 2; i++ {
+		status, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+		assert.NoError(t, err)
+		assert.Equal(t, verifier.StatusPending, status)
+	}
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestVerificationService_PendingThenVerified(t *testing.T) {
+	var calls atomic.Int32
+	statuses := map[string]string{"later": "pending"}
+	srv := newCallbackServer(t, statuses, &calls)
+	defer srv.Close()
+	s, accounts, linked := newTestService(srv)
+	linked.accounts[1] = "later"
+
+	status, _ := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.Equal(t, verifier.StatusPending, status)
+
+	// PendingTTL passed
+	statuses["later"] = "verified"
+	last := accounts.accounts["5:later"]
+	last.CheckedAt = last.CheckedAt.Add(-time.Minute)
+	accounts.accounts["5:later"] = last
+	status, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.NoError(t, err)
+	assert.Equal(t, verifier.StatusVerified, status)
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestVerificationService_Errors(t *testing.T) {
+	var calls atomic.Int32
+	srv := newCallbackServer(t, map[string]string{}, &calls)
+	defer srv.Close()
+	s, _, linked := newTestService(srv)
+	linked.accounts[1] = "down"
+
+	_, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.ErrorIs(t, err, ErrCannotVerify)
+
+	// failures are not cached
+	_, err = s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.ErrorIs(t, err, ErrCannotVerify)
+	assert.Equal(t, int32(2), calls.Load())
+
+	// nor are the failures of the store
+	s, accounts, linked := newTestService(srv)
+	linked.accounts[1] = "down"
+	accounts.err = errors.New("db")
+	_, err = s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: externalTask})
+	assert.ErrorIs(t, err, ErrCannotVerify)
+	assert.Equal(t, int32(2), calls.Load())
+
+	telegramTask := entity.Task{Id: 4, Kind: entity.TaskKindTelegramSubscription}
+	_, err = s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: telegramTask})
+	assert.ErrorIs(t, err, ErrNoVerifier)
+}
+
+func TestVerificationService_ManualTask(t *testing.T) {
+	s := NewVerificationService(nil, &mockTaskAccountsRepo{}, &mockLinkedAccountsRepo{}, Config{ResultTTL: time.Minute, PendingTTL: time.Minute})
+	status, err := s.Verify(context.Background(), VerificationVerifyInput{UserId: 1, Task: entity.Task{Id: 1, Kind: entity.TaskKindManual}})
+	assert.NoError(t, err)
+	assert.Equal(t, verifier.StatusVerified, status)
+}
@@ -0,0 +1,3 @@
+-- User roles embedded into issued JWTs
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user'
+  CONSTRAINT users_role_check CHECK (role IN ('user', 'moderator', 'admin'));
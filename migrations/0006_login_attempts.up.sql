@@ -0,0 +1,7 @@
+-- Failed sign-in attempts per key ("user:<username>" or "ip:<address>")
+CREATE TABLE IF NOT EXISTS login_attempts (
+  key            TEXT PRIMARY KEY,
+  failures       INTEGER     NOT NULL DEFAULT 0,
+  last_failed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  locked_until   TIMESTAMPTZ NULL
+);
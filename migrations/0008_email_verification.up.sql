@@ -0,0 +1,16 @@
+-- Set once the owner of users.email confirmed it; reset when the email changes
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ NULL;
+
+-- Verification codes sent to a newly set email (only the hash is stored)
+CREATE TABLE IF NOT EXISTS email_verifications (
+  id         SERIAL PRIMARY KEY,
+  user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  email      TEXT        NOT NULL,
+  code_hash  TEXT        NOT NULL,
+  attempts   INTEGER     NOT NULL DEFAULT 0,
+  expires_at TIMESTAMPTZ NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  used_at    TIMESTAMPTZ NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_verifications_user_id ON email_verifications(user_id);
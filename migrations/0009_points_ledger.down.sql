@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS points (
+  user_id INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  task_id INTEGER     NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+  points  INTEGER     NOT NULL DEFAULT 0,
+  upd_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, task_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_points_user_id ON points(user_id);
+CREATE INDEX IF NOT EXISTS idx_points_task_id ON points(task_id);
+CREATE INDEX IF NOT EXISTS idx_points_user_id_upd_at ON points(user_id, upd_at DESC);
+
+-- restore the running total at the latest entry of each task
+INSERT INTO points (user_id, task_id, points, upd_at)
+SELECT DISTINCT ON (user_id, task_id) user_id, task_id, running, created_at
+FROM (
+  SELECT id, user_id, task_id, created_at,
+         SUM(delta) OVER (PARTITION BY user_id ORDER BY created_at, id) AS running
+  FROM points_ledger
+) l
+WHERE task_id IS NOT NULL
+ORDER BY user_id, task_id, created_at DESC, id DESC;
+
+DROP TABLE IF EXISTS point_balances;
+DROP TABLE IF EXISTS points_ledger;
@@ -0,0 +1,47 @@
+-- Append-only ledger of points movements; a balance is the sum of its deltas
+CREATE TABLE IF NOT EXISTS points_ledger (
+  id              BIGSERIAL PRIMARY KEY,
+  user_id         INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  task_id         INTEGER     NULL REFERENCES tasks(id) ON DELETE SET NULL,
+  delta           INTEGER     NOT NULL,
+  reason          TEXT        NOT NULL,
+  idempotency_key TEXT        NULL UNIQUE,
+  created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_points_ledger_user_id_created_at ON points_ledger(user_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_points_ledger_user_id_task_id ON points_ledger(user_id, task_id);
+
+-- Materialized balance per user, updated together with every ledger entry
+CREATE TABLE IF NOT EXISTS point_balances (
+  user_id    INTEGER     PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+  balance    INTEGER     NOT NULL DEFAULT 0,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_point_balances_balance ON point_balances(balance DESC);
+
+-- points.points held the running total of the user at the time of the award,
+-- so the granted amount is the difference with the previous row
+INSERT INTO points_ledger (user_id, task_id, delta, reason, idempotency_key, created_at)
+SELECT
+  user_id,
+  task_id,
+  points - LAG(points, 1, 0) OVER (PARTITION BY user_id ORDER BY upd_at, task_id),
+  CASE task_id
+    WHEN 1 THEN 'referral_given'
+    WHEN 2 THEN 'referral_received'
+    WHEN 5 THEN 'email_verified'
+    ELSE 'task_completed'
+  END,
+  'task:' || task_id || ':user:' || user_id,
+  upd_at
+FROM points
+ORDER BY upd_at, task_id;
+
+INSERT INTO point_balances (user_id, balance, updated_at)
+SELECT user_id, SUM(delta), MAX(created_at)
+FROM points_ledger
+GROUP BY user_id;
+
+DROP TABLE IF EXISTS points;
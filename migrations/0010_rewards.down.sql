@@ -0,0 +1,2 @@
+ALTER TABLE points_ledger DROP COLUMN IF EXISTS reward_id;
+DROP TABLE IF EXISTS rewards;
@@ -0,0 +1,15 @@
+-- Rewards users can spend points on. A NULL stock is unlimited; a NULL bound
+-- of the active window is open.
+CREATE TABLE IF NOT EXISTS rewards (
+  id           SERIAL PRIMARY KEY,
+  name         TEXT        NOT NULL UNIQUE,
+  descr        TEXT        NOT NULL DEFAULT '',
+  cost         INTEGER     NOT NULL CHECK (cost > 0),
+  stock        INTEGER     NULL CHECK (stock >= 0),
+  active_from  TIMESTAMPTZ NULL,
+  active_until TIMESTAMPTZ NULL,
+  created_at   TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Redemptions are recorded in the ledger with the redeemed reward
+ALTER TABLE points_ledger ADD COLUMN IF NOT EXISTS reward_id INTEGER NULL REFERENCES rewards(id) ON DELETE SET NULL;
@@ -0,0 +1,15 @@
+-- Responses of mutating requests sent with an Idempotency-Key header. A NULL
+-- status marks a request still being processed.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+  user_id       INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  key           TEXT        NOT NULL,
+  fingerprint   TEXT        NOT NULL,
+  status        INTEGER     NULL,
+  content_type  TEXT        NOT NULL DEFAULT '',
+  response_body BYTEA       NULL,
+  created_at    TIMESTAMPTZ NOT NULL DEFAULT now(),
+  expires_at    TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON idempotency_keys(expires_at);
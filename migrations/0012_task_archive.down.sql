@@ -0,0 +1,2 @@
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_points_positive;
+ALTER TABLE tasks DROP COLUMN IF EXISTS archived_at;
@@ -0,0 +1,4 @@
+-- Archived tasks are hidden from the task list and cannot be completed; a
+-- NULL archived_at is an active task.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ NULL;
+ALTER TABLE tasks ADD CONSTRAINT tasks_points_positive CHECK (points > 0);
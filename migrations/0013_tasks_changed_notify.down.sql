@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS tasks_changed ON tasks;
+DROP FUNCTION IF EXISTS notify_tasks_changed();
@@ -0,0 +1,12 @@
+-- Notify the running services that the tasks changed, so they reload their
+-- task catalog.
+CREATE OR REPLACE FUNCTION notify_tasks_changed() RETURNS trigger AS $$
+BEGIN
+  PERFORM pg_notify('tasks_changed', '');
+  RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER tasks_changed
+  AFTER INSERT OR UPDATE OR DELETE OR TRUNCATE ON tasks
+  FOR EACH STATEMENT EXECUTE FUNCTION notify_tasks_changed();
@@ -0,0 +1,2 @@
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_kind_check;
+ALTER TABLE tasks DROP COLUMN IF EXISTS metadata, DROP COLUMN IF EXISTS kind;
@@ -0,0 +1,13 @@
+-- The kind of a task decides how it is completed; metadata holds kind
+-- specific settings.
+ALTER TABLE tasks
+  ADD COLUMN IF NOT EXISTS kind     TEXT  NOT NULL DEFAULT 'manual',
+  ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+
+ALTER TABLE tasks ADD CONSTRAINT tasks_kind_check
+  CHECK (kind IN ('manual', 'referral_giver', 'referral_receiver', 'email', 'external_verification'));
+
+UPDATE tasks SET kind = 'referral_giver'        WHERE name = 'give_referral';
+UPDATE tasks SET kind = 'referral_receiver'     WHERE name = 'get_referral';
+UPDATE tasks SET kind = 'email'                 WHERE name = 'complete_email';
+UPDATE tasks SET kind = 'external_verification' WHERE name IN ('subscribe_telegram', 'subscribe_twitter');
@@ -0,0 +1,13 @@
+DROP INDEX IF EXISTS idx_points_ledger_user_id_task_id_created_at;
+CREATE INDEX IF NOT EXISTS idx_points_ledger_user_id_task_id ON points_ledger(user_id, task_id);
+
+ALTER TABLE users DROP COLUMN IF EXISTS timezone;
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_window_check;
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_completion_limit_positive;
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_recurrence_check;
+ALTER TABLE tasks
+  DROP COLUMN IF EXISTS completion_limit,
+  DROP COLUMN IF EXISTS recurrence,
+  DROP COLUMN IF EXISTS ends_at,
+  DROP COLUMN IF EXISTS starts_at;
@@ -0,0 +1,19 @@
+-- Tasks may be limited to a time window and repeated once per day or week.
+-- completion_limit is the number of completions per period; a one-time task
+-- has a single period covering all time.
+ALTER TABLE tasks
+  ADD COLUMN IF NOT EXISTS starts_at        TIMESTAMPTZ NULL,
+  ADD COLUMN IF NOT EXISTS ends_at          TIMESTAMPTZ NULL,
+  ADD COLUMN IF NOT EXISTS recurrence       TEXT        NOT NULL DEFAULT 'once',
+  ADD COLUMN IF NOT EXISTS completion_limit INTEGER     NOT NULL DEFAULT 1;
+
+ALTER TABLE tasks ADD CONSTRAINT tasks_recurrence_check CHECK (recurrence IN ('once', 'daily', 'weekly'));
+ALTER TABLE tasks ADD CONSTRAINT tasks_completion_limit_positive CHECK (completion_limit > 0);
+ALTER TABLE tasks ADD CONSTRAINT tasks_window_check CHECK (starts_at IS NULL OR ends_at IS NULL OR starts_at < ends_at);
+
+-- Periods of recurring tasks start at midnight in the user's timezone
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+
+-- Completions of a task are counted since the start of the current period
+DROP INDEX IF EXISTS idx_points_ledger_user_id_task_id;
+CREATE INDEX IF NOT EXISTS idx_points_ledger_user_id_task_id_created_at ON points_ledger(user_id, task_id, created_at);
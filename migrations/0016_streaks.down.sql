@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS streaks;
+
+ALTER TABLE tasks DROP COLUMN IF EXISTS streak_group;
@@ -0,0 +1,14 @@
+-- Completions of the tasks of a streak group on consecutive days make up a
+-- streak of the user. An empty group is not tracked.
+ALTER TABLE tasks ADD COLUMN IF NOT EXISTS streak_group TEXT NOT NULL DEFAULT '';
+
+-- last_day is the day of the last completion in the user's timezone
+CREATE TABLE IF NOT EXISTS streaks (
+  user_id        INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  streak_group   TEXT        NOT NULL,
+  current_streak INTEGER     NOT NULL,
+  longest_streak INTEGER     NOT NULL,
+  last_day       DATE        NOT NULL,
+  updated_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, streak_group)
+);
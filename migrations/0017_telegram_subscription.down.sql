@@ -0,0 +1,5 @@
+UPDATE tasks SET kind = 'external_verification' WHERE kind = 'telegram_subscription';
+
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_kind_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_kind_check
+  CHECK (kind IN ('manual', 'referral_giver', 'referral_receiver', 'email', 'external_verification'));
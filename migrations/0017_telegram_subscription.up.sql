@@ -0,0 +1,7 @@
+-- Subscriptions to Telegram chats are checked with the Bot API; the chat is
+-- set in metadata.chat by an administrator.
+ALTER TABLE tasks DROP CONSTRAINT IF EXISTS tasks_kind_check;
+ALTER TABLE tasks ADD CONSTRAINT tasks_kind_check
+  CHECK (kind IN ('manual', 'referral_giver', 'referral_receiver', 'email', 'external_verification', 'telegram_subscription'));
+
+UPDATE tasks SET kind = 'telegram_subscription' WHERE name = 'subscribe_telegram';
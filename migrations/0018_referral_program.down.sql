@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_points_ledger_user_id_referral_id;
+ALTER TABLE points_ledger DROP COLUMN IF EXISTS referral_id;
+
+DROP TABLE IF EXISTS referral_tiers;
+
+DROP INDEX IF EXISTS idx_users_referrer;
+ALTER TABLE users DROP COLUMN IF EXISTS referral_code;
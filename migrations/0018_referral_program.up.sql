@@ -0,0 +1,26 @@
+-- Users share their referral code; signing up with it makes them the referrer
+ALTER TABLE users ADD COLUMN IF NOT EXISTS referral_code TEXT NULL UNIQUE;
+
+UPDATE users SET referral_code = upper(substr(md5(random()::text || id::text), 1, 10))
+WHERE referral_code IS NULL;
+
+ALTER TABLE users ALTER COLUMN referral_code SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_users_referrer ON users(referrer);
+
+-- A referrer is paid percent of the task points earned by the referrals at
+-- the level; level 1 are the direct referrals
+CREATE TABLE IF NOT EXISTS referral_tiers (
+  level   INTEGER      PRIMARY KEY CHECK (level > 0),
+  percent NUMERIC(5,2) NOT NULL CHECK (percent > 0 AND percent <= 100)
+);
+
+INSERT INTO referral_tiers (level, percent) VALUES
+  (1, 20),
+  (2, 10)
+ON CONFLICT (level) DO NOTHING;
+
+-- referral_id is the referral the points of the referrer were earned for
+ALTER TABLE points_ledger ADD COLUMN IF NOT EXISTS referral_id INTEGER NULL REFERENCES users(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_points_ledger_user_id_referral_id ON points_ledger(user_id, referral_id) WHERE referral_id IS NOT NULL;
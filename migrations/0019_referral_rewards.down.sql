@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS referral_rewards;
+
+ALTER TABLE users DROP COLUMN IF EXISTS signup_ip;
@@ -0,0 +1,24 @@
+-- signup_ip lets the anti-fraud rules compare the networks of a referral and
+-- their referrer
+ALTER TABLE users ADD COLUMN IF NOT EXISTS signup_ip INET NULL;
+
+-- The bonus of the referrer for a referral is held until the anti-fraud rules
+-- or an admin release or reject it; reason is the rule that held it
+CREATE TABLE IF NOT EXISTS referral_rewards (
+  id          SERIAL PRIMARY KEY,
+  referrer_id INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  referral_id INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  task_id     INTEGER     NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+  points      INTEGER     NOT NULL,
+  status      TEXT        NOT NULL DEFAULT 'pending'
+    CHECK (status IN ('pending', 'review', 'released', 'rejected')),
+  reason      TEXT        NOT NULL DEFAULT '',
+  created_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+  decided_at  TIMESTAMPTZ NULL,
+  UNIQUE (referral_id, task_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_status_id ON referral_rewards(status, id)
+  WHERE status IN ('pending', 'review');
+CREATE INDEX IF NOT EXISTS idx_referral_rewards_referrer_decided_at ON referral_rewards(referrer_id, decided_at)
+  WHERE status = 'released';
@@ -0,0 +1,12 @@
+-- An account on an external service verifies a task for one user only; the
+-- row also keeps the last check of the account, shared by all instances.
+-- An account is claimed once it is verified: until then another user may
+-- take it over, so accounts cannot be squatted with failing checks.
+CREATE TABLE IF NOT EXISTS task_accounts (
+  task_id    INTEGER     NOT NULL REFERENCES tasks(id) ON DELETE CASCADE,
+  account    TEXT        NOT NULL,
+  user_id    INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  status     TEXT        NOT NULL CHECK (status IN ('verified', 'rejected', 'pending')),
+  checked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (task_id, account)
+);
@@ -0,0 +1,4 @@
+-- The timezone decides where the periods of recurring tasks start, so it may
+-- be changed only once a day: switching zones back and forth would open new
+-- periods early.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS timezone_changed_at TIMESTAMPTZ NULL;
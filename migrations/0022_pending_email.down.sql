@@ -0,0 +1,17 @@
+-- Put the latest pending email of each user without an email back on the
+-- user as unverified, unless another user has taken the address meanwhile
+-- or a newer pending one claims it. Emails marked verified by the up
+-- migration stay verified.
+UPDATE users u SET email = p.email
+FROM (
+  SELECT DISTINCT ON (email) user_id, email
+  FROM (
+    SELECT DISTINCT ON (user_id) id, user_id, email
+    FROM email_verifications
+    WHERE used_at IS NULL
+    ORDER BY user_id, id DESC
+  ) latest
+  ORDER BY email, id DESC
+) p
+WHERE u.id = p.user_id AND u.email IS NULL
+  AND NOT EXISTS (SELECT 1 FROM users o WHERE o.email = p.email);
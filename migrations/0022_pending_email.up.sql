@@ -0,0 +1,20 @@
+-- An email becomes users.email only once it is verified; until then it is
+-- kept on its verification, so an unverified address no longer holds the
+-- unique users.email.
+
+-- Emails set before 0008 have no verification: their owners were trusted
+-- and rewarded then, so they are kept as verified.
+UPDATE users u SET email_verified_at = now()
+WHERE u.email IS NOT NULL AND u.email_verified_at IS NULL
+  AND NOT EXISTS (SELECT 1 FROM email_verifications v WHERE v.user_id = u.id);
+
+-- The other unverified emails must be kept on a verification before they
+-- are cleared. SetEmail used to write both in one transaction, so the row
+-- is normally there; an expired one keeps the address for the down migration.
+INSERT INTO email_verifications (user_id, email, code_hash, expires_at)
+SELECT u.id, u.email, '', now()
+FROM users u
+WHERE u.email IS NOT NULL AND u.email_verified_at IS NULL
+  AND NOT EXISTS (SELECT 1 FROM email_verifications v WHERE v.user_id = u.id AND v.email = u.email);
+
+UPDATE users SET email = NULL WHERE email IS NOT NULL AND email_verified_at IS NULL;
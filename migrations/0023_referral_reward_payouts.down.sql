@@ -0,0 +1,2 @@
+-- Nothing to undo: the points were owed for released rewards and may have
+-- been spent since.
@@ -0,0 +1,23 @@
+-- Rewards used to be paid with the key of the task, so only the first
+-- released referral of a referrer was paid for each task. Pay the released
+-- rewards missing from the ledger with the per-referral key.
+WITH unpaid AS (
+  SELECT r.referrer_id, r.referral_id, r.task_id, r.points
+  FROM referral_rewards r
+  WHERE r.status = 'released'
+    AND NOT EXISTS (
+      SELECT 1 FROM points_ledger l
+      WHERE l.user_id = r.referrer_id AND l.referral_id = r.referral_id
+        AND l.task_id = r.task_id AND l.reason = 'referral_given'
+    )
+), paid AS (
+  INSERT INTO points_ledger (user_id, task_id, referral_id, delta, reason, idempotency_key)
+  SELECT referrer_id, task_id, referral_id, points, 'referral_given',
+         'referral:' || referral_id || ':task:' || task_id
+  FROM unpaid
+  ON CONFLICT (idempotency_key) DO NOTHING
+  RETURNING user_id, delta
+)
+INSERT INTO point_balances (user_id, balance)
+SELECT user_id, sum(delta) FROM paid GROUP BY user_id
+ON CONFLICT (user_id) DO UPDATE SET balance = point_balances.balance + EXCLUDED.balance, updated_at = now();
@@ -0,0 +1,11 @@
+-- An account on an external service is linked to a user once the user proves
+-- they own it; tasks of the kind are verified with the linked account only.
+-- An account is linked to one user at a time.
+CREATE TABLE IF NOT EXISTS linked_accounts (
+  user_id   INTEGER     NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  kind      TEXT        NOT NULL,
+  account   TEXT        NOT NULL,
+  linked_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  PRIMARY KEY (user_id, kind),
+  UNIQUE (kind, account)
+);
@@ -0,0 +1,105 @@
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Resolver finds the address of the client that sent a request. Forwarding
+// headers are honored only when the request came from a trusted proxy: anyone
+// else could put any address there.
+type Resolver struct {
+	trusted []netip.Prefix
+}
+
+// New builds a Resolver trusting the given proxies, each one an address or a
+// CIDR. Without proxies the headers are ignored and RemoteAddr is used.
+func New(trustedProxies []string) (*Resolver, error) {
+	trusted := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, proxy := range trustedProxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(proxy); err == nil {
+			trusted = append(trusted, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+		}
+		addr = addr.Unmap()
+		trusted = append(trusted, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return &Resolver{trusted: trusted}, nil
+}
+
+// IP returns the client address of r. When the peer is a trusted proxy,
+// X-Forwarded-For is walked from the right and the first address not
+// belonging to a trusted proxy is the client; X-Real-IP is used when there is
+// no X-Forwarded-For.
+func (res *Resolver) IP(r *http.Request) string {
+	remote := remoteHost(r.RemoteAddr)
+	if !res.isTrusted(remote) {
+		return remote
+	}
+
+	if hops := forwardedFor(r); len(hops) > 0 {
+		for i := len(hops) - 1; i >= 0; i-- {
+			addr, err := netip.ParseAddr(hops[i])
+			if err != nil {
+				// garbage before a trusted hop was not written by our proxies
+				return remote
+			}
+			if !res.isTrusted(addr.Unmap().String()) || i == 0 {
+				return addr.Unmap().String()
+			}
+		}
+	}
+
+	if xrip := strings.TrimSpace(r.Header.Get("X-Real-IP")); xrip != "" {
+		if addr, err := netip.ParseAddr(xrip); err == nil {
+			return addr.Unmap().String()
+		}
+	}
+	return remote
+}
+
+func (res *Resolver) isTrusted(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range res.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedFor returns the hops of all X-Forwarded-For headers in order.
+func forwardedFor(r *http.Request) []string {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	return hops
+}
+
+func remoteHost(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil || host == "" {
+		return remoteAddr
+	}
+	return host
+}
@@ -0,0 +1,84 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew_InvalidProxy(t *testing.T) {
+	_, err := New([]string{"10.0.0.0/8", "not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestResolver_IP(t *testing.T) {
+	res, err := New([]string{"10.0.0.0/8", "192.168.1.1"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		{name: "direct", remote: "203.0.113.7:5000", want: "203.0.113.7"},
+		{
+			name:    "spoofed header from an untrusted peer",
+			remote:  "203.0.113.7:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"},
+			want:    "203.0.113.7",
+		},
+		{
+			name:    "trusted proxy",
+			remote:  "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "client prepends a fake hop",
+			remote:  "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.9, 192.168.1.1"},
+			want:    "198.51.100.9",
+		},
+		{
+			name:    "garbage hop",
+			remote:  "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, junk"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "only trusted hops",
+			remote:  "10.0.0.2:5000",
+			headers: map[string]string{"X-Forwarded-For": "10.1.1.1, 10.0.0.3"},
+			want:    "10.1.1.1",
+		},
+		{
+			name:    "x-real-ip from a trusted proxy",
+			remote:  "192.168.1.1:5000",
+			headers: map[string]string{"X-Real-IP": "198.51.100.9"},
+			want:    "198.51.100.9",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			assert.Equal(t, tt.want, res.IP(r))
+		})
+	}
+}
+
+func TestResolver_IP_NoTrustedProxies(t *testing.T) {
+	res, err := New(nil)
+	assert.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.2:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.9")
+	assert.Equal(t, "10.0.0.2", res.IP(r))
+}
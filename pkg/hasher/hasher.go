@@ -1,25 +1,63 @@
 package hasher
 
 import (
-	"crypto/sha1"
-	"fmt"
+	"errors"
 )
 
+var (
+	ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+	ErrMalformedHash    = errors.New("malformed password hash")
+)
+
+// PasswordHasher hashes passwords into self-describing encoded strings
+// (algorithm, parameters and salt are stored next to the digest).
 type PasswordHasher interface {
-	Hash(password string) string
+	Hash(password string) (string, error)
+	Verify(password, encodedHash string) (bool, error)
+	// NeedsRehash reports whether encodedHash was produced by an outdated
+	// algorithm or with weaker parameters than the current ones.
+	NeedsRehash(encodedHash string) bool
+}
+
+// Algorithm is a PasswordHasher that can recognize its own encoded hashes.
+type Algorithm interface {
+	PasswordHasher
+	Supports(encodedHash string) bool
 }
 
-type SHA1Hasher struct {
-	salt string
+var _ PasswordHasher = (*Chain)(nil)
+
+// Chain hashes new passwords with the preferred algorithm and verifies
+// hashes produced by any of the known algorithms, so stored hashes can be
+// upgraded transparently.
+type Chain struct {
+	preferred  Algorithm
+	algorithms []Algorithm
+}
+
+func NewChain(preferred Algorithm, legacy ...Algorithm) *Chain {
+	return &Chain{
+		preferred:  preferred,
+		algorithms: append([]Algorithm{preferred}, legacy...),
+	}
 }
 
-func NewSHA1Hasher(salt string) *SHA1Hasher {
-	return &SHA1Hasher{salt: salt}
+func (c *Chain) Hash(password string) (string, error) {
+	return c.preferred.Hash(password)
 }
 
-func (h *SHA1Hasher) Hash(password string) string {
-	hash := sha1.New()
-	hash.Write([]byte(password))
+func (c *Chain) Verify(password, encodedHash string) (bool, error) {
+	for _, alg := range c.algorithms {
+		if alg.Supports(encodedHash) {
+			return alg.Verify(password, encodedHash)
+		}
+	}
+	return false, ErrUnknownAlgorithm
+}
 
-	return fmt.Sprintf("%x", hash.Sum([]byte(h.salt)))
+func (c *Chain) NeedsRehash(encodedHash string) bool {
+	if !c.preferred.Supports(encodedHash) {
+		return true
+	}
+	return c.preferred.NeedsRehash(encodedHash)
 }
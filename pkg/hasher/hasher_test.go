@@ -3,21 +3,34 @@ package hasher
 import (
 	"crypto/sha1"
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
 )
 
+var testArgon2idParams = Argon2idParams{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
 func TestSHA1Hasher_Hash_IsDeterministic(t *testing.T) {
 	h := NewSHA1Hasher("salt")
-	got1 := h.Hash("password")
-	got2 := h.Hash("password")
+	got1, err := h.Hash("password")
+	assert.NoError(t, err)
+	got2, err := h.Hash("password")
+	assert.NoError(t, err)
 	assert.Equal(t, got1, got2, "expected deterministic hash")
 }
 
 func TestSHA1Hasher_Hash_MatchesAlgorithm(t *testing.T) {
 	h := NewSHA1Hasher("pepper")
-	got := h.Hash("p@ssW0rd")
+	got, err := h.Hash("p@ssW0rd")
+	assert.NoError(t, err)
 
 	hash := sha1.New()
 	hash.Write([]byte("p@ssW0rd"))
@@ -25,3 +38,104 @@ func TestSHA1Hasher_Hash_MatchesAlgorithm(t *testing.T) {
 
 	assert.Equal(t, expected, got, "unexpected hash")
 }
+
+func TestSHA1Hasher_VerifyAndSupports(t *testing.T) {
+	h := NewSHA1Hasher("pepper")
+	encoded, _ := h.Hash("p@ssW0rd")
+
+	assert.True(t, h.Supports(encoded))
+	assert.False(t, h.Supports("$argon2id$v=19$m=1,t=1,p=1$c2FsdA$a2V5"))
+	ok, err := h.Verify("p@ssW0rd", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = h.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.True(t, h.NeedsRehash(encoded))
+}
+
+func TestArgon2idHasher_HashAndVerify(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2idParams)
+	encoded, err := h.Hash("p@ssW0rd")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$"))
+
+	ok, err := h.Verify("p@ssW0rd", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = h.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestArgon2idHasher_Hash_UsesRandomSalt(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2idParams)
+	got1, _ := h.Hash("password")
+	got2, _ := h.Hash("password")
+	assert.NotEqual(t, got1, got2)
+}
+
+func TestArgon2idHasher_NeedsRehash(t *testing.T) {
+	weak := NewArgon2idHasher(testArgon2idParams)
+	encoded, _ := weak.Hash("password")
+	assert.False(t, weak.NeedsRehash(encoded))
+
+	stronger := testArgon2idParams
+	stronger.Iterations = 3
+	assert.True(t, NewArgon2idHasher(stronger).NeedsRehash(encoded))
+}
+
+func TestArgon2idHasher_Verify_Malformed(t *testing.T) {
+	h := NewArgon2idHasher(testArgon2idParams)
+	_, err := h.Verify("password", "$argon2id$v=19$garbage")
+	assert.ErrorIs(t, err, ErrMalformedHash)
+}
+
+func TestBcryptHasher_HashAndVerify(t *testing.T) {
+	h := NewBcryptHasher(bcrypt.MinCost)
+	encoded, err := h.Hash("p@ssW0rd")
+	assert.NoError(t, err)
+	assert.True(t, h.Supports(encoded))
+
+	ok, err := h.Verify("p@ssW0rd", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = h.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.False(t, h.NeedsRehash(encoded))
+	assert.True(t, NewBcryptHasher(bcrypt.MinCost+1).NeedsRehash(encoded))
+}
+
+func TestChain_VerifiesLegacyAndRequestsRehash(t *testing.T) {
+	legacy := NewSHA1Hasher("salt")
+	c := NewChain(NewArgon2idHasher(testArgon2idParams), NewBcryptHasher(bcrypt.MinCost), legacy)
+
+	legacyHash, _ := legacy.Hash("password")
+	ok, err := c.Verify("password", legacyHash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, c.NeedsRehash(legacyHash))
+
+	bcryptHash, _ := NewBcryptHasher(bcrypt.MinCost).Hash("password")
+	ok, err = c.Verify("password", bcryptHash)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, c.NeedsRehash(bcryptHash))
+
+	current, err := c.Hash("password")
+	assert.NoError(t, err)
+	ok, err = c.Verify("password", current)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, c.NeedsRehash(current))
+}
+
+func TestChain_Verify_UnknownAlgorithm(t *testing.T) {
+	c := NewChain(NewArgon2idHasher(testArgon2idParams))
+	_, err := c.Verify("password", "$unknown$hash")
+	assert.ErrorIs(t, err, ErrUnknownAlgorithm)
+}
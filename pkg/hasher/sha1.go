@@ -0,0 +1,45 @@
+package hasher
+
+import (
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+)
+
+var _ Algorithm = (*SHA1Hasher)(nil)
+
+// SHA1Hasher is the legacy unsalted-per-user digest. It is kept only to
+// verify hashes created before the switch to adaptive algorithms; every
+// hash it recognizes is reported as needing a rehash.
+type SHA1Hasher struct {
+	salt string
+}
+
+func NewSHA1Hasher(salt string) *SHA1Hasher {
+	return &SHA1Hasher{salt: salt}
+}
+
+func (h *SHA1Hasher) Hash(password string) (string, error) {
+	hash := sha1.New()
+	hash.Write([]byte(password))
+
+	return fmt.Sprintf("%x", hash.Sum([]byte(h.salt))), nil
+}
+
+func (h *SHA1Hasher) Verify(password, encodedHash string) (bool, error) {
+	expected, _ := h.Hash(password)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(encodedHash)) == 1, nil
+}
+
+func (h *SHA1Hasher) NeedsRehash(_ string) bool {
+	return true
+}
+
+func (h *SHA1Hasher) Supports(encodedHash string) bool {
+	if len(encodedHash) != hex.EncodedLen(len(h.salt)+sha1.Size) {
+		return false
+	}
+	_, err := hex.DecodeString(encodedHash)
+	return err == nil
+}
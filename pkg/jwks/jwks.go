@@ -0,0 +1,124 @@
+package jwks
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+)
+
+// JSONWebKey is the public part of a key as published in a JWKS document (RFC 7517).
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP (Ed25519)
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// KeySet holds the active signing key and the previous keys that are still
+// accepted for verification until their grace period elapses.
+type KeySet struct {
+	active      Key
+	keys        map[string]Key
+	gracePeriod time.Duration
+	now         func() time.Time
+}
+
+func NewKeySet(active Key, gracePeriod time.Duration, previous ...Key) (*KeySet, error) {
+	if active.Private == nil {
+		return nil, ErrNoSigningKey
+	}
+
+	set := &KeySet{
+		active:      active,
+		keys:        make(map[string]Key, len(previous)+1),
+		gracePeriod: gracePeriod,
+		now:         time.Now,
+	}
+	set.keys[active.Id] = active
+
+	for _, key := range previous {
+		if _, ok := set.keys[key.Id]; ok {
+			return nil, fmt.Errorf("jwks.NewKeySet: duplicate kid %q", key.Id)
+		}
+		set.keys[key.Id] = key
+	}
+	return set, nil
+}
+
+// Active returns the key new tokens are signed with.
+func (s *KeySet) Active() Key {
+	return s.active
+}
+
+// Lookup returns the key with the given kid if it may still verify tokens.
+func (s *KeySet) Lookup(kid string) (Key, bool) {
+	key, ok := s.keys[kid]
+	if !ok || !s.accepted(key) {
+		return Key{}, false
+	}
+	return key, true
+}
+
+func (s *KeySet) accepted(key Key) bool {
+	if key.Id == s.active.Id || key.RetiredAt.IsZero() {
+		return true
+	}
+	return s.now().Before(key.RetiredAt.Add(s.gracePeriod))
+}
+
+// JWKS returns the public keys that may verify tokens. Symmetric keys are
+// never published.
+func (s *KeySet) JWKS() JSONWebKeySet {
+	set := JSONWebKeySet{Keys: []JSONWebKey{}}
+
+	// active key first, so clients picking the first key get the right one
+	previous := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		if key.Id != s.active.Id {
+			previous = append(previous, key)
+		}
+	}
+	sort.Slice(previous, func(i, j int) bool { return previous[i].Id < previous[j].Id })
+	ordered := append([]Key{s.active}, previous...)
+
+	for _, key := range ordered {
+		if !s.accepted(key) {
+			continue
+		}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "RSA",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Algorithm,
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			set.Keys = append(set.Keys, JSONWebKey{
+				Kty: "OKP",
+				Kid: key.Id,
+				Use: "sig",
+				Alg: key.Algorithm,
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return set
+}
@@ -0,0 +1,115 @@
+package jwks
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func rsaPEM(t *testing.T) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func ed25519PEM(t *testing.T) ([]byte, []byte) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	privateDer, err := x509.MarshalPKCS8PrivateKey(private)
+	assert.NoError(t, err)
+	publicDer, err := x509.MarshalPKIXPublicKey(public)
+	assert.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDer}),
+		pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDer})
+}
+
+func TestLoadPEMKey_RSA(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rsa.pem")
+	assert.NoError(t, os.WriteFile(path, rsaPEM(t), 0o600))
+
+	key, err := LoadPEMKey("k1", AlgRS256, path)
+	assert.NoError(t, err)
+	assert.Equal(t, "k1", key.Id)
+	assert.IsType(t, &rsa.PrivateKey{}, key.Private)
+	assert.IsType(t, &rsa.PublicKey{}, key.Public)
+}
+
+func TestParsePEMKey_PublicOnly(t *testing.T) {
+	_, public := ed25519PEM(t)
+	key, err := ParsePEMKey("k1", AlgEdDSA, public)
+	assert.NoError(t, err)
+	assert.Nil(t, key.Private)
+	assert.IsType(t, ed25519.PublicKey{}, key.Public)
+}
+
+func TestParsePEMKey_AlgorithmMismatch(t *testing.T) {
+	_, err := ParsePEMKey("k1", AlgEdDSA, rsaPEM(t))
+	assert.ErrorIs(t, err, ErrAlgorithmMismatch)
+}
+
+func TestParsePEMKey_NoBlock(t *testing.T) {
+	_, err := ParsePEMKey("k1", AlgRS256, []byte("garbage"))
+	assert.ErrorIs(t, err, ErrNoPEMBlock)
+}
+
+func TestNewKeySet_RequiresPrivateActiveKey(t *testing.T) {
+	_, public := ed25519PEM(t)
+	key, _ := ParsePEMKey("k1", AlgEdDSA, public)
+	_, err := NewKeySet(key, time.Hour)
+	assert.ErrorIs(t, err, ErrNoSigningKey)
+}
+
+func TestKeySet_LookupHonoursGracePeriod(t *testing.T) {
+	private, _ := ed25519PEM(t)
+	active, _ := ParsePEMKey("new", AlgEdDSA, private)
+	recent, _ := ParsePEMKey("recent", AlgEdDSA, private)
+	recent.RetiredAt = time.Now().Add(-time.Hour)
+	old, _ := ParsePEMKey("old", AlgEdDSA, private)
+	old.RetiredAt = time.Now().Add(-48 * time.Hour)
+
+	set, err := NewKeySet(active, 24*time.Hour, recent, old)
+	assert.NoError(t, err)
+
+	_, ok := set.Lookup("new")
+	assert.True(t, ok)
+	_, ok = set.Lookup("recent")
+	assert.True(t, ok)
+	_, ok = set.Lookup("old")
+	assert.False(t, ok)
+	_, ok = set.Lookup("unknown")
+	assert.False(t, ok)
+}
+
+func TestKeySet_JWKS(t *testing.T) {
+	edPrivate, _ := ed25519PEM(t)
+	active, _ := ParsePEMKey("ed", AlgEdDSA, edPrivate)
+	previous, _ := ParsePEMKey("rsa", AlgRS256, rsaPEM(t))
+	previous.RetiredAt = time.Now()
+	hmac := NewHMACKey("hs", []byte("secret"))
+
+	set, err := NewKeySet(active, time.Hour, previous, hmac)
+	assert.NoError(t, err)
+
+	doc := set.JWKS()
+	if assert.Len(t, doc.Keys, 2, "symmetric keys must not be published") {
+		assert.Equal(t, "ed", doc.Keys[0].Kid)
+		assert.Equal(t, "OKP", doc.Keys[0].Kty)
+		assert.Equal(t, "Ed25519", doc.Keys[0].Crv)
+		assert.NotEmpty(t, doc.Keys[0].X)
+
+		assert.Equal(t, "rsa", doc.Keys[1].Kid)
+		assert.Equal(t, "RSA", doc.Keys[1].Kty)
+		assert.Equal(t, "AQAB", doc.Keys[1].E)
+		assert.NotEmpty(t, doc.Keys[1].N)
+	}
+}
@@ -0,0 +1,111 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+var (
+	ErrNoPEMBlock        = errors.New("no PEM block found")
+	ErrUnsupportedKey    = errors.New("unsupported key type")
+	ErrAlgorithmMismatch = errors.New("key type does not match algorithm")
+	ErrNoSigningKey      = errors.New("active key has no private part")
+)
+
+// Key is a JWT signing key identified by its kid. Verify-only keys
+// (loaded from a public PEM) have a nil Private part.
+type Key struct {
+	Id        string
+	Algorithm string
+	Private   crypto.PrivateKey // *rsa.PrivateKey, ed25519.PrivateKey or []byte for HS256
+	Public    crypto.PublicKey  // *rsa.PublicKey, ed25519.PublicKey or []byte for HS256
+	// RetiredAt is the moment the key stopped signing; zero for keys in use.
+	RetiredAt time.Time
+}
+
+// NewHMACKey returns a symmetric HS256 key.
+func NewHMACKey(id string, secret []byte) Key {
+	return Key{Id: id, Algorithm: AlgHS256, Private: secret, Public: secret}
+}
+
+// LoadPEMKey reads a PEM file holding a private key (PKCS#8 or PKCS#1) or a
+// public key (PKIX) and checks that it matches the algorithm.
+func LoadPEMKey(id, algorithm, path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("jwks.LoadPEMKey - os.ReadFile: %w", err)
+	}
+	return ParsePEMKey(id, algorithm, data)
+}
+
+func ParsePEMKey(id, algorithm string, data []byte) (Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return Key{}, ErrNoPEMBlock
+	}
+
+	key := Key{Id: id, Algorithm: algorithm}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("jwks.ParsePEMKey - x509.ParsePKCS8PrivateKey: %w", err)
+		}
+		signer, ok := private.(crypto.Signer)
+		if !ok {
+			return Key{}, ErrUnsupportedKey
+		}
+		key.Private = private
+		key.Public = signer.Public()
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("jwks.ParsePEMKey - x509.ParsePKCS1PrivateKey: %w", err)
+		}
+		key.Private = private
+		key.Public = &private.PublicKey
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return Key{}, fmt.Errorf("jwks.ParsePEMKey - x509.ParsePKIXPublicKey: %w", err)
+		}
+		key.Public = public
+	default:
+		return Key{}, fmt.Errorf("%w: PEM block %q", ErrUnsupportedKey, block.Type)
+	}
+
+	if err := checkAlgorithm(algorithm, key.Public); err != nil {
+		return Key{}, err
+	}
+	return key, nil
+}
+
+func checkAlgorithm(algorithm string, public crypto.PublicKey) error {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		if algorithm != AlgRS256 {
+			return ErrAlgorithmMismatch
+		}
+	case ed25519.PublicKey:
+		if algorithm != AlgEdDSA {
+			return ErrAlgorithmMismatch
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	return nil
+}
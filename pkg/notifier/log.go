@@ -0,0 +1,21 @@
+package notifier
+
+import (
+	"context"
+	"denet-test-task/pkg/logctx"
+)
+
+var _ Notifier = (*LogNotifier)(nil)
+
+// LogNotifier writes messages to the context logger. It is meant for local
+// development: message bodies may contain secrets such as reset tokens.
+type LogNotifier struct{}
+
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Notify(ctx context.Context, msg Message) error {
+	logctx.FromContext(ctx).Info("notification", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
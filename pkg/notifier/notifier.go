@@ -0,0 +1,17 @@
+package notifier
+
+import (
+	"context"
+)
+
+// Message is a notification addressed to a single recipient.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users (e-mail, messenger, ...).
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
@@ -0,0 +1,85 @@
+package notifier
+
+import (
+	"context"
+	"errors"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileNotifier_AppendsMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.txt")
+	n := NewFileNotifier(path)
+
+	assert.NoError(t, n.Notify(context.Background(), Message{To: "a@b.co", Subject: "first", Body: "hello"}))
+	assert.NoError(t, n.Notify(context.Background(), Message{To: "c@d.co", Subject: "second", Body: "bye"}))
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	out := string(data)
+	assert.Contains(t, out, "To: a@b.co\nSubject: first\n\nhello\n")
+	assert.Contains(t, out, "To: c@d.co\nSubject: second\n\nbye\n")
+	assert.Less(t, strings.Index(out, "first"), strings.Index(out, "second"))
+}
+
+func TestFileNotifier_UnwritablePath(t *testing.T) {
+	n := NewFileNotifier(filepath.Join(t.TempDir(), "missing", "outbox.txt"))
+	err := n.Notify(context.Background(), Message{To: "a@b.co"})
+	assert.Error(t, err)
+}
+
+func TestLogNotifier_Notify(t *testing.T) {
+	assert.NoError(t, NewLogNotifier().Notify(context.Background(), Message{To: "a@b.co"}))
+}
+
+func TestSMTPNotifier_Notify(t *testing.T) {
+	n := NewSMTPNotifier(SMTPConfig{Host: "smtp.example.com", Port: 587, Username: "user", Password: "pass", From: "noreply@example.com"})
+
+	var (
+		gotAddr string
+		gotFrom string
+		gotTo   []string
+		gotMsg  string
+	)
+	n.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.NotNil(t, a)
+		gotAddr, gotFrom, gotTo, gotMsg = addr, from, to, string(msg)
+		return nil
+	}
+
+	err := n.Notify(context.Background(), Message{To: "a@b.co", Subject: "Confirm", Body: "line1\nline2"})
+	assert.NoError(t, err)
+	assert.Equal(t, "smtp.example.com:587", gotAddr)
+	assert.Equal(t, "noreply@example.com", gotFrom)
+	assert.Equal(t, []string{"a@b.co"}, gotTo)
+	assert.Contains(t, gotMsg, "To: a@b.co\r\n")
+	assert.Contains(t, gotMsg, "Subject: Confirm\r\n")
+	assert.True(t, strings.HasSuffix(gotMsg, "\r\n\r\nline1\r\nline2\r\n"))
+}
+
+func TestSMTPNotifier_RejectsHeaderInjection(t *testing.T) {
+	n := NewSMTPNotifier(SMTPConfig{Host: "smtp.example.com", Port: 25, From: "noreply@example.com"})
+	n.sendMail = func(string, smtp.Auth, string, []string, []byte) error {
+		t.Fatal("must not send")
+		return nil
+	}
+
+	err := n.Notify(context.Background(), Message{To: "a@b.co\r\nBcc: x@y.z"})
+	assert.Error(t, err)
+}
+
+func TestSMTPNotifier_SendError(t *testing.T) {
+	n := NewSMTPNotifier(SMTPConfig{Host: "smtp.example.com", Port: 25, From: "noreply@example.com"})
+	n.sendMail = func(_ string, a smtp.Auth, _ string, _ []string, _ []byte) error {
+		assert.Nil(t, a)
+		return errors.New("connection refused")
+	}
+
+	err := n.Notify(context.Background(), Message{To: "a@b.co"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,36 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Listen subscribes to the notification channel and calls fn with the payload
+// of every notification until ctx is done or the connection fails. It holds a
+// pool connection for the whole time, so the pool must have one to spare.
+func (p *Postgres) Listen(ctx context.Context, channel string, fn func(payload string)) error {
+	conn, err := p.Pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres - Listen - p.Pool.Acquire: %w", err)
+	}
+	// the connection is closed rather than returned to the pool with an
+	// active subscription
+	defer func() {
+		_ = conn.Conn().Close(context.Background())
+		conn.Release()
+	}()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return fmt.Errorf("postgres - Listen - conn.Exec: %w", err)
+	}
+
+	for {
+		notification, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return fmt.Errorf("postgres - Listen - conn.WaitForNotification: %w", err)
+		}
+		fn(notification.Payload)
+	}
+}
@@ -63,8 +63,9 @@ func New(url string, opts ...Option) (*Postgres, error) {
 	ticker := time.NewTicker(pg.connTimeout)
 	defer ticker.Stop()
 
+	var pool *pgxpool.Pool
 	for attempt := 0; attempt < pg.connAttempts; attempt++ {
-		pg.Pool, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
+		pool, err = pgxpool.NewWithConfig(context.Background(), poolConfig)
 		if err == nil {
 			break
 		}
@@ -81,6 +82,7 @@ func New(url string, opts ...Option) (*Postgres, error) {
 	if err != nil {
 		return nil, fmt.Errorf("pgdb - New - pgxpool.ConnectConfig: %w", err)
 	}
+	pg.Pool = &txPool{pool}
 
 	return pg, nil
 }
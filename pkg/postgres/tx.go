@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type txKey struct{}
+
+var _ PgxPool = (*txPool)(nil)
+
+// txPool runs queries in the transaction carried by the context, if any, so
+// repositories built on Postgres join a transaction started by WithTx
+// without knowing about it.
+type txPool struct {
+	*pgxpool.Pool
+}
+
+func txFromContext(ctx context.Context) (pgx.Tx, bool) {
+	tx, ok := ctx.Value(txKey{}).(pgx.Tx)
+	return tx, ok
+}
+
+func (p *txPool) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Exec(ctx, sql, arguments...)
+	}
+	return p.Pool.Exec(ctx, sql, arguments...)
+}
+
+func (p *txPool) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Query(ctx, sql, args...)
+	}
+	return p.Pool.Query(ctx, sql, args...)
+}
+
+func (p *txPool) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.QueryRow(ctx, sql, args...)
+	}
+	return p.Pool.QueryRow(ctx, sql, args...)
+}
+
+func (p *txPool) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.SendBatch(ctx, b)
+	}
+	return p.Pool.SendBatch(ctx, b)
+}
+
+// Begin starts a savepoint when the context already carries a transaction.
+func (p *txPool) Begin(ctx context.Context) (pgx.Tx, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Begin(ctx)
+	}
+	return p.Pool.Begin(ctx)
+}
+
+// BeginTx starts a savepoint when the context already carries a transaction;
+// txOptions are then those of the outer transaction.
+func (p *txPool) BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.Begin(ctx)
+	}
+	return p.Pool.BeginTx(ctx, txOptions)
+}
+
+func (p *txPool) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	if tx, ok := txFromContext(ctx); ok {
+		return tx.CopyFrom(ctx, tableName, columnNames, rowSrc)
+	}
+	return p.Pool.CopyFrom(ctx, tableName, columnNames, rowSrc)
+}
+
+// WithTx runs fn in a transaction. Queries made through Pool with the context
+// passed to fn join the transaction, which is committed if fn returns nil and
+// rolled back otherwise. A nested call joins the outer transaction.
+func (p *Postgres) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := txFromContext(ctx); ok {
+		return fn(ctx)
+	}
+
+	tx, err := p.Pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("postgres - WithTx - p.Pool.Begin: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	if err := fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("postgres - WithTx - tx.Commit: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package ttlcache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache_SetGet(t *testing.T) {
+	c := New[string, int](time.Minute)
+	c.Set("a", 1)
+
+	got, ok := c.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 1, got)
+
+	_, ok = c.Get("b")
+	assert.False(t, ok)
+}
+
+func TestCache_Expires(t *testing.T) {
+	now := time.Now()
+	c := New[string, int](time.Minute)
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+
+	now = now.Add(time.Minute)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+}
+
+func TestCache_SweepsExpiredOnSet(t *testing.T) {
+	now := time.Now()
+	c := New[string, int](time.Minute)
+	c.now = func() time.Time { return now }
+	c.Set("a", 1)
+	c.Set("b", 2)
+
+	now = now.Add(2 * time.Minute)
+	c.Set("c", 3)
+	assert.Equal(t, 1, c.Len())
+}
+
+func TestCache_Delete(t *testing.T) {
+	c := New[int, bool](time.Minute)
+	c.Set(1, true)
+	c.Delete(1)
+	_, ok := c.Get(1)
+	assert.False(t, ok)
+}
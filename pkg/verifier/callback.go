@@ -0,0 +1,119 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var _ Verifier = (*CallbackVerifier)(nil)
+
+// CallbackConfig holds the endpoint of the verification service. Secret is
+// sent as a bearer token when set and signs the link tokens of the service;
+// accounts cannot be linked without it.
+type CallbackConfig struct {
+	URL    string
+	Secret string
+}
+
+// CallbackVerifier delegates the check to an HTTP service. The service gets
+// the request as JSON and answers with {"status": "verified" | "rejected" |
+// "pending"}; 202 Accepted without a body is pending as well.
+type CallbackVerifier struct {
+	cfg    CallbackConfig
+	client *http.Client
+}
+
+func NewCallbackVerifier(cfg CallbackConfig, client *http.Client) *CallbackVerifier {
+	return &CallbackVerifier{cfg: cfg, client: client}
+}
+
+type callbackRequest struct {
+	UserId  int            `json:"user_id"`
+	TaskId  int            `json:"task_id"`
+	Account string         `json:"account"`
+	Params  map[string]any `json:"params"`
+}
+
+type callbackResponse struct {
+	Status Status `json:"status"`
+}
+
+// Link checks a link token the verification service issues to its user for
+// our user: the account, expires_at in Unix seconds and signature, the hex
+// HMAC-SHA256 of "<user id>:<account>:<expires_at>" with Secret. The token
+// names the user, so it cannot link the account to anyone else.
+func (v *CallbackVerifier) Link(_ context.Context, userId int, proof map[string]string) (string, error) {
+	if v.cfg.Secret == "" {
+		return "", fmt.Errorf("CallbackVerifier.Link: %w: no secret", ErrInvalidRequest)
+	}
+
+	account, signature := proof["account"], proof["signature"]
+	if account == "" || signature == "" {
+		return "", fmt.Errorf("CallbackVerifier.Link: %w: no account or signature", ErrInvalidProof)
+	}
+	expiresAt, err := strconv.ParseInt(proof["expires_at"], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("CallbackVerifier.Link: %w: no expires_at", ErrInvalidProof)
+	}
+	if time.Now().After(time.Unix(expiresAt, 0)) {
+		return "", fmt.Errorf("CallbackVerifier.Link: %w: expired", ErrInvalidProof)
+	}
+
+	mac := hmac.New(sha256.New, []byte(v.cfg.Secret))
+	fmt.Fprintf(mac, "%d:%s:%d", userId, account, expiresAt)
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(signature)) {
+		return "", fmt.Errorf("CallbackVerifier.Link: %w: bad signature", ErrInvalidProof)
+	}
+	return account, nil
+}
+
+func (v *CallbackVerifier) Verify(ctx context.Context, req Request) (Status, error) {
+	payload, err := json.Marshal(callbackRequest{
+		UserId:  req.UserId,
+		TaskId:  req.TaskId,
+		Account: req.Account,
+		Params:  req.Params,
+	})
+	if err != nil {
+		return "", fmt.Errorf("CallbackVerifier.Verify - json.Marshal: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, v.cfg.URL, bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("CallbackVerifier.Verify - http.NewRequestWithContext: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if v.cfg.Secret != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+v.cfg.Secret)
+	}
+
+	resp, err := v.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("CallbackVerifier.Verify - client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("CallbackVerifier.Verify: unexpected status %d", resp.StatusCode)
+	}
+
+	var body callbackResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		if resp.StatusCode == http.StatusAccepted {
+			return StatusPending, nil
+		}
+		return "", fmt.Errorf("CallbackVerifier.Verify - json.Decode: %w", err)
+	}
+	if !body.Status.Valid() {
+		return "", fmt.Errorf("CallbackVerifier.Verify: unknown status %q", body.Status)
+	}
+	return body.Status, nil
+}
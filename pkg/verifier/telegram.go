@@ -0,0 +1,139 @@
+package verifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var _ Verifier = (*TelegramVerifier)(nil)
+
+// TelegramConfig holds the parameters of the Telegram Bot API. APIURL is
+// https://api.telegram.org unless a local Bot API server is used; LoginTTL
+// is how long the data of the Login Widget can be used to link an account.
+type TelegramConfig struct {
+	APIURL   string
+	BotToken string
+	LoginTTL time.Duration
+}
+
+// TelegramVerifier checks that the user is a member of the chat in the
+// "chat" param with getChatMember. The bot must be an administrator of the
+// chat; Account is the numeric Telegram id of the user, linked with the
+// Telegram Login Widget of the bot.
+type TelegramVerifier struct {
+	cfg    TelegramConfig
+	client *http.Client
+}
+
+func NewTelegramVerifier(cfg TelegramConfig, client *http.Client) *TelegramVerifier {
+	return &TelegramVerifier{cfg: cfg, client: client}
+}
+
+type telegramResponse struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Result      struct {
+		Status   string `json:"status"`
+		IsMember bool   `json:"is_member"`
+	} `json:"result"`
+}
+
+// Link checks the data the Telegram Login Widget passes to the site: the
+// fields of the Telegram user and their hash, signed with the bot token, so
+// the id in them is the account of whoever logged in with the widget.
+func (v *TelegramVerifier) Link(_ context.Context, _ int, proof map[string]string) (string, error) {
+	id, hash := proof["id"], proof["hash"]
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil || hash == "" {
+		return "", fmt.Errorf("TelegramVerifier.Link: %w: no id or hash", ErrInvalidProof)
+	}
+	authDate, err := strconv.ParseInt(proof["auth_date"], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("TelegramVerifier.Link: %w: no auth_date", ErrInvalidProof)
+	}
+	if time.Since(time.Unix(authDate, 0)) > v.cfg.LoginTTL {
+		return "", fmt.Errorf("TelegramVerifier.Link: %w: expired", ErrInvalidProof)
+	}
+
+	// the data-check-string is the other fields sorted by name, one per line
+	keys := make([]string, 0, len(proof))
+	for key := range proof {
+		if key != "hash" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = key + "=" + proof[key]
+	}
+
+	secret := sha256.Sum256([]byte(v.cfg.BotToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(lines, "\n")))
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(hash)) {
+		return "", fmt.Errorf("TelegramVerifier.Link: %w: bad hash", ErrInvalidProof)
+	}
+	return id, nil
+}
+
+func (v *TelegramVerifier) Verify(ctx context.Context, req Request) (Status, error) {
+	chat, _ := req.Params["chat"].(string)
+	if chat == "" {
+		return "", fmt.Errorf("TelegramVerifier.Verify: %w: no chat", ErrInvalidRequest)
+	}
+	if _, err := strconv.ParseInt(req.Account, 10, 64); err != nil {
+		return StatusRejected, nil
+	}
+
+	query := url.Values{"chat_id": {chat}, "user_id": {req.Account}}
+	endpoint := strings.TrimRight(v.cfg.APIURL, "/") + "/bot" + v.cfg.BotToken + "/getChatMember?" + query.Encode()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return "", fmt.Errorf("TelegramVerifier.Verify - http.NewRequestWithContext: %w", err)
+	}
+
+	resp, err := v.client.Do(httpReq)
+	if err != nil {
+		// the url holds the bot token, so it is left out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("TelegramVerifier.Verify - client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body telegramResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("TelegramVerifier.Verify - json.Decode: status %d: %w", resp.StatusCode, err)
+	}
+	if !body.Ok {
+		// the user has never been seen by Telegram in the chat
+		if body.ErrorCode == http.StatusBadRequest && strings.Contains(body.Description, "user not found") {
+			return StatusRejected, nil
+		}
+		return "", fmt.Errorf("TelegramVerifier.Verify: getChatMember: %d %s", body.ErrorCode, body.Description)
+	}
+
+	switch body.Result.Status {
+	case "creator", "administrator", "member":
+		return StatusVerified, nil
+	case "restricted":
+		if body.Result.IsMember {
+			return StatusVerified, nil
+		}
+	}
+	return StatusRejected, nil
+}
@@ -0,0 +1,53 @@
+package verifier
+
+import (
+	"context"
+	"fmt"
+)
+
+// Status is the outcome of a verification.
+type Status string
+
+const (
+	// StatusVerified means the user did what the task asks for.
+	StatusVerified Status = "verified"
+	// StatusRejected means the user did not.
+	StatusRejected Status = "rejected"
+	// StatusPending means the check is still running and should be retried later.
+	StatusPending Status = "pending"
+)
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusVerified, StatusRejected, StatusPending:
+		return true
+	}
+	return false
+}
+
+// Request is a claim that a user did something on an external service.
+// Account is the id of the user on that service, as returned by Link, and
+// Params are the settings of the task, such as the channel to subscribe to.
+type Request struct {
+	UserId  int
+	TaskId  int
+	Account string
+	Params  map[string]any
+}
+
+// Verifier checks claims against an external service. Link proves that an
+// account on the service belongs to the user and returns the account; only
+// accounts proven this way are passed to Verify.
+type Verifier interface {
+	Link(ctx context.Context, userId int, proof map[string]string) (string, error)
+	Verify(ctx context.Context, req Request) (Status, error)
+}
+
+var (
+	// ErrInvalidRequest is returned for requests that cannot be verified, such
+	// as a task without the settings the verifier needs.
+	ErrInvalidRequest = fmt.Errorf("invalid verification request")
+	// ErrInvalidProof is returned by Link for proofs that are incomplete,
+	// forged or expired.
+	ErrInvalidProof = fmt.Errorf("invalid account proof")
+)
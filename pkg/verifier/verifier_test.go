@@ -0,0 +1,200 @@
+package verifier
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTelegramServer stands in for the Bot API, answering getChatMember with
+// the member statuses by user id.
+func newTelegramServer(t *testing.T, statuses map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/bottoken/getChatMember", r.URL.Path)
+		assert.Equal(t, "@denet", r.URL.Query().Get("chat_id"))
+
+		status, ok := statuses[r.URL.Query().Get("user_id")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: user not found"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"status":"` + status + `","is_member":false}}`))
+	}))
+}
+
+func TestTelegramVerifier_Verify(t *testing.T) {
+	srv := newTelegramServer(t, map[string]string{"1": "member", "2": "creator", "3": "left", "4": "kicked"})
+	defer srv.Close()
+	v := NewTelegramVerifier(TelegramConfig{APIURL: srv.URL, BotToken: "token"}, srv.Client())
+	params := map[string]any{"chat": "@denet"}
+
+	cases := map[string]Status{
+		"1":         StatusVerified,
+		"2":         StatusVerified,
+		"3":         StatusRejected,
+		"4":         StatusRejected,
+		"5":         StatusRejected,
+		"not an id": StatusRejected,
+	}
+	for account, want := range cases {
+		got, err := v.Verify(context.Background(), Request{UserId: 1, TaskId: 4, Account: account, Params: params})
+		assert.NoError(t, err, account)
+		assert.Equal(t, want, got, account)
+	}
+}
+
+func TestTelegramVerifier_Errors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		_, _ = w.Write([]byte(`{"ok":false,"error_code":401,"description":"Unauthorized"}`))
+	}))
+	defer srv.Close()
+	v := NewTelegramVerifier(TelegramConfig{APIURL: srv.URL, BotToken: "secret-token"}, srv.Client())
+
+	_, err := v.Verify(context.Background(), Request{Account: "1", Params: map[string]any{}})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	_, err = v.Verify(context.Background(), Request{Account: "1", Params: map[string]any{"chat": "@denet"}})
+	assert.Error(t, err)
+
+	srv.Close()
+	_, err = v.Verify(context.Background(), Request{Account: "1", Params: map[string]any{"chat": "@denet"}})
+	if assert.Error(t, err) {
+		assert.NotContains(t, err.Error(), "secret-token")
+	}
+}
+
+// telegramLogin returns the data of the Login Widget for the id, signed with
+// the bot token as Telegram does.
+func telegramLogin(botToken string, id string, authDate time.Time) map[string]string {
+	authDateStr := strconv.FormatInt(authDate.Unix(), 10)
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte("auth_date=" + authDateStr + "\nfirst_name=Ann\nid=" + id + "\nusername=ann"))
+	return map[string]string{
+		"id":         id,
+		"first_name": "Ann",
+		"username":   "ann",
+		"auth_date":  authDateStr,
+		"hash":       hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+func TestTelegramVerifier_Link(t *testing.T) {
+	v := NewTelegramVerifier(TelegramConfig{BotToken: "token", LoginTTL: time.Hour}, http.DefaultClient)
+
+	account, err := v.Link(context.Background(), 7, telegramLogin("token", "42", time.Now()))
+	assert.NoError(t, err)
+	assert.Equal(t, "42", account)
+
+	// signed by another bot
+	_, err = v.Link(context.Background(), 7, telegramLogin("other", "42", time.Now()))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	// a field changed after signing
+	proof := telegramLogin("token", "42", time.Now())
+	proof["id"] = "43"
+	_, err = v.Link(context.Background(), 7, proof)
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	_, err = v.Link(context.Background(), 7, telegramLogin("token", "42", time.Now().Add(-2*time.Hour)))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	_, err = v.Link(context.Background(), 7, map[string]string{"id": "42"})
+	assert.ErrorIs(t, err, ErrInvalidProof)
+}
+
+// linkToken returns the proof the verification service issues for the user.
+func linkToken(secret string, userId int, account string, expiresAt time.Time) map[string]string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d:%s:%d", userId, account, expiresAt.Unix())
+	return map[string]string{
+		"account":    account,
+		"expires_at": strconv.FormatInt(expiresAt.Unix(), 10),
+		"signature":  hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+func TestCallbackVerifier_Link(t *testing.T) {
+	v := NewCallbackVerifier(CallbackConfig{URL: "http://verifier", Secret: "secret"}, http.DefaultClient)
+	expiresAt := time.Now().Add(time.Minute)
+
+	account, err := v.Link(context.Background(), 7, linkToken("secret", 7, "ok", expiresAt))
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", account)
+
+	// the token is for another user
+	_, err = v.Link(context.Background(), 8, linkToken("secret", 7, "ok", expiresAt))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	_, err = v.Link(context.Background(), 7, linkToken("other", 7, "ok", expiresAt))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	_, err = v.Link(context.Background(), 7, linkToken("secret", 7, "ok", time.Now().Add(-time.Minute)))
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	_, err = v.Link(context.Background(), 7, map[string]string{"account": "ok"})
+	assert.ErrorIs(t, err, ErrInvalidProof)
+
+	// accounts cannot be linked without the secret
+	v = NewCallbackVerifier(CallbackConfig{URL: "http://verifier"}, http.DefaultClient)
+	_, err = v.Link(context.Background(), 7, linkToken("", 7, "ok", expiresAt))
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+}
+
+func TestCallbackVerifier_Verify(t *testing.T) {
+	var got callbackRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+
+		switch got.Account {
+		case "ok":
+			_, _ = w.Write([]byte(`{"status":"verified"}`))
+		case "no":
+			_, _ = w.Write([]byte(`{"status":"rejected"}`))
+		case "later":
+			w.WriteHeader(http.StatusAccepted)
+		case "unknown":
+			_, _ = w.Write([]byte(`{"status":"maybe"}`))
+		default:
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+	v := NewCallbackVerifier(CallbackConfig{URL: srv.URL, Secret: "secret"}, srv.Client())
+
+	status, err := v.Verify(context.Background(), Request{UserId: 7, TaskId: 5, Account: "ok", Params: map[string]any{"handle": "@denet"}})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusVerified, status)
+	assert.Equal(t, callbackRequest{UserId: 7, TaskId: 5, Account: "ok", Params: map[string]any{"handle": "@denet"}}, got)
+
+	status, err = v.Verify(context.Background(), Request{Account: "no"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusRejected, status)
+
+	status, err = v.Verify(context.Background(), Request{Account: "later"})
+	assert.NoError(t, err)
+	assert.Equal(t, StatusPending, status)
+
+	_, err = v.Verify(context.Background(), Request{Account: "unknown"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "maybe")
+	}
+
+	_, err = v.Verify(context.Background(), Request{Account: "down"})
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrInvalidRequest))
+}